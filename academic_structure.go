@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type SubjectAssignmentModel struct {
+	Subject string `json:"subject"`
+	Teacher string `json:"teacher"`
+}
+
+// ClassStructureModel is one row of the subjects onboarding step.
+type ClassStructureModel struct {
+	ClassName string                   `json:"className"`
+	Sections  []string                 `json:"sections"`
+	Subjects  []SubjectAssignmentModel `json:"subjects"`
+}
+
+type RowValidationError struct {
+	Row    int      `json:"row"`
+	Errors []string `json:"errors"`
+}
+
+var sectionPattern = regexp.MustCompile(`^[A-Z0-9]{1,3}$`)
+
+type academicStructureStore struct {
+	sync.RWMutex
+	classes map[string][]ClassStructureModel
+}
+
+var academicStructures = &academicStructureStore{classes: map[string][]ClassStructureModel{}}
+
+func (s *academicStructureStore) get(schoolId string) ([]ClassStructureModel, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	classes, ok := s.classes[schoolId]
+	return classes, ok
+}
+
+// set replaces the school's structure; the onboarding step always submits
+// the complete set of classes.
+func (s *academicStructureStore) set(schoolId string, classes []ClassStructureModel) {
+	s.Lock()
+	defer s.Unlock()
+	s.classes[schoolId] = classes
+}
+
+// strandedStudents reports the classes and sections missing from classes
+// that students of the school are still placed in, with how many students
+// each holds. The structure cannot be saved while any are left.
+func strandedStudents(schoolId string, classes []ClassStructureModel) []string {
+	sections := map[string][]string{}
+	for _, class := range classes {
+		sections[class.ClassName] = class.Sections
+	}
+	counts := map[string]int{}
+	students.RLock()
+	if school, ok := students.schools[schoolId]; ok {
+		for _, student := range school.students {
+			classSections, ok := sections[student.ClassName]
+			switch {
+			case !ok:
+				counts[fmt.Sprintf("class %s", student.ClassName)]++
+			case student.Section != "" && !containsValue(classSections, student.Section):
+				counts[fmt.Sprintf("class %s section %s", student.ClassName, student.Section)]++
+			}
+		}
+	}
+	students.RUnlock()
+
+	var errs []string
+	for place, count := range counts {
+		errs = append(errs, fmt.Sprintf("%s is removed but still has %d student(s)", place, count))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// parseClassStructure converts the untyped rows of
+// OnBoardingSubjectDataRequestDto into classes, collecting validation errors
+// per row (1-based).
+func parseClassStructure(schoolId string, rows []map[string]interface{}) ([]ClassStructureModel, []RowValidationError) {
+	var classes []ClassStructureModel
+	var rowErrors []RowValidationError
+	teachers := dropDownList(schoolId, "allTeachersDropDown")
+	seenClasses := map[string]int{}
+
+	if len(rows) == 0 {
+		return nil, []RowValidationError{{Row: 0, Errors: []string{"at least one class is required"}}}
+	}
+	for i, row := range rows {
+		var errs []string
+		var class ClassStructureModel
+		raw, _ := json.Marshal(row)
+		if err := json.Unmarshal(raw, &class); err != nil {
+			rowErrors = append(rowErrors, RowValidationError{Row: i + 1, Errors: []string{"invalid row: " + err.Error()}})
+			continue
+		}
+
+		class.ClassName = strings.TrimSpace(class.ClassName)
+		if class.ClassName == "" {
+			errs = append(errs, "className is required")
+		} else if previous, ok := seenClasses[class.ClassName]; ok {
+			errs = append(errs, fmt.Sprintf("className %q is already defined in row %d", class.ClassName, previous))
+		} else {
+			seenClasses[class.ClassName] = i + 1
+		}
+
+		if len(class.Sections) == 0 {
+			errs = append(errs, "at least one section is required")
+		}
+		seenSections := map[string]bool{}
+		for j, section := range class.Sections {
+			section = strings.ToUpper(strings.TrimSpace(section))
+			class.Sections[j] = section
+			if !sectionPattern.MatchString(section) {
+				errs = append(errs, fmt.Sprintf("section %q must be 1-3 letters or digits", section))
+			} else if seenSections[section] {
+				errs = append(errs, fmt.Sprintf("section %q is repeated", section))
+			}
+			seenSections[section] = true
+		}
+
+		if len(class.Subjects) == 0 {
+			errs = append(errs, "at least one subject is required")
+		}
+		seenSubjects := map[string]bool{}
+		for j, subject := range class.Subjects {
+			subject.Subject = strings.Join(strings.Fields(subject.Subject), " ")
+			subject.Teacher = strings.TrimSpace(subject.Teacher)
+			class.Subjects[j] = subject
+			key := strings.ToLower(subject.Subject)
+			switch {
+			case subject.Subject == "":
+				errs = append(errs, fmt.Sprintf("subjects[%d].subject is required", j))
+			case seenSubjects[key]:
+				errs = append(errs, fmt.Sprintf("subject %q is repeated", subject.Subject))
+			}
+			seenSubjects[key] = true
+			if subject.Teacher != "" && !containsValue(teachers, subject.Teacher) {
+				errs = append(errs, fmt.Sprintf("teacher %q for %s is not one of %s", subject.Teacher, subject.Subject, strings.Join(teachers, ", ")))
+			}
+		}
+
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, RowValidationError{Row: i + 1, Errors: errs})
+			continue
+		}
+		classes = append(classes, class)
+	}
+	return classes, rowErrors
+}
+
+// applyAcademicStructure overrides the "classes", "subjects" and "sections"
+// dropdowns with the structure saved during onboarding.
+func applyAcademicStructure(schoolId string, dropDowns map[string]interface{}) {
+	classes, ok := academicStructures.get(schoolId)
+	if !ok {
+		return
+	}
+	names := make([]string, 0, len(classes))
+	subjects := map[string][]string{}
+	sections := map[string][]string{}
+	for _, class := range classes {
+		names = append(names, class.ClassName)
+		sections[class.ClassName] = append([]string{}, class.Sections...)
+		for _, subject := range class.Subjects {
+			subjects[class.ClassName] = append(subjects[class.ClassName], subject.Subject)
+		}
+	}
+	dropDowns["classes"] = names
+	dropDowns["subjects"] = subjects
+	dropDowns["sections"] = sections
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseClassStructure(t *testing.T) {
+	resetDropDownMaster()
+	subjects := []interface{}{map[string]interface{}{"subject": "Maths"}}
+	tests := []struct {
+		name     string
+		rows     []map[string]interface{}
+		want     []ClassStructureModel
+		wantErrs map[int]string // row to a fragment of one of its errors
+	}{
+		{
+			name: "normalized",
+			rows: []map[string]interface{}{
+				{"className": " 10 ", "sections": []interface{}{"a", " b"}, "subjects": []interface{}{map[string]interface{}{"subject": " Social   Science ", "teacher": "Teacher 1"}}},
+			},
+			want: []ClassStructureModel{{ClassName: "10", Sections: []string{"A", "B"}, Subjects: []SubjectAssignmentModel{{Subject: "Social Science", Teacher: "Teacher 1"}}}},
+		},
+		{name: "no rows", wantErrs: map[int]string{0: "at least one class"}},
+		{
+			name: "row errors",
+			rows: []map[string]interface{}{
+				{"className": "10", "sections": []interface{}{"A"}, "subjects": subjects},
+				{"className": "10", "sections": []interface{}{"A"}, "subjects": subjects},
+				{"className": "11", "sections": []interface{}{"A", "a"}, "subjects": subjects},
+				{"className": "12", "sections": []interface{}{"A-1"}, "subjects": subjects},
+				{"className": "", "sections": []interface{}{}, "subjects": []interface{}{}},
+				{"className": "9", "sections": []interface{}{"A"}, "subjects": []interface{}{map[string]interface{}{"subject": "Maths"}, map[string]interface{}{"subject": "maths"}}},
+				{"className": "8", "sections": []interface{}{"A"}, "subjects": []interface{}{map[string]interface{}{"subject": "Maths", "teacher": "Nobody"}}},
+				{"className": 8, "sections": "A"},
+			},
+			wantErrs: map[int]string{
+				2: "already defined in row 1",
+				3: `section "A" is repeated`,
+				4: "1-3 letters or digits",
+				5: "className is required",
+				6: `subject "maths" is repeated`,
+				7: `teacher "Nobody"`,
+				8: "invalid row",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			classes, rowErrors := parseClassStructure(defaultSchoolID, test.rows)
+			if test.want != nil && !reflect.DeepEqual(classes, test.want) {
+				t.Errorf("classes = %+v, want %+v", classes, test.want)
+			}
+			if len(rowErrors) != len(test.wantErrs) {
+				t.Fatalf("row errors = %+v, want rows %v", rowErrors, test.wantErrs)
+			}
+			for _, rowError := range rowErrors {
+				want, ok := test.wantErrs[rowError.Row]
+				if !ok || !strings.Contains(strings.Join(rowError.Errors, "; "), want) {
+					t.Errorf("row %d errors = %v, want %q", rowError.Row, rowError.Errors, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStrandedStudents(t *testing.T) {
+	resetStudents()
+	students.Lock()
+	school := students.school("SCH-S")
+	for _, placement := range [][2]string{{"10", "A"}, {"10", "B"}, {"10", "B"}, {"11", "A"}} {
+		school.insert(StudentModel{Name: "Student", ClassName: placement[0], Section: placement[1]})
+	}
+	students.Unlock()
+
+	tests := []struct {
+		name    string
+		classes []ClassStructureModel
+		want    []string
+	}{
+		{
+			name:    "everything kept",
+			classes: []ClassStructureModel{{ClassName: "10", Sections: []string{"A", "B", "C"}}, {ClassName: "11", Sections: []string{"A"}}},
+		},
+		{
+			name:    "section removed",
+			classes: []ClassStructureModel{{ClassName: "10", Sections: []string{"A"}}, {ClassName: "11", Sections: []string{"A"}}},
+			want:    []string{"class 10 section B is removed but still has 2 student(s)"},
+		},
+		{
+			name:    "class removed",
+			classes: []ClassStructureModel{{ClassName: "10", Sections: []string{"A", "B"}}},
+			want:    []string{"class 11 is removed but still has 1 student(s)"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := strandedStudents("SCH-S", test.classes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("strandedStudents = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+type SwitchAccountRequestDto struct {
+	StudentId string `json:"studentId"`
+}
+
+// defaultActiveStudent is the student a login acts for right after signing
+// in: the first one linked to it, or none.
+func defaultActiveStudent(schoolId, username string) string {
+	if links := studentAccounts.linked(schoolId, username); len(links) > 0 {
+		return links[0].StudentId
+	}
+	return ""
+}
+
+// switchAccounts lists the students linked to the login, marking the
+// active one.
+func switchAccounts(schoolId, username, activeId string) []SwitchAccountModel {
+	accounts := []SwitchAccountModel{}
+	for _, link := range studentAccounts.linked(schoolId, username) {
+		student, ok := students.get(schoolId, link.StudentId)
+		if !ok {
+			continue
+		}
+		accounts = append(accounts, SwitchAccountModel{
+			StudentId:       student.Id,
+			Name:            student.Name,
+			ClassName:       strings.TrimSpace(student.ClassName + " " + student.Section),
+			AdmissionNumber: student.AdmissionNumber,
+			Relation:        link.Relation,
+			Active:          student.Id == activeId,
+		})
+	}
+	return accounts
+}
+
+// requestStudent resolves the student a student-facing request is about
+// from ?studentId and the login's active student.
+func requestStudent(c echo.Context, claims jwt.MapClaims) (string, int, BaseResponse) {
+	return scopedStudent(claims, c.QueryParam("studentId"))
+}
+
+// pageStudent resolves the student of a page that only makes sense for a
+// student, such as homework or marks. Student and parent logins need an
+// active student; staff get the school-wide sample without ?studentId.
+func pageStudent(c echo.Context, claims jwt.MapClaims) (string, int, BaseResponse) {
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return "", status, failure
+	}
+	role := claimString(claims, "user_role")
+	if studentId == "" {
+		if role != roleAdmin && role != roleTeacher {
+			return "", http.StatusNotFound, failed("No student is linked to this login")
+		}
+		return "", http.StatusOK, BaseResponse{}
+	}
+	if _, ok := students.get(schoolID(claims), studentId); !ok {
+		return "", http.StatusNotFound, failed("Student " + studentId + " not found")
+	}
+	return studentId, http.StatusOK, BaseResponse{}
+}
+
+// scopedStudent resolves the student a request is about. Staff name it;
+// student and parent logins get their active student and must switch
+// before asking about another one. An empty id means the request is not
+// about a particular student.
+func scopedStudent(claims jwt.MapClaims, requested string) (string, int, BaseResponse) {
+	role := claimString(claims, "user_role")
+	if role == roleAdmin || role == roleTeacher {
+		return requested, http.StatusOK, BaseResponse{}
+	}
+	active := claimString(claims, "student_id")
+	if requested != "" && requested != active {
+		return "", http.StatusForbidden, failed("Switch to student " + requested + " first")
+	}
+	if active != "" && !studentAccounts.canActFor(schoolID(claims), claimString(claims, "id"), active) {
+		return "", http.StatusForbidden, failed("Student " + active + " is no longer linked to this login")
+	}
+	return active, http.StatusOK, BaseResponse{}
+}
+
+// applyActiveStudent fills the profile header with the student and lists
+// the students the login can switch to.
+func applyActiveStudent(page *CoreProfilePageModel, claims jwt.MapClaims, studentId string) {
+	details := page.GenericBasicDetailsPageModel
+	if student, ok := students.get(schoolID(claims), studentId); ok {
+		details.Name = student.Name
+		details.ClassName = strings.TrimSpace(student.ClassName + " " + student.Section)
+		details.RollNumberValue = student.AdmissionNumber
+	}
+	details.Accounts = switchAccounts(schoolID(claims), claimString(claims, "id"), claimString(claims, "student_id"))
+}
+
+// AccountsHandler lists the students the login can switch between.
+func AccountsHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	return c.JSON(http.StatusOK, success(switchAccounts(schoolID(claims), claimString(claims, "id"), claimString(claims, "student_id"))))
+}
+
+// SwitchAccountHandler makes another linked student the active one and
+// issues tokens scoped to it.
+func SwitchAccountHandler(c echo.Context) error {
+	var req SwitchAccountRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	schoolId, username := schoolID(claims), claimString(claims, "id")
+	if !studentAccounts.canActFor(schoolId, username, req.StudentId) {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	chatAccess, _ := claims["chat_access"].(bool)
+	response, err := issueTokens(Claims{
+		Email:      claimString(claims, "email"),
+		Name:       claimString(claims, "name"),
+		UserRole:   claimString(claims, "user_role"),
+		ChatAccess: chatAccess,
+		Id:         username,
+		SchoolId:   schoolId,
+		StudentId:  req.StudentId,
+	})
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to generate token")
+	}
+	response["accounts"] = switchAccounts(schoolId, username, req.StudentId)
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+func TestScopedStudent(t *testing.T) {
+	ids := guardianFamily(t)
+	ravi, anu, mohan := ids[0], ids[1], ids[2]
+	claims := func(id, role, active string) jwt.MapClaims {
+		return jwt.MapClaims{"id": id, "user_role": role, "school_id": defaultSchoolID, "student_id": active}
+	}
+	tests := []struct {
+		name      string
+		claims    jwt.MapClaims
+		requested string
+		want      string
+		status    int
+	}{
+		{"staff name the student", claims("teacher1", roleTeacher, ""), mohan, mohan, http.StatusOK},
+		{"staff without a student", claims("admin", roleAdmin, ""), "", "", http.StatusOK},
+		{"active student", claims("parent1", roleStudent, ravi), "", ravi, http.StatusOK},
+		{"active student named", claims("parent1", roleStudent, anu), anu, anu, http.StatusOK},
+		{"sibling before switching", claims("parent1", roleStudent, ravi), anu, "", http.StatusForbidden},
+		{"another family", claims("parent2", roleStudent, mohan), ravi, "", http.StatusForbidden},
+		{"unlinked since the token", claims("parent2", roleStudent, ravi), "", "", http.StatusForbidden},
+		{"no active student", claims("parent3", roleStudent, ""), "", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, status, _ := scopedStudent(test.claims, test.requested)
+			if got != test.want || status != test.status {
+				t.Errorf("scopedStudent = %q, %d; want %q, %d", got, status, test.want, test.status)
+			}
+		})
+	}
+}
+
+func TestPageStudent(t *testing.T) {
+	ravi := guardianFamily(t)[0]
+	tests := []struct {
+		name   string
+		token  string
+		target string
+		want   string
+		status int
+	}{
+		{"staff sample", testToken(t, "admin", roleAdmin, ""), "/home", "", http.StatusOK},
+		{"staff name a student", testToken(t, "admin", roleAdmin, ""), "/home?studentId=" + ravi, ravi, http.StatusOK},
+		{"staff name an unknown student", testToken(t, "admin", roleAdmin, ""), "/home?studentId=STU-NONE", "", http.StatusNotFound},
+		{"family", testToken(t, "parent1", roleStudent, ravi), "/home", ravi, http.StatusOK},
+		{"login without students", testToken(t, "parent3", roleStudent, ""), "/home", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			claims, _, _ := bearerClaims(c)
+			got, status, _ := pageStudent(c, claims)
+			if got != test.want || status != test.status {
+				t.Errorf("pageStudent = %q, %d; want %q, %d", got, status, test.want, test.status)
+			}
+		})
+	}
+}
+
+func TestSwitchAccountHandler(t *testing.T) {
+	ids := guardianFamily(t)
+	ravi, anu, mohan := ids[0], ids[1], ids[2]
+	parent := testToken(t, "parent1", roleStudent, ravi)
+
+	if rec := callHandler(t, SwitchAccountHandler, http.MethodPost, "/switch-account", parent, SwitchAccountRequestDto{StudentId: mohan}); rec.Code != http.StatusForbidden {
+		t.Errorf("switch to another family's student = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec := callHandler(t, SwitchAccountHandler, http.MethodPost, "/switch-account", parent, SwitchAccountRequestDto{StudentId: anu})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("switch = %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		AccessToken string               `json:"access_token"`
+		Accounts    []SwitchAccountModel `json:"accounts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+response.AccessToken)
+	claims, _, _ := bearerClaims(echo.New().NewContext(req, httptest.NewRecorder()))
+	if claimString(claims, "student_id") != anu || claimString(claims, "id") != "parent1" || claimString(claims, "user_role") != roleStudent {
+		t.Errorf("switched claims = %v", claims)
+	}
+	if len(response.Accounts) != 2 || response.Accounts[0].Active || !response.Accounts[1].Active {
+		t.Errorf("accounts = %+v", response.Accounts)
+	}
+}
+
+func TestFeeHandlerScopesStudent(t *testing.T) {
+	ravi := guardianFamily(t)[0]
+	students.Lock()
+	other := students.school("SCH-OTHER").insert(StudentModel{Name: "Other School"}).Id
+	students.Unlock()
+	feeLedger = &feeLedgerStore{charges: map[string][]FeeChargeModel{}}
+	feeLedger.chargeMonthly(ravi, transportFeeSource, "Transport Fee", "Route 1", 500, time.Now())
+	feeLedger.chargeMonthly(other, transportFeeSource, "Transport Fee", "Route 9", 900, time.Now())
+
+	admin := testToken(t, "admin", roleAdmin, "")
+	tests := []struct {
+		name   string
+		token  string
+		target string
+		status int
+	}{
+		{"staff name a student", admin, "/fees?studentId=" + ravi, http.StatusOK},
+		{"staff name another school's student", admin, "/fees?studentId=" + other, http.StatusNotFound},
+		{"staff sample", admin, "/fees", http.StatusOK},
+		{"family", testToken(t, "parent1", roleStudent, ravi), "/fees", http.StatusOK},
+		{"login without students", testToken(t, "parent3", roleStudent, ""), "/fees", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var page GenericFeePageModel
+			decodeData(t, callHandler(t, FeeHandler, http.MethodGet, test.target, test.token, nil), test.status, &page)
+			for _, fee := range page.FeeTypes {
+				if fee.AmountDueValue == "$900" {
+					t.Errorf("another school's charge was shown: %+v", fee)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BlobObject describes a stored file.
+type BlobObject struct {
+	Key         string    `json:"key"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// BlobStore keeps files uploaded to the API, such as school logos.
+type BlobStore interface {
+	Put(key, contentType string, data []byte) (BlobObject, error)
+	Get(key string) ([]byte, BlobObject, error)
+	Delete(key string) error
+}
+
+var (
+	errBlobNotFound   = errors.New("blob not found")
+	errInvalidBlobKey = errors.New("invalid blob key")
+	blobKeyPattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(/[A-Za-z0-9][A-Za-z0-9._-]*)*$`)
+)
+
+// privateBlobPrefix starts the keys of files that are never served
+// publicly, such as student documents.
+const privateBlobPrefix = "private/"
+
+// blobs is the store used by the API. BLOB_DIR overrides its location.
+var blobs BlobStore = newFileBlobStore(blobDir())
+
+func blobDir() string {
+	if dir := os.Getenv("BLOB_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("data", "blobs")
+}
+
+// fileBlobStore stores each blob as a file with a ".meta" JSON sidecar.
+type fileBlobStore struct {
+	root string
+}
+
+func newFileBlobStore(root string) *fileBlobStore {
+	return &fileBlobStore{root: root}
+}
+
+func (s *fileBlobStore) path(key string) (string, error) {
+	if !blobKeyPattern.MatchString(key) || strings.Contains(key, "..") || strings.HasSuffix(key, ".meta") {
+		return "", errInvalidBlobKey
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *fileBlobStore) Put(key, contentType string, data []byte) (BlobObject, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return BlobObject{}, err
+	}
+	sum := sha256.Sum256(data)
+	object := BlobObject{
+		Key:         key,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		CreatedAt:   time.Now().UTC(),
+	}
+	meta, err := json.Marshal(object)
+	if err != nil {
+		return BlobObject{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return BlobObject{}, err
+	}
+	// Write to a temporary file first so readers never see partial data
+	if err := writeFileAtomic(path, data); err != nil {
+		return BlobObject{}, err
+	}
+	if err := writeFileAtomic(path+".meta", meta); err != nil {
+		return BlobObject{}, err
+	}
+	return object, nil
+}
+
+func (s *fileBlobStore) Get(key string) ([]byte, BlobObject, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, BlobObject{}, err
+	}
+	meta, err := os.ReadFile(path + ".meta")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, BlobObject{}, errBlobNotFound
+	} else if err != nil {
+		return nil, BlobObject{}, err
+	}
+	var object BlobObject
+	if err := json.Unmarshal(meta, &object); err != nil {
+		return nil, BlobObject{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, BlobObject{}, errBlobNotFound
+	}
+	return data, object, err
+}
+
+func (s *fileBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{path + ".meta", path} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// BlobHandler serves public blobs such as school logos. Keys under
+// private/ are only reachable through signed media URLs.
+func BlobHandler(c echo.Context) error {
+	key := c.Param("*")
+	if strings.HasPrefix(key, privateBlobPrefix) {
+		return c.JSON(http.StatusNotFound, failed("File not found"))
+	}
+	data, object, err := blobs.Get(key)
+	if errors.Is(err, errBlobNotFound) || errors.Is(err, errInvalidBlobKey) {
+		return c.JSON(http.StatusNotFound, failed("File not found"))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to read file"))
+	}
+	etag := `"` + object.SHA256 + `"`
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, object.ContentType, data)
+}
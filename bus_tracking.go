@@ -0,0 +1,652 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// roadDetourFactor converts straight line distance into road distance.
+	roadDetourFactor = 1.3
+	// defaultBusSpeedKmph is used when the bus is stopped or barely moving.
+	defaultBusSpeedKmph = 20
+	// stalePositionAfter marks a position as stale when no ping arrived.
+	stalePositionAfter = 2 * time.Minute
+	maxPingsPerDay     = 2880
+	maxPingsPerBatch   = 500
+	tripHistoryDays    = 30
+	streamHeartbeat    = 15 * time.Second
+)
+
+type GPSPingDto struct {
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	SpeedKmph  float64 `json:"speedKmph"`
+	Heading    float64 `json:"heading"`
+	RecordedAt string  `json:"recordedAt"`
+	RouteId    string  `json:"routeId"`
+}
+
+// GPSIngestDto accepts either a single ping or a batch of up to
+// maxPingsPerBatch buffered by a device that was offline.
+type GPSIngestDto struct {
+	GPSPingDto
+	Pings []GPSPingDto `json:"pings"`
+}
+
+type VehiclePositionModel struct {
+	VehicleId  string    `json:"vehicleId"`
+	RouteId    string    `json:"routeId"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	SpeedKmph  float64   `json:"speedKmph"`
+	Heading    float64   `json:"heading"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+type StopETAModel struct {
+	StopId     string  `json:"stopId"`
+	Name       string  `json:"name"`
+	Passed     bool    `json:"passed"`
+	DistanceKm float64 `json:"distanceKm"`
+	EtaMinutes int     `json:"etaMinutes"`
+	ExpectedAt string  `json:"expectedAt"`
+}
+
+type LiveLocationModel struct {
+	RouteId     string                `json:"routeId"`
+	RouteName   string                `json:"routeName"`
+	VehicleName string                `json:"vehicleName"`
+	Position    *VehiclePositionModel `json:"position"`
+	Stale       bool                  `json:"stale"`
+	MyStop      *StopETAModel         `json:"myStop"`
+	Stops       []StopETAModel        `json:"stops"`
+}
+
+type DeviceTokenRequestDto struct {
+	VehicleId string `json:"vehicleId"`
+}
+
+type trackedDevice struct {
+	SchoolId  string
+	VehicleId string
+}
+
+type busTrackingStore struct {
+	sync.RWMutex
+	devices     map[string]trackedDevice
+	latest      map[string]VehiclePositionModel
+	history     map[string]map[string][]VehiclePositionModel
+	subscribers map[string]map[chan VehiclePositionModel]bool
+}
+
+var busTracking = &busTrackingStore{
+	devices:     map[string]trackedDevice{},
+	latest:      map[string]VehiclePositionModel{},
+	history:     map[string]map[string][]VehiclePositionModel{},
+	subscribers: map[string]map[chan VehiclePositionModel]bool{},
+}
+
+// issueDeviceToken returns a new ingest token for the vehicle, revoking any
+// earlier one.
+func (s *busTrackingStore) issueDeviceToken(device trackedDevice) (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	s.Lock()
+	defer s.Unlock()
+	for token, existing := range s.devices {
+		if existing == device {
+			delete(s.devices, token)
+		}
+	}
+	token := hex.EncodeToString(raw)
+	s.devices[token] = device
+	return token, nil
+}
+
+func (s *busTrackingStore) device(token string) (trackedDevice, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	device, ok := s.devices[token]
+	return device, ok
+}
+
+// record stores a batch of positions of a vehicle in the trip history,
+// keeps the newest one as the latest position and notifies the stream
+// subscribers of the vehicle.
+func (s *busTrackingStore) record(vehicleId string, positions []VehiclePositionModel) {
+	if len(positions) == 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	days, ok := s.history[vehicleId]
+	if !ok {
+		days = map[string][]VehiclePositionModel{}
+		s.history[vehicleId] = days
+	}
+	touched := map[string]bool{}
+	newest := positions[0]
+	for _, position := range positions {
+		day := position.RecordedAt.Format(dateLayout)
+		days[day] = append(days[day], position)
+		touched[day] = true
+		if position.RecordedAt.After(newest.RecordedAt) {
+			newest = position
+		}
+	}
+	for day := range touched {
+		pings := days[day]
+		sort.SliceStable(pings, func(i, j int) bool { return pings[i].RecordedAt.Before(pings[j].RecordedAt) })
+		if len(pings) > maxPingsPerDay {
+			pings = pings[len(pings)-maxPingsPerDay:]
+		}
+		days[day] = pings
+	}
+	oldest := time.Now().AddDate(0, 0, -tripHistoryDays).Format(dateLayout)
+	for date := range days {
+		if date < oldest {
+			delete(days, date)
+		}
+	}
+
+	// Late pings from a batch only extend the history
+	if latest, ok := s.latest[vehicleId]; ok && !newest.RecordedAt.After(latest.RecordedAt) {
+		return
+	}
+	s.latest[vehicleId] = newest
+	for subscriber := range s.subscribers[vehicleId] {
+		select {
+		case subscriber <- newest:
+		default:
+			// A slow client skips this update and gets the next one
+		}
+	}
+}
+
+func (s *busTrackingStore) position(vehicleId string) (VehiclePositionModel, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	position, ok := s.latest[vehicleId]
+	return position, ok
+}
+
+// averageSpeed returns the mean speed of the last pings of the day.
+func (s *busTrackingStore) averageSpeed(vehicleId string, day time.Time) float64 {
+	s.RLock()
+	defer s.RUnlock()
+	pings := s.history[vehicleId][day.Format(dateLayout)]
+	if len(pings) > 5 {
+		pings = pings[len(pings)-5:]
+	}
+	total := 0.0
+	for _, ping := range pings {
+		total += ping.SpeedKmph
+	}
+	if len(pings) == 0 {
+		return 0
+	}
+	return total / float64(len(pings))
+}
+
+func (s *busTrackingStore) subscribe(vehicleId string) chan VehiclePositionModel {
+	s.Lock()
+	defer s.Unlock()
+	subscriber := make(chan VehiclePositionModel, 4)
+	if s.subscribers[vehicleId] == nil {
+		s.subscribers[vehicleId] = map[chan VehiclePositionModel]bool{}
+	}
+	s.subscribers[vehicleId][subscriber] = true
+	return subscriber
+}
+
+func (s *busTrackingStore) unsubscribe(vehicleId string, subscriber chan VehiclePositionModel) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.subscribers[vehicleId], subscriber)
+}
+
+// haversineKm returns the great circle distance between two points.
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLng := toRad(lat2-lat1), toRad(lng2-lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// estimateStops estimates when the bus reaches each stop of the route. The
+// bus is placed on the route by its nearest stop; stops before that are
+// passed and later ones are reached in the order of route.Stops.
+func estimateStops(route RouteModel, position VehiclePositionModel, speedKmph float64) []StopETAModel {
+	stops := make([]StopETAModel, len(route.Stops))
+	for i, stop := range route.Stops {
+		stops[i] = StopETAModel{StopId: stop.Id, Name: stop.Name, EtaMinutes: -1}
+	}
+	for _, stop := range route.Stops {
+		if stop.Latitude == 0 && stop.Longitude == 0 {
+			// ETAs need the coordinates of every stop
+			return stops
+		}
+	}
+	if len(route.Stops) == 0 {
+		return stops
+	}
+
+	distanceTo := func(i int) float64 {
+		return haversineKm(position.Latitude, position.Longitude, route.Stops[i].Latitude, route.Stops[i].Longitude)
+	}
+	segment := func(i int) float64 {
+		a, b := route.Stops[i], route.Stops[i+1]
+		return haversineKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+	}
+	next := 0
+	for i := range route.Stops {
+		if distanceTo(i) < distanceTo(next) {
+			next = i
+		}
+	}
+	// Past the nearest stop when the bus is closer to the following stop
+	// than the nearest stop itself is
+	if next+1 < len(route.Stops) && distanceTo(next+1) < segment(next) {
+		next++
+	}
+
+	if speedKmph < 5 {
+		speedKmph = defaultBusSpeedKmph
+	}
+	distance := 0.0
+	for i := range stops {
+		if i < next {
+			stops[i].Passed = true
+			continue
+		}
+		if i == next {
+			distance = distanceTo(i) * roadDetourFactor
+		} else {
+			distance += segment(i-1) * roadDetourFactor
+		}
+		minutes := int(math.Ceil(distance / speedKmph * 60))
+		stops[i].DistanceKm = math.Round(distance*100) / 100
+		stops[i].EtaMinutes = minutes
+		stops[i].ExpectedAt = position.RecordedAt.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)
+	}
+	return stops
+}
+
+// activeRoute picks the route a vehicle is driving: the one named by the
+// ping, else the one whose schedule includes now, else its only route.
+// Callers must hold the transport lock.
+func (t *schoolTransport) activeRoute(vehicleId, routeId string, now time.Time) string {
+	if route, ok := t.routes[routeId]; ok && route.VehicleId == vehicleId {
+		return routeId
+	}
+	clock := now.Format("15:04")
+	var only []string
+	for _, route := range t.routes {
+		if route.VehicleId != vehicleId || len(route.Stops) == 0 {
+			continue
+		}
+		only = append(only, route.Id)
+		if morning, afternoon := routeRuns(*route, clock); morning || afternoon {
+			return route.Id
+		}
+	}
+	if len(only) == 1 {
+		return only[0]
+	}
+	return ""
+}
+
+// routeRuns reports whether an "HH:MM" clock falls in the route's morning
+// pickup run or its afternoon drop run. The route must have stops.
+func routeRuns(route RouteModel, clock string) (morning, afternoon bool) {
+	first, last := route.Stops[0], route.Stops[len(route.Stops)-1]
+	morning = clock >= shiftClock(first.PickupTime, -30) && clock <= shiftClock(last.PickupTime, 60)
+	afternoon = clock >= shiftClock(minClock(first.DropTime, last.DropTime), -60) && clock <= shiftClock(maxClock(first.DropTime, last.DropTime), 30)
+	return morning, afternoon
+}
+
+// driveOrder returns the route with its stops in the order the bus reaches
+// them at now: pickup order, or drop time order on the afternoon run.
+func driveOrder(route RouteModel, now time.Time) RouteModel {
+	if len(route.Stops) == 0 {
+		return route
+	}
+	if morning, afternoon := routeRuns(route, now.Format("15:04")); morning || !afternoon {
+		return route
+	}
+	route.Stops = append([]RouteStopModel{}, route.Stops...)
+	sort.SliceStable(route.Stops, func(i, j int) bool { return route.Stops[i].DropTime < route.Stops[j].DropTime })
+	return route
+}
+
+// shiftClock moves an "HH:MM" time by minutes within the same day.
+func shiftClock(clock string, minutes int) string {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return clock
+	}
+	shifted := parsed.Add(time.Duration(minutes) * time.Minute)
+	if shifted.Day() != parsed.Day() {
+		if minutes < 0 {
+			return "00:00"
+		}
+		return "23:59"
+	}
+	return shifted.Format("15:04")
+}
+
+func minClock(a, b string) string {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxClock(a, b string) string {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// liveLocation builds the live view of a route, with ETAs relative to the
+// given stop when it is set.
+func liveLocation(schoolId, routeId, stopId string) (LiveLocationModel, bool) {
+	transport.RLock()
+	school, ok := transport.schools[schoolId]
+	if !ok {
+		transport.RUnlock()
+		return LiveLocationModel{}, false
+	}
+	route, ok := school.routes[routeId]
+	if !ok {
+		transport.RUnlock()
+		return LiveLocationModel{}, false
+	}
+	view := school.routeView(*route)
+	vehicleName := ""
+	if vehicle, ok := school.vehicles[route.VehicleId]; ok {
+		vehicleName = vehicle.Name
+	}
+	transport.RUnlock()
+
+	live := LiveLocationModel{RouteId: view.Id, RouteName: view.Name, VehicleName: vehicleName, Stops: []StopETAModel{}}
+	position, ok := busTracking.position(view.VehicleId)
+	if !ok || position.RouteId != view.Id {
+		// The bus is not on this route right now
+		return live, true
+	}
+	live.Position = &position
+	live.Stale = time.Since(position.RecordedAt) > stalePositionAfter
+	live.Stops = estimateStops(driveOrder(view, position.RecordedAt.Local()), position, busTracking.averageSpeed(view.VehicleId, position.RecordedAt))
+	for i := range live.Stops {
+		if live.Stops[i].StopId == stopId {
+			live.MyStop = &live.Stops[i]
+		}
+	}
+	return live, true
+}
+
+func vehicleExists(schoolId, vehicleId string) bool {
+	transport.RLock()
+	defer transport.RUnlock()
+	school, ok := transport.schools[schoolId]
+	return ok && school.vehicles[vehicleId] != nil
+}
+
+// studentRoute returns the route and stop a student is assigned to.
+func studentRoute(schoolId, studentId string) (TransportAssignmentModel, bool) {
+	transport.RLock()
+	defer transport.RUnlock()
+	school, ok := transport.schools[schoolId]
+	if !ok {
+		return TransportAssignmentModel{}, false
+	}
+	assignment, ok := school.assignments[studentId]
+	if !ok {
+		return TransportAssignmentModel{}, false
+	}
+	return *assignment, true
+}
+
+// IssueDeviceTokenHandler issues the token a vehicle's GPS device or driver
+// app sends in the X-Device-Token header. Issuing again revokes the old one.
+func IssueDeviceTokenHandler(c echo.Context) error {
+	var req DeviceTokenRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	if !vehicleExists(schoolID(claims), req.VehicleId) {
+		return c.JSON(http.StatusNotFound, failed("Vehicle "+req.VehicleId+" does not exist"))
+	}
+	token, err := busTracking.issueDeviceToken(trackedDevice{SchoolId: schoolID(claims), VehicleId: req.VehicleId})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to issue device token"))
+	}
+	return c.JSON(http.StatusOK, success(map[string]string{"vehicleId": req.VehicleId, "deviceToken": token}))
+}
+
+// GPSPingHandler ingests pings from a vehicle device.
+func GPSPingHandler(c echo.Context) error {
+	device, ok := busTracking.device(c.Request().Header.Get("X-Device-Token"))
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, BaseResponse{
+			Status:  "UNAUTHORIZED",
+			Message: "Invalid device token",
+			Errors:  []string{"Invalid device token"},
+		})
+	}
+	var req GPSIngestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	pings := req.Pings
+	if len(pings) == 0 {
+		pings = []GPSPingDto{req.GPSPingDto}
+	}
+	if len(pings) > maxPingsPerBatch {
+		return c.JSON(http.StatusRequestEntityTooLarge, failed(fmt.Sprintf("Send at most %d pings per request", maxPingsPerBatch)))
+	}
+
+	now := time.Now()
+	var positions []VehiclePositionModel
+	var errs []string
+	for i, ping := range pings {
+		recordedAt := now
+		if ping.RecordedAt != "" {
+			parsed, err := time.Parse(time.RFC3339, ping.RecordedAt)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("pings[%d].recordedAt must be an RFC 3339 time", i))
+				continue
+			}
+			recordedAt = parsed
+		}
+		switch {
+		case ping.Latitude < -90 || ping.Latitude > 90 || ping.Longitude < -180 || ping.Longitude > 180:
+			errs = append(errs, fmt.Sprintf("pings[%d] has invalid coordinates", i))
+		case ping.SpeedKmph < 0 || ping.SpeedKmph > 150:
+			errs = append(errs, fmt.Sprintf("pings[%d].speedKmph is out of range", i))
+		case recordedAt.After(now.Add(time.Minute)) || recordedAt.Before(now.Add(-24*time.Hour)):
+			errs = append(errs, fmt.Sprintf("pings[%d].recordedAt must be within the last 24 hours", i))
+		default:
+			positions = append(positions, VehiclePositionModel{
+				VehicleId:  device.VehicleId,
+				RouteId:    ping.RouteId,
+				Latitude:   ping.Latitude,
+				Longitude:  ping.Longitude,
+				SpeedKmph:  ping.SpeedKmph,
+				Heading:    ping.Heading,
+				RecordedAt: recordedAt.UTC(),
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid GPS data", errs...))
+	}
+
+	transport.RLock()
+	school := transport.schools[device.SchoolId]
+	for i := range positions {
+		if school != nil {
+			positions[i].RouteId = school.activeRoute(device.VehicleId, positions[i].RouteId, positions[i].RecordedAt.Local())
+		}
+	}
+	transport.RUnlock()
+	busTracking.record(device.VehicleId, positions)
+	return c.JSON(http.StatusOK, success(map[string]int{"accepted": len(positions)}))
+}
+
+// LiveLocationHandler returns where the bus of a route is now, with ETAs.
+// For a student, given by studentId or the login's active student, the
+// route and stop are the student's. Only staff may ask for a route by
+// routeId.
+func LiveLocationHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	studentId, status, failure := pageStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	routeId, stopId := c.QueryParam("routeId"), ""
+	if studentId != "" {
+		assignment, ok := studentRoute(schoolID(claims), studentId)
+		if !ok {
+			return c.JSON(http.StatusNotFound, failed("Student "+studentId+" does not use school transport"))
+		}
+		routeId, stopId = assignment.RouteId, assignment.StopId
+	}
+	live, ok := liveLocation(schoolID(claims), routeId, stopId)
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Route not found"))
+	}
+	return c.JSON(http.StatusOK, success(live))
+}
+
+// LiveLocationStreamHandler streams the student's bus as Server-Sent Events.
+// A "location" event is sent on connect and for every new position.
+func LiveLocationStreamHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	assignment, ok := studentRoute(schoolID(claims), studentId)
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Student "+studentId+" does not use school transport"))
+	}
+	transport.RLock()
+	route, ok := transport.schools[schoolID(claims)].routes[assignment.RouteId]
+	transport.RUnlock()
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Route not found"))
+	}
+	vehicleId := route.VehicleId
+
+	updates := busTracking.subscribe(vehicleId)
+	defer busTracking.unsubscribe(vehicleId, updates)
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set("Connection", "keep-alive")
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+
+	send := func() error {
+		live, ok := liveLocation(schoolID(claims), assignment.RouteId, assignment.StopId)
+		if !ok {
+			return fmt.Errorf("route %s was removed", assignment.RouteId)
+		}
+		payload, err := json.Marshal(live)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(response, "event: location\ndata: %s\n\n", payload); err != nil {
+			return err
+		}
+		response.Flush()
+		return nil
+	}
+	if err := send(); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case position := <-updates:
+			if position.RouteId != assignment.RouteId {
+				continue
+			}
+			if err := send(); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(response, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			response.Flush()
+		}
+	}
+}
+
+// TripHistoryHandler returns a vehicle's positions for a UTC day (default
+// today).
+func TripHistoryHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	day := time.Now().UTC().Format(dateLayout)
+	if date := c.QueryParam("date"); date != "" {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return c.JSON(http.StatusBadRequest, failed("date must be YYYY-MM-DD"))
+		}
+		day = date
+	}
+
+	vehicleId := c.QueryParam("vehicleId")
+	if !vehicleExists(schoolID(claims), vehicleId) {
+		return c.JSON(http.StatusNotFound, failed("Vehicle "+vehicleId+" does not exist"))
+	}
+	busTracking.RLock()
+	pings := append([]VehiclePositionModel{}, busTracking.history[vehicleId][day]...)
+	busTracking.RUnlock()
+	return c.JSON(http.StatusOK, success(map[string]interface{}{
+		"vehicleId": vehicleId,
+		"date":      day,
+		"positions": pings,
+	}))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func resetBusTracking() {
+	busTracking = &busTrackingStore{
+		devices:     map[string]trackedDevice{},
+		latest:      map[string]VehiclePositionModel{},
+		history:     map[string]map[string][]VehiclePositionModel{},
+		subscribers: map[string]map[chan VehiclePositionModel]bool{},
+	}
+}
+
+func TestBusTrackingRecord(t *testing.T) {
+	resetBusTracking()
+	subscriber := busTracking.subscribe("VEH-1")
+	defer busTracking.unsubscribe("VEH-1", subscriber)
+
+	day := time.Now().UTC().Truncate(24 * time.Hour).Add(8 * time.Hour)
+	at := func(minutes int) VehiclePositionModel {
+		return VehiclePositionModel{VehicleId: "VEH-1", RecordedAt: day.Add(time.Duration(minutes) * time.Minute)}
+	}
+	history := func() []int {
+		busTracking.RLock()
+		defer busTracking.RUnlock()
+		var minutes []int
+		for _, ping := range busTracking.history["VEH-1"][day.Format(dateLayout)] {
+			minutes = append(minutes, int(ping.RecordedAt.Sub(day).Minutes()))
+		}
+		return minutes
+	}
+
+	// A buffered batch arrives out of order
+	busTracking.record("VEH-1", []VehiclePositionModel{at(3), at(1), at(2)})
+	if got := history(); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("history = %v, want sorted", got)
+	}
+	if latest, _ := busTracking.position("VEH-1"); !latest.RecordedAt.Equal(at(3).RecordedAt) {
+		t.Errorf("latest = %v", latest.RecordedAt)
+	}
+	if update := <-subscriber; !update.RecordedAt.Equal(at(3).RecordedAt) {
+		t.Errorf("subscriber got %v", update.RecordedAt)
+	}
+
+	// Late pings fill the history in but leave the latest position alone
+	busTracking.record("VEH-1", []VehiclePositionModel{at(0), at(2)})
+	if got := history(); len(got) != 5 || got[0] != 0 || got[4] != 3 {
+		t.Errorf("history = %v", got)
+	}
+	if latest, _ := busTracking.position("VEH-1"); !latest.RecordedAt.Equal(at(3).RecordedAt) {
+		t.Errorf("a late ping moved the bus back to %v", latest.RecordedAt)
+	}
+	select {
+	case update := <-subscriber:
+		t.Errorf("late pings were streamed: %v", update.RecordedAt)
+	default:
+	}
+
+	// A day keeps only its newest pings
+	var full []VehiclePositionModel
+	for i := 0; i < maxPingsPerDay+10; i++ {
+		ping := at(0)
+		ping.RecordedAt = ping.RecordedAt.Add(time.Duration(i) * time.Second)
+		full = append(full, ping)
+	}
+	busTracking.record("VEH-1", full)
+	if got := history(); len(got) != maxPingsPerDay {
+		t.Errorf("history has %d pings, want %d", len(got), maxPingsPerDay)
+	}
+}
+
+func TestGPSPingHandler(t *testing.T) {
+	resetBusTracking()
+	token, err := busTracking.issueDeviceToken(trackedDevice{SchoolId: "SCH-GPS", VehicleId: "VEH-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := busTracking.device(token); !ok {
+		t.Fatal("issued token is unknown")
+	}
+	reissued, _ := busTracking.issueDeviceToken(trackedDevice{SchoolId: "SCH-GPS", VehicleId: "VEH-1"})
+
+	recent := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	batch := func(count int) string {
+		pings := make([]string, count)
+		for i := range pings {
+			pings[i] = `{"latitude":12.9,"longitude":77.6,"recordedAt":"` + recent + `"}`
+		}
+		return `{"pings":[` + strings.Join(pings, ",") + `]}`
+	}
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{"revoked token", token, `{"latitude":12.9,"longitude":77.6}`, http.StatusUnauthorized},
+		{"single ping", reissued, `{"latitude":12.9,"longitude":77.6,"speedKmph":30}`, http.StatusOK},
+		{"batch", reissued, batch(3), http.StatusOK},
+		{"batch over the cap", reissued, batch(maxPingsPerBatch + 1), http.StatusRequestEntityTooLarge},
+		{"bad coordinates", reissued, `{"latitude":91,"longitude":77.6}`, http.StatusBadRequest},
+		{"too fast", reissued, `{"latitude":12.9,"longitude":77.6,"speedKmph":200}`, http.StatusBadRequest},
+		{"too old", reissued, `{"latitude":12.9,"longitude":77.6,"recordedAt":"2020-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"bad time", reissued, `{"latitude":12.9,"longitude":77.6,"recordedAt":"08:00"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/transport/gps", strings.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("X-Device-Token", test.token)
+			rec := httptest.NewRecorder()
+			if err := GPSPingHandler(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, test.status, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestEstimateStops(t *testing.T) {
+	route := RouteModel{Stops: []RouteStopModel{
+		{Id: "A", Latitude: 12.9, Longitude: 77.60},
+		{Id: "B", Latitude: 12.9, Longitude: 77.61},
+		{Id: "C", Latitude: 12.9, Longitude: 77.62},
+	}}
+	tests := []struct {
+		name      string
+		longitude float64
+		speed     float64
+		passed    []bool
+		eta       []int
+	}{
+		{"before the first stop", 77.59, 30, []bool{false, false, false}, []int{3, 6, 9}},
+		{"nearest to B, short of it", 77.606, 30, []bool{true, false, false}, []int{-1, 2, 4}},
+		{"past B", 77.614, 30, []bool{true, true, false}, []int{-1, -1, 2}},
+		{"stopped bus uses the default speed", 77.614, 0, []bool{true, true, false}, []int{-1, -1, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stops := estimateStops(route, VehiclePositionModel{Latitude: 12.9, Longitude: test.longitude}, test.speed)
+			for i, stop := range stops {
+				if stop.Passed != test.passed[i] || stop.EtaMinutes != test.eta[i] {
+					t.Errorf("stop %s = passed %v, eta %d; want %v, %d", stop.StopId, stop.Passed, stop.EtaMinutes, test.passed[i], test.eta[i])
+				}
+			}
+		})
+	}
+
+	// Without coordinates for every stop there are no ETAs
+	route.Stops[1].Latitude, route.Stops[1].Longitude = 0, 0
+	for _, stop := range estimateStops(route, VehiclePositionModel{Latitude: 12.9, Longitude: 77.6}, 30) {
+		if stop.EtaMinutes != -1 || stop.Passed {
+			t.Errorf("stop %s = %+v", stop.StopId, stop)
+		}
+	}
+}
+
+func TestActiveRoute(t *testing.T) {
+	school := &schoolTransport{routes: map[string]*RouteModel{
+		"R-AM": {Id: "R-AM", VehicleId: "VEH-1", Stops: []RouteStopModel{{PickupTime: "07:00", DropTime: "16:30"}, {PickupTime: "07:40", DropTime: "16:00"}}},
+		"R-PM": {Id: "R-PM", VehicleId: "VEH-1", Stops: []RouteStopModel{{PickupTime: "09:00", DropTime: "18:30"}, {PickupTime: "09:30", DropTime: "18:00"}}},
+		"R-2":  {Id: "R-2", VehicleId: "VEH-2", Stops: []RouteStopModel{{PickupTime: "07:00", DropTime: "16:00"}}},
+	}}
+	tests := []struct {
+		name, vehicle, routeId, clock, want string
+	}{
+		{"named by the ping", "VEH-1", "R-PM", "07:10", "R-PM"},
+		{"named route of another vehicle", "VEH-2", "R-AM", "03:00", "R-2"},
+		{"morning schedule", "VEH-1", "", "06:45", "R-AM"},
+		{"afternoon schedule", "VEH-1", "", "18:40", "R-PM"},
+		{"out of schedule with two routes", "VEH-1", "", "03:00", ""},
+		{"only route", "VEH-2", "", "03:00", "R-2"},
+	}
+	for _, test := range tests {
+		now, _ := time.Parse("15:04", test.clock)
+		if got := school.activeRoute(test.vehicle, test.routeId, now); got != test.want {
+			t.Errorf("%s: activeRoute = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDriveOrder(t *testing.T) {
+	route := RouteModel{Stops: []RouteStopModel{
+		{Id: "A", PickupTime: "07:00", DropTime: "16:40"},
+		{Id: "B", PickupTime: "07:15", DropTime: "16:20"},
+		{Id: "C", PickupTime: "07:30", DropTime: "16:00"},
+	}}
+	tests := []struct {
+		clock string
+		want  string
+	}{
+		{"07:05", "ABC"},
+		{"15:30", "CBA"},
+		{"16:30", "CBA"},
+		{"12:00", "ABC"},
+	}
+	for _, test := range tests {
+		now, _ := time.Parse("15:04", test.clock)
+		got := ""
+		for _, stop := range driveOrder(route, now).Stops {
+			got += stop.Id
+		}
+		if got != test.want {
+			t.Errorf("driveOrder at %s = %s, want %s", test.clock, got, test.want)
+		}
+	}
+	if route.Stops[0].Id != "A" {
+		t.Errorf("driveOrder reordered the route's own stops")
+	}
+
+	// On the drop run the bus near C has passed nothing yet, and A comes last
+	afternoon, _ := time.Parse("15:04", "15:55")
+	stops := estimateStops(driveOrder(RouteModel{Stops: []RouteStopModel{
+		{Id: "A", Latitude: 12.9, Longitude: 77.60, PickupTime: "07:00", DropTime: "16:40"},
+		{Id: "B", Latitude: 12.9, Longitude: 77.61, PickupTime: "07:15", DropTime: "16:20"},
+		{Id: "C", Latitude: 12.9, Longitude: 77.62, PickupTime: "07:30", DropTime: "16:00"},
+	}}, afternoon), VehiclePositionModel{Latitude: 12.9, Longitude: 77.621}, 30)
+	if stops[0].StopId != "C" || stops[0].Passed || stops[2].StopId != "A" || stops[2].Passed || stops[2].EtaMinutes <= stops[1].EtaMinutes {
+		t.Errorf("drop run stops = %+v", stops)
+	}
+}
+
+func TestDropTimesInOrder(t *testing.T) {
+	stops := func(drops ...string) []RouteStopModel {
+		list := make([]RouteStopModel, len(drops))
+		for i, drop := range drops {
+			list[i].DropTime = drop
+		}
+		return list
+	}
+	tests := []struct {
+		name  string
+		stops []RouteStopModel
+		want  bool
+	}{
+		{"with the stops", stops("15:30", "15:45", "16:00"), true},
+		{"against the stops", stops("16:00", "15:45", "15:30"), true},
+		{"one stop", stops("16:00"), true},
+		{"zigzag", stops("15:30", "16:00", "15:45"), false},
+		{"malformed", stops("15:30", "4pm", "15:00"), true},
+	}
+	for _, test := range tests {
+		if got := dropTimesInOrder(test.stops); got != test.want {
+			t.Errorf("%s: dropTimesInOrder = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestShiftClock(t *testing.T) {
+	tests := []struct {
+		clock   string
+		minutes int
+		want    string
+	}{
+		{"07:30", -30, "07:00"},
+		{"07:30", 45, "08:15"},
+		{"00:10", -30, "00:00"},
+		{"23:50", 30, "23:59"},
+		{"", 30, ""},
+	}
+	for _, test := range tests {
+		if got := shiftClock(test.clock, test.minutes); got != test.want {
+			t.Errorf("shiftClock(%q, %d) = %q, want %q", test.clock, test.minutes, got, test.want)
+		}
+	}
+}
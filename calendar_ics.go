@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CalendarEntry is one all-day item rendered into the ICS output.
+type CalendarEntry struct {
+	Date     time.Time
+	Summary  string
+	Category string
+}
+
+type CalendarFeedModel struct {
+	FeedURL    string `json:"feedUrl"`
+	WebcalURL  string `json:"webcalUrl"`
+	ExportHint string `json:"exportHint"`
+}
+
+type calendarFeedOwner struct {
+	UserId string
+	Role   string
+}
+
+type calendarFeedStore struct {
+	sync.RWMutex
+	byToken map[string]calendarFeedOwner
+	byUser  map[string]string
+}
+
+var calendarFeeds = &calendarFeedStore{
+	byToken: map[string]calendarFeedOwner{},
+	byUser:  map[string]string{},
+}
+
+// tokenFor returns the user's feed token, issuing a new one when the user
+// has none or rotate is set. Rotating invalidates the previous URL.
+func (s *calendarFeedStore) tokenFor(owner calendarFeedOwner, rotate bool) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if token, ok := s.byUser[owner.UserId]; ok {
+		if !rotate {
+			return token, nil
+		}
+		delete(s.byToken, token)
+	}
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	s.byToken[token] = owner
+	s.byUser[owner.UserId] = token
+	return token, nil
+}
+
+func (s *calendarFeedStore) owner(token string) (calendarFeedOwner, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	owner, ok := s.byToken[token]
+	return owner, ok
+}
+
+// calendarEntries flattens the user's calendar into events, holidays, exam
+// dates and homework due dates. Recurring events are expanded within
+// [from, to).
+func calendarEntries(userId, role string, from, to time.Time) []CalendarEntry {
+	calendar := buildCalendar(userId, role, from, to)
+	var entries []CalendarEntry
+
+	for key, day := range calendar.Events {
+		date, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			continue
+		}
+		for _, event := range day.Events {
+			entries = append(entries, CalendarEntry{Date: date, Summary: event, Category: "EVENT"})
+		}
+	}
+	for key, holidays := range calendar.Holidays {
+		date, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			continue
+		}
+		for _, holiday := range holidays {
+			entries = append(entries, CalendarEntry{Date: date, Summary: holiday.Name, Category: "HOLIDAY"})
+		}
+	}
+	for exam, marks := range fillGenericAcademicStatsModel("").MarksModel.MarksData {
+		for _, mark := range marks {
+			date, err := time.Parse(dateLayout, mark.TestDate)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, CalendarEntry{Date: date, Summary: exam + ": " + mark.SubjectName, Category: "EXAM"})
+		}
+	}
+	for _, homework := range fillGenericStudentHomeworkViewModel() {
+		date, err := time.Parse(dateLayout, homework.Date)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, CalendarEntry{Date: date, Summary: homework.Heading + " homework due: " + homework.SubHeading, Category: "HOMEWORK"})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Date.Equal(entries[j].Date) {
+			return entries[i].Date.Before(entries[j].Date)
+		}
+		if entries[i].Category != entries[j].Category {
+			return entries[i].Category < entries[j].Category
+		}
+		return entries[i].Summary < entries[j].Summary
+	})
+	return entries
+}
+
+// renderICS serialises entries as an RFC 5545 VCALENDAR.
+func renderICS(name string, entries []CalendarEntry) string {
+	var b strings.Builder
+	writeLine := func(line string) {
+		// Fold lines longer than 75 octets without splitting UTF-8 sequences
+		for len(line) > 75 {
+			cut := 75
+			for cut > 0 && line[cut]&0xC0 == 0x80 {
+				cut--
+			}
+			b.WriteString(line[:cut] + "\r\n")
+			line = " " + line[cut:]
+		}
+		b.WriteString(line + "\r\n")
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//AcademicHub//School Calendar//EN")
+	writeLine("CALSCALE:GREGORIAN")
+	writeLine("METHOD:PUBLISH")
+	writeLine("X-WR-CALNAME:" + escapeICSText(name))
+	seen := map[string]int{}
+	for _, entry := range entries {
+		// Identical entries on the same day (e.g. a repeated event name)
+		// still need distinct, stable UIDs.
+		base := fmt.Sprintf("%s|%s|%s", entry.Date.Format(dateLayout), entry.Category, entry.Summary)
+		seen[base]++
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d", base, seen[base])))
+		writeLine("BEGIN:VEVENT")
+		writeLine("UID:" + hex.EncodeToString(sum[:10]) + "@academichub")
+		writeLine("DTSTAMP:" + stamp)
+		writeLine("DTSTART;VALUE=DATE:" + entry.Date.Format("20060102"))
+		writeLine("DTEND;VALUE=DATE:" + entry.Date.AddDate(0, 0, 1).Format("20060102"))
+		writeLine("SUMMARY:" + escapeICSText(entry.Summary))
+		writeLine("CATEGORIES:" + entry.Category)
+		writeLine("TRANSP:TRANSPARENT")
+		writeLine("END:VEVENT")
+	}
+	writeLine("END:VCALENDAR")
+	return b.String()
+}
+
+// escapeICSText escapes a TEXT value. Bare CRs and CRLFs become LF first so
+// no raw line break can end the content line early.
+func escapeICSText(value string) string {
+	value = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(value)
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
+
+func calendarFeedModel(c echo.Context, token string) CalendarFeedModel {
+	path := c.Request().Host + "/calendar/feed/" + token
+	return CalendarFeedModel{
+		FeedURL:    c.Scheme() + "://" + path,
+		WebcalURL:  "webcal://" + path,
+		ExportHint: "/calendar/export?month=YYYY-MM",
+	}
+}
+
+// CalendarFeedURLHandler returns the caller's personal subscription URL.
+func CalendarFeedURLHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	token, err := calendarFeeds.tokenFor(calendarFeedOwner{
+		UserId: claimString(claims, "id"),
+		Role:   claimString(claims, "user_role"),
+	}, false)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to issue feed token"))
+	}
+	return c.JSON(http.StatusOK, success(calendarFeedModel(c, token)))
+}
+
+// ResetCalendarFeedURLHandler rotates the caller's feed token, revoking the
+// previously shared URL.
+func ResetCalendarFeedURLHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	token, err := calendarFeeds.tokenFor(calendarFeedOwner{
+		UserId: claimString(claims, "id"),
+		Role:   claimString(claims, "user_role"),
+	}, true)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to issue feed token"))
+	}
+	return c.JSON(http.StatusOK, success(calendarFeedModel(c, token)))
+}
+
+// CalendarFeedHandler serves the subscription feed. Calendar apps cannot send
+// a Bearer token, so the unguessable path token is the credential.
+func CalendarFeedHandler(c echo.Context) error {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+	owner, ok := calendarFeeds.owner(token)
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Calendar feed not found"))
+	}
+	// Subscribers get recurring events from a month back to a year ahead
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	entries := calendarEntries(owner.UserId, owner.Role, thisMonth.AddDate(0, -1, 0), thisMonth.AddDate(1, 0, 0))
+	body := renderICS("School Calendar", entries)
+	c.Response().Header().Set("Cache-Control", "private, max-age=900")
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(body))
+}
+
+// CalendarExportHandler downloads a one-off .ics file for a single month.
+func CalendarExportHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	month, err := time.Parse("2006-01", c.QueryParam("month"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("month must use the YYYY-MM format"))
+	}
+
+	var entries []CalendarEntry
+	for _, entry := range calendarEntries(claimString(claims, "id"), claimString(claims, "user_role"), month, month.AddDate(0, 1, 0)) {
+		if entry.Date.Year() == month.Year() && entry.Date.Month() == month.Month() {
+			entries = append(entries, entry)
+		}
+	}
+	name := "School Calendar " + month.Format("January 2006")
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="calendar-%s.ics"`, month.Format("2006-01")))
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(renderICS(name, entries)))
+}
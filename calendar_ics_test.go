@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestEscapeICSText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Sports Day", "Sports Day"},
+		{"Maths; Science, English", `Maths\; Science\, English`},
+		{`C:\path`, `C:\\path`},
+		{"line one\nline two", `line one\nline two`},
+		{"line one\r\nline two", `line one\nline two`},
+		{"line one\rline two", `line one\nline two`},
+		{"a\r\n\r\nb", `a\n\nb`},
+	}
+	for _, test := range tests {
+		if got := escapeICSText(test.in); got != test.want {
+			t.Errorf("escapeICSText(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRenderICS(t *testing.T) {
+	date := time.Date(2030, 3, 11, 0, 0, 0, 0, time.UTC)
+	long := strings.Repeat("अभिभावक बैठक ", 10)
+	entries := []CalendarEntry{
+		{Date: date, Summary: "Annual Day\r\nAll welcome", Category: "EVENT"},
+		{Date: date, Summary: "Annual Day\r\nAll welcome", Category: "EVENT"},
+		{Date: date, Summary: long, Category: "EVENT"},
+	}
+	ics := renderICS("Class 10, A", entries)
+
+	if !strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(ics, "END:VCALENDAR\r\n") {
+		t.Fatalf("not a VCALENDAR:\n%s", ics)
+	}
+	if strings.Count(ics, "BEGIN:VEVENT") != len(entries) {
+		t.Errorf("got %d events, want %d", strings.Count(ics, "BEGIN:VEVENT"), len(entries))
+	}
+	if strings.Contains(strings.ReplaceAll(ics, "\r\n", ""), "\r") || strings.Contains(strings.ReplaceAll(ics, "\r\n", ""), "\n") {
+		t.Errorf("bare line break in output:\n%q", ics)
+	}
+	for _, want := range []string{
+		"X-WR-CALNAME:Class 10\\, A\r\n",
+		"DTSTART;VALUE=DATE:20300311\r\n",
+		"DTEND;VALUE=DATE:20300312\r\n",
+		"SUMMARY:Annual Day\\nAll welcome\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("missing %q in:\n%s", want, ics)
+		}
+	}
+
+	uids := map[string]bool{}
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets is not folded: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("folding split a UTF-8 sequence: %q", line)
+		}
+		if strings.HasPrefix(line, "UID:") {
+			if uids[line] {
+				t.Errorf("duplicate %s", line)
+			}
+			uids[line] = true
+		}
+	}
+
+	unfolded := strings.ReplaceAll(ics, "\r\n ", "")
+	if !strings.Contains(unfolded, "SUMMARY:"+long+"\r\n") {
+		t.Errorf("long summary does not unfold back to the original")
+	}
+}
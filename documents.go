@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxDocumentSize = 5 << 20
+
+	documentPending  = "PENDING"
+	documentVerified = "VERIFIED"
+	documentRejected = "REJECTED"
+
+	defaultDocumentReminderDays = 30
+)
+
+// documentExtensions are the file types accepted for student documents.
+var documentExtensions = map[string]string{
+	"application/pdf": "pdf",
+	"image/jpeg":      "jpg",
+	"image/png":       "png",
+}
+
+// DocumentTypeModel is a kind of document a school collects. Documents of
+// types that expire need an expiry date; reminders start ReminderDays
+// before it.
+type DocumentTypeModel struct {
+	Name         string `json:"name"`
+	Expires      bool   `json:"expires"`
+	ReminderDays int    `json:"reminderDays,omitempty"`
+	Required     bool   `json:"required"`
+}
+
+// DocumentVersionModel is one uploaded file of a document. Each version is
+// verified on its own.
+type DocumentVersionModel struct {
+	Version         int    `json:"version"`
+	FileName        string `json:"fileName"`
+	ContentType     string `json:"contentType"`
+	Size            int64  `json:"size"`
+	ExpiresOn       string `json:"expiresOn,omitempty"`
+	Status          string `json:"status"`
+	RejectionReason string `json:"rejectionReason,omitempty"`
+	ReviewedBy      string `json:"reviewedBy,omitempty"`
+	ReviewedAt      string `json:"reviewedAt,omitempty"`
+	UploadedBy      string `json:"uploadedBy"`
+	UploadedAt      string `json:"uploadedAt"`
+	DownloadURL     string `json:"downloadUrl,omitempty"`
+
+	mediaId string
+}
+
+// StudentDocumentModel holds every version of one document type of a
+// student, newest last.
+type StudentDocumentModel struct {
+	Id        string                 `json:"id"`
+	StudentId string                 `json:"studentId"`
+	Type      string                 `json:"type"`
+	Current   DocumentVersionModel   `json:"current"`
+	Versions  []DocumentVersionModel `json:"versions"`
+}
+
+type DocumentVerifyRequestDto struct {
+	DocumentId string `json:"documentId"`
+	Version    int    `json:"version"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason"`
+}
+
+type DocumentReminderModel struct {
+	DocumentId  string `json:"documentId"`
+	StudentId   string `json:"studentId"`
+	StudentName string `json:"studentName"`
+	Type        string `json:"type"`
+	ExpiresOn   string `json:"expiresOn"`
+	DaysLeft    int    `json:"daysLeft"`
+	Expired     bool   `json:"expired"`
+}
+
+func defaultDocumentTypes() []DocumentTypeModel {
+	return []DocumentTypeModel{
+		{Name: "ADHAAR", Required: true},
+		{Name: "Birth Certificate", Required: true},
+		{Name: "Transfer Certificate"},
+		{Name: "10th results"},
+		{Name: "Medical Certificate", Expires: true, ReminderDays: defaultDocumentReminderDays},
+		{Name: "Passport", Expires: true, ReminderDays: 90},
+	}
+}
+
+type documentStore struct {
+	sync.RWMutex
+	types     map[string][]DocumentTypeModel
+	documents map[string]map[string]*StudentDocumentModel
+}
+
+var studentDocuments = &documentStore{types: map[string][]DocumentTypeModel{}, documents: map[string]map[string]*StudentDocumentModel{}}
+
+// schoolTypes returns the school's document types, seeding the defaults.
+// Callers must hold the lock.
+func (s *documentStore) schoolTypes(schoolId string) []DocumentTypeModel {
+	types, ok := s.types[schoolId]
+	if !ok {
+		types = defaultDocumentTypes()
+		s.types[schoolId] = types
+	}
+	return types
+}
+
+// school returns the documents of a school by id. Callers must hold the
+// lock.
+func (s *documentStore) school(schoolId string) map[string]*StudentDocumentModel {
+	documents, ok := s.documents[schoolId]
+	if !ok {
+		documents = map[string]*StudentDocumentModel{}
+		s.documents[schoolId] = documents
+	}
+	return documents
+}
+
+// documentType finds a type by name. Callers must hold the write lock, as
+// the school's default types may be seeded.
+func (s *documentStore) documentType(schoolId, name string) (DocumentTypeModel, bool) {
+	for _, documentType := range s.schoolTypes(schoolId) {
+		if strings.EqualFold(documentType.Name, strings.TrimSpace(name)) {
+			return documentType, true
+		}
+	}
+	return DocumentTypeModel{}, false
+}
+
+// forStudent returns the student's documents sorted by type. Callers must
+// hold the lock.
+func (s *documentStore) forStudent(schoolId, studentId string) []StudentDocumentModel {
+	list := []StudentDocumentModel{}
+	for _, document := range s.documents[schoolId] {
+		if document.StudentId == studentId {
+			list = append(list, *document)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Type < list[j].Type })
+	return list
+}
+
+// withDownloadURLs copies a document and signs a download URL for each
+// version.
+func withDownloadURLs(schoolId string, document StudentDocumentModel, now time.Time) StudentDocumentModel {
+	versions := make([]DocumentVersionModel, len(document.Versions))
+	for i, version := range document.Versions {
+		version.DownloadURL = signedMediaURL(schoolId, version.mediaId, now).URL
+		versions[i] = version
+	}
+	document.Versions = versions
+	document.Current = versions[len(versions)-1]
+	return document
+}
+
+// applyStudentDocuments replaces the profile's Documents tab with the
+// student's documents and their download links.
+func applyStudentDocuments(page *CoreProfilePageModel, schoolId, studentId string, now time.Time) {
+	studentDocuments.RLock()
+	documents := studentDocuments.forStudent(schoolId, studentId)
+	studentDocuments.RUnlock()
+	rows := []map[string]string{}
+	for _, document := range documents {
+		document = withDownloadURLs(schoolId, document, now)
+		icon := "assets/images/pdfLogo.png"
+		if document.Current.ContentType != "application/pdf" {
+			icon = "assets/images/imageLogo.png"
+		}
+		rows = append(rows, map[string]string{
+			"imageValue":        icon,
+			"documentTypeValue": document.Type,
+			"downloadTextValue": "Download",
+			"downloadUrlValue":  document.Current.DownloadURL,
+			"statusValue":       document.Current.Status,
+			"expiresOnValue":    document.Current.ExpiresOn,
+		})
+	}
+	for i, item := range page.OptionMenuModel.MenuItems {
+		if item.Text == "Documents" {
+			page.OptionMenuModel.MenuItems[i].DTO = rows
+		}
+	}
+}
+
+// canUploadDocument reports whether the caller is an admin or may act for
+// the student.
+func canUploadDocument(claims jwt.MapClaims, studentId string) bool {
+	return claimString(claims, "user_role") == roleAdmin || studentAccounts.canActFor(schoolID(claims), claimString(claims, "id"), studentId)
+}
+
+// DocumentTypesHandler lists the document types of the school.
+func DocumentTypesHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	studentDocuments.Lock()
+	defer studentDocuments.Unlock()
+	return c.JSON(http.StatusOK, success(studentDocuments.schoolTypes(schoolID(claims))))
+}
+
+// SaveDocumentTypeHandler adds a document type or updates the one with the
+// same name.
+func SaveDocumentTypeHandler(c echo.Context) error {
+	var req DocumentTypeModel
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	req.Name = strings.Join(strings.Fields(req.Name), " ")
+	var errs []string
+	if req.Name == "" || len(req.Name) > 60 {
+		errs = append(errs, "name is required and must be at most 60 characters")
+	}
+	if req.ReminderDays < 0 || req.ReminderDays > 365 {
+		errs = append(errs, "reminderDays must be between 0 and 365")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid document type", errs...))
+	}
+	if !req.Expires {
+		req.ReminderDays = 0
+	} else if req.ReminderDays == 0 {
+		req.ReminderDays = defaultDocumentReminderDays
+	}
+
+	schoolId := schoolID(claims)
+	studentDocuments.Lock()
+	defer studentDocuments.Unlock()
+	types := studentDocuments.schoolTypes(schoolId)
+	replaced := false
+	for i, documentType := range types {
+		if strings.EqualFold(documentType.Name, req.Name) {
+			req.Name = documentType.Name
+			types[i], replaced = req, true
+		}
+	}
+	if !replaced {
+		types = append(types, req)
+	}
+	studentDocuments.types[schoolId] = types
+	return c.JSON(http.StatusOK, success(types))
+}
+
+// UploadDocumentHandler stores a new version of a student's document from
+// a multipart form with studentId, type, expiresOn and file. Admins,
+// the student and the student's parents may upload; studentId defaults to
+// the active student.
+func UploadDocumentHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	schoolId := schoolID(claims)
+	studentId, status, failure := scopedStudent(claims, c.FormValue("studentId"))
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if studentId == "" {
+		return c.JSON(http.StatusBadRequest, failed("studentId is required"))
+	}
+	if _, ok := students.get(schoolId, studentId); !ok {
+		return c.JSON(http.StatusNotFound, failed("Student "+studentId+" not found"))
+	}
+	if !canUploadDocument(claims, studentId) {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	studentDocuments.Lock()
+	documentType, ok := studentDocuments.documentType(schoolId, c.FormValue("type"))
+	studentDocuments.Unlock()
+	if !ok {
+		return c.JSON(http.StatusBadRequest, failed("type "+c.FormValue("type")+" is not a document type of this school"))
+	}
+	now := time.Now()
+	expiresOn := strings.TrimSpace(c.FormValue("expiresOn"))
+	if documentType.Expires {
+		expiry, err := time.ParseInLocation(dateLayout, expiresOn, now.Location())
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, failed("expiresOn (YYYY-MM-DD) is required for "+documentType.Name))
+		}
+		if expiry.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())) {
+			return c.JSON(http.StatusBadRequest, failed(documentType.Name+" has already expired"))
+		}
+	} else {
+		expiresOn = ""
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("file is required"))
+	}
+	if fileHeader.Size > maxDocumentSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, failed("file must be at most 5 MB"))
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("Unable to read file"))
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxDocumentSize))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("Unable to read file"))
+	}
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	extension, ok := documentExtensions[contentType]
+	if !ok {
+		return c.JSON(http.StatusUnsupportedMediaType, failed("file must be a PDF, JPEG or PNG"))
+	}
+
+	studentDocuments.Lock()
+	defer studentDocuments.Unlock()
+	documents := studentDocuments.school(schoolId)
+	var document *StudentDocumentModel
+	for _, existing := range documents {
+		if existing.StudentId == studentId && existing.Type == documentType.Name {
+			document = existing
+		}
+	}
+	if document == nil {
+		document = &StudentDocumentModel{Id: newID("DOC"), StudentId: studentId, Type: documentType.Name}
+	}
+
+	version := DocumentVersionModel{
+		Version:     len(document.Versions) + 1,
+		FileName:    fileHeader.Filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		ExpiresOn:   expiresOn,
+		Status:      documentPending,
+		UploadedBy:  claimString(claims, "id"),
+		UploadedAt:  now.Format(time.RFC3339),
+	}
+	key := fmt.Sprintf("%sschools/%s/documents/%s/%s-v%d.%s", privateBlobPrefix, schoolId, studentId, document.Id, version.Version, extension)
+	if _, err := blobs.Put(key, contentType, data); err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to store the document"))
+	}
+	media := mediaItems.add(schoolId, MediaItemModel{
+		Title:          fmt.Sprintf("%s v%d", documentType.Name, version.Version),
+		Source:         mediaSourceBlob,
+		Key:            key,
+		OwnerStudentId: studentId,
+		Visibility:     mediaVisibilityFamily,
+		CreatedBy:      version.UploadedBy,
+		CreatedAt:      version.UploadedAt,
+	})
+	version.mediaId = media.Id
+	document.Versions = append(document.Versions, version)
+	document.Current = version
+	documents[document.Id] = document
+	return c.JSON(http.StatusOK, success(withDownloadURLs(schoolId, *document, now)))
+}
+
+// DocumentsHandler lists a student's documents with signed download links.
+func DocumentsHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	schoolId := schoolID(claims)
+	now := time.Now()
+	studentDocuments.RLock()
+	documents := studentDocuments.forStudent(schoolId, studentId)
+	studentDocuments.RUnlock()
+	for i := range documents {
+		documents[i] = withDownloadURLs(schoolId, documents[i], now)
+	}
+	return c.JSON(http.StatusOK, success(documents))
+}
+
+// VerifyDocumentHandler records the admin's decision on the current version
+// of a document. Rejections need a reason.
+func VerifyDocumentHandler(c echo.Context) error {
+	var req DocumentVerifyRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	req.Status = strings.ToUpper(strings.TrimSpace(req.Status))
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Status != documentVerified && req.Status != documentRejected {
+		return c.JSON(http.StatusBadRequest, failed("status must be VERIFIED or REJECTED"))
+	}
+	if req.Status == documentRejected && req.Reason == "" {
+		return c.JSON(http.StatusBadRequest, failed("reason is required to reject a document"))
+	}
+
+	schoolId := schoolID(claims)
+	studentDocuments.Lock()
+	defer studentDocuments.Unlock()
+	document, ok := studentDocuments.school(schoolId)[req.DocumentId]
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Document "+req.DocumentId+" not found"))
+	}
+	current := &document.Versions[len(document.Versions)-1]
+	if req.Version != 0 && req.Version != current.Version {
+		return c.JSON(http.StatusConflict, failed("Version "+strconv.Itoa(req.Version)+" has been replaced by version "+strconv.Itoa(current.Version)))
+	}
+	current.Status = req.Status
+	current.RejectionReason = ""
+	if req.Status == documentRejected {
+		current.RejectionReason = req.Reason
+	}
+	current.ReviewedBy = claimString(claims, "id")
+	current.ReviewedAt = time.Now().Format(time.RFC3339)
+	document.Current = *current
+	return c.JSON(http.StatusOK, success(withDownloadURLs(schoolId, *document, time.Now())))
+}
+
+// DocumentRemindersHandler lists current documents that have expired or
+// expire within their type's reminder window, soonest first. withinDays
+// overrides the window.
+func DocumentRemindersHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	within := -1
+	if value := c.QueryParam("withinDays"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > 365 {
+			return c.JSON(http.StatusBadRequest, failed("withinDays must be between 0 and 365"))
+		}
+		within = parsed
+	}
+
+	schoolId := schoolID(claims)
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	reminders := []DocumentReminderModel{}
+	studentDocuments.Lock()
+	for _, document := range studentDocuments.school(schoolId) {
+		expiry, err := time.ParseInLocation(dateLayout, document.Current.ExpiresOn, now.Location())
+		if err != nil {
+			continue
+		}
+		window := within
+		if window < 0 {
+			documentType, _ := studentDocuments.documentType(schoolId, document.Type)
+			window = documentType.ReminderDays
+		}
+		daysLeft := int(expiry.Sub(today).Hours() / 24)
+		if daysLeft > window {
+			continue
+		}
+		student, _ := students.get(schoolId, document.StudentId)
+		reminders = append(reminders, DocumentReminderModel{
+			DocumentId:  document.Id,
+			StudentId:   document.StudentId,
+			StudentName: student.Name,
+			Type:        document.Type,
+			ExpiresOn:   document.Current.ExpiresOn,
+			DaysLeft:    daysLeft,
+			Expired:     daysLeft < 0,
+		})
+	}
+	studentDocuments.Unlock()
+	sort.Slice(reminders, func(i, j int) bool {
+		if reminders[i].ExpiresOn != reminders[j].ExpiresOn {
+			return reminders[i].ExpiresOn < reminders[j].ExpiresOn
+		}
+		return reminders[i].StudentName < reminders[j].StudentName
+	})
+	return c.JSON(http.StatusOK, success(reminders))
+}
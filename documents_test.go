@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var testPDF = []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n")
+
+func uploadDocument(t *testing.T, token string, fields map[string]string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if data != nil {
+		part, err := form.CreateFormFile("file", "scan.pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/profile/documents", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	if err := UploadDocumentHandler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func resetDocuments(t *testing.T) []string {
+	t.Helper()
+	ids := guardianFamily(t)
+	studentDocuments = &documentStore{types: map[string][]DocumentTypeModel{}, documents: map[string]map[string]*StudentDocumentModel{}}
+	mediaItems = &mediaStore{items: map[string]map[string]*MediaItemModel{}, restricted: map[string]bool{}, public: map[string]bool{}}
+	saved := blobs
+	blobs = newFileBlobStore(t.TempDir())
+	t.Cleanup(func() { blobs = saved })
+	return ids
+}
+
+func TestUploadDocumentRejects(t *testing.T) {
+	ids := resetDocuments(t)
+	ravi, mohan := ids[0], ids[2]
+	parent := testToken(t, "parent1", roleStudent, ravi)
+	soon := time.Now().AddDate(0, 0, 10).Format(dateLayout)
+	today, yesterday := time.Now().Format(dateLayout), time.Now().AddDate(0, 0, -1).Format(dateLayout)
+	tests := []struct {
+		name   string
+		token  string
+		fields map[string]string
+		data   []byte
+		status int
+	}{
+		{"another family's student", testToken(t, "parent2", roleStudent, mohan), map[string]string{"studentId": ravi, "type": "ADHAAR"}, testPDF, http.StatusForbidden},
+		{"teacher", testToken(t, "teacher1", roleTeacher, ""), map[string]string{"studentId": ravi, "type": "ADHAAR"}, testPDF, http.StatusForbidden},
+		{"unknown type", parent, map[string]string{"type": "Library Card"}, testPDF, http.StatusBadRequest},
+		{"expiring type without a date", parent, map[string]string{"type": "Passport"}, testPDF, http.StatusBadRequest},
+		{"already expired", parent, map[string]string{"type": "Passport", "expiresOn": "2020-01-01"}, testPDF, http.StatusBadRequest},
+		{"expired yesterday", parent, map[string]string{"type": "Passport", "expiresOn": yesterday}, testPDF, http.StatusBadRequest},
+		{"no file", parent, map[string]string{"type": "ADHAAR"}, nil, http.StatusBadRequest},
+		{"unsupported file", parent, map[string]string{"type": "ADHAAR"}, []byte("plain text"), http.StatusUnsupportedMediaType},
+		{"too large", parent, map[string]string{"type": "ADHAAR"}, append(append([]byte{}, testPDF...), make([]byte, maxDocumentSize)...), http.StatusRequestEntityTooLarge},
+		{"valid", parent, map[string]string{"type": "passport", "expiresOn": soon}, testPDF, http.StatusOK},
+		{"expires today", parent, map[string]string{"type": "Passport", "expiresOn": today}, testPDF, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decodeData(t, uploadDocument(t, test.token, test.fields, test.data), test.status, nil)
+		})
+	}
+}
+
+func TestDocumentVersionsAndVerification(t *testing.T) {
+	ravi := resetDocuments(t)[0]
+	parent := testToken(t, "parent1", roleStudent, ravi)
+	admin := testToken(t, "admin", roleAdmin, "")
+
+	var first, second StudentDocumentModel
+	decodeData(t, uploadDocument(t, parent, map[string]string{"type": "adhaar"}, testPDF), http.StatusOK, &first)
+	decodeData(t, uploadDocument(t, parent, map[string]string{"type": "ADHAAR"}, testPDF), http.StatusOK, &second)
+	if second.Id != first.Id || len(second.Versions) != 2 || second.Current.Version != 2 || second.Current.Status != documentPending {
+		t.Fatalf("second upload = %+v", second)
+	}
+
+	verify := func(req DocumentVerifyRequestDto, status int) StudentDocumentModel {
+		t.Helper()
+		var document StudentDocumentModel
+		decodeData(t, callHandler(t, VerifyDocumentHandler, http.MethodPost, "/profile/documents/verify", admin, req), status, &document)
+		return document
+	}
+	verify(DocumentVerifyRequestDto{DocumentId: first.Id, Version: 1, Status: "verified"}, http.StatusConflict)
+	verify(DocumentVerifyRequestDto{DocumentId: first.Id, Status: "rejected"}, http.StatusBadRequest)
+	verify(DocumentVerifyRequestDto{DocumentId: first.Id, Status: "approved"}, http.StatusBadRequest)
+	if rec := callHandler(t, VerifyDocumentHandler, http.MethodPost, "/profile/documents/verify", parent, DocumentVerifyRequestDto{DocumentId: first.Id, Status: "verified"}); rec.Code != http.StatusForbidden {
+		t.Errorf("family verification = %d", rec.Code)
+	}
+	verified := verify(DocumentVerifyRequestDto{DocumentId: first.Id, Version: 2, Status: "verified"}, http.StatusOK)
+	if verified.Current.Status != documentVerified || verified.Versions[0].Status != documentPending || verified.Current.ReviewedBy != "admin" {
+		t.Errorf("verified = %+v", verified)
+	}
+
+	// Each version downloads through its own signed link
+	e := echo.New()
+	e.GET("/media-files/:schoolId/:id", MediaFileHandler)
+	for _, version := range verified.Versions {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, version.DownloadURL, nil))
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), testPDF) || !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("version %d download = %d, %q", version.Version, rec.Code, rec.Header().Get("Content-Disposition"))
+		}
+	}
+}
+
+func TestDocumentReminders(t *testing.T) {
+	ids := resetDocuments(t)
+	admin := testToken(t, "admin", roleAdmin, "")
+	in := func(days int) string { return time.Now().AddDate(0, 0, days).Format(dateLayout) }
+	for _, upload := range []struct {
+		student, documentType, expiresOn string
+	}{
+		{ids[0], "Medical Certificate", in(10)},
+		{ids[1], "Medical Certificate", in(40)},
+		{ids[1], "Passport", in(60)},
+		{ids[2], "Passport", in(200)},
+		{ids[2], "ADHAAR", in(1)},
+	} {
+		decodeData(t, uploadDocument(t, admin, map[string]string{"studentId": upload.student, "type": upload.documentType, "expiresOn": upload.expiresOn}, testPDF), http.StatusOK, nil)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Medical Certificate 10", "Passport 60"}},
+		{"?withinDays=45", []string{"Medical Certificate 10", "Medical Certificate 40"}},
+		{"?withinDays=365", []string{"Medical Certificate 10", "Medical Certificate 40", "Passport 60", "Passport 200"}},
+	}
+	for _, test := range tests {
+		var reminders []DocumentReminderModel
+		decodeData(t, callHandler(t, DocumentRemindersHandler, http.MethodGet, "/profile/documents/reminders"+test.query, admin, nil), http.StatusOK, &reminders)
+		var got []string
+		for _, reminder := range reminders {
+			got = append(got, reminder.Type+" "+strconv.Itoa(reminder.DaysLeft))
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("reminders%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+	decodeData(t, callHandler(t, DocumentRemindersHandler, http.MethodGet, "/profile/documents/reminders?withinDays=400", admin, nil), http.StatusBadRequest, nil)
+}
+
+func TestConcurrentFirstUploads(t *testing.T) {
+	ids := resetDocuments(t)
+	admin := testToken(t, "admin", roleAdmin, "")
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(studentId string) {
+			defer wg.Done()
+			<-start
+			if rec := uploadDocument(t, admin, map[string]string{"studentId": studentId, "type": "ADHAAR"}, testPDF); rec.Code != http.StatusOK {
+				t.Errorf("upload for %s = %d: %s", studentId, rec.Code, rec.Body.String())
+			}
+		}(ids[i%len(ids)])
+	}
+	close(start)
+	wg.Wait()
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DropDownValueModel is one option of a dropdown. Parent is set for
+// dependent dropdowns such as "exam-term-wise-drop-down", whose options
+// depend on the selected term.
+type DropDownValueModel struct {
+	Id        string `json:"id"`
+	Key       string `json:"key"`
+	Parent    string `json:"parent,omitempty"`
+	Value     string `json:"value"`
+	SortOrder int    `json:"sortOrder"`
+	Active    bool   `json:"active"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+type DropDownMasterModel struct {
+	Version int                 `json:"version"`
+	Keys    []DropDownMasterKey `json:"keys"`
+	Managed map[string]string   `json:"managed"`
+}
+
+type DropDownMasterKey struct {
+	Key       string               `json:"key"`
+	Dependent bool                 `json:"dependent"`
+	Values    []DropDownValueModel `json:"values"`
+}
+
+type DropDownValueRequestDto struct {
+	Id        string `json:"id"`
+	Key       string `json:"key"`
+	Parent    string `json:"parent"`
+	Value     string `json:"value"`
+	SortOrder *int   `json:"sortOrder"`
+	Active    *bool  `json:"active"`
+}
+
+// DropDownRequestDto selects dropdowns for /extract-dropdown. Without keys
+// every dropdown is returned. Class narrows the class dependent dropdowns
+// to the list of that class.
+type DropDownRequestDto struct {
+	Keys  []string `json:"keys"`
+	Class string   `json:"class"`
+}
+
+type DropDownReorderDto struct {
+	Key    string   `json:"key"`
+	Parent string   `json:"parent"`
+	Ids    []string `json:"ids"`
+}
+
+// managedDropDownKeys are derived from other modules and cannot be edited
+// here.
+var managedDropDownKeys = map[string]string{
+	"classes":     "the subjects onboarding step",
+	"subjects":    "the subjects onboarding step",
+	"sections":    "the subjects onboarding step",
+	"vehicleName": "the transport module",
+	"routeName":   "the transport module",
+	"routeStops":  "the transport module",
+	// enquiryTransitions is written against these statuses
+	"enquiry-status": "the enquiry workflow",
+}
+
+// upperCaseDropDownKeys hold codes that records store upper-cased.
+var upperCaseDropDownKeys = map[string]bool{
+	"gender":        true,
+	"religion":      true,
+	"caste":         true,
+	"admissionType": true,
+	"boards":        true,
+}
+
+// dropDownReferences count, per value, the records of a school using a
+// key's values, so values still in use are deactivated instead of deleted.
+var dropDownReferences = map[string]func(schoolId string) map[string]int{
+	"gender":        studentFieldReferences(func(s *StudentModel) string { return s.Gender }),
+	"religion":      studentFieldReferences(func(s *StudentModel) string { return s.Religion }),
+	"caste":         studentFieldReferences(func(s *StudentModel) string { return s.Caste }),
+	"admissionType": studentFieldReferences(func(s *StudentModel) string { return s.AdmissionType }),
+	"boards": func(schoolId string) map[string]int {
+		if profile, ok := schoolProfiles.get(schoolId); ok {
+			return map[string]int{profile.SchoolBoard: 1}
+		}
+		return nil
+	},
+	"school_type": func(schoolId string) map[string]int {
+		if profile, ok := schoolProfiles.get(schoolId); ok {
+			return map[string]int{profile.SchoolType: 1}
+		}
+		return nil
+	},
+	"enquiry-source": enquiryFieldReferences(func(e *EnquiryModel) []string { return []string{e.Source} }),
+	"enquiry-preferred-communication": enquiryFieldReferences(func(e *EnquiryModel) []string {
+		modes := []string{e.PreferredCommunication}
+		for _, followUp := range e.FollowUps {
+			modes = append(modes, followUp.Mode)
+		}
+		return modes
+	}),
+}
+
+func studentFieldReferences(field func(*StudentModel) string) func(schoolId string) map[string]int {
+	return func(schoolId string) map[string]int {
+		students.RLock()
+		defer students.RUnlock()
+		counts := map[string]int{}
+		if school, ok := students.schools[schoolId]; ok {
+			for _, student := range school.students {
+				counts[field(student)]++
+			}
+		}
+		return counts
+	}
+}
+
+func enquiryFieldReferences(field func(*EnquiryModel) []string) func(schoolId string) map[string]int {
+	return func(schoolId string) map[string]int {
+		enquiries.RLock()
+		defer enquiries.RUnlock()
+		counts := map[string]int{}
+		for _, enquiry := range enquiries.enquiries[schoolId] {
+			seen := map[string]bool{}
+			for _, value := range field(enquiry) {
+				if !seen[value] {
+					seen[value] = true
+					counts[value]++
+				}
+			}
+		}
+		return counts
+	}
+}
+
+// dropDownReferenceCounts counts the references of every value of the
+// school by key. It takes the locks of the referencing stores, so call it
+// before locking the dropdown master.
+func dropDownReferenceCounts(schoolId string) map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	for key, count := range dropDownReferences {
+		counts[key] = count(schoolId)
+	}
+	return counts
+}
+
+type schoolDropDowns struct {
+	version int
+	values  map[string]*DropDownValueModel
+	// known keeps keys whose values were all deleted
+	known     map[string]bool
+	dependent map[string]bool
+}
+
+type dropDownMasterStore struct {
+	sync.RWMutex
+	schools map[string]*schoolDropDowns
+}
+
+var dropDownMaster = &dropDownMasterStore{schools: map[string]*schoolDropDowns{}}
+
+// school returns the school's master data, seeding it from
+// defaultDropDownData on first use. Callers must hold the lock.
+func (s *dropDownMasterStore) school(schoolId string) *schoolDropDowns {
+	if school, ok := s.schools[schoolId]; ok {
+		return school
+	}
+	school := &schoolDropDowns{version: 1, values: map[string]*DropDownValueModel{}, known: map[string]bool{}, dependent: map[string]bool{}}
+	now := time.Now().Format(time.RFC3339)
+	add := func(key, parent string, values []string) {
+		school.known[key] = true
+		for i, value := range values {
+			id := newID("DDV")
+			school.values[id] = &DropDownValueModel{Id: id, Key: key, Parent: parent, Value: value, SortOrder: i + 1, Active: true, UpdatedAt: now}
+		}
+	}
+	for key, values := range defaultDropDownData() {
+		if _, managed := managedDropDownKeys[key]; managed {
+			continue
+		}
+		switch values := values.(type) {
+		case []string:
+			add(key, "", values)
+		case map[string][]string:
+			school.dependent[key] = true
+			for parent, children := range values {
+				add(key, parent, children)
+			}
+		}
+	}
+	s.schools[schoolId] = school
+	return school
+}
+
+// sorted returns the values of a key (and parent, for dependent keys) in
+// display order.
+func (d *schoolDropDowns) sorted(key, parent string, includeInactive bool) []DropDownValueModel {
+	var values []DropDownValueModel
+	for _, value := range d.values {
+		if value.Key == key && value.Parent == parent && (includeInactive || value.Active) {
+			values = append(values, *value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].SortOrder != values[j].SortOrder {
+			return values[i].SortOrder < values[j].SortOrder
+		}
+		return values[i].Value < values[j].Value
+	})
+	return values
+}
+
+func (d *schoolDropDowns) keys() []string {
+	keys := make([]string, 0, len(d.known))
+	for key := range d.known {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *schoolDropDowns) parents(key string) []string {
+	seen := map[string]bool{}
+	var parents []string
+	for _, value := range d.values {
+		if value.Key == key && !seen[value.Parent] {
+			seen[value.Parent] = true
+			parents = append(parents, value.Parent)
+		}
+	}
+	sort.Strings(parents)
+	return parents
+}
+
+// render returns the active values in the shape served by /extract-dropdown:
+// a list per key, or a list per parent for dependent keys.
+func (s *dropDownMasterStore) render(schoolId string) (map[string]interface{}, int) {
+	s.Lock()
+	defer s.Unlock()
+	school := s.school(schoolId)
+	dropDowns := map[string]interface{}{}
+	for _, key := range school.keys() {
+		if school.dependent[key] {
+			children := map[string][]string{}
+			for _, parent := range school.parents(key) {
+				for _, value := range school.sorted(key, parent, false) {
+					children[parent] = append(children[parent], value.Value)
+				}
+			}
+			dropDowns[key] = children
+			continue
+		}
+		values := []string{}
+		for _, value := range school.sorted(key, "", false) {
+			values = append(values, value.Value)
+		}
+		dropDowns[key] = values
+	}
+	return dropDowns, school.version
+}
+
+// classDependentDropDownKeys are keyed by class name.
+var classDependentDropDownKeys = map[string]bool{"subjects": true, "sections": true}
+
+// selectDropDowns picks the requested keys, reporting unknown keys and
+// classes as errors.
+func selectDropDowns(dropDowns map[string]interface{}, req DropDownRequestDto) (map[string]interface{}, []string) {
+	var errs []string
+	class := strings.TrimSpace(req.Class)
+	keys := req.Keys
+	if len(keys) == 0 {
+		if class == "" {
+			return dropDowns, nil
+		}
+		for key := range dropDowns {
+			keys = append(keys, key)
+		}
+	}
+
+	selected := map[string]interface{}{}
+	for _, key := range keys {
+		values, ok := dropDowns[key]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown dropdown key %q", key))
+			continue
+		}
+		byClass, dependent := values.(map[string][]string)
+		if class == "" || !dependent || !classDependentDropDownKeys[key] {
+			selected[key] = values
+			continue
+		}
+		list, ok := byClass[class]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s has no values for class %q", key, class))
+			list = []string{}
+		}
+		selected[key] = list
+	}
+	return selected, errs
+}
+
+// dropDownETag hashes the rendered dropdowns, so it also changes when a
+// managed key changes through another module.
+func dropDownETag(dropDowns map[string]interface{}) string {
+	raw, _ := json.Marshal(dropDowns)
+	sum := sha256.Sum256(raw)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func normalizeDropDownValue(key, value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if upperCaseDropDownKeys[key] {
+		value = strings.ToUpper(value)
+	}
+	return value
+}
+
+// DropDownMasterHandler lists the school's master data including inactive
+// values, for the admin screens.
+func DropDownMasterHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	dropDownMaster.Lock()
+	defer dropDownMaster.Unlock()
+	school := dropDownMaster.school(schoolID(claims))
+	master := DropDownMasterModel{Version: school.version, Keys: []DropDownMasterKey{}, Managed: managedDropDownKeys}
+	for _, key := range school.keys() {
+		entry := DropDownMasterKey{Key: key, Dependent: school.dependent[key], Values: []DropDownValueModel{}}
+		for _, parent := range school.parents(key) {
+			entry.Values = append(entry.Values, school.sorted(key, parent, true)...)
+		}
+		master.Keys = append(master.Keys, entry)
+	}
+	return c.JSON(http.StatusOK, success(master))
+}
+
+// SaveDropDownValueHandler adds a value, or updates one when an id is
+// given. Adding a value that exists but is inactive reactivates it.
+func SaveDropDownValueHandler(c echo.Context) error {
+	var req DropDownValueRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	usage := dropDownReferenceCounts(schoolID(claims))
+	dropDownMaster.Lock()
+	defer dropDownMaster.Unlock()
+	school := dropDownMaster.school(schoolID(claims))
+
+	var existing *DropDownValueModel
+	if req.Id != "" {
+		var ok bool
+		if existing, ok = school.values[req.Id]; !ok {
+			return c.JSON(http.StatusNotFound, failed("Dropdown value "+req.Id+" does not exist"))
+		}
+		req.Key, req.Parent = existing.Key, existing.Parent
+		if req.Value == "" {
+			req.Value = existing.Value
+		}
+	}
+	req.Key = strings.TrimSpace(req.Key)
+	req.Parent = strings.TrimSpace(req.Parent)
+	req.Value = normalizeDropDownValue(req.Key, req.Value)
+
+	var errs []string
+	if owner, managed := managedDropDownKeys[req.Key]; managed {
+		return c.JSON(http.StatusConflict, failed(fmt.Sprintf("%s is maintained through %s", req.Key, owner)))
+	}
+	if req.Key == "" {
+		errs = append(errs, "key is required")
+	}
+	if req.Value == "" {
+		errs = append(errs, "value is required")
+	}
+	if school.dependent[req.Key] && req.Parent == "" {
+		errs = append(errs, req.Key+" needs a parent value")
+	}
+	if !school.dependent[req.Key] && req.Parent != "" && len(school.sorted(req.Key, "", true)) > 0 {
+		errs = append(errs, req.Key+" does not take a parent value")
+	}
+	if req.SortOrder != nil && *req.SortOrder < 1 {
+		errs = append(errs, "sortOrder must be at least 1")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid dropdown value", errs...))
+	}
+
+	siblings := school.sorted(req.Key, req.Parent, true)
+	for _, sibling := range siblings {
+		if sibling.Id == req.Id || !strings.EqualFold(sibling.Value, req.Value) {
+			continue
+		}
+		if existing == nil && !sibling.Active {
+			// Re-adding a deactivated value brings it back
+			existing = school.values[sibling.Id]
+			req.Id = sibling.Id
+			active := true
+			req.Active = &active
+			break
+		}
+		return c.JSON(http.StatusConflict, failed(fmt.Sprintf("%q already exists in %s", req.Value, req.Key)))
+	}
+
+	if existing == nil {
+		id := newID("DDV")
+		existing = &DropDownValueModel{Id: id, Key: req.Key, Parent: req.Parent, Active: true, SortOrder: len(siblings) + 1}
+		if req.Parent != "" {
+			school.dependent[req.Key] = true
+		}
+		school.values[id] = existing
+		school.known[req.Key] = true
+	} else if existing.Value != req.Value {
+		if references := usage[existing.Key][existing.Value]; references > 0 {
+			return c.JSON(http.StatusConflict, failed(fmt.Sprintf("%q is used by %d records and cannot be renamed; add a new value and deactivate this one", existing.Value, references)))
+		}
+	}
+	existing.Value = req.Value
+	if req.SortOrder != nil {
+		existing.SortOrder = *req.SortOrder
+	}
+	if req.Active != nil {
+		existing.Active = *req.Active
+	}
+	existing.UpdatedAt = time.Now().Format(time.RFC3339)
+	school.version++
+	return c.JSON(http.StatusOK, success(*existing))
+}
+
+// DeleteDropDownValueHandler removes a value. Values still referenced by
+// records are deactivated instead, so those records stay valid while the
+// value is no longer offered.
+func DeleteDropDownValueHandler(c echo.Context) error {
+	var req DropDownValueRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	usage := dropDownReferenceCounts(schoolID(claims))
+	dropDownMaster.Lock()
+	defer dropDownMaster.Unlock()
+	school := dropDownMaster.school(schoolID(claims))
+	value, ok := school.values[req.Id]
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Dropdown value "+req.Id+" does not exist"))
+	}
+
+	result := map[string]interface{}{"id": value.Id, "deleted": true, "references": 0}
+	if references := usage[value.Key][value.Value]; references > 0 {
+		value.Active = false
+		value.UpdatedAt = time.Now().Format(time.RFC3339)
+		result["deleted"], result["references"] = false, references
+	} else {
+		delete(school.values, value.Id)
+	}
+	school.version++
+	return c.JSON(http.StatusOK, success(result))
+}
+
+// ReorderDropDownHandler sets the display order of a key's values to the
+// order of the given ids. Values left out keep their place after them.
+func ReorderDropDownHandler(c echo.Context) error {
+	var req DropDownReorderDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	dropDownMaster.Lock()
+	defer dropDownMaster.Unlock()
+	school := dropDownMaster.school(schoolID(claims))
+	siblings := school.sorted(req.Key, req.Parent, true)
+	if len(siblings) == 0 {
+		return c.JSON(http.StatusNotFound, failed("Dropdown "+req.Key+" has no values"))
+	}
+	position := map[string]int{}
+	for i, id := range req.Ids {
+		value, ok := school.values[id]
+		if !ok || value.Key != req.Key || value.Parent != req.Parent {
+			return c.JSON(http.StatusBadRequest, failed(fmt.Sprintf("%s is not a value of %s", id, req.Key)))
+		}
+		position[id] = i + 1
+	}
+	next := len(req.Ids)
+	now := time.Now().Format(time.RFC3339)
+	for _, sibling := range siblings {
+		order, ok := position[sibling.Id]
+		if !ok {
+			next++
+			order = next
+		}
+		school.values[sibling.Id].SortOrder = order
+		school.values[sibling.Id].UpdatedAt = now
+	}
+	school.version++
+	return c.JSON(http.StatusOK, success(school.sorted(req.Key, req.Parent, true)))
+}
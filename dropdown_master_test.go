@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func resetDropDownMaster() {
+	dropDownMaster = &dropDownMasterStore{schools: map[string]*schoolDropDowns{}}
+}
+
+// dropDownValueId returns the id of a value of the default school.
+func dropDownValueId(t *testing.T, key, value string) string {
+	t.Helper()
+	dropDownMaster.Lock()
+	defer dropDownMaster.Unlock()
+	for _, candidate := range dropDownMaster.school(defaultSchoolID).values {
+		if candidate.Key == key && candidate.Value == value {
+			return candidate.Id
+		}
+	}
+	t.Fatalf("no %s value %q", key, value)
+	return ""
+}
+
+func TestDropDownValueReferences(t *testing.T) {
+	resetDropDownMaster()
+	resetStudents()
+	students.Lock()
+	students.school(defaultSchoolID).insert(StudentModel{Name: "Ravi Kumar", Gender: "MALE", Religion: "HINDU"})
+	students.school("SCH-OTHER").insert(StudentModel{Name: "Anu Das", Gender: "FEMALE"})
+	students.Unlock()
+	admin := testToken(t, "admin", roleAdmin, "")
+	male, female := dropDownValueId(t, "gender", "MALE"), dropDownValueId(t, "gender", "FEMALE")
+
+	type deleted struct {
+		Deleted    bool `json:"deleted"`
+		References int  `json:"references"`
+	}
+	tests := []struct {
+		name   string
+		call   func() int
+		status int
+	}{
+		{"renaming a used value", func() int {
+			return callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Id: male, Value: "BOY"}).Code
+		}, http.StatusConflict},
+		{"renaming a value used only by another school", func() int {
+			return callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Id: female, Value: "Girl"}).Code
+		}, http.StatusOK},
+		{"adding a duplicate", func() int {
+			return callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Key: "gender", Value: " male "}).Code
+		}, http.StatusConflict},
+		{"editing a managed key", func() int {
+			return callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Key: "classes", Value: "13"}).Code
+		}, http.StatusConflict},
+	}
+	for _, test := range tests {
+		if status := test.call(); status != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, status, test.status)
+		}
+	}
+
+	var result deleted
+	decodeData(t, callHandler(t, DeleteDropDownValueHandler, http.MethodPost, "/dropdown-master/value/delete", admin, DropDownValueRequestDto{Id: male}), http.StatusOK, &result)
+	if result.Deleted || result.References != 1 {
+		t.Errorf("deleting a used value = %+v, want deactivated with 1 reference", result)
+	}
+	decodeData(t, callHandler(t, DeleteDropDownValueHandler, http.MethodPost, "/dropdown-master/value/delete", admin, DropDownValueRequestDto{Id: female}), http.StatusOK, &result)
+	if !result.Deleted {
+		t.Errorf("deleting an unused value = %+v, want deleted", result)
+	}
+	if got := dropDownList(defaultSchoolID, "gender"); len(got) != 0 {
+		t.Errorf("gender offers %v after deleting both values", got)
+	}
+
+	// Adding the deactivated value again brings it back
+	var value DropDownValueModel
+	decodeData(t, callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Key: "gender", Value: "male"}), http.StatusOK, &value)
+	if value.Id != male || !value.Active {
+		t.Errorf("re-added value = %+v, want %s reactivated", value, male)
+	}
+}
+
+func TestSelectDropDowns(t *testing.T) {
+	dropDowns := map[string]interface{}{
+		"gender":   []string{"MALE", "FEMALE"},
+		"sections": map[string][]string{"1": {"A", "B"}, "12": {"A"}},
+		"exam":     map[string][]string{"Term-1": {"UT-1"}},
+	}
+	tests := []struct {
+		name     string
+		req      DropDownRequestDto
+		want     map[string]interface{}
+		wantErrs int
+	}{
+		{"everything", DropDownRequestDto{}, dropDowns, 0},
+		{"some keys", DropDownRequestDto{Keys: []string{"gender"}}, map[string]interface{}{"gender": dropDowns["gender"]}, 0},
+		{"by class", DropDownRequestDto{Keys: []string{"sections", "exam"}, Class: "1"},
+			map[string]interface{}{"sections": []string{"A", "B"}, "exam": dropDowns["exam"]}, 0},
+		{"unknown class", DropDownRequestDto{Keys: []string{"sections"}, Class: "9"}, map[string]interface{}{"sections": []string{}}, 1},
+		{"unknown key", DropDownRequestDto{Keys: []string{"colour"}}, map[string]interface{}{}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, errs := selectDropDowns(dropDowns, test.req)
+			if !reflect.DeepEqual(got, test.want) || len(errs) != test.wantErrs {
+				t.Errorf("selectDropDowns = %v, %v; want %v with %d errors", got, errs, test.want, test.wantErrs)
+			}
+		})
+	}
+}
+
+func TestDropDownHandler(t *testing.T) {
+	resetDropDownMaster()
+	admin := testToken(t, "admin", roleAdmin, "")
+	extract := func(req DropDownRequestDto, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		httpReq := httptest.NewRequest(http.MethodPost, "/extract-dropdown", bytes.NewReader(body))
+		httpReq.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		httpReq.Header.Set(echo.HeaderAuthorization, "Bearer "+admin)
+		if etag != "" {
+			httpReq.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		if err := DropDownHandler(echo.New().NewContext(httpReq, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	var data struct {
+		Data map[string][]string `json:"data"`
+		ETag string              `json:"etag"`
+	}
+
+	genders := DropDownRequestDto{Keys: []string{"gender"}}
+	first := extract(genders, "")
+	decodeData(t, first, http.StatusOK, &data)
+	etag := first.Header().Get("ETag")
+	if etag == "" || data.ETag != etag || len(data.Data["gender"]) == 0 {
+		t.Fatalf("first reply = %+v, ETag %q", data, etag)
+	}
+	if rec := extract(genders, etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Errorf("revalidation = %d %q, ETag %q; want 304", rec.Code, rec.Body.String(), rec.Header().Get("ETag"))
+	}
+	if rec := extract(DropDownRequestDto{Keys: []string{"religion"}}, etag); rec.Code != http.StatusOK {
+		t.Errorf("other keys with the gender ETag = %d, want 200", rec.Code)
+	}
+
+	// A changed value invalidates the cached copy
+	decodeData(t, callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Key: "gender", Value: "Other"}), http.StatusOK, nil)
+	changed := extract(genders, etag)
+	decodeData(t, changed, http.StatusOK, &data)
+	if changed.Header().Get("ETag") == etag || !containsValue(data.Data["gender"], "OTHER") {
+		t.Errorf("after a change = %+v, ETag %q", data, changed.Header().Get("ETag"))
+	}
+
+	response := decodeData(t, extract(DropDownRequestDto{Keys: []string{"gender", "colour"}}, ""), http.StatusOK, &data)
+	if len(data.Data) != 1 || len(response.Errors) != 1 || !strings.Contains(response.Errors[0], `unknown dropdown key "colour"`) {
+		t.Errorf("unknown key = %+v, errors %v", data.Data, response.Errors)
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	enquiryEventCreate   = "CREATE"
+	enquiryEventAssign   = "ASSIGN"
+	enquiryEventStatus   = "STATUS"
+	enquiryEventFollowUp = "FOLLOW_UP"
+	enquiryEventComplete = "COMPLETE_FOLLOW_UP"
+	enquiryEventNote     = "NOTE"
+	enquiryEventConvert  = "CONVERT"
+
+	enquiryStatusNew       = "Not Contacted"
+	enquiryStatusConverted = "Converted"
+)
+
+// enquiryTransitions lists the statuses each status can move to. Junk leads
+// and converted enquiries are final; lost leads can be revived.
+var enquiryTransitions = map[string][]string{
+	"Not Contacted":        {"Attempted to Contact", "Contacted", "Junk Lead", "Missed"},
+	"Attempted to Contact": {"Contacted", "Not Interested", "Junk Lead", "Missed", "LOST"},
+	"Missed":               {"Attempted to Contact", "Contacted", "LOST"},
+	"Contacted":            {"Contact in Future", "Not Interested", "LOST"},
+	"Contact in Future":    {"Attempted to Contact", "Contacted", "Not Interested", "LOST"},
+	"Not Interested":       {"Contact in Future", "LOST"},
+	"LOST":                 {"Contact in Future"},
+}
+
+// enquiryContactedStatuses are the statuses a lead only reaches after the
+// school has spoken to the family. The first move to any of them sets
+// FirstContactedAt.
+var enquiryContactedStatuses = []string{"Contacted", "Contact in Future", "Not Interested", enquiryStatusConverted}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[A-Za-z]{2,}$`)
+
+type EnquiryNoteModel struct {
+	Text      string `json:"text"`
+	CreatedBy string `json:"createdBy"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type EnquiryFollowUpModel struct {
+	Id          string `json:"id"`
+	DueAt       string `json:"dueAt"`
+	Mode        string `json:"mode"`
+	Note        string `json:"note"`
+	AssignedTo  string `json:"assignedTo"`
+	Done        bool   `json:"done"`
+	Outcome     string `json:"outcome,omitempty"`
+	CompletedAt string `json:"completedAt,omitempty"`
+}
+
+// EnquiryHistoryModel records every event applied to an enquiry.
+type EnquiryHistoryModel struct {
+	Event     string `json:"event"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	By        string `json:"by"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type EnquiryModel struct {
+	Id                     string                 `json:"id"`
+	StudentName            string                 `json:"studentName"`
+	ParentName             string                 `json:"parentName"`
+	Phone                  string                 `json:"phone"`
+	Email                  string                 `json:"email"`
+	ClassName              string                 `json:"className"`
+	Source                 string                 `json:"source"`
+	PreferredCommunication string                 `json:"preferredCommunication"`
+	Status                 string                 `json:"status"`
+	AssignedTo             string                 `json:"assignedTo"`
+	NextFollowUpAt         string                 `json:"nextFollowUpAt"`
+	FirstContactedAt       string                 `json:"firstContactedAt"`
+	ConvertedStudentId     string                 `json:"convertedStudentId"`
+	CreatedAt              string                 `json:"createdAt"`
+	UpdatedAt              string                 `json:"updatedAt"`
+	FollowUps              []EnquiryFollowUpModel `json:"followUps"`
+	Notes                  []EnquiryNoteModel     `json:"notes"`
+	History                []EnquiryHistoryModel  `json:"history"`
+}
+
+// EnquiryEventDto is the body of /submit-enquiry-event. Event selects which
+// of the other fields are used.
+type EnquiryEventDto struct {
+	Event      string        `json:"event"`
+	EnquiryId  string        `json:"enquiryId"`
+	Enquiry    EnquiryModel  `json:"enquiry"`
+	AssignTo   string        `json:"assignTo"`
+	Status     string        `json:"status"`
+	FollowUpAt string        `json:"followUpAt"`
+	FollowUpId string        `json:"followUpId"`
+	Mode       string        `json:"mode"`
+	Note       string        `json:"note"`
+	Student    *StudentModel `json:"student"`
+}
+
+type enquiryStore struct {
+	sync.RWMutex
+	enquiries map[string]map[string]*EnquiryModel
+}
+
+var enquiries = &enquiryStore{enquiries: map[string]map[string]*EnquiryModel{}}
+
+// school returns the school's enquiries. Callers must hold the lock.
+func (s *enquiryStore) school(schoolId string) map[string]*EnquiryModel {
+	school, ok := s.enquiries[schoolId]
+	if !ok {
+		school = map[string]*EnquiryModel{}
+		s.enquiries[schoolId] = school
+	}
+	return school
+}
+
+// isStaff reports whether a user id belongs to school staff who can handle
+// enquiries.
+func isStaff(userId string) bool {
+	role := roleForUsername(userId)
+	return role == roleAdmin || role == roleTeacher
+}
+
+// normalizeEnquiry validates a new enquiry against the enquiry dropdowns.
+func normalizeEnquiry(schoolId string, enquiry EnquiryModel) (EnquiryModel, []string) {
+	var errs []string
+	enquiry.StudentName = strings.Join(strings.Fields(enquiry.StudentName), " ")
+	enquiry.ParentName = strings.Join(strings.Fields(enquiry.ParentName), " ")
+	enquiry.Phone = normalizePhone(enquiry.Phone)
+	enquiry.Email = strings.ToLower(strings.TrimSpace(enquiry.Email))
+	enquiry.ClassName = strings.TrimSpace(enquiry.ClassName)
+	enquiry.Source = strings.TrimSpace(enquiry.Source)
+	enquiry.PreferredCommunication = strings.TrimSpace(enquiry.PreferredCommunication)
+
+	if enquiry.StudentName == "" {
+		errs = append(errs, "studentName is required")
+	}
+	if enquiry.ParentName == "" {
+		errs = append(errs, "parentName is required")
+	}
+	if enquiry.Phone == "" && enquiry.Email == "" {
+		errs = append(errs, "phone or email is required")
+	}
+	if enquiry.Phone != "" && !mobileNumberPattern.MatchString(enquiry.Phone) {
+		errs = append(errs, "phone must be a 10 digit mobile number")
+	}
+	if enquiry.Email != "" && !emailPattern.MatchString(enquiry.Email) {
+		errs = append(errs, "email is not a valid address")
+	}
+	if classes := dropDownList(schoolId, "classes"); enquiry.ClassName != "" && !containsValue(classes, enquiry.ClassName) {
+		errs = append(errs, "className must be one of "+strings.Join(classes, ", "))
+	}
+	if sources := dropDownList(schoolId, "enquiry-source"); !containsValue(sources, enquiry.Source) {
+		errs = append(errs, "source must be one of "+strings.Join(sources, ", "))
+	}
+	if modes := dropDownList(schoolId, "enquiry-preferred-communication"); enquiry.PreferredCommunication != "" && !containsValue(modes, enquiry.PreferredCommunication) {
+		errs = append(errs, "preferredCommunication must be one of "+strings.Join(modes, ", "))
+	}
+	return enquiry, errs
+}
+
+// normalizePhone strips separators and an Indian country code.
+func normalizePhone(phone string) string {
+	phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(phone))
+	phone = strings.TrimPrefix(phone, "+91")
+	if len(phone) == 11 && strings.HasPrefix(phone, "0") {
+		phone = phone[1:]
+	}
+	return phone
+}
+
+func (e *EnquiryModel) record(event, from, to, by string, at time.Time) {
+	e.History = append(e.History, EnquiryHistoryModel{Event: event, From: from, To: to, By: by, CreatedAt: at.Format(time.RFC3339)})
+	e.UpdatedAt = at.Format(time.RFC3339)
+}
+
+// refreshNextFollowUp keeps NextFollowUpAt on the earliest open follow-up.
+func (e *EnquiryModel) refreshNextFollowUp() {
+	e.NextFollowUpAt = ""
+	for _, followUp := range e.FollowUps {
+		if !followUp.Done && (e.NextFollowUpAt == "" || followUp.DueAt < e.NextFollowUpAt) {
+			e.NextFollowUpAt = followUp.DueAt
+		}
+	}
+}
+
+func canTransition(from, to string) bool {
+	for _, next := range enquiryTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// create stores a new lead. Callers must hold the lock.
+func (s *enquiryStore) create(schoolId string, enquiry EnquiryModel, by string, now time.Time) *EnquiryModel {
+	enquiry.Id = newID("ENQ")
+	enquiry.Status = enquiryStatusNew
+	enquiry.CreatedAt = now.Format(time.RFC3339)
+	enquiry.FollowUps = []EnquiryFollowUpModel{}
+	enquiry.Notes = []EnquiryNoteModel{}
+	enquiry.History = nil
+	enquiry.FirstContactedAt, enquiry.ConvertedStudentId, enquiry.NextFollowUpAt = "", "", ""
+	enquiry.record(enquiryEventCreate, "", enquiryStatusNew, by, now)
+	s.school(schoolId)[enquiry.Id] = &enquiry
+	return &enquiry
+}
+
+// applyEnquiryEvent applies an event other than CREATE to an enquiry.
+// Callers must hold the lock. It returns the HTTP status and errors on
+// failure.
+func applyEnquiryEvent(schoolId string, enquiry *EnquiryModel, req EnquiryEventDto, userId, role string, now time.Time) (int, []string) {
+	if enquiry.Status == enquiryStatusConverted && req.Event != enquiryEventNote {
+		return http.StatusConflict, []string{"enquiry " + enquiry.Id + " is already converted"}
+	}
+
+	switch req.Event {
+	case enquiryEventAssign:
+		if role != roleAdmin {
+			return http.StatusForbidden, []string{"only admins can assign enquiries"}
+		}
+		if !isStaff(req.AssignTo) {
+			return http.StatusBadRequest, []string{fmt.Sprintf("assignTo %q is not a counsellor", req.AssignTo)}
+		}
+		previous := enquiry.AssignedTo
+		enquiry.AssignedTo = req.AssignTo
+		for i := range enquiry.FollowUps {
+			if !enquiry.FollowUps[i].Done {
+				enquiry.FollowUps[i].AssignedTo = req.AssignTo
+			}
+		}
+		enquiry.record(req.Event, previous, req.AssignTo, userId, now)
+
+	case enquiryEventStatus:
+		if req.Status == enquiryStatusConverted {
+			return http.StatusBadRequest, []string{"use the CONVERT event to convert an enquiry"}
+		}
+		if statuses := dropDownList(schoolId, "enquiry-status"); !containsValue(statuses, req.Status) {
+			return http.StatusBadRequest, []string{"status must be one of " + strings.Join(statuses, ", ")}
+		}
+		if !canTransition(enquiry.Status, req.Status) {
+			return http.StatusConflict, []string{fmt.Sprintf("cannot move from %q to %q", enquiry.Status, req.Status)}
+		}
+		if containsValue(enquiryContactedStatuses, req.Status) && enquiry.FirstContactedAt == "" {
+			enquiry.FirstContactedAt = now.Format(time.RFC3339)
+		}
+		from := enquiry.Status
+		enquiry.Status = req.Status
+		if note := strings.TrimSpace(req.Note); note != "" {
+			enquiry.Notes = append(enquiry.Notes, EnquiryNoteModel{Text: note, CreatedBy: userId, CreatedAt: now.Format(time.RFC3339)})
+		}
+		enquiry.record(req.Event, from, req.Status, userId, now)
+
+	case enquiryEventFollowUp:
+		dueAt, err := time.Parse(time.RFC3339, req.FollowUpAt)
+		if err != nil {
+			return http.StatusBadRequest, []string{"followUpAt must be an RFC 3339 time"}
+		}
+		if dueAt.Before(now) {
+			return http.StatusBadRequest, []string{"followUpAt must be in the future"}
+		}
+		mode := strings.TrimSpace(req.Mode)
+		if mode == "" {
+			mode = enquiry.PreferredCommunication
+		}
+		if modes := dropDownList(schoolId, "enquiry-preferred-communication"); mode != "" && !containsValue(modes, mode) {
+			return http.StatusBadRequest, []string{"mode must be one of " + strings.Join(modes, ", ")}
+		}
+		assignedTo := enquiry.AssignedTo
+		if assignedTo == "" {
+			assignedTo = userId
+		}
+		followUp := EnquiryFollowUpModel{
+			Id:         newID("FUP"),
+			DueAt:      dueAt.UTC().Format(time.RFC3339),
+			Mode:       mode,
+			Note:       strings.TrimSpace(req.Note),
+			AssignedTo: assignedTo,
+		}
+		enquiry.FollowUps = append(enquiry.FollowUps, followUp)
+		enquiry.refreshNextFollowUp()
+		enquiry.record(req.Event, "", followUp.DueAt, userId, now)
+
+	case enquiryEventComplete:
+		index := -1
+		for i, followUp := range enquiry.FollowUps {
+			if followUp.Id == req.FollowUpId {
+				index = i
+			}
+		}
+		if index < 0 {
+			return http.StatusNotFound, []string{"follow-up " + req.FollowUpId + " does not exist"}
+		}
+		if enquiry.FollowUps[index].Done {
+			return http.StatusConflict, []string{"follow-up " + req.FollowUpId + " is already completed"}
+		}
+		enquiry.FollowUps[index].Done = true
+		enquiry.FollowUps[index].Outcome = strings.TrimSpace(req.Note)
+		enquiry.FollowUps[index].CompletedAt = now.Format(time.RFC3339)
+		enquiry.refreshNextFollowUp()
+		enquiry.record(req.Event, "", req.FollowUpId, userId, now)
+
+	case enquiryEventNote:
+		note := strings.TrimSpace(req.Note)
+		if note == "" {
+			return http.StatusBadRequest, []string{"note is required"}
+		}
+		enquiry.Notes = append(enquiry.Notes, EnquiryNoteModel{Text: note, CreatedBy: userId, CreatedAt: now.Format(time.RFC3339)})
+		enquiry.record(req.Event, "", "", userId, now)
+
+	case enquiryEventConvert:
+		if role != roleAdmin {
+			return http.StatusForbidden, []string{"only admins can convert enquiries"}
+		}
+		if enquiry.Status != "Contacted" && enquiry.Status != "Contact in Future" {
+			return http.StatusConflict, []string{fmt.Sprintf("only contacted enquiries can be converted, this one is %q", enquiry.Status)}
+		}
+		student, status, errs := convertEnquiry(schoolId, enquiry, req.Student)
+		if len(errs) > 0 {
+			return status, errs
+		}
+		from := enquiry.Status
+		enquiry.Status = enquiryStatusConverted
+		if enquiry.FirstContactedAt == "" {
+			enquiry.FirstContactedAt = now.Format(time.RFC3339)
+		}
+		enquiry.ConvertedStudentId = student.Id
+		for i := range enquiry.FollowUps {
+			if !enquiry.FollowUps[i].Done {
+				enquiry.FollowUps[i].Done = true
+				enquiry.FollowUps[i].Outcome = "Closed on conversion"
+				enquiry.FollowUps[i].CompletedAt = now.Format(time.RFC3339)
+			}
+		}
+		enquiry.refreshNextFollowUp()
+		enquiry.record(req.Event, from, enquiryStatusConverted, userId, now)
+
+	default:
+		return http.StatusBadRequest, []string{fmt.Sprintf("unknown event %q", req.Event)}
+	}
+	return http.StatusOK, nil
+}
+
+// convertEnquiry creates the admission record of an enquiry. The submitted
+// student details win; missing ones are taken from the enquiry.
+func convertEnquiry(schoolId string, enquiry *EnquiryModel, details *StudentModel) (StudentModel, int, []string) {
+	var student StudentModel
+	if details != nil {
+		student = *details
+	}
+	if student.Name == "" {
+		student.Name = enquiry.StudentName
+	}
+	if student.FatherName == "" && student.MotherName == "" {
+		student.FatherName = enquiry.ParentName
+	}
+	if student.ClassName == "" {
+		student.ClassName = enquiry.ClassName
+	}
+	if blocked := onboardingProgress.blockedBy(schoolId, onboardingStepStudents); len(blocked) > 0 {
+		return StudentModel{}, http.StatusConflict, append([]string{"Complete the earlier onboarding steps first"}, blocked...)
+	}
+	dropDowns, _ := fillDropDownData(schoolId)
+	student, errs := normalizeStudent(dropDowns, student)
+	if len(errs) > 0 {
+		return StudentModel{}, http.StatusBadRequest, errs
+	}
+	students.Lock()
+	defer students.Unlock()
+	school := students.school(schoolId)
+	if existing, ok := school.findDuplicate(student); ok {
+		return StudentModel{}, http.StatusConflict, []string{"Student already exists with admission number " + existing.AdmissionNumber}
+	}
+	return school.insert(student), http.StatusOK, nil
+}
+
+// EnquiryEventHandler captures enquiries and applies the CRM events:
+// ASSIGN, STATUS, FOLLOW_UP, COMPLETE_FOLLOW_UP, NOTE and CONVERT.
+func EnquiryEventHandler(c echo.Context) error {
+	var req EnquiryEventDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role, userId := claimString(claims, "user_role"), claimString(claims, "id")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	req.Event = strings.ToUpper(strings.TrimSpace(req.Event))
+	if req.Event == "" {
+		req.Event = enquiryEventCreate
+	}
+	now := time.Now()
+
+	if req.Event == enquiryEventCreate {
+		enquiry, errs := normalizeEnquiry(schoolID(claims), req.Enquiry)
+		if len(errs) > 0 {
+			return c.JSON(http.StatusBadRequest, failed("Invalid enquiry", errs...))
+		}
+		enquiries.Lock()
+		defer enquiries.Unlock()
+		enquiry.AssignedTo = ""
+		if role == roleTeacher {
+			enquiry.AssignedTo = userId
+		}
+		return c.JSON(http.StatusOK, success(*enquiries.create(schoolID(claims), enquiry, userId, now)))
+	}
+
+	enquiries.Lock()
+	defer enquiries.Unlock()
+	enquiry, ok := enquiries.school(schoolID(claims))[req.EnquiryId]
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Enquiry "+req.EnquiryId+" does not exist"))
+	}
+	if role == roleTeacher && enquiry.AssignedTo != userId {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	if status, errs := applyEnquiryEvent(schoolID(claims), enquiry, req, userId, role, now); len(errs) > 0 {
+		return c.JSON(status, failed("Could not apply "+req.Event, errs...))
+	}
+	return c.JSON(http.StatusOK, success(*enquiry))
+}
+
+// EnquiryListHandler lists enquiries, newest first, filtered by status,
+// source and assignee. Teachers only see enquiries assigned to them.
+func EnquiryListHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	statusFilter, source, assignedTo := c.QueryParam("status"), c.QueryParam("source"), c.QueryParam("assignedTo")
+	if role == roleTeacher {
+		assignedTo = claimString(claims, "id")
+	}
+
+	enquiries.RLock()
+	defer enquiries.RUnlock()
+	list := []EnquiryModel{}
+	for _, enquiry := range enquiries.enquiries[schoolID(claims)] {
+		if (statusFilter == "" || enquiry.Status == statusFilter) &&
+			(source == "" || enquiry.Source == source) &&
+			(assignedTo == "" || enquiry.AssignedTo == assignedTo) {
+			list = append(list, *enquiry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt > list[j].CreatedAt || (list[i].CreatedAt == list[j].CreatedAt && list[i].Id > list[j].Id)
+	})
+	return c.JSON(http.StatusOK, success(list))
+}
+
+// EnquiryHandler returns one enquiry with its follow-ups, notes and history.
+func EnquiryHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	enquiries.RLock()
+	defer enquiries.RUnlock()
+	enquiry, ok := enquiries.enquiries[schoolID(claims)][c.Param("id")]
+	if !ok || (role == roleTeacher && enquiry.AssignedTo != claimString(claims, "id")) {
+		return c.JSON(http.StatusNotFound, failed("Enquiry "+c.Param("id")+" does not exist"))
+	}
+	return c.JSON(http.StatusOK, success(*enquiry))
+}
+
+// EnquiryReminderModel is an open follow-up due soon or overdue.
+type EnquiryReminderModel struct {
+	EnquiryId   string               `json:"enquiryId"`
+	StudentName string               `json:"studentName"`
+	ParentName  string               `json:"parentName"`
+	Phone       string               `json:"phone"`
+	Overdue     bool                 `json:"overdue"`
+	FollowUp    EnquiryFollowUpModel `json:"followUp"`
+}
+
+// EnquiryRemindersHandler lists the caller's open follow-ups that are
+// overdue or due within the next "hours" (default 24).
+func EnquiryRemindersHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	hours := 24
+	if value := c.QueryParam("hours"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 24*30 {
+			return c.JSON(http.StatusBadRequest, failed("hours must be between 1 and 720"))
+		}
+		hours = parsed
+	}
+	now := time.Now().UTC()
+	until := now.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339)
+
+	enquiries.RLock()
+	defer enquiries.RUnlock()
+	reminders := []EnquiryReminderModel{}
+	for _, enquiry := range enquiries.enquiries[schoolID(claims)] {
+		for _, followUp := range enquiry.FollowUps {
+			if followUp.Done || followUp.AssignedTo != claimString(claims, "id") || followUp.DueAt > until {
+				continue
+			}
+			reminders = append(reminders, EnquiryReminderModel{
+				EnquiryId:   enquiry.Id,
+				StudentName: enquiry.StudentName,
+				ParentName:  enquiry.ParentName,
+				Phone:       enquiry.Phone,
+				Overdue:     followUp.DueAt < now.Format(time.RFC3339),
+				FollowUp:    followUp,
+			})
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].FollowUp.DueAt < reminders[j].FollowUp.DueAt })
+	return c.JSON(http.StatusOK, success(reminders))
+}
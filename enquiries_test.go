@@ -0,0 +1,270 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const enquirySchool = "SCH-ENQ"
+
+// newEnquiry creates a lead and walks it through the given statuses, an hour
+// apart. "Converted" is applied as a CONVERT event.
+func newEnquiry(t *testing.T, created time.Time, statuses ...string) (*EnquiryModel, int) {
+	t.Helper()
+	enquiries.Lock()
+	defer enquiries.Unlock()
+	enquiry := enquiries.create(enquirySchool, EnquiryModel{StudentName: "Ravi Kumar", ParentName: "Suresh Kumar", ClassName: "12", Source: "Source1"}, "admin", created)
+	for i, status := range statuses {
+		at := created.Add(time.Duration(i+1) * time.Hour)
+		req := EnquiryEventDto{Event: enquiryEventStatus, Status: status}
+		if status == enquiryStatusConverted {
+			req = EnquiryEventDto{Event: enquiryEventConvert, Student: &StudentModel{
+				Name: enquiry.Id, DateOfBirth: "2015-01-02", Gender: "MALE", Religion: "HINDU", Caste: "GENERAL", AdmissionType: "NEW", Section: "A",
+			}}
+		}
+		if code, errs := applyEnquiryEvent(enquirySchool, enquiry, req, "admin", roleAdmin, at); code != http.StatusOK {
+			return enquiry, code
+		} else if len(errs) > 0 {
+			t.Fatalf("applyEnquiryEvent: %v", errs)
+		}
+	}
+	return enquiry, http.StatusOK
+}
+
+func resetEnquiries() {
+	enquiries = &enquiryStore{enquiries: map[string]map[string]*EnquiryModel{}}
+	resetDropDownMaster()
+	resetStudents()
+	completeOnboardingBefore(enquirySchool, onboardingStepStudents)
+}
+
+func TestEnquiryDropDowns(t *testing.T) {
+	resetEnquiries()
+	enquiries.Lock()
+	enquiry := enquiries.create(defaultSchoolID, EnquiryModel{StudentName: "Ravi Kumar", Source: "Source1", PreferredCommunication: "Call"}, "admin", time.Now())
+	enquiry.FollowUps = append(enquiry.FollowUps, EnquiryFollowUpModel{Mode: "Email"}, EnquiryFollowUpModel{Mode: "Email"})
+	enquiries.Unlock()
+	admin := testToken(t, "admin", roleAdmin, "")
+
+	rename := func(key, value, to string) int {
+		return callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Id: dropDownValueId(t, key, value), Value: to}).Code
+	}
+	tests := []struct {
+		name       string
+		key, value string
+		status     int
+	}{
+		{"source of a lead", "enquiry-source", "Source1", http.StatusConflict},
+		{"unused source", "enquiry-source", "Source2", http.StatusOK},
+		{"preferred mode of a lead", "enquiry-preferred-communication", "Call", http.StatusConflict},
+		{"mode of a follow-up", "enquiry-preferred-communication", "Email", http.StatusConflict},
+		{"unused mode", "enquiry-preferred-communication", "Message", http.StatusOK},
+	}
+	for _, test := range tests {
+		if status := rename(test.key, test.value, test.value+" (renamed)"); status != test.status {
+			t.Errorf("%s: rename = %d, want %d", test.name, status, test.status)
+		}
+	}
+
+	var result struct {
+		Deleted    bool `json:"deleted"`
+		References int  `json:"references"`
+	}
+	decodeData(t, callHandler(t, DeleteDropDownValueHandler, http.MethodPost, "/dropdown-master/value/delete", admin, DropDownValueRequestDto{Id: dropDownValueId(t, "enquiry-preferred-communication", "Email")}), http.StatusOK, &result)
+	if result.Deleted || result.References != 1 {
+		t.Errorf("deleting a used mode = %+v, want deactivated with 1 reference", result)
+	}
+
+	// The statuses follow the workflow and cannot be edited
+	if rec := callHandler(t, SaveDropDownValueHandler, http.MethodPost, "/dropdown-master/value", admin, DropDownValueRequestDto{Key: "enquiry-status", Value: "Visited"}); rec.Code != http.StatusConflict {
+		t.Errorf("adding a status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	statuses := dropDownList(defaultSchoolID, "enquiry-status")
+	for from, next := range enquiryTransitions {
+		for _, status := range append([]string{from}, next...) {
+			if !containsValue(statuses, status) {
+				t.Errorf("workflow status %q is not offered in %v", status, statuses)
+			}
+		}
+	}
+	for _, status := range statuses {
+		if _, ok := enquiryTransitions[status]; !ok && status != "Junk Lead" {
+			t.Errorf("status %q has no transitions", status)
+		}
+	}
+}
+
+func TestNormalizeEnquiry(t *testing.T) {
+	resetEnquiries()
+	valid := EnquiryModel{StudentName: " Ravi   Kumar ", ParentName: "Suresh Kumar", Phone: "+91 98450-12345", Email: " Suresh@Mail.com ", ClassName: "12", Source: "Source1", PreferredCommunication: "Call"}
+	tests := []struct {
+		name    string
+		change  func(*EnquiryModel)
+		wantErr string
+	}{
+		{"valid", func(e *EnquiryModel) {}, ""},
+		{"trunk prefix", func(e *EnquiryModel) { e.Phone = "09845012345" }, ""},
+		{"no student", func(e *EnquiryModel) { e.StudentName = " " }, "studentName is required"},
+		{"no contact", func(e *EnquiryModel) { e.Phone, e.Email = "", "" }, "phone or email is required"},
+		{"landline", func(e *EnquiryModel) { e.Phone = "080 2345 678" }, "phone must be a 10 digit mobile number"},
+		{"bad email", func(e *EnquiryModel) { e.Email = "suresh@mail" }, "email is not a valid address"},
+		{"unknown class", func(e *EnquiryModel) { e.ClassName = "13" }, "className must be one of"},
+		{"no source", func(e *EnquiryModel) { e.Source = "" }, "source must be one of"},
+		{"unknown mode", func(e *EnquiryModel) { e.PreferredCommunication = "Fax" }, "preferredCommunication must be one of"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enquiry := valid
+			test.change(&enquiry)
+			got, errs := normalizeEnquiry(defaultSchoolID, enquiry)
+			if test.wantErr != "" {
+				if !strings.Contains(strings.Join(errs, "; "), test.wantErr) {
+					t.Errorf("errors = %v, want %q", errs, test.wantErr)
+				}
+				return
+			}
+			if len(errs) > 0 || got.StudentName != "Ravi Kumar" || got.Phone != "9845012345" || got.Email != "suresh@mail.com" {
+				t.Errorf("enquiry = %+v, %v", got, errs)
+			}
+		})
+	}
+}
+
+func enquiryEvent(t *testing.T, token string, req EnquiryEventDto, status int) EnquiryModel {
+	t.Helper()
+	var enquiry EnquiryModel
+	decodeData(t, callHandler(t, EnquiryEventHandler, http.MethodPost, "/submit-enquiry-event", token, req), status, &enquiry)
+	return enquiry
+}
+
+func TestEnquiryEventHandler(t *testing.T) {
+	resetEnquiries()
+	completeOnboardingBefore(defaultSchoolID, onboardingStepStudents)
+	admin := testToken(t, "admin", roleAdmin, "")
+	teacher1, teacher2 := testToken(t, "teacher1", roleTeacher, ""), testToken(t, "teacher2", roleTeacher, "")
+	event := func(token string, req EnquiryEventDto, status int) EnquiryModel {
+		return enquiryEvent(t, token, req, status)
+	}
+	lead := EnquiryModel{StudentName: "Ravi Kumar", ParentName: "Suresh Kumar", Phone: "9845012345", ClassName: "12", Source: "Source1", PreferredCommunication: "Call"}
+	details := &StudentModel{DateOfBirth: "2015-01-02", Gender: "MALE", Religion: "HINDU", Caste: "GENERAL", AdmissionType: "NEW", Section: "A"}
+
+	// Teachers own the leads they capture; admins' leads start unassigned
+	own := event(teacher1, EnquiryEventDto{Enquiry: lead}, http.StatusOK)
+	if own.AssignedTo != "teacher1" || own.Status != enquiryStatusNew {
+		t.Fatalf("teacher's lead = %+v", own)
+	}
+	walkIn := event(admin, EnquiryEventDto{Event: "create", Enquiry: lead}, http.StatusOK)
+	if walkIn.AssignedTo != "" {
+		t.Errorf("admin's lead assigned to %q", walkIn.AssignedTo)
+	}
+	event(testToken(t, "parent1", roleStudent, ""), EnquiryEventDto{Enquiry: lead}, http.StatusForbidden)
+
+	inFuture := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	followUp := event(teacher1, EnquiryEventDto{Event: enquiryEventFollowUp, EnquiryId: own.Id, FollowUpAt: inFuture}, http.StatusOK)
+	if len(followUp.FollowUps) != 1 || followUp.FollowUps[0].Mode != "Call" || followUp.FollowUps[0].AssignedTo != "teacher1" || followUp.NextFollowUpAt != inFuture {
+		t.Fatalf("follow-up = %+v", followUp)
+	}
+	followUpId := followUp.FollowUps[0].Id
+
+	tests := []struct {
+		name   string
+		token  string
+		req    EnquiryEventDto
+		status int
+	}{
+		{"another teacher's lead", teacher2, EnquiryEventDto{Event: enquiryEventNote, EnquiryId: own.Id, Note: "Called"}, http.StatusForbidden},
+		{"teacher assigns", teacher1, EnquiryEventDto{Event: enquiryEventAssign, EnquiryId: own.Id, AssignTo: "teacher2"}, http.StatusForbidden},
+		{"assign to a family", admin, EnquiryEventDto{Event: enquiryEventAssign, EnquiryId: own.Id, AssignTo: "parent1"}, http.StatusBadRequest},
+		{"unknown lead", admin, EnquiryEventDto{Event: enquiryEventNote, EnquiryId: "ENQ-NONE", Note: "Called"}, http.StatusNotFound},
+		{"unknown event", admin, EnquiryEventDto{Event: "VISIT", EnquiryId: own.Id}, http.StatusBadRequest},
+		{"empty note", teacher1, EnquiryEventDto{Event: enquiryEventNote, EnquiryId: own.Id, Note: " "}, http.StatusBadRequest},
+		{"past follow-up", teacher1, EnquiryEventDto{Event: enquiryEventFollowUp, EnquiryId: own.Id, FollowUpAt: "2020-01-01T09:00:00Z"}, http.StatusBadRequest},
+		{"unknown mode", teacher1, EnquiryEventDto{Event: enquiryEventFollowUp, EnquiryId: own.Id, FollowUpAt: inFuture, Mode: "Fax"}, http.StatusBadRequest},
+		{"unknown follow-up", teacher1, EnquiryEventDto{Event: enquiryEventComplete, EnquiryId: own.Id, FollowUpId: "FUP-NONE"}, http.StatusNotFound},
+		{"status outside the list", teacher1, EnquiryEventDto{Event: enquiryEventStatus, EnquiryId: own.Id, Status: "Visited"}, http.StatusBadRequest},
+		{"converted through STATUS", admin, EnquiryEventDto{Event: enquiryEventStatus, EnquiryId: own.Id, Status: enquiryStatusConverted}, http.StatusBadRequest},
+		{"teacher converts", teacher1, EnquiryEventDto{Event: enquiryEventConvert, EnquiryId: own.Id, Student: details}, http.StatusForbidden},
+		{"convert before contact", admin, EnquiryEventDto{Event: enquiryEventConvert, EnquiryId: own.Id, Student: details}, http.StatusConflict},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enquiryEvent(t, test.token, test.req, test.status)
+		})
+	}
+
+	// Reassigning moves the open follow-ups and the lead away from teacher1
+	assigned := event(admin, EnquiryEventDto{Event: "assign", EnquiryId: own.Id, AssignTo: "teacher2"}, http.StatusOK)
+	if assigned.AssignedTo != "teacher2" || assigned.FollowUps[0].AssignedTo != "teacher2" {
+		t.Errorf("assigned = %+v", assigned)
+	}
+	event(teacher1, EnquiryEventDto{Event: enquiryEventNote, EnquiryId: own.Id, Note: "Called"}, http.StatusForbidden)
+
+	completed := event(teacher2, EnquiryEventDto{Event: enquiryEventComplete, EnquiryId: own.Id, FollowUpId: followUpId, Note: "Visiting on Monday"}, http.StatusOK)
+	if !completed.FollowUps[0].Done || completed.FollowUps[0].Outcome != "Visiting on Monday" || completed.NextFollowUpAt != "" {
+		t.Errorf("completed = %+v", completed)
+	}
+	event(teacher2, EnquiryEventDto{Event: enquiryEventComplete, EnquiryId: own.Id, FollowUpId: followUpId}, http.StatusConflict)
+
+	// Conversion admits the student and closes the lead
+	event(teacher2, EnquiryEventDto{Event: enquiryEventStatus, EnquiryId: own.Id, Status: "Contacted"}, http.StatusOK)
+	event(teacher2, EnquiryEventDto{Event: enquiryEventFollowUp, EnquiryId: own.Id, FollowUpAt: inFuture}, http.StatusOK)
+	converted := event(admin, EnquiryEventDto{Event: enquiryEventConvert, EnquiryId: own.Id, Student: details}, http.StatusOK)
+	student, ok := students.get(defaultSchoolID, converted.ConvertedStudentId)
+	if converted.Status != enquiryStatusConverted || !ok || student.Name != "Ravi Kumar" || student.FatherName != "Suresh Kumar" || student.ClassName != "12" {
+		t.Fatalf("converted = %+v, student %+v", converted, student)
+	}
+	if converted.NextFollowUpAt != "" || !converted.FollowUps[1].Done || converted.FollowUps[1].Outcome != "Closed on conversion" {
+		t.Errorf("open follow-ups after conversion = %+v", converted.FollowUps)
+	}
+	event(admin, EnquiryEventDto{Event: enquiryEventStatus, EnquiryId: own.Id, Status: "LOST"}, http.StatusConflict)
+	event(admin, EnquiryEventDto{Event: enquiryEventNote, EnquiryId: own.Id, Note: "Fees paid"}, http.StatusOK)
+
+	// The same child enquiring twice is admitted once
+	event(admin, EnquiryEventDto{Event: enquiryEventStatus, EnquiryId: walkIn.Id, Status: "Contacted"}, http.StatusOK)
+	event(admin, EnquiryEventDto{Event: enquiryEventConvert, EnquiryId: walkIn.Id, Student: details}, http.StatusConflict)
+}
+
+func TestEnquiryRemindersHandler(t *testing.T) {
+	resetEnquiries()
+	now := time.Now().UTC()
+	at := func(hours int) string { return now.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339) }
+	enquiries.Lock()
+	enquiry := enquiries.create(defaultSchoolID, EnquiryModel{StudentName: "Ravi Kumar", Phone: "9845012345"}, "admin", now)
+	enquiry.FollowUps = []EnquiryFollowUpModel{
+		{Id: "FUP-1", DueAt: at(30), AssignedTo: "teacher1"},
+		{Id: "FUP-2", DueAt: at(-2), AssignedTo: "teacher1"},
+		{Id: "FUP-3", DueAt: at(5), AssignedTo: "teacher1"},
+		{Id: "FUP-4", DueAt: at(-5), AssignedTo: "teacher1", Done: true},
+		{Id: "FUP-5", DueAt: at(1), AssignedTo: "teacher2"},
+	}
+	enquiries.Unlock()
+	teacher := testToken(t, "teacher1", roleTeacher, "")
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "FUP-2 overdue, FUP-3"},
+		{"?hours=48", "FUP-2 overdue, FUP-3, FUP-1"},
+		{"?hours=1", "FUP-2 overdue"},
+	}
+	for _, test := range tests {
+		var reminders []EnquiryReminderModel
+		decodeData(t, callHandler(t, EnquiryRemindersHandler, http.MethodGet, "/enquiry-reminders"+test.query, teacher, nil), http.StatusOK, &reminders)
+		var got []string
+		for _, reminder := range reminders {
+			entry := reminder.FollowUp.Id
+			if reminder.Overdue {
+				entry += " overdue"
+			}
+			got = append(got, entry)
+		}
+		if strings.Join(got, ", ") != test.want {
+			t.Errorf("reminders%s = %v, want %s", test.query, got, test.want)
+		}
+	}
+	decodeData(t, callHandler(t, EnquiryRemindersHandler, http.MethodGet, "/enquiry-reminders?hours=0", teacher, nil), http.StatusBadRequest, nil)
+	decodeData(t, callHandler(t, EnquiryRemindersHandler, http.MethodGet, "/enquiry-reminders", testToken(t, "parent1", roleStudent, ""), nil), http.StatusForbidden, nil)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// EnquiryGroupStats are the counts of one source or counsellor.
+type EnquiryGroupStats struct {
+	Name                       string         `json:"name"`
+	Enquiries                  int            `json:"enquiries"`
+	Contacted                  int            `json:"contacted"`
+	Converted                  int            `json:"converted"`
+	ConversionRate             float64        `json:"conversionRate"`
+	AverageHoursToFirstContact float64        `json:"averageHoursToFirstContact"`
+	OverdueFollowUps           int            `json:"overdueFollowUps"`
+	ByStatus                   map[string]int `json:"byStatus"`
+
+	contactHours float64
+}
+
+// EnquiryFunnelStage is one step of the admission funnel. RateFromPrevious
+// is the share of the previous stage that reached this one.
+type EnquiryFunnelStage struct {
+	Stage            string  `json:"stage"`
+	Enquiries        int     `json:"enquiries"`
+	RateFromPrevious float64 `json:"rateFromPrevious"`
+	RateFromStart    float64 `json:"rateFromStart"`
+}
+
+type EnquiryReportModel struct {
+	From                       string               `json:"from"`
+	To                         string               `json:"to"`
+	Total                      EnquiryGroupStats    `json:"total"`
+	Funnel                     []EnquiryFunnelStage `json:"funnel"`
+	BySource                   []EnquiryGroupStats  `json:"bySource"`
+	ByCounsellor               []EnquiryGroupStats  `json:"byCounsellor"`
+	AverageHoursToFirstContact float64              `json:"averageHoursToFirstContact"`
+}
+
+// percent returns part/whole as a percentage with one decimal.
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)*1000/float64(whole)) / 10
+}
+
+func (g *EnquiryGroupStats) add(enquiry *EnquiryModel, now time.Time) {
+	g.Enquiries++
+	g.ByStatus[enquiry.Status]++
+	stage := funnelStage(enquiry)
+	if stage >= funnelContacted {
+		g.Contacted++
+		created, _ := time.Parse(time.RFC3339, enquiry.CreatedAt)
+		contacted, _ := time.Parse(time.RFC3339, enquiry.FirstContactedAt)
+		g.contactHours += contacted.Sub(created).Hours()
+	}
+	if stage >= funnelConverted {
+		g.Converted++
+	}
+	for _, followUp := range enquiry.FollowUps {
+		if dueAt, err := time.Parse(time.RFC3339, followUp.DueAt); err == nil && !followUp.Done && dueAt.Before(now) {
+			g.OverdueFollowUps++
+		}
+	}
+}
+
+func (g *EnquiryGroupStats) finish() {
+	g.ConversionRate = percent(g.Converted, g.Enquiries)
+	if g.Contacted > 0 {
+		g.AverageHoursToFirstContact = math.Round(g.contactHours*10/float64(g.Contacted)) / 10
+	}
+}
+
+// The funnel stages, in order. Each stage includes every later one, so an
+// enquiry counts towards all stages up to the furthest it reached.
+const (
+	funnelEnquired = iota
+	funnelReachedOut
+	funnelContacted
+	funnelConverted
+)
+
+var funnelStageNames = []string{"Enquired", "Reached Out", "Contacted", "Converted"}
+
+// funnelStage returns the furthest funnel stage an enquiry reached. A lead
+// was reached out to once it left "Not Contacted" for anything other than
+// being marked junk.
+func funnelStage(enquiry *EnquiryModel) int {
+	switch {
+	case enquiry.Status == enquiryStatusConverted:
+		return funnelConverted
+	case enquiry.FirstContactedAt != "":
+		return funnelContacted
+	}
+	for _, entry := range enquiry.History {
+		if entry.Event == enquiryEventStatus && entry.From == enquiryStatusNew && entry.To != "Junk Lead" {
+			return funnelReachedOut
+		}
+	}
+	return funnelEnquired
+}
+
+// buildEnquiryReport reports on the enquiries created in [from, to).
+func buildEnquiryReport(schoolId string, from, to, now time.Time) EnquiryReportModel {
+	newGroup := func(name string) *EnquiryGroupStats {
+		return &EnquiryGroupStats{Name: name, ByStatus: map[string]int{}}
+	}
+	total := newGroup("All")
+	sources := map[string]*EnquiryGroupStats{}
+	counsellors := map[string]*EnquiryGroupStats{}
+	reached := make([]int, len(funnelStageNames))
+
+	enquiries.RLock()
+	for _, enquiry := range enquiries.enquiries[schoolId] {
+		created, err := time.Parse(time.RFC3339, enquiry.CreatedAt)
+		if err != nil || created.Before(from) || !created.Before(to) {
+			continue
+		}
+		total.add(enquiry, now)
+		if sources[enquiry.Source] == nil {
+			sources[enquiry.Source] = newGroup(enquiry.Source)
+		}
+		sources[enquiry.Source].add(enquiry, now)
+		counsellor := enquiry.AssignedTo
+		if counsellor == "" {
+			counsellor = "Unassigned"
+		}
+		if counsellors[counsellor] == nil {
+			counsellors[counsellor] = newGroup(counsellor)
+		}
+		counsellors[counsellor].add(enquiry, now)
+		for stage := funnelStage(enquiry); stage >= 0; stage-- {
+			reached[stage]++
+		}
+	}
+	enquiries.RUnlock()
+
+	total.finish()
+	report := EnquiryReportModel{
+		From:                       from.Format(dateLayout),
+		To:                         to.AddDate(0, 0, -1).Format(dateLayout),
+		AverageHoursToFirstContact: total.AverageHoursToFirstContact,
+		BySource:                   []EnquiryGroupStats{},
+		ByCounsellor:               []EnquiryGroupStats{},
+	}
+	for i, name := range funnelStageNames {
+		funnel := EnquiryFunnelStage{Stage: name, Enquiries: reached[i], RateFromPrevious: 100, RateFromStart: percent(reached[i], reached[funnelEnquired])}
+		if i > 0 {
+			funnel.RateFromPrevious = percent(reached[i], reached[i-1])
+		}
+		report.Funnel = append(report.Funnel, funnel)
+	}
+
+	// Every configured source is listed, including those without leads
+	for _, source := range dropDownList(schoolId, "enquiry-source") {
+		if sources[source] == nil {
+			sources[source] = newGroup(source)
+		}
+	}
+	for _, group := range sources {
+		group.finish()
+		report.BySource = append(report.BySource, *group)
+	}
+	for _, group := range counsellors {
+		group.finish()
+		report.ByCounsellor = append(report.ByCounsellor, *group)
+	}
+	byVolume := func(groups []EnquiryGroupStats) {
+		sort.Slice(groups, func(i, j int) bool {
+			if groups[i].Enquiries != groups[j].Enquiries {
+				return groups[i].Enquiries > groups[j].Enquiries
+			}
+			return groups[i].Name < groups[j].Name
+		})
+	}
+	byVolume(report.BySource)
+	byVolume(report.ByCounsellor)
+	report.Total = *total
+	return report
+}
+
+// enquiryReportCSV flattens the report into one table with a section
+// column.
+func enquiryReportCSV(report EnquiryReportModel) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{{"section", "name", "enquiries", "contacted", "converted", "conversion_rate_pct", "avg_hours_to_first_contact", "overdue_follow_ups"}}
+	formatFloat := func(value float64) string { return strconv.FormatFloat(value, 'f', 1, 64) }
+	group := func(section string, g EnquiryGroupStats) []string {
+		return []string{section, g.Name, strconv.Itoa(g.Enquiries), strconv.Itoa(g.Contacted), strconv.Itoa(g.Converted),
+			formatFloat(g.ConversionRate), formatFloat(g.AverageHoursToFirstContact), strconv.Itoa(g.OverdueFollowUps)}
+	}
+	rows = append(rows, group("total", report.Total))
+	for _, stage := range report.Funnel {
+		rows = append(rows, []string{"funnel", stage.Stage, strconv.Itoa(stage.Enquiries), "", "", formatFloat(stage.RateFromPrevious), "", ""})
+	}
+	for _, g := range report.BySource {
+		rows = append(rows, group("source", g))
+	}
+	for _, g := range report.ByCounsellor {
+		rows = append(rows, group("counsellor", g))
+	}
+	statuses := make([]string, 0, len(report.Total.ByStatus))
+	for status := range report.Total.ByStatus {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		rows = append(rows, []string{"status", status, strconv.Itoa(report.Total.ByStatus[status]), "", "", "", "", ""})
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EnquiryReportHandler reports the admission funnel for enquiries created
+// between from and to (inclusive, YYYY-MM-DD; default the last 30 days).
+// format=csv downloads it as CSV.
+func EnquiryReportHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from, to := today.AddDate(0, 0, -29), today
+	var errs []string
+	if value := c.QueryParam("from"); value != "" {
+		parsed, err := time.ParseInLocation(dateLayout, value, now.Location())
+		if err != nil {
+			errs = append(errs, "from must be YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	if value := c.QueryParam("to"); value != "" {
+		parsed, err := time.ParseInLocation(dateLayout, value, now.Location())
+		if err != nil {
+			errs = append(errs, "to must be YYYY-MM-DD")
+		}
+		to = parsed
+	}
+	if len(errs) == 0 && to.Before(from) {
+		errs = append(errs, "to must not be before from")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid report range", errs...))
+	}
+
+	report := buildEnquiryReport(schoolID(claims), from, to.AddDate(0, 0, 1), now)
+	if c.QueryParam("format") != "csv" {
+		return c.JSON(http.StatusOK, success(report))
+	}
+	data, err := enquiryReportCSV(report)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to build the report"))
+	}
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="enquiry-report-%s-to-%s.csv"`, report.From, report.To))
+	return c.Blob(http.StatusOK, "text/csv", data)
+}
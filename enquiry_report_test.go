@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEnquiryStatusTransitions(t *testing.T) {
+	resetEnquiries()
+	created := time.Date(2030, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		statuses         []string
+		wantCode         int
+		wantStatus       string
+		wantFirstContact string
+	}{
+		{"attempted only", []string{"Attempted to Contact"}, http.StatusOK, "Attempted to Contact", ""},
+		{"missed then lost", []string{"Missed", "LOST"}, http.StatusOK, "LOST", ""},
+		{"contacted directly", []string{"Contacted", "Contact in Future"}, http.StatusOK, "Contact in Future", "2030-03-01T10:00:00Z"},
+		{"not interested after an attempt", []string{"Attempted to Contact", "Not Interested"}, http.StatusOK, "Not Interested", "2030-03-01T11:00:00Z"},
+		{"revived lost lead", []string{"Attempted to Contact", "LOST", "Contact in Future"}, http.StatusOK, "Contact in Future", "2030-03-01T12:00:00Z"},
+		{"converted", []string{"Contacted", "Converted"}, http.StatusOK, enquiryStatusConverted, "2030-03-01T10:00:00Z"},
+		{"junk is final", []string{"Junk Lead", "Contacted"}, http.StatusConflict, "Junk Lead", ""},
+		{"convert without contact", []string{"Attempted to Contact", "Converted"}, http.StatusConflict, "Attempted to Contact", ""},
+		{"same status", []string{enquiryStatusNew}, http.StatusConflict, enquiryStatusNew, ""},
+		{"unknown status", []string{"Enrolled"}, http.StatusBadRequest, enquiryStatusNew, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enquiry, code := newEnquiry(t, created, test.statuses...)
+			if code != test.wantCode || enquiry.Status != test.wantStatus || enquiry.FirstContactedAt != test.wantFirstContact {
+				t.Errorf("got %d, status %q, first contact %q; want %d, %q, %q",
+					code, enquiry.Status, enquiry.FirstContactedAt, test.wantCode, test.wantStatus, test.wantFirstContact)
+			}
+		})
+	}
+
+	// The STATUS event never converts
+	enquiries.Lock()
+	enquiry := enquiries.create(enquirySchool, EnquiryModel{StudentName: "Anu"}, "admin", created)
+	code, _ := applyEnquiryEvent(enquirySchool, enquiry, EnquiryEventDto{Event: enquiryEventStatus, Status: enquiryStatusConverted}, "admin", roleAdmin, created)
+	enquiries.Unlock()
+	if code != http.StatusBadRequest {
+		t.Errorf("STATUS to Converted = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestEnquiryFunnelIsNested(t *testing.T) {
+	resetEnquiries()
+	created := time.Date(2030, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, statuses := range [][]string{
+		{},
+		{"Junk Lead"},
+		{"Attempted to Contact"},
+		{"Attempted to Contact", "Not Interested"},
+		{"Contacted"},
+		{"Contacted", "Converted"},
+		{"Attempted to Contact", "LOST", "Contact in Future", "Converted"},
+	} {
+		newEnquiry(t, created, statuses...)
+	}
+
+	report := buildEnquiryReport(enquirySchool, created.AddDate(0, 0, -1), created.AddDate(0, 0, 1), created.AddDate(0, 0, 2))
+	want := []struct {
+		stage string
+		count int
+	}{
+		{"Enquired", 7},
+		{"Reached Out", 5},
+		{"Contacted", 4},
+		{"Converted", 2},
+	}
+	if len(report.Funnel) != len(want) {
+		t.Fatalf("funnel = %+v", report.Funnel)
+	}
+	for i, stage := range report.Funnel {
+		if stage.Stage != want[i].stage || stage.Enquiries != want[i].count {
+			t.Errorf("stage %d = %s %d, want %s %d", i, stage.Stage, stage.Enquiries, want[i].stage, want[i].count)
+		}
+		if stage.RateFromPrevious > 100 {
+			t.Errorf("%s keeps %.1f%% of the previous stage", stage.Stage, stage.RateFromPrevious)
+		}
+	}
+	if report.Total.Contacted != 4 || report.Total.Converted != 2 || report.Total.ConversionRate != 28.6 {
+		t.Errorf("total = %+v", report.Total)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const monthLayout = "2006-01"
+
+// FeeChargeModel is one monthly amount owed by a student. Source identifies
+// the module that raised it, e.g. "transport", so it can be revised later.
+type FeeChargeModel struct {
+	Id          string `json:"id"`
+	StudentId   string `json:"studentId"`
+	FeeType     string `json:"feeType"`
+	Description string `json:"description"`
+	Month       string `json:"month"`
+	DueDate     string `json:"dueDate"`
+	Amount      int    `json:"amount"`
+	Source      string `json:"source"`
+}
+
+type feeLedgerStore struct {
+	sync.RWMutex
+	charges map[string][]FeeChargeModel
+}
+
+var feeLedger = &feeLedgerStore{charges: map[string][]FeeChargeModel{}}
+
+// academicYearEnd returns the last month of the academic year (April to
+// March) containing month.
+func academicYearEnd(month time.Time) time.Time {
+	year := month.Year()
+	if month.Month() >= time.April {
+		year++
+	}
+	return time.Date(year, time.March, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// nextMonth returns the first of the month after t. Adding a month to the
+// 29th to 31st would skip a shorter month.
+func nextMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// chargeMonthly raises a charge for every month from start to the end of
+// the academic year, replacing charges the same source raised for those
+// months.
+func (s *feeLedgerStore) chargeMonthly(studentId, source, feeType, description string, amount int, start time.Time) {
+	start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	s.Lock()
+	defer s.Unlock()
+	s.dropFrom(studentId, source, start)
+	for month := start; !month.After(academicYearEnd(start)); month = month.AddDate(0, 1, 0) {
+		s.charges[studentId] = append(s.charges[studentId], FeeChargeModel{
+			Id:          newID("FEE"),
+			StudentId:   studentId,
+			FeeType:     feeType,
+			Description: description,
+			Month:       month.Format(monthLayout),
+			DueDate:     time.Date(month.Year(), month.Month(), 10, 0, 0, 0, 0, time.UTC).Format(dateLayout),
+			Amount:      amount,
+			Source:      source,
+		})
+	}
+}
+
+// cancelFrom drops the charges a source raised for month and later months.
+func (s *feeLedgerStore) cancelFrom(studentId, source string, month time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.dropFrom(studentId, source, month)
+}
+
+// dropFrom is cancelFrom for callers that hold the lock.
+func (s *feeLedgerStore) dropFrom(studentId, source string, month time.Time) {
+	from := month.Format(monthLayout)
+	kept := s.charges[studentId][:0]
+	for _, charge := range s.charges[studentId] {
+		if charge.Source != source || charge.Month < from {
+			kept = append(kept, charge)
+		}
+	}
+	s.charges[studentId] = kept
+}
+
+// chargesFor returns a student's charges ordered by month.
+func (s *feeLedgerStore) chargesFor(studentId string) []FeeChargeModel {
+	s.RLock()
+	defer s.RUnlock()
+	charges := append([]FeeChargeModel{}, s.charges[studentId]...)
+	sort.SliceStable(charges, func(i, j int) bool { return charges[i].Month < charges[j].Month })
+	return charges
+}
+
+// applyFeeCharges adds the student's charges for the given month to the fee
+// page.
+func applyFeeCharges(page *GenericFeePageModel, studentId string, month time.Time) {
+	for _, charge := range feeLedger.chargesFor(studentId) {
+		if charge.Month != month.Format(monthLayout) {
+			continue
+		}
+		dueDate, _ := time.Parse(dateLayout, charge.DueDate)
+		page.FeeTypes = append(page.FeeTypes, FeeTypeModel{
+			FeeType:        charge.FeeType,
+			DueDateText:    "Due Date",
+			DueDateValue:   dueDate.Format("2 January 2006"),
+			AmountDueText:  "Amount Due",
+			AmountDueValue: fmt.Sprintf("$%d", charge.Amount),
+		})
+	}
+}
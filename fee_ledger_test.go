@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcademicYearEnd(t *testing.T) {
+	tests := []struct {
+		month time.Month
+		year  int
+		want  string
+	}{
+		{time.April, 2030, "2031-03"},
+		{time.December, 2030, "2031-03"},
+		{time.January, 2031, "2031-03"},
+		{time.March, 2031, "2031-03"},
+	}
+	for _, test := range tests {
+		month := time.Date(test.year, test.month, 1, 0, 0, 0, 0, time.UTC)
+		if got := academicYearEnd(month).Format(monthLayout); got != test.want {
+			t.Errorf("academicYearEnd(%s) = %s, want %s", month.Format(monthLayout), got, test.want)
+		}
+	}
+}
+
+func TestNextMonth(t *testing.T) {
+	tests := []struct {
+		day  string
+		want string
+	}{
+		{"2031-01-31", "2031-02"},
+		{"2031-01-29", "2031-02"},
+		{"2030-08-31", "2030-09"},
+		{"2030-12-15", "2031-01"},
+		{"2030-04-01", "2030-05"},
+	}
+	for _, test := range tests {
+		day, _ := time.Parse(dateLayout, test.day)
+		if got := nextMonth(day).Format(monthLayout); got != test.want {
+			t.Errorf("nextMonth(%s) = %s, want %s", test.day, got, test.want)
+		}
+	}
+}
+
+func TestFeeLedgerChargeMonthly(t *testing.T) {
+	feeLedger = &feeLedgerStore{charges: map[string][]FeeChargeModel{}}
+	feeLedger.chargeMonthly("STU-1", "tuition", "Tuition", "Tuition fee", 900, time.Date(2030, time.April, 1, 0, 0, 0, 0, time.UTC))
+	feeLedger.chargeMonthly("STU-1", "transport", "Transport", "Bus 1", 500, time.Date(2030, time.April, 15, 0, 0, 0, 0, time.UTC))
+	// Moving to another route in October replaces the rest of the year
+	feeLedger.chargeMonthly("STU-1", "transport", "Transport", "Bus 2", 700, time.Date(2030, time.October, 20, 0, 0, 0, 0, time.UTC))
+
+	amounts := map[string]map[string]int{}
+	for _, charge := range feeLedger.chargesFor("STU-1") {
+		if amounts[charge.Source] == nil {
+			amounts[charge.Source] = map[string]int{}
+		}
+		if _, ok := amounts[charge.Source][charge.Month]; ok {
+			t.Errorf("%s charged twice for %s", charge.Source, charge.Month)
+		}
+		amounts[charge.Source][charge.Month] = charge.Amount
+	}
+	tests := []struct {
+		source, month string
+		want          int
+	}{
+		{"tuition", "2030-04", 900},
+		{"tuition", "2031-03", 900},
+		{"transport", "2030-09", 500},
+		{"transport", "2030-10", 700},
+		{"transport", "2031-03", 700},
+		{"transport", "2031-04", 0},
+	}
+	for _, test := range tests {
+		if got := amounts[test.source][test.month]; got != test.want {
+			t.Errorf("%s %s = %d, want %d", test.source, test.month, got, test.want)
+		}
+	}
+	if len(amounts["tuition"]) != 12 || len(amounts["transport"]) != 12 {
+		t.Errorf("got %d tuition and %d transport months, want 12 each", len(amounts["tuition"]), len(amounts["transport"]))
+	}
+
+	feeLedger.cancelFrom("STU-1", "transport", time.Date(2031, time.January, 1, 0, 0, 0, 0, time.UTC))
+	transport := 0
+	for _, charge := range feeLedger.chargesFor("STU-1") {
+		if charge.Source == "transport" {
+			transport++
+			if charge.Month >= "2031-01" {
+				t.Errorf("transport still charged for %s", charge.Month)
+			}
+		}
+	}
+	if transport != 9 {
+		t.Errorf("got %d transport months after cancelling, want 9", transport)
+	}
+}
+
+func TestFeeLedgerConcurrentReplacement(t *testing.T) {
+	feeLedger = &feeLedgerStore{charges: map[string][]FeeChargeModel{}}
+	start := time.Date(2030, time.April, 1, 0, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(amount int) {
+			defer wg.Done()
+			feeLedger.chargeMonthly("STU-1", "transport", "Transport", "Bus", amount, start)
+			feeLedger.chargesFor("STU-1")
+		}(i)
+	}
+	wg.Wait()
+
+	charges := feeLedger.chargesFor("STU-1")
+	if len(charges) != 12 {
+		t.Fatalf("got %d charges, want 12 after concurrent replacements", len(charges))
+	}
+	for _, charge := range charges {
+		if charge.Amount != charges[0].Amount {
+			t.Errorf("charges mix amounts %d and %d", charges[0].Amount, charge.Amount)
+		}
+	}
+}
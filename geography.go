@@ -0,0 +1,147 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// geographyData is built from the ISO 3166-1 and ISO 3166-2 tables; only
+// Indian subdivisions carry cities.
+//
+//go:embed geodata/geography.json
+var geographyData []byte
+
+type CountryModel struct {
+	Code              string             `json:"code"`
+	Alpha3            string             `json:"alpha3"`
+	Numeric           string             `json:"numeric"`
+	Name              string             `json:"name"`
+	PostalCodePattern string             `json:"postalCodePattern,omitempty"`
+	Subdivisions      []SubdivisionModel `json:"subdivisions,omitempty"`
+
+	postalCode *regexp.Regexp
+}
+
+// SubdivisionModel is a state, union territory, province or similar.
+// Aliases hold former names that are still accepted.
+type SubdivisionModel struct {
+	Code    string   `json:"code"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Aliases []string `json:"aliases,omitempty"`
+	Cities  []string `json:"cities,omitempty"`
+}
+
+type geographyIndex struct {
+	countries []*CountryModel
+	byKey     map[string]*CountryModel
+}
+
+var geography = loadGeography()
+
+func loadGeography() *geographyIndex {
+	var data struct {
+		Countries []*CountryModel `json:"countries"`
+	}
+	if err := json.Unmarshal(geographyData, &data); err != nil {
+		panic("geodata/geography.json: " + err.Error())
+	}
+	index := &geographyIndex{countries: data.Countries, byKey: map[string]*CountryModel{}}
+	sort.SliceStable(index.countries, func(i, j int) bool { return index.countries[i].Name < index.countries[j].Name })
+	for _, country := range index.countries {
+		if country.PostalCodePattern != "" {
+			country.postalCode = regexp.MustCompile(country.PostalCodePattern)
+		}
+		sort.SliceStable(country.Subdivisions, func(i, j int) bool { return country.Subdivisions[i].Name < country.Subdivisions[j].Name })
+		for _, key := range []string{country.Code, country.Alpha3, country.Name} {
+			index.byKey[strings.ToLower(key)] = country
+		}
+	}
+	return index
+}
+
+// country finds a country by ISO alpha-2 or alpha-3 code or by name.
+func (g *geographyIndex) country(key string) (*CountryModel, bool) {
+	country, ok := g.byKey[strings.ToLower(strings.TrimSpace(key))]
+	return country, ok
+}
+
+// subdivision finds a subdivision by its ISO code, with or without the
+// country prefix ("IN-KA" or "KA"), by name or by a former name.
+func (c *CountryModel) subdivision(key string) (*SubdivisionModel, bool) {
+	key = strings.TrimSpace(key)
+	for i := range c.Subdivisions {
+		subdivision := &c.Subdivisions[i]
+		if strings.EqualFold(subdivision.Code, key) || strings.EqualFold(subdivision.Code, c.Code+"-"+key) || strings.EqualFold(subdivision.Name, key) {
+			return subdivision, true
+		}
+		for _, alias := range subdivision.Aliases {
+			if strings.EqualFold(alias, key) {
+				return subdivision, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// city returns the dataset spelling of a city of the subdivision.
+func (s *SubdivisionModel) city(name string) (string, bool) {
+	for _, city := range s.Cities {
+		if strings.EqualFold(city, strings.TrimSpace(name)) {
+			return city, true
+		}
+	}
+	return "", false
+}
+
+// validPostalCode reports whether code fits the country's postal code
+// format. Countries without a known format accept any code.
+func (c *CountryModel) validPostalCode(code string) bool {
+	return c.postalCode == nil || c.postalCode.MatchString(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// CountriesHandler lists every country with its codes, sorted by name.
+func CountriesHandler(c echo.Context) error {
+	countries := make([]CountryModel, 0, len(geography.countries))
+	for _, country := range geography.countries {
+		summary := *country
+		summary.Subdivisions = nil
+		countries = append(countries, summary)
+	}
+	return c.JSON(http.StatusOK, success(countries))
+}
+
+// StatesHandler lists the subdivisions of a country, without their cities.
+func StatesHandler(c echo.Context) error {
+	country, ok := geography.country(c.Param("country"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Country "+c.Param("country")+" not found"))
+	}
+	states := make([]SubdivisionModel, 0, len(country.Subdivisions))
+	for _, subdivision := range country.Subdivisions {
+		subdivision.Cities = nil
+		states = append(states, subdivision)
+	}
+	return c.JSON(http.StatusOK, success(states))
+}
+
+// CitiesHandler lists the cities of a subdivision, sorted by name.
+func CitiesHandler(c echo.Context) error {
+	country, ok := geography.country(c.Param("country"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Country "+c.Param("country")+" not found"))
+	}
+	subdivision, ok := country.subdivision(c.Param("state"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("State "+c.Param("state")+" not found in "+country.Name))
+	}
+	cities := append([]string{}, subdivision.Cities...)
+	sort.Strings(cities)
+	return c.JSON(http.StatusOK, success(cities))
+}
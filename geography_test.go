@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// getGeography serves target through the geography routes.
+func getGeography(t *testing.T, target string, status int, data interface{}) {
+	t.Helper()
+	e := echo.New()
+	e.GET("/country", CountriesHandler)
+	e.GET("/country/:country/state", StatesHandler)
+	e.GET("/country/:country/:state/cities", CitiesHandler)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	decodeData(t, rec, status, data)
+}
+
+func TestCountryLookup(t *testing.T) {
+	for _, key := range []string{"IN", "in", "IND", " India "} {
+		if country, ok := geography.country(key); !ok || country.Code != "IN" {
+			t.Errorf("country(%q) = %v, %v", key, country, ok)
+		}
+	}
+	if country, ok := geography.country("XX"); ok {
+		t.Errorf("country(XX) = %s", country.Name)
+	}
+}
+
+func TestSubdivisionLookup(t *testing.T) {
+	india, _ := geography.country("IN")
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"IN-KA", "IN-KA"},
+		{"ka", "IN-KA"},
+		{"Karnataka", "IN-KA"},
+		{" karnataka ", "IN-KA"},
+		{"Orissa", "IN-OR"},
+		{"pondicherry", "IN-PY"},
+		{"Daman and Diu", "IN-DH"},
+		{"US-KA", ""},
+		{"Mysore State", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		subdivision, ok := india.subdivision(test.key)
+		if test.want == "" {
+			if ok {
+				t.Errorf("subdivision(%q) = %s, want none", test.key, subdivision.Code)
+			}
+			continue
+		}
+		if !ok || subdivision.Code != test.want {
+			t.Errorf("subdivision(%q) = %v, want %s", test.key, subdivision, test.want)
+		}
+	}
+
+	karnataka, _ := india.subdivision("KA")
+	if city, ok := karnataka.city(" ballari "); !ok || city != "Ballari" {
+		t.Errorf("city(ballari) = %q, %v", city, ok)
+	}
+	if !india.validPostalCode(" 560001 ") || india.validPostalCode("060001") || india.validPostalCode("56001") {
+		t.Error("Indian PIN codes are six digits without a leading zero")
+	}
+}
+
+func TestGeographyHandlers(t *testing.T) {
+	var countries []CountryModel
+	getGeography(t, "/country", http.StatusOK, &countries)
+	if len(countries) != len(geography.countries) || !sort.SliceIsSorted(countries, func(i, j int) bool { return countries[i].Name < countries[j].Name }) {
+		t.Errorf("countries are not all listed by name: %d of %d", len(countries), len(geography.countries))
+	}
+	for _, country := range countries {
+		if len(country.Subdivisions) > 0 {
+			t.Fatalf("%s lists its subdivisions", country.Name)
+		}
+	}
+
+	var states, again []SubdivisionModel
+	getGeography(t, "/country/ind/state", http.StatusOK, &states)
+	getGeography(t, "/country/IN/state", http.StatusOK, &again)
+	if len(states) == 0 || !reflect.DeepEqual(states, again) || !sort.SliceIsSorted(states, func(i, j int) bool { return states[i].Name < states[j].Name }) {
+		t.Errorf("states of India are not stable and sorted by name: %+v", states)
+	}
+	for _, state := range states {
+		if len(state.Cities) > 0 {
+			t.Fatalf("%s lists its cities", state.Name)
+		}
+	}
+	// Cities are stripped from the response only
+	if karnataka, _ := geography.byKey["in"].subdivision("KA"); len(karnataka.Cities) == 0 {
+		t.Error("listing states removed the cities from the dataset")
+	}
+
+	var cities, aliased []string
+	getGeography(t, "/country/IN/Odisha/cities", http.StatusOK, &cities)
+	getGeography(t, "/country/india/orissa/cities", http.StatusOK, &aliased)
+	if len(cities) == 0 || !sort.StringsAreSorted(cities) || !reflect.DeepEqual(cities, aliased) {
+		t.Errorf("cities of Odisha = %d sorted %v, by former name %d", len(cities), sort.StringsAreSorted(cities), len(aliased))
+	}
+
+	for _, target := range []string{"/country/XX/state", "/country/XX/KA/cities", "/country/IN/Atlantis/cities", "/country/US/KA/cities"} {
+		getGeography(t, target, http.StatusNotFound, nil)
+	}
+}
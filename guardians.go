@@ -0,0 +1,493 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	guardianChangeAdd    = "ADD"
+	guardianChangeUpdate = "UPDATE"
+	guardianChangeUnlink = "UNLINK"
+
+	changePending  = "PENDING"
+	changeApproved = "APPROVED"
+	changeRejected = "REJECTED"
+)
+
+var guardianRelationships = []string{"FATHER", "MOTHER", "GUARDIAN", "GRANDPARENT", "SIBLING", "OTHER"}
+
+// GuardianModel is a parent or guardian. One guardian can be linked to
+// several students, e.g. siblings.
+type GuardianModel struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Phone      string `json:"phone"`
+	Email      string `json:"email"`
+	Occupation string `json:"occupation"`
+	UpdatedAt  string `json:"updatedAt"`
+}
+
+// guardianLink connects a guardian to a student. The relationship and the
+// custody and pickup flags can differ between the guardian's students.
+type guardianLink struct {
+	GuardianId   string
+	Relationship string
+	HasCustody   bool
+	CanPickUp    bool
+}
+
+// StudentGuardianModel is a guardian as seen from one student.
+type StudentGuardianModel struct {
+	GuardianId   string `json:"guardianId"`
+	Name         string `json:"name"`
+	Relationship string `json:"relationship"`
+	Phone        string `json:"phone"`
+	Email        string `json:"email"`
+	Occupation   string `json:"occupation"`
+	HasCustody   bool   `json:"hasCustody"`
+	CanPickUp    bool   `json:"canPickUp"`
+}
+
+// GuardianChangeRequestModel is a change to a student's guardians waiting
+// for, or decided by, an admin. Previous is the guardian when the request
+// was made, for the reviewer to compare against.
+type GuardianChangeRequestModel struct {
+	Id          string                `json:"id"`
+	StudentId   string                `json:"studentId"`
+	Action      string                `json:"action"`
+	GuardianId  string                `json:"guardianId,omitempty"`
+	Proposed    *StudentGuardianModel `json:"proposed,omitempty"`
+	Previous    *StudentGuardianModel `json:"previous,omitempty"`
+	Status      string                `json:"status"`
+	Reason      string                `json:"reason,omitempty"`
+	RequestedBy string                `json:"requestedBy"`
+	RequestedAt string                `json:"requestedAt"`
+	ReviewedBy  string                `json:"reviewedBy,omitempty"`
+	ReviewedAt  string                `json:"reviewedAt,omitempty"`
+}
+
+// GuardianChangeRequestDto asks to ADD a guardian to the student (a new one,
+// or an existing one by guardianId), UPDATE a linked guardian or UNLINK it.
+type GuardianChangeRequestDto struct {
+	StudentId  string               `json:"studentId"`
+	Action     string               `json:"action"`
+	GuardianId string               `json:"guardianId"`
+	Guardian   StudentGuardianModel `json:"guardian"`
+}
+
+type ChangeDecisionDto struct {
+	RequestId string `json:"requestId"`
+	Approve   bool   `json:"approve"`
+	Reason    string `json:"reason"`
+}
+
+type schoolGuardians struct {
+	guardians map[string]*GuardianModel
+	links     map[string][]guardianLink
+	requests  map[string]*GuardianChangeRequestModel
+}
+
+type guardianStore struct {
+	sync.RWMutex
+	schools map[string]*schoolGuardians
+}
+
+var guardians = &guardianStore{schools: map[string]*schoolGuardians{}}
+
+// school returns the guardians of a school by id. Callers must hold the
+// lock.
+func (s *guardianStore) school(schoolId string) *schoolGuardians {
+	school, ok := s.schools[schoolId]
+	if !ok {
+		school = &schoolGuardians{
+			guardians: map[string]*GuardianModel{},
+			links:     map[string][]guardianLink{},
+			requests:  map[string]*GuardianChangeRequestModel{},
+		}
+		s.schools[schoolId] = school
+	}
+	return school
+}
+
+// forStudent returns the student's guardians in the order they were linked.
+func (s *schoolGuardians) forStudent(studentId string) []StudentGuardianModel {
+	list := []StudentGuardianModel{}
+	for _, link := range s.links[studentId] {
+		if guardian, ok := s.guardians[link.GuardianId]; ok {
+			list = append(list, studentGuardian(*guardian, link))
+		}
+	}
+	return list
+}
+
+func (s *schoolGuardians) linkIndex(studentId, guardianId string) int {
+	for i, link := range s.links[studentId] {
+		if link.GuardianId == guardianId {
+			return i
+		}
+	}
+	return -1
+}
+
+// linkedToAny reports whether the guardian is linked to one of the students.
+func (s *schoolGuardians) linkedToAny(guardianId string, studentIds []string) bool {
+	for _, studentId := range studentIds {
+		if s.linkIndex(studentId, guardianId) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func studentGuardian(guardian GuardianModel, link guardianLink) StudentGuardianModel {
+	return StudentGuardianModel{
+		GuardianId:   guardian.Id,
+		Name:         guardian.Name,
+		Relationship: link.Relationship,
+		Phone:        guardian.Phone,
+		Email:        guardian.Email,
+		Occupation:   guardian.Occupation,
+		HasCustody:   link.HasCustody,
+		CanPickUp:    link.CanPickUp,
+	}
+}
+
+// normalizeGuardian tidies the guardian and validates it. Only the link
+// fields are checked when an existing guardian is being linked.
+func normalizeGuardian(guardian *StudentGuardianModel, linkOnly bool) []string {
+	var errs []string
+	guardian.Relationship = strings.ToUpper(strings.TrimSpace(guardian.Relationship))
+	if !containsValue(guardianRelationships, guardian.Relationship) {
+		errs = append(errs, "relationship must be one of "+strings.Join(guardianRelationships, ", "))
+	}
+	if linkOnly {
+		return errs
+	}
+	guardian.Name = strings.Join(strings.Fields(guardian.Name), " ")
+	guardian.Phone = normalizePhone(guardian.Phone)
+	guardian.Email = strings.ToLower(strings.TrimSpace(guardian.Email))
+	guardian.Occupation = strings.Join(strings.Fields(guardian.Occupation), " ")
+	if guardian.Name == "" || len(guardian.Name) > 100 {
+		errs = append(errs, "name is required and must be at most 100 characters")
+	}
+	if guardian.Phone == "" && guardian.Email == "" {
+		errs = append(errs, "phone or email is required")
+	}
+	if guardian.Phone != "" && !mobileNumberPattern.MatchString(guardian.Phone) {
+		errs = append(errs, "phone must be a 10 digit mobile number")
+	}
+	if guardian.Email != "" && !emailPattern.MatchString(guardian.Email) {
+		errs = append(errs, "email is not a valid email address")
+	}
+	if len(guardian.Occupation) > 60 {
+		errs = append(errs, "occupation must be at most 60 characters")
+	}
+	return errs
+}
+
+// apply makes an approved change take effect. It fails when the student's
+// guardians changed in a way that conflicts since the request was made.
+// Callers must hold the lock.
+func (s *schoolGuardians) apply(request *GuardianChangeRequestModel, now time.Time) string {
+	links := s.links[request.StudentId]
+	index := s.linkIndex(request.StudentId, request.GuardianId)
+	switch request.Action {
+	case guardianChangeAdd:
+		proposed := request.Proposed
+		if request.GuardianId == "" {
+			guardian := &GuardianModel{Id: newID("GRD"), Name: proposed.Name, Phone: proposed.Phone, Email: proposed.Email, Occupation: proposed.Occupation, UpdatedAt: now.Format(time.RFC3339)}
+			s.guardians[guardian.Id] = guardian
+			request.GuardianId = guardian.Id
+		} else if _, ok := s.guardians[request.GuardianId]; !ok {
+			return "Guardian " + request.GuardianId + " no longer exists"
+		} else if index >= 0 {
+			return "Guardian " + request.GuardianId + " is already linked to " + request.StudentId
+		}
+		s.links[request.StudentId] = append(links, guardianLink{GuardianId: request.GuardianId, Relationship: proposed.Relationship, HasCustody: proposed.HasCustody, CanPickUp: proposed.CanPickUp})
+	case guardianChangeUpdate:
+		if index < 0 {
+			return "Guardian " + request.GuardianId + " is no longer linked to " + request.StudentId
+		}
+		proposed := request.Proposed
+		guardian := s.guardians[request.GuardianId]
+		guardian.Name, guardian.Phone, guardian.Email, guardian.Occupation = proposed.Name, proposed.Phone, proposed.Email, proposed.Occupation
+		guardian.UpdatedAt = now.Format(time.RFC3339)
+		links[index] = guardianLink{GuardianId: request.GuardianId, Relationship: proposed.Relationship, HasCustody: proposed.HasCustody, CanPickUp: proposed.CanPickUp}
+	case guardianChangeUnlink:
+		if index < 0 {
+			return "Guardian " + request.GuardianId + " is no longer linked to " + request.StudentId
+		}
+		s.links[request.StudentId] = append(links[:index:index], links[index+1:]...)
+	}
+	return ""
+}
+
+// applyStudentInformation fills the profile's Information tab from the
+// student record and its guardians. Linked fathers and mothers take the
+// place of the names given at admission.
+func applyStudentInformation(page *CoreProfilePageModel, schoolId string, student StudentModel) {
+	list := []StudentGuardianModel{}
+	guardians.RLock()
+	if school, ok := guardians.schools[schoolId]; ok {
+		list = school.forStudent(student.Id)
+	}
+	guardians.RUnlock()
+	fatherName, motherName := student.FatherName, student.MotherName
+	for _, guardian := range list {
+		switch guardian.Relationship {
+		case "FATHER":
+			fatherName = guardian.Name
+		case "MOTHER":
+			motherName = guardian.Name
+		}
+	}
+	for i, item := range page.OptionMenuModel.MenuItems {
+		information, ok := item.DTO.(InformationDetailsModel)
+		if !ok {
+			continue
+		}
+		information.FatherNameValue = fatherName
+		information.MotherNameValue = motherName
+		information.Guardians = list
+		page.OptionMenuModel.MenuItems[i].DTO = information
+	}
+}
+
+// GuardiansHandler lists the student's guardians and the changes to them
+// still waiting for approval.
+func GuardiansHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if studentId == "" {
+		return c.JSON(http.StatusBadRequest, failed("studentId is required"))
+	}
+	if _, ok := students.get(schoolID(claims), studentId); !ok {
+		return c.JSON(http.StatusNotFound, failed("Student "+studentId+" not found"))
+	}
+
+	guardians.Lock()
+	defer guardians.Unlock()
+	school := guardians.school(schoolID(claims))
+	pending := []GuardianChangeRequestModel{}
+	for _, request := range school.requests {
+		if request.StudentId == studentId && request.Status == changePending {
+			pending = append(pending, *request)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Id < pending[j].Id })
+	return c.JSON(http.StatusOK, success(map[string]interface{}{
+		"guardians": school.forStudent(studentId),
+		"pending":   pending,
+	}))
+}
+
+// GuardianChangeHandler records a change to a student's guardians. Changes
+// by the student or a parent wait for an admin; an admin's apply at once.
+func GuardianChangeHandler(c echo.Context) error {
+	var req GuardianChangeRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role == roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	studentId, status, failure := scopedStudent(claims, req.StudentId)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if studentId == "" {
+		return c.JSON(http.StatusBadRequest, failed("studentId is required"))
+	}
+	if _, ok := students.get(schoolID(claims), studentId); !ok {
+		return c.JSON(http.StatusNotFound, failed("Student "+studentId+" not found"))
+	}
+	if role != roleAdmin && !studentAccounts.canActFor(schoolID(claims), claimString(claims, "id"), studentId) {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	req.Action = strings.ToUpper(strings.TrimSpace(req.Action))
+	req.GuardianId = strings.TrimSpace(req.GuardianId)
+	var errs []string
+	switch req.Action {
+	case guardianChangeAdd:
+		errs = normalizeGuardian(&req.Guardian, req.GuardianId != "")
+	case guardianChangeUpdate:
+		errs = normalizeGuardian(&req.Guardian, false)
+	case guardianChangeUnlink:
+	default:
+		errs = append(errs, "action must be ADD, UPDATE or UNLINK")
+	}
+	if req.Action != guardianChangeAdd && req.GuardianId == "" {
+		errs = append(errs, "guardianId is required to "+strings.ToLower(req.Action)+" a guardian")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid guardian change", errs...))
+	}
+
+	now := time.Now()
+	schoolId := schoolID(claims)
+	guardians.Lock()
+	defer guardians.Unlock()
+	school := guardians.school(schoolId)
+	request := &GuardianChangeRequestModel{
+		StudentId:   studentId,
+		Action:      req.Action,
+		GuardianId:  req.GuardianId,
+		Status:      changePending,
+		RequestedBy: claimString(claims, "id"),
+		RequestedAt: now.Format(time.RFC3339),
+	}
+	if req.Action != guardianChangeUnlink {
+		request.Proposed = &req.Guardian
+	}
+	if req.GuardianId != "" {
+		guardian, ok := school.guardians[req.GuardianId]
+		if !ok {
+			return c.JSON(http.StatusNotFound, failed("Guardian "+req.GuardianId+" not found"))
+		}
+		index := school.linkIndex(studentId, req.GuardianId)
+		switch {
+		case req.Action == guardianChangeAdd && index >= 0:
+			return c.JSON(http.StatusConflict, failed("Guardian "+req.GuardianId+" is already linked to "+studentId))
+		case req.Action == guardianChangeAdd && role != roleAdmin:
+			// Families may only link guardians of students they act for,
+			// such as a sibling's parents.
+			var siblings []string
+			for _, link := range studentAccounts.linked(schoolId, claimString(claims, "id")) {
+				siblings = append(siblings, link.StudentId)
+			}
+			if !school.linkedToAny(req.GuardianId, siblings) {
+				return c.JSON(http.StatusNotFound, failed("Guardian "+req.GuardianId+" not found"))
+			}
+		case req.Action != guardianChangeAdd && index < 0:
+			return c.JSON(http.StatusNotFound, failed("Guardian "+req.GuardianId+" is not linked to "+studentId))
+		case req.Action != guardianChangeAdd:
+			previous := studentGuardian(*guardian, school.links[studentId][index])
+			request.Previous = &previous
+		}
+		if request.Proposed != nil {
+			request.Proposed.GuardianId = req.GuardianId
+			if req.Action == guardianChangeAdd {
+				request.Proposed.Name, request.Proposed.Phone, request.Proposed.Email, request.Proposed.Occupation = guardian.Name, guardian.Phone, guardian.Email, guardian.Occupation
+			}
+		}
+		for _, existing := range school.requests {
+			if existing.Status == changePending && existing.StudentId == studentId && existing.GuardianId == req.GuardianId {
+				return c.JSON(http.StatusConflict, failed("A change to guardian "+req.GuardianId+" is already waiting for approval ("+existing.Id+")"))
+			}
+		}
+	}
+
+	request.Id = newID("GCR")
+	if role == roleAdmin {
+		if problem := school.apply(request, now); problem != "" {
+			return c.JSON(http.StatusConflict, failed(problem))
+		}
+		request.Status = changeApproved
+		request.ReviewedBy = request.RequestedBy
+		request.ReviewedAt = request.RequestedAt
+		if request.Proposed != nil {
+			request.Proposed.GuardianId = request.GuardianId
+		}
+	}
+	school.requests[request.Id] = request
+	return c.JSON(http.StatusOK, success(*request))
+}
+
+// GuardianChangeRequestsHandler lists guardian change requests, newest
+// first. Admins see the whole school, filtered by studentId and status;
+// families see their active student's.
+func GuardianChangeRequestsHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") == roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin && studentId == "" {
+		return c.JSON(http.StatusOK, success([]GuardianChangeRequestModel{}))
+	}
+	statusFilter := strings.ToUpper(c.QueryParam("status"))
+
+	guardians.RLock()
+	defer guardians.RUnlock()
+	result := []GuardianChangeRequestModel{}
+	if school, ok := guardians.schools[schoolID(claims)]; ok {
+		for _, request := range school.requests {
+			if (studentId == "" || request.StudentId == studentId) && (statusFilter == "" || request.Status == statusFilter) {
+				result = append(result, *request)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id > result[j].Id })
+	return c.JSON(http.StatusOK, success(result))
+}
+
+// GuardianChangeDecisionHandler approves or rejects a pending guardian
+// change. Approval applies it; rejection needs a reason.
+func GuardianChangeDecisionHandler(c echo.Context) error {
+	var req ChangeDecisionDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !req.Approve && req.Reason == "" {
+		return c.JSON(http.StatusBadRequest, failed("reason is required to reject a change"))
+	}
+
+	now := time.Now()
+	guardians.Lock()
+	defer guardians.Unlock()
+	school := guardians.school(schoolID(claims))
+	request, ok := school.requests[req.RequestId]
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Change request not found"))
+	}
+	if request.Status != changePending {
+		return c.JSON(http.StatusConflict, failed("Change request already "+request.Status))
+	}
+	if req.Approve {
+		if problem := school.apply(request, now); problem != "" {
+			return c.JSON(http.StatusConflict, failed(problem))
+		}
+		request.Status = changeApproved
+		if request.Proposed != nil {
+			request.Proposed.GuardianId = request.GuardianId
+		}
+	} else {
+		request.Status = changeRejected
+	}
+	request.Reason = req.Reason
+	request.ReviewedBy = claimString(claims, "id")
+	request.ReviewedAt = now.Format(time.RFC3339)
+	return c.JSON(http.StatusOK, success(*request))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// guardianFamily stores three students and links parent1 to the first two,
+// who are siblings, and parent2 to the third.
+func guardianFamily(t *testing.T) []string {
+	t.Helper()
+	resetStudents()
+	guardians = &guardianStore{schools: map[string]*schoolGuardians{}}
+	studentAccounts = &studentAccountStore{links: map[string]map[string][]StudentAccountLinkModel{}}
+
+	var ids []string
+	students.Lock()
+	for _, name := range []string{"Ravi Kumar", "Anu Kumar", "Mohan Das"} {
+		ids = append(ids, students.school(defaultSchoolID).insert(StudentModel{Name: name}).Id)
+	}
+	students.Unlock()
+	studentAccounts.Lock()
+	links := studentAccounts.school(defaultSchoolID)
+	for i, username := range []string{"parent1", "parent1", "parent2"} {
+		links[username] = append(links[username], StudentAccountLinkModel{Username: username, StudentId: ids[i], Relation: accountRelationParent})
+	}
+	studentAccounts.Unlock()
+	return ids
+}
+
+func TestNormalizeGuardian(t *testing.T) {
+	tests := []struct {
+		name     string
+		guardian StudentGuardianModel
+		linkOnly bool
+		want     StudentGuardianModel
+		wantErr  string
+	}{
+		{
+			name:     "tidied",
+			guardian: StudentGuardianModel{Name: " Suresh   Kumar ", Relationship: "father", Phone: "+91 98450-12345", Email: " Suresh@Mail.com "},
+			want:     StudentGuardianModel{Name: "Suresh Kumar", Relationship: "FATHER", Phone: "9845012345", Email: "suresh@mail.com"},
+		},
+		{
+			name:     "link only checks the relationship",
+			guardian: StudentGuardianModel{Relationship: "Mother"},
+			linkOnly: true,
+			want:     StudentGuardianModel{Relationship: "MOTHER"},
+		},
+		{name: "unknown relationship", guardian: StudentGuardianModel{Name: "Suresh", Relationship: "UNCLE", Phone: "9845012345"}, wantErr: "relationship must be one of"},
+		{name: "no contact", guardian: StudentGuardianModel{Name: "Suresh", Relationship: "FATHER"}, wantErr: "phone or email is required"},
+		{name: "landline", guardian: StudentGuardianModel{Name: "Suresh", Relationship: "FATHER", Phone: "0802345678"}, wantErr: "10 digit mobile number"},
+		{name: "bad email", guardian: StudentGuardianModel{Name: "Suresh", Relationship: "FATHER", Email: "suresh@mail"}, wantErr: "not a valid email"},
+		{name: "no name", guardian: StudentGuardianModel{Relationship: "FATHER", Phone: "9845012345"}, wantErr: "name is required"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			guardian := test.guardian
+			errs := normalizeGuardian(&guardian, test.linkOnly)
+			if test.wantErr != "" {
+				if !strings.Contains(strings.Join(errs, "; "), test.wantErr) {
+					t.Errorf("errors = %v, want %q", errs, test.wantErr)
+				}
+				return
+			}
+			if len(errs) > 0 || guardian != test.want {
+				t.Errorf("guardian = %+v, %v; want %+v", guardian, errs, test.want)
+			}
+		})
+	}
+}
+
+func TestGuardianChangeApproval(t *testing.T) {
+	ids := guardianFamily(t)
+	ravi, anu, mohan := ids[0], ids[1], ids[2]
+	admin := testToken(t, "admin", roleAdmin, "")
+	father := StudentGuardianModel{Name: "Suresh Kumar", Relationship: "FATHER", Phone: "9845012345"}
+
+	change := func(token string, req GuardianChangeRequestDto, status int) GuardianChangeRequestModel {
+		t.Helper()
+		var request GuardianChangeRequestModel
+		decodeData(t, callHandler(t, GuardianChangeHandler, http.MethodPost, "/profile/guardians", token, req), status, &request)
+		return request
+	}
+	decide := func(req ChangeDecisionDto, status int) GuardianChangeRequestModel {
+		t.Helper()
+		var request GuardianChangeRequestModel
+		decodeData(t, callHandler(t, GuardianChangeDecisionHandler, http.MethodPost, "/profile/guardians/requests/decision", admin, req), status, &request)
+		return request
+	}
+	linked := func(studentId string) []StudentGuardianModel {
+		guardians.RLock()
+		defer guardians.RUnlock()
+		return guardians.schools[defaultSchoolID].forStudent(studentId)
+	}
+
+	// A parent's change waits for an admin
+	added := change(testToken(t, "parent1", roleStudent, ravi), GuardianChangeRequestDto{Action: "add", Guardian: father}, http.StatusOK)
+	if added.Status != changePending || len(linked(ravi)) != 0 {
+		t.Fatalf("parent's change = %+v with %d guardians linked", added, len(linked(ravi)))
+	}
+	decide(ChangeDecisionDto{RequestId: added.Id}, http.StatusBadRequest)
+	approved := decide(ChangeDecisionDto{RequestId: added.Id, Approve: true}, http.StatusOK)
+	guardianId := approved.GuardianId
+	if approved.Status != changeApproved || guardianId == "" || approved.Proposed.GuardianId != guardianId {
+		t.Fatalf("approved = %+v", approved)
+	}
+	if list := linked(ravi); len(list) != 1 || list[0].Name != "Suresh Kumar" {
+		t.Fatalf("guardians = %+v", list)
+	}
+	decide(ChangeDecisionDto{RequestId: added.Id, Approve: true}, http.StatusConflict)
+
+	tests := []struct {
+		name   string
+		token  string
+		req    GuardianChangeRequestDto
+		status int
+	}{
+		{"teacher", testToken(t, "teacher1", roleTeacher, ""), GuardianChangeRequestDto{StudentId: ravi, Action: "UNLINK", GuardianId: guardianId}, http.StatusForbidden},
+		{"another family's guardian", testToken(t, "parent2", roleStudent, mohan), GuardianChangeRequestDto{Action: "ADD", GuardianId: guardianId, Guardian: father}, http.StatusNotFound},
+		{"another student", testToken(t, "parent2", roleStudent, mohan), GuardianChangeRequestDto{StudentId: ravi, Action: "UNLINK", GuardianId: guardianId}, http.StatusForbidden},
+		{"already linked", admin, GuardianChangeRequestDto{StudentId: ravi, Action: "ADD", GuardianId: guardianId, Guardian: father}, http.StatusConflict},
+		{"not linked", admin, GuardianChangeRequestDto{StudentId: anu, Action: "UNLINK", GuardianId: guardianId}, http.StatusNotFound},
+		{"no guardian id", admin, GuardianChangeRequestDto{StudentId: ravi, Action: "UPDATE", Guardian: father}, http.StatusBadRequest},
+		{"unknown action", admin, GuardianChangeRequestDto{StudentId: ravi, Action: "MERGE"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			change(test.token, test.req, test.status)
+		})
+	}
+
+	// A sibling's guardian can be linked, and keeps its details
+	sibling := change(testToken(t, "parent1", roleStudent, anu), GuardianChangeRequestDto{Action: "ADD", GuardianId: guardianId, Guardian: StudentGuardianModel{Relationship: "father"}}, http.StatusOK)
+	if sibling.Proposed.Name != "Suresh Kumar" || sibling.Proposed.Phone != father.Phone {
+		t.Errorf("sibling link proposes %+v", sibling.Proposed)
+	}
+
+	// One pending change per guardian and student
+	updated := father
+	updated.Phone = "9845099999"
+	parent := testToken(t, "parent1", roleStudent, ravi)
+	pending := change(parent, GuardianChangeRequestDto{Action: "UPDATE", GuardianId: guardianId, Guardian: updated}, http.StatusOK)
+	if pending.Previous == nil || pending.Previous.Phone != father.Phone {
+		t.Errorf("previous = %+v", pending.Previous)
+	}
+	change(parent, GuardianChangeRequestDto{Action: "UNLINK", GuardianId: guardianId}, http.StatusConflict)
+
+	// Admins wait for the pending change too, then apply theirs at once
+	change(admin, GuardianChangeRequestDto{StudentId: ravi, Action: "UNLINK", GuardianId: guardianId}, http.StatusConflict)
+	rejected := decide(ChangeDecisionDto{RequestId: pending.Id, Reason: "Unlinking instead"}, http.StatusOK)
+	if rejected.Status != changeRejected || rejected.ReviewedBy != "admin" || rejected.Reason != "Unlinking instead" {
+		t.Errorf("rejected = %+v", rejected)
+	}
+	unlinked := change(admin, GuardianChangeRequestDto{StudentId: ravi, Action: "UNLINK", GuardianId: guardianId}, http.StatusOK)
+	if unlinked.Status != changeApproved || len(linked(ravi)) != 0 {
+		t.Errorf("admin unlink = %+v with %d guardians linked", unlinked, len(linked(ravi)))
+	}
+
+	// The sibling link still applies, since the guardian exists
+	if approved := decide(ChangeDecisionDto{RequestId: sibling.Id, Approve: true}, http.StatusOK); approved.Status != changeApproved {
+		t.Errorf("sibling link = %+v", approved)
+	}
+	if list := linked(anu); len(list) != 1 || list[0].GuardianId != guardianId || list[0].Relationship != "FATHER" {
+		t.Errorf("sibling guardians = %+v", list)
+	}
+}
+
+func TestGuardianApplyRejectsStaleChanges(t *testing.T) {
+	school := (&guardianStore{schools: map[string]*schoolGuardians{}}).school(defaultSchoolID)
+	school.guardians["GRD-1"] = &GuardianModel{Id: "GRD-1", Name: "Suresh Kumar"}
+	school.links["STU-1"] = []guardianLink{{GuardianId: "GRD-1", Relationship: "FATHER"}}
+	proposed := &StudentGuardianModel{Name: "Suresh Kumar", Relationship: "FATHER"}
+	tests := []struct {
+		name    string
+		request GuardianChangeRequestModel
+		want    string
+	}{
+		{"add a removed guardian", GuardianChangeRequestModel{StudentId: "STU-2", Action: guardianChangeAdd, GuardianId: "GRD-9", Proposed: proposed}, "Guardian GRD-9 no longer exists"},
+		{"add a linked guardian", GuardianChangeRequestModel{StudentId: "STU-1", Action: guardianChangeAdd, GuardianId: "GRD-1", Proposed: proposed}, "Guardian GRD-1 is already linked to STU-1"},
+		{"update an unlinked guardian", GuardianChangeRequestModel{StudentId: "STU-2", Action: guardianChangeUpdate, GuardianId: "GRD-1", Proposed: proposed}, "Guardian GRD-1 is no longer linked to STU-2"},
+		{"unlink an unlinked guardian", GuardianChangeRequestModel{StudentId: "STU-2", Action: guardianChangeUnlink, GuardianId: "GRD-1"}, "Guardian GRD-1 is no longer linked to STU-2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := test.request
+			if got := school.apply(&request, time.Now()); got != test.want {
+				t.Errorf("apply = %q, want %q", got, test.want)
+			}
+		})
+	}
+	if len(school.links["STU-1"]) != 1 || len(school.links["STU-2"]) != 0 {
+		t.Errorf("links changed: %+v", school.links)
+	}
+}
+
+func TestApplyStudentInformation(t *testing.T) {
+	guardians = &guardianStore{schools: map[string]*schoolGuardians{}}
+	student := StudentModel{Id: "STU-1", FatherName: "Suresh", MotherName: "Lakshmi"}
+	information := func(page CoreProfilePageModel) InformationDetailsModel {
+		t.Helper()
+		for _, item := range page.OptionMenuModel.MenuItems {
+			if information, ok := item.DTO.(InformationDetailsModel); ok {
+				return information
+			}
+		}
+		t.Fatal("no Information tab")
+		return InformationDetailsModel{}
+	}
+
+	// Viewing a school without guardians leaves the store alone
+	page := fillProfileModel()
+	applyStudentInformation(&page, "SCH-NONE", student)
+	if got := information(page); got.FatherNameValue != "Suresh" || got.MotherNameValue != "Lakshmi" || got.Guardians == nil {
+		t.Errorf("information = %+v", got)
+	}
+	if len(guardians.schools) != 0 {
+		t.Errorf("a profile view added schools %v", guardians.schools)
+	}
+
+	guardians.Lock()
+	school := guardians.school(defaultSchoolID)
+	school.guardians["GRD-1"] = &GuardianModel{Id: "GRD-1", Name: "Suresh Kumar"}
+	school.links["STU-1"] = []guardianLink{{GuardianId: "GRD-1", Relationship: "FATHER"}}
+	guardians.Unlock()
+	page = fillProfileModel()
+	applyStudentInformation(&page, defaultSchoolID, student)
+	if got := information(page); got.FatherNameValue != "Suresh Kumar" || got.MotherNameValue != "Lakshmi" || len(got.Guardians) != 1 {
+		t.Errorf("information = %+v", got)
+	}
+}
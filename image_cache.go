@@ -0,0 +1,396 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultImageCacheBudget = 512 << 20
+	imageCacheTTL           = 24 * time.Hour
+)
+
+// cachedImage is an image held in the on-disk cache. The data lives in
+// <dir>/<hash> and the rest in the <hash>.meta sidecar.
+type cachedImage struct {
+	Key          string    `json:"key"`
+	ContentType  string    `json:"contentType"`
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"lastModified"`
+	Size         int64     `json:"size"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+type ImageCacheStatsModel struct {
+	Entries        int     `json:"entries"`
+	Bytes          int64   `json:"bytes"`
+	BudgetBytes    int64   `json:"budgetBytes"`
+	Hits           int64   `json:"hits"`
+	Misses         int64   `json:"misses"`
+	Coalesced      int64   `json:"coalesced"`
+	Evictions      int64   `json:"evictions"`
+	UpstreamErrors int64   `json:"upstreamErrors"`
+	NotModified    int64   `json:"notModified"`
+	HitRate        float64 `json:"hitRate"`
+}
+
+// imageFetchCall is an upstream fetch shared by every request for the same
+// key. It is cancelled once all of them have gone away.
+type imageFetchCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	entry   cachedImage
+	data    []byte
+	err     error
+}
+
+// imageCacheStore is an LRU cache of proxied images with a byte budget.
+type imageCacheStore struct {
+	sync.Mutex
+	dir      string
+	budget   int64
+	size     int64
+	loadOnce sync.Once
+	lru      *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*imageFetchCall
+	stats    ImageCacheStatsModel
+}
+
+// imageCache is the cache in front of /image. IMAGE_CACHE_DIR and
+// IMAGE_CACHE_MAX_BYTES override its location and budget.
+var imageCache = newImageCacheStore(imageCacheDir(), imageCacheBudget())
+
+func imageCacheDir() string {
+	if dir := os.Getenv("IMAGE_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("data", "image-cache")
+}
+
+func imageCacheBudget() int64 {
+	if value := os.Getenv("IMAGE_CACHE_MAX_BYTES"); value != "" {
+		if budget, err := strconv.ParseInt(value, 10, 64); err == nil && budget > 0 {
+			return budget
+		}
+		log.Printf("ignoring invalid IMAGE_CACHE_MAX_BYTES %q", value)
+	}
+	return defaultImageCacheBudget
+}
+
+func newImageCacheStore(dir string, budget int64) *imageCacheStore {
+	return &imageCacheStore{
+		dir:      dir,
+		budget:   budget,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]*imageFetchCall{},
+	}
+}
+
+func (s *imageCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// load indexes the images left on disk by an earlier run, least recently
+// used first. The disk is scanned once, before the lock is taken.
+func (s *imageCacheStore) load() {
+	s.loadOnce.Do(func() {
+		metas, _ := filepath.Glob(filepath.Join(s.dir, "*.meta"))
+		type found struct {
+			entry cachedImage
+			used  time.Time
+		}
+		var entries []found
+		for _, meta := range metas {
+			raw, err := os.ReadFile(meta)
+			var entry cachedImage
+			if err != nil || json.Unmarshal(raw, &entry) != nil {
+				continue
+			}
+			info, err := os.Stat(strings.TrimSuffix(meta, ".meta"))
+			if err != nil || info.Size() != entry.Size {
+				os.Remove(meta)
+				continue
+			}
+			entries = append(entries, found{entry, info.ModTime()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+
+		s.Lock()
+		for _, found := range entries {
+			if _, ok := s.entries[found.entry.Key]; ok {
+				continue
+			}
+			s.entries[found.entry.Key] = s.lru.PushFront(found.entry)
+			s.size += found.entry.Size
+		}
+		stale := s.evict()
+		s.Unlock()
+		removeCachedFiles(stale)
+	})
+}
+
+// evict drops least recently used images until the cache fits its budget
+// and returns their paths for removeCachedFiles. Callers must hold the
+// lock.
+func (s *imageCacheStore) evict() []string {
+	var stale []string
+	for s.size > s.budget && s.lru.Len() > 0 {
+		stale = append(stale, s.remove(s.lru.Back()))
+		s.stats.Evictions++
+	}
+	return stale
+}
+
+// remove drops an entry from the index and returns the path of its files.
+// Callers must hold the lock.
+func (s *imageCacheStore) remove(element *list.Element) string {
+	entry := s.lru.Remove(element).(cachedImage)
+	delete(s.entries, entry.Key)
+	s.size -= entry.Size
+	return s.path(entry.Key)
+}
+
+// removeCachedFiles deletes the files of dropped entries. Call it without
+// the lock.
+func removeCachedFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+		os.Remove(path + ".meta")
+	}
+}
+
+// lookup returns a fresh cached image. The index is consulted under the
+// lock and the file is read after releasing it.
+func (s *imageCacheStore) lookup(key string, now time.Time) (cachedImage, []byte, bool) {
+	s.Lock()
+	element, ok := s.entries[key]
+	if !ok {
+		s.Unlock()
+		return cachedImage{}, nil, false
+	}
+	entry := element.Value.(cachedImage)
+	if now.Sub(entry.FetchedAt) > imageCacheTTL {
+		stale := s.remove(element)
+		s.Unlock()
+		removeCachedFiles([]string{stale})
+		return cachedImage{}, nil, false
+	}
+	s.lru.MoveToFront(element)
+	s.Unlock()
+
+	path := s.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil || int64(len(data)) != entry.Size {
+		// Evicted or replaced meanwhile, or damaged on disk
+		s.Lock()
+		if current, ok := s.entries[key]; ok && current.Value.(cachedImage) == entry {
+			s.remove(current)
+		}
+		s.Unlock()
+		return cachedImage{}, nil, false
+	}
+	os.Chtimes(path, now, now)
+	return entry, data, true
+}
+
+// write stores a fetched image on disk. Images larger than the whole
+// budget are served but not kept. It runs without the lock.
+func (s *imageCacheStore) write(entry cachedImage, data []byte) bool {
+	if entry.Size > s.budget {
+		return false
+	}
+	meta, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(s.dir, 0o755)
+	}
+	path := s.path(entry.Key)
+	if err == nil {
+		err = writeFileAtomic(path, data)
+	}
+	if err == nil {
+		err = writeFileAtomic(path+".meta", meta)
+	}
+	if err != nil {
+		log.Printf("image cache: storing %s: %v", entry.Key, err)
+		os.Remove(path)
+		return false
+	}
+	return true
+}
+
+// index adds a written image to the LRU, replacing an older copy, and
+// returns the paths of the images evicted to make room. Callers must hold
+// the lock.
+func (s *imageCacheStore) index(entry cachedImage) []string {
+	if element, ok := s.entries[entry.Key]; ok {
+		// The files were overwritten in place
+		s.remove(element)
+	}
+	s.entries[entry.Key] = s.lru.PushFront(entry)
+	s.size += entry.Size
+	return s.evict()
+}
+
+// get returns the image for key from the cache, calling fetch on a miss.
+// Concurrent misses for the same key share one fetch.
+func (s *imageCacheStore) get(ctx context.Context, key string, fetch func(context.Context) (proxiedImage, error)) (cachedImage, []byte, error) {
+	s.load()
+	if entry, data, ok := s.lookup(key, time.Now()); ok {
+		s.Lock()
+		s.stats.Hits++
+		s.Unlock()
+		return entry, data, nil
+	}
+	s.Lock()
+	s.stats.Misses++
+	call, ok := s.inflight[key]
+	if ok {
+		s.stats.Coalesced++
+	} else {
+		fetchCtx, cancel := context.WithTimeout(context.Background(), imageFetchTimeout)
+		call = &imageFetchCall{done: make(chan struct{}), cancel: cancel}
+		s.inflight[key] = call
+		go s.fetch(fetchCtx, key, call, fetch)
+	}
+	call.waiters++
+	s.Unlock()
+
+	select {
+	case <-call.done:
+		return call.entry, call.data, call.err
+	case <-ctx.Done():
+		s.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if s.inflight[key] == call {
+				delete(s.inflight, key)
+			}
+		}
+		s.Unlock()
+		return cachedImage{}, nil, ctx.Err()
+	}
+}
+
+func (s *imageCacheStore) fetch(ctx context.Context, key string, call *imageFetchCall, fetch func(context.Context) (proxiedImage, error)) {
+	defer call.cancel()
+	image, err := fetch(ctx)
+	var entry cachedImage
+	stored := false
+	if err == nil {
+		sum := sha256.Sum256(image.data)
+		entry = cachedImage{
+			Key:          key,
+			ContentType:  image.contentType,
+			ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+			LastModified: image.lastModified,
+			Size:         int64(len(image.data)),
+			FetchedAt:    time.Now().UTC(),
+		}
+		if entry.LastModified == "" {
+			entry.LastModified = entry.FetchedAt.Format(http.TimeFormat)
+		}
+		stored = s.write(entry, image.data)
+	}
+
+	s.Lock()
+	if s.inflight[key] == call {
+		delete(s.inflight, key)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			s.stats.UpstreamErrors++
+		}
+		call.err = err
+		close(call.done)
+		s.Unlock()
+		return
+	}
+	call.entry, call.data = entry, image.data
+	var stale []string
+	if stored {
+		stale = s.index(entry)
+	}
+	close(call.done)
+	s.Unlock()
+	removeCachedFiles(stale)
+}
+
+func (s *imageCacheStore) snapshot() ImageCacheStatsModel {
+	s.load()
+	s.Lock()
+	defer s.Unlock()
+	stats := s.stats
+	stats.Entries = s.lru.Len()
+	stats.Bytes = s.size
+	stats.BudgetBytes = s.budget
+	stats.HitRate = percent(int(stats.Hits), int(stats.Hits+stats.Misses))
+	return stats
+}
+
+// notModified reports whether the request's conditional headers match the
+// cached image.
+func notModified(r *http.Request, entry cachedImage) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == entry.ETag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(entry.LastModified)
+	return err == nil && !modified.After(since)
+}
+
+// serveCachedImage answers with the image or 304 when the client copy is
+// current.
+func serveCachedImage(c echo.Context, entry cachedImage, data []byte, cacheControl string) error {
+	header := c.Response().Header()
+	header.Set("Cache-Control", cacheControl)
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("ETag", entry.ETag)
+	header.Set("Last-Modified", entry.LastModified)
+	if notModified(c.Request(), entry) {
+		imageCache.Lock()
+		imageCache.stats.NotModified++
+		imageCache.Unlock()
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, entry.ContentType, data)
+}
+
+// ImageCacheStatsHandler reports the image cache counters for monitoring.
+func ImageCacheStatsHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	return c.JSON(http.StatusOK, success(imageCache.snapshot()))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFetch returns a fetch that serves data and counts its calls.
+func countingFetch(calls *int32, data string) func(context.Context) (proxiedImage, error) {
+	return func(context.Context) (proxiedImage, error) {
+		atomic.AddInt32(calls, 1)
+		return proxiedImage{data: []byte(data), contentType: "image/png"}, nil
+	}
+}
+
+func TestImageCacheCoalescesMisses(t *testing.T) {
+	cache := newImageCacheStore(t.TempDir(), 1<<20)
+	release := make(chan struct{})
+	var calls int32
+	fetch := func(ctx context.Context) (proxiedImage, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return proxiedImage{data: []byte("png"), contentType: "image/png"}, nil
+	}
+
+	const requests = 10
+	var wg sync.WaitGroup
+	results := make([]string, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, data, err := cache.get(context.Background(), "a", fetch)
+			if err != nil {
+				t.Errorf("get: %v", err)
+			}
+			results[i] = string(data)
+		}(i)
+	}
+	// Wait for every request to join the fetch before releasing it
+	for deadline := time.Now().Add(5 * time.Second); cache.snapshot().Misses < requests; {
+		if time.Now().After(deadline) {
+			t.Fatal("requests did not reach the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("fetched %d times, want once", calls)
+	}
+	for i, result := range results {
+		if result != "png" {
+			t.Errorf("request %d got %q", i, result)
+		}
+	}
+	if stats := cache.snapshot(); stats.Coalesced != requests-1 || stats.Entries != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if _, _, err := cache.get(context.Background(), "a", fetch); err != nil || cache.snapshot().Hits != 1 {
+		t.Errorf("second get: %v, stats %+v", err, cache.snapshot())
+	}
+}
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	cache := newImageCacheStore(dir, 10)
+	var calls int32
+	get := func(key string) {
+		t.Helper()
+		if _, _, err := cache.get(context.Background(), key, countingFetch(&calls, "1234")); err != nil {
+			t.Fatalf("get %s: %v", key, err)
+		}
+	}
+	get("a")
+	get("b")
+	get("a") // a is now the most recently used
+	get("c") // over budget, b goes
+
+	tests := []struct {
+		key  string
+		kept bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	for _, test := range tests {
+		_, err := os.Stat(cache.path(test.key))
+		if kept := err == nil; kept != test.kept {
+			t.Errorf("%s on disk = %v, want %v", test.key, kept, test.kept)
+		}
+		cache.Lock()
+		_, indexed := cache.entries[test.key]
+		cache.Unlock()
+		if indexed != test.kept {
+			t.Errorf("%s indexed = %v, want %v", test.key, indexed, test.kept)
+		}
+	}
+	if stats := cache.snapshot(); stats.Bytes != 8 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Images larger than the whole budget are served but not kept
+	_, data, err := cache.get(context.Background(), "huge", countingFetch(&calls, "12345678901"))
+	if err != nil || string(data) != "12345678901" {
+		t.Errorf("huge image = %q, %v", data, err)
+	}
+	if _, err := os.Stat(cache.path("huge")); err == nil {
+		t.Error("an image over the budget was stored")
+	}
+
+	// A restart finds the images on disk
+	reloaded := newImageCacheStore(dir, 10)
+	before := atomic.LoadInt32(&calls)
+	for _, key := range []string{"a", "c"} {
+		if _, _, err := reloaded.get(context.Background(), key, countingFetch(&calls, "1234")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != before || reloaded.snapshot().Hits != 2 {
+		t.Errorf("reloaded cache fetched %d images, stats %+v", calls-before, reloaded.snapshot())
+	}
+}
+
+func TestImageCacheDropsDamagedFiles(t *testing.T) {
+	cache := newImageCacheStore(t.TempDir(), 1<<20)
+	var calls int32
+	cache.get(context.Background(), "a", countingFetch(&calls, "1234"))
+	if err := os.WriteFile(cache.path("a"), []byte("12"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.lookup("a", time.Now()); ok {
+		t.Error("a truncated file was served")
+	}
+	if _, _, ok := cache.lookup("a", time.Now()); ok || cache.snapshot().Entries != 0 {
+		t.Errorf("damaged entry kept, stats %+v", cache.snapshot())
+	}
+}
+
+func TestImageCacheCancelsAbandonedFetch(t *testing.T) {
+	cache := newImageCacheStore(t.TempDir(), 1<<20)
+	cancelled := make(chan struct{})
+	fetch := func(ctx context.Context) (proxiedImage, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return proxiedImage{}, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for cache.snapshot().Misses == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, _, err := cache.get(ctx, "a", fetch); !errors.Is(err, context.Canceled) {
+		t.Errorf("get = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the upstream fetch was not cancelled")
+	}
+	if stats := cache.snapshot(); stats.UpstreamErrors != 0 {
+		t.Errorf("a cancelled fetch counts as an upstream error: %+v", stats)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	entry := cachedImage{ETag: `"abc"`, LastModified: "Mon, 11 Mar 2030 09:00:00 GMT"}
+	tests := []struct {
+		name          string
+		noneMatch     string
+		modifiedSince string
+		want          bool
+	}{
+		{"no conditions", "", "", false},
+		{"same etag", `"abc"`, "", true},
+		{"weak etag in a list", `"x", W/"abc"`, "", true},
+		{"any", "*", "", true},
+		{"other etag wins over date", `"x"`, "Mon, 11 Mar 2030 10:00:00 GMT", false},
+		{"modified since earlier", "", "Mon, 11 Mar 2030 08:00:00 GMT", false},
+		{"not modified since", "", "Mon, 11 Mar 2030 09:00:00 GMT", true},
+		{"bad date", "", "yesterday", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/image", nil)
+		if test.noneMatch != "" {
+			req.Header.Set("If-None-Match", test.noneMatch)
+		}
+		if test.modifiedSince != "" {
+			req.Header.Set("If-Modified-Since", test.modifiedSince)
+		}
+		if got := notModified(req, entry); got != test.want {
+			t.Errorf("%s: notModified = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	driveImageURL        = "https://drive.google.com/uc?id=%s"
+	maxProxiedImageSize  = 10 << 20
+	maxImageRedirects    = 5
+	imageConnectTimeout  = 5 * time.Second
+	imageResponseTimeout = 10 * time.Second
+	imageFetchTimeout    = 30 * time.Second
+
+	publicImageCacheControl = "public, max-age=604800"
+)
+
+var (
+	driveFileIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{10,128}$`)
+
+	// proxiedImageTypes are the only content types passed on to clients.
+	proxiedImageTypes = map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+		"image/webp": true,
+	}
+
+	// imageProxyHosts are the hosts Drive may redirect a download to.
+	imageProxyHosts = []string{"drive.google.com", "drive.usercontent.google.com", ".googleusercontent.com"}
+)
+
+// imageProxyClient is shared by all proxy requests so connections to the
+// upstream are pooled.
+var imageProxyClient = &http.Client{
+	Timeout: imageFetchTimeout,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: imageConnectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   imageConnectTimeout,
+		ResponseHeaderTimeout: imageResponseTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxImageRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "https" || !imageProxyHostAllowed(req.URL.Hostname()) {
+			return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+		}
+		return nil
+	},
+}
+
+func imageProxyHostAllowed(host string) bool {
+	for _, allowed := range imageProxyHosts {
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxiedImage is an image fetched from the upstream.
+type proxiedImage struct {
+	data         []byte
+	contentType  string
+	lastModified string
+}
+
+// imageFetchError is an upstream failure mapped to the status and message
+// returned to the client.
+type imageFetchError struct {
+	status     int
+	message    string
+	retryAfter string
+}
+
+func (e *imageFetchError) Error() string {
+	return e.message
+}
+
+// upstreamImageError maps an upstream HTTP status to the client response.
+func upstreamImageError(resp *http.Response) *imageFetchError {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &imageFetchError{status: http.StatusNotFound, message: "Image not found"}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &imageFetchError{status: http.StatusForbidden, message: "Image is not shared publicly"}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &imageFetchError{status: http.StatusServiceUnavailable, message: "Image host is rate limiting, try again later", retryAfter: resp.Header.Get("Retry-After")}
+	default:
+		return &imageFetchError{status: http.StatusBadGateway, message: fmt.Sprintf("Image host returned %d", resp.StatusCode)}
+	}
+}
+
+// fetchImage downloads an image, enforcing the size limit and content type
+// allow-list. The request is cancelled with its context.
+func fetchImage(req *http.Request) (proxiedImage, error) {
+	ctx := req.Context()
+	resp, err := imageProxyClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return proxiedImage{}, ctx.Err()
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return proxiedImage{}, &imageFetchError{status: http.StatusGatewayTimeout, message: "Image host timed out"}
+		}
+		return proxiedImage{}, &imageFetchError{status: http.StatusBadGateway, message: "Failed to reach the image host"}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return proxiedImage{}, upstreamImageError(resp)
+	}
+	if resp.ContentLength > maxProxiedImageSize {
+		return proxiedImage{}, &imageFetchError{status: http.StatusBadGateway, message: "Image is larger than 10 MB"}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProxiedImageSize+1))
+	if err != nil {
+		if ctx.Err() != nil {
+			return proxiedImage{}, ctx.Err()
+		}
+		return proxiedImage{}, &imageFetchError{status: http.StatusBadGateway, message: "Failed to read the image"}
+	}
+	if len(data) > maxProxiedImageSize {
+		return proxiedImage{}, &imageFetchError{status: http.StatusBadGateway, message: "Image is larger than 10 MB"}
+	}
+
+	contentType, err := checkImageContent(data, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	return proxiedImage{data: data, contentType: contentType, lastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// checkImageContent returns the content type of an allowed image. Drive
+// answers unshared or oversized files with an HTML page and labels some
+// downloads application/octet-stream, so the body decides.
+func checkImageContent(data []byte, declaredType string) (string, error) {
+	declared, _, _ := mime.ParseMediaType(declaredType)
+	sniffed := http.DetectContentType(data)
+	if !(proxiedImageTypes[declared] || declared == "application/octet-stream" || declared == "") || !proxiedImageTypes[sniffed] {
+		return "", &imageFetchError{status: http.StatusUnsupportedMediaType, message: "File is not a supported image"}
+	}
+	return sniffed, nil
+}
+
+// writeImageError answers a failed fetch. Nothing is written when the
+// client has gone away.
+func writeImageError(c echo.Context, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return c.JSON(http.StatusGatewayTimeout, failed("Image host timed out"))
+	}
+	var fetchErr *imageFetchError
+	if !errors.As(err, &fetchErr) {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to fetch image"))
+	}
+	if fetchErr.retryAfter != "" {
+		c.Response().Header().Set("Retry-After", fetchErr.retryAfter)
+	}
+	return c.JSON(fetchErr.status, failed(fetchErr.message))
+}
+
+// handleImageProxy serves an image through the image cache, resized when
+// width or height is given. id names a Google Drive file registered as
+// PUBLIC media; school and key name an object in that school's configured
+// image source. Drive and S3 objects must also be registered as PUBLIC
+// media, since their keys reach files the API never stored; the school's
+// own local files are served unless restricted. Everything else is only
+// served through signed media URLs.
+func handleImageProxy(c echo.Context) error {
+	if key := c.QueryParam("key"); key != "" {
+		schoolId := c.QueryParam("school")
+		if schoolId == "" {
+			return c.JSON(http.StatusBadRequest, failed("school is required with key"))
+		}
+		source := imageSources.forSchool(schoolId)
+		if problem := source.ValidateKey(schoolId, key); problem != "" {
+			return c.JSON(http.StatusBadRequest, failed(problem))
+		}
+		cacheKey := source.CacheKey(key)
+		_, local := source.(localImageSource)
+		if mediaItems.isRestricted(cacheKey) || (!local && !mediaItems.isPublic(cacheKey)) {
+			return c.JSON(http.StatusForbidden, failed("This file needs a signed media URL"))
+		}
+		return serveImage(c, cacheKey, publicImageCacheControl, func(ctx context.Context) (proxiedImage, error) {
+			return source.Fetch(ctx, schoolId, key)
+		})
+	}
+
+	fileID := c.QueryParam("id")
+	if fileID == "" {
+		return c.JSON(http.StatusBadRequest, failed("File ID is required"))
+	}
+	var drive driveImageSource
+	if problem := drive.ValidateKey("", fileID); problem != "" {
+		return c.JSON(http.StatusBadRequest, failed(problem))
+	}
+	cacheKey := drive.CacheKey(fileID)
+	if !mediaItems.isPublic(cacheKey) {
+		return c.JSON(http.StatusForbidden, failed("This file needs a signed media URL"))
+	}
+	return serveImage(c, cacheKey, publicImageCacheControl, func(ctx context.Context) (proxiedImage, error) {
+		return drive.Fetch(ctx, "", fileID)
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fetchFrom fetches url with the proxy client, as the image sources do.
+func fetchFrom(t *testing.T, ctx context.Context, url string) (proxiedImage, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetchImage(req)
+}
+
+func wantFetchError(t *testing.T, err error, status int, message string) {
+	t.Helper()
+	var fetchErr *imageFetchError
+	if !errors.As(err, &fetchErr) || fetchErr.status != status || !strings.Contains(fetchErr.message, message) {
+		t.Errorf("error = %v, want %d %q", err, status, message)
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	pngData := testPNG(t)
+	oversized := make([]byte, maxProxiedImageSize+1)
+	copy(oversized, pngData)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2030 15:04:05 GMT")
+			w.Write(pngData)
+		case "/declared-large":
+			w.Header().Set("Content-Length", strconv.Itoa(maxProxiedImageSize+1))
+			w.Write(pngData)
+		case "/streamed-large":
+			// Flushing first drops the Content-Length, so only the read limit applies
+			w.Write(oversized[:512])
+			w.(http.Flusher).Flush()
+			w.Write(oversized[512:])
+		case "/exact-limit":
+			w.Write(oversized[:maxProxiedImageSize])
+		case "/share-page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>Sign in to view</body></html>"))
+		case "/redirect-out":
+			http.Redirect(w, r, "https://example.com/photo", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	image, err := fetchFrom(t, context.Background(), server.URL+"/photo")
+	if err != nil || image.contentType != "image/png" || !bytes.Equal(image.data, pngData) || image.lastModified != "Mon, 02 Jan 2030 15:04:05 GMT" {
+		t.Fatalf("image = %s %d bytes %q, %v", image.contentType, len(image.data), image.lastModified, err)
+	}
+	if image, err := fetchFrom(t, context.Background(), server.URL+"/exact-limit"); err != nil || len(image.data) != maxProxiedImageSize {
+		t.Errorf("image at the limit = %d bytes, %v", len(image.data), err)
+	}
+
+	tests := []struct {
+		path    string
+		status  int
+		message string
+	}{
+		{"/declared-large", http.StatusBadGateway, "larger than 10 MB"},
+		{"/streamed-large", http.StatusBadGateway, "larger than 10 MB"},
+		{"/share-page", http.StatusUnsupportedMediaType, "not a supported image"},
+		{"/missing", http.StatusNotFound, "Image not found"},
+		{"/redirect-out", http.StatusBadGateway, "Failed to reach the image host"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			_, err := fetchFrom(t, context.Background(), server.URL+test.path)
+			wantFetchError(t, err, test.status, test.message)
+		})
+	}
+}
+
+func TestFetchImageCancelled(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetchFrom(t, ctx, server.URL)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch was not cancelled")
+	}
+}
+
+func TestUpstreamImageError(t *testing.T) {
+	tests := []struct {
+		upstream   int
+		retryAfter string
+		status     int
+		message    string
+	}{
+		{http.StatusNotFound, "", http.StatusNotFound, "Image not found"},
+		{http.StatusUnauthorized, "", http.StatusForbidden, "not shared publicly"},
+		{http.StatusForbidden, "", http.StatusForbidden, "not shared publicly"},
+		{http.StatusTooManyRequests, "120", http.StatusServiceUnavailable, "rate limiting"},
+		{http.StatusInternalServerError, "", http.StatusBadGateway, "returned 500"},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.upstream, Header: http.Header{}}
+		if test.retryAfter != "" {
+			resp.Header.Set("Retry-After", test.retryAfter)
+		}
+		err := upstreamImageError(resp)
+		if err.status != test.status || !strings.Contains(err.message, test.message) || err.retryAfter != test.retryAfter {
+			t.Errorf("upstream %d = %+v", test.upstream, err)
+		}
+	}
+}
+
+func TestCheckImageContent(t *testing.T) {
+	pngData := testPNG(t)
+	tests := []struct {
+		name     string
+		data     []byte
+		declared string
+		want     string
+	}{
+		{"declared png", pngData, "image/png", "image/png"},
+		{"octet stream", pngData, "application/octet-stream", "image/png"},
+		{"undeclared", pngData, "", "image/png"},
+		{"mislabelled image type", pngData, "image/jpeg; charset=binary", "image/png"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif", "image/gif"},
+		{"html page", []byte("<html><body>Sign in</body></html>"), "text/html", ""},
+		{"html labelled as image", []byte("<html><body>Sign in</body></html>"), "image/png", ""},
+		{"png labelled as html", pngData, "text/html", ""},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "image/svg+xml", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := checkImageContent(test.data, test.declared)
+			if test.want == "" {
+				wantFetchError(t, err, http.StatusUnsupportedMediaType, "not a supported image")
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("content type = %q, %v; want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestImageProxyRedirects(t *testing.T) {
+	hosts := []struct {
+		host string
+		want bool
+	}{
+		{"drive.google.com", true},
+		{"drive.usercontent.google.com", true},
+		{"lh3.googleusercontent.com", true},
+		{"googleusercontent.com", false},
+		{"evilgoogleusercontent.com", false},
+		{"drive.google.com.example.com", false},
+		{"127.0.0.1", false},
+	}
+	for _, test := range hosts {
+		if got := imageProxyHostAllowed(test.host); got != test.want {
+			t.Errorf("imageProxyHostAllowed(%q) = %v, want %v", test.host, got, test.want)
+		}
+	}
+
+	redirect := func(url string, hops int) error {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return imageProxyClient.CheckRedirect(req, make([]*http.Request, hops))
+	}
+	if err := redirect("https://drive.usercontent.google.com/download?id=1", 1); err != nil {
+		t.Errorf("redirect to Drive downloads = %v", err)
+	}
+	if err := redirect("http://drive.usercontent.google.com/download?id=1", 1); err == nil {
+		t.Error("plain http redirect was allowed")
+	}
+	if err := redirect("https://169.254.169.254/latest/meta-data", 1); err == nil {
+		t.Error("redirect off the allow-list was allowed")
+	}
+	if err := redirect("https://drive.google.com/uc?id=1", maxImageRedirects); err == nil {
+		t.Error("redirect past the limit was allowed")
+	}
+}
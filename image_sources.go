@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	imageSourceDrive = "drive"
+	imageSourceS3    = "s3"
+	imageSourceLocal = "local"
+)
+
+// ImageSource is a storage backend images are proxied from. Keys are the
+// backend's own object names, such as a Drive file id or an S3 object key.
+type ImageSource interface {
+	// ValidateKey returns a problem with key, or "" when the school may
+	// request it.
+	ValidateKey(schoolId, key string) string
+	// CacheKey identifies the object in the image cache.
+	CacheKey(key string) string
+	Fetch(ctx context.Context, schoolId, key string) (proxiedImage, error)
+}
+
+// ImageSourceConfigModel selects the backend for a school's images. The
+// secret access key is never returned.
+type ImageSourceConfigModel struct {
+	Type      string               `json:"type"`
+	S3        *S3SourceConfigModel `json:"s3,omitempty"`
+	UpdatedAt string               `json:"updatedAt,omitempty"`
+}
+
+// driveImageSource fetches publicly shared Google Drive files by id.
+type driveImageSource struct{}
+
+func (driveImageSource) ValidateKey(schoolId, key string) string {
+	if !driveFileIDPattern.MatchString(key) {
+		return "File ID is invalid"
+	}
+	return ""
+}
+
+func (driveImageSource) CacheKey(key string) string {
+	return "drive:" + key
+}
+
+func (driveImageSource) Fetch(ctx context.Context, schoolId, key string) (proxiedImage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(driveImageURL, key), nil)
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	return fetchImage(req)
+}
+
+// localImageSource serves files the API stored itself, such as logos and
+// documents, from the blob store. Schools only reach their own folder.
+type localImageSource struct {
+	store BlobStore
+}
+
+func (localImageSource) ValidateKey(schoolId, key string) string {
+	if !strings.HasPrefix(key, "schools/"+schoolId+"/") || !blobKeyPattern.MatchString(key) || strings.Contains(key, "..") {
+		return "key is not a file of this school"
+	}
+	return ""
+}
+
+func (localImageSource) CacheKey(key string) string {
+	return "local:" + key
+}
+
+func (s localImageSource) Fetch(ctx context.Context, schoolId, key string) (proxiedImage, error) {
+	data, object, err := s.store.Get(key)
+	if errors.Is(err, errBlobNotFound) || errors.Is(err, errInvalidBlobKey) {
+		return proxiedImage{}, &imageFetchError{status: http.StatusNotFound, message: "Image not found"}
+	}
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	if len(data) > maxProxiedImageSize {
+		return proxiedImage{}, &imageFetchError{status: http.StatusBadGateway, message: "Image is larger than 10 MB"}
+	}
+	contentType, err := checkImageContent(data, object.ContentType)
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	return proxiedImage{data: data, contentType: contentType, lastModified: object.CreatedAt.Format(http.TimeFormat)}, nil
+}
+
+type imageSourceStore struct {
+	sync.RWMutex
+	configs map[string]ImageSourceConfigModel
+}
+
+var imageSources = &imageSourceStore{configs: map[string]ImageSourceConfigModel{}}
+
+// forSchool returns the school's configured source. Schools without a
+// configuration use the local blob store.
+func (s *imageSourceStore) forSchool(schoolId string) ImageSource {
+	s.RLock()
+	config, ok := s.configs[schoolId]
+	s.RUnlock()
+	switch {
+	case !ok:
+		return localImageSource{store: blobs}
+	case config.Type == imageSourceDrive:
+		return driveImageSource{}
+	case config.Type == imageSourceS3:
+		return s3ImageSource{config: *config.S3}
+	default:
+		return localImageSource{store: blobs}
+	}
+}
+
+func (s *imageSourceStore) get(schoolId string) ImageSourceConfigModel {
+	s.RLock()
+	defer s.RUnlock()
+	config, ok := s.configs[schoolId]
+	if !ok {
+		return ImageSourceConfigModel{Type: imageSourceLocal}
+	}
+	if config.S3 != nil {
+		masked := *config.S3
+		masked.SecretAccessKey = ""
+		config.S3 = &masked
+	}
+	return config
+}
+
+// ImageSourceHandler shows which backend serves the school's images.
+func ImageSourceHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	return c.JSON(http.StatusOK, success(imageSources.get(schoolID(claims))))
+}
+
+// SaveImageSourceHandler switches the school's image backend. An empty S3
+// secret keeps the one already saved.
+func SaveImageSourceHandler(c echo.Context) error {
+	var req ImageSourceConfigModel
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	schoolId := schoolID(claims)
+
+	config := ImageSourceConfigModel{Type: strings.ToLower(strings.TrimSpace(req.Type)), UpdatedAt: time.Now().Format(time.RFC3339)}
+	var errs []string
+	switch config.Type {
+	case imageSourceDrive, imageSourceLocal:
+	case imageSourceS3:
+		if req.S3 == nil {
+			errs = append(errs, "s3 is required for type s3")
+			break
+		}
+		s3 := *req.S3
+		if s3.SecretAccessKey == "" {
+			imageSources.RLock()
+			if existing := imageSources.configs[schoolId].S3; existing != nil && existing.AccessKeyId == strings.TrimSpace(s3.AccessKeyId) {
+				s3.SecretAccessKey = existing.SecretAccessKey
+			}
+			imageSources.RUnlock()
+		}
+		errs = normalizeS3Config(&s3)
+		config.S3 = &s3
+	default:
+		errs = append(errs, "type must be drive, s3 or local")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid image source", errs...))
+	}
+
+	imageSources.Lock()
+	imageSources.configs[schoolId] = config
+	imageSources.Unlock()
+	mediaItems.reindex()
+	return c.JSON(http.StatusOK, success(imageSources.get(schoolId)))
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxVariantDimension   = 2048
+	maxSourceImagePixels  = 40_000_000
+	defaultVariantQuality = 80
+	fitContain            = "contain"
+	fitCover              = "cover"
+	fitFill               = "fill"
+)
+
+// variantSizes are the widths and heights variants are rendered at.
+// Requested sizes round up to the next one, so the cache holds a bounded
+// number of renditions per image.
+var variantSizes = []int{16, 32, 48, 64, 96, 128, 160, 192, 256, 320, 384, 480, 640, 768, 960, 1280, 1600, maxVariantDimension}
+
+// variantRenders limits how many images are decoded and resized at once.
+var variantRenders = make(chan struct{}, runtime.NumCPU())
+
+// snapVariantSize rounds a requested width or height up to a variant size.
+func snapVariantSize(size int) int {
+	for _, standard := range variantSizes {
+		if size <= standard {
+			return standard
+		}
+	}
+	return maxVariantDimension
+}
+
+// imageVariant is a resized or re-encoded rendition of a proxied image.
+// Zero width or height leaves that side to the aspect ratio.
+type imageVariant struct {
+	width   int
+	height  int
+	fit     string
+	quality int
+	format  string
+}
+
+// parseImageVariant reads width, height, fit, quality and format from the
+// query. ok is false when none is given and the original is wanted. Width
+// and height are rounded up to the variant sizes.
+func parseImageVariant(query url.Values) (imageVariant, bool, []string) {
+	variant := imageVariant{fit: fitContain, quality: defaultVariantQuality}
+	var errs []string
+	requested := false
+	dimension := func(name string, target *int) {
+		value := query.Get(name)
+		if value == "" {
+			return
+		}
+		requested = true
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxVariantDimension {
+			errs = append(errs, fmt.Sprintf("%s must be between 1 and %d", name, maxVariantDimension))
+			return
+		}
+		*target = snapVariantSize(parsed)
+	}
+	dimension("width", &variant.width)
+	dimension("height", &variant.height)
+
+	if value := query.Get("fit"); value != "" {
+		requested = true
+		variant.fit = strings.ToLower(value)
+		if variant.fit != fitContain && variant.fit != fitCover && variant.fit != fitFill {
+			errs = append(errs, "fit must be contain, cover or fill")
+		} else if variant.fit != fitContain && (variant.width == 0 || variant.height == 0) {
+			errs = append(errs, "fit="+variant.fit+" needs both width and height")
+		}
+	}
+	if value := query.Get("quality"); value != "" {
+		requested = true
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			errs = append(errs, "quality must be between 1 and 100")
+		}
+		variant.quality = parsed
+	}
+	if value := query.Get("format"); value != "" {
+		requested = true
+		variant.format = strings.ToLower(value)
+		if variant.format == "jpg" {
+			variant.format = "jpeg"
+		}
+		if variant.format != "jpeg" && variant.format != "png" {
+			errs = append(errs, "format must be jpeg or png")
+		}
+	}
+	return variant, requested, errs
+}
+
+// key identifies the variant in the image cache. Quality only affects JPEG,
+// so it is left out for PNG.
+func (v imageVariant) key() string {
+	quality := v.quality
+	if v.format == "png" {
+		quality = 0
+	}
+	return fmt.Sprintf("w=%d,h=%d,fit=%s,q=%d,f=%s", v.width, v.height, v.fit, quality, v.format)
+}
+
+// variantFormat is the format a variant without an explicit one is encoded
+// in: JPEG stays JPEG and everything else becomes PNG.
+func variantFormat(original cachedImage) string {
+	if original.ContentType == "image/jpeg" {
+		return "jpeg"
+	}
+	return "png"
+}
+
+// cropToAspect returns the centred part of bounds with the aspect ratio of
+// width x height.
+func cropToAspect(bounds image.Rectangle, width, height int) image.Rectangle {
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW*height > srcH*width {
+		cropW := srcH * width / height
+		x := bounds.Min.X + (srcW-cropW)/2
+		return image.Rect(x, bounds.Min.Y, x+cropW, bounds.Max.Y)
+	}
+	cropH := srcW * height / width
+	y := bounds.Min.Y + (srcH-cropH)/2
+	return image.Rect(bounds.Min.X, y, bounds.Max.X, y+cropH)
+}
+
+// renderVariant decodes the original, resizes it for the variant and
+// encodes it as JPEG or PNG. Without an explicit format JPEG stays JPEG and
+// everything else becomes PNG. Images are never enlarged except by fill.
+func renderVariant(original cachedImage, data []byte, variant imageVariant) (proxiedImage, error) {
+	unsupported := &imageFetchError{status: http.StatusUnsupportedMediaType, message: "Image cannot be resized"}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return proxiedImage{}, unsupported
+	}
+	if config.Width*config.Height > maxSourceImagePixels {
+		return proxiedImage{}, &imageFetchError{status: http.StatusUnprocessableEntity, message: "Image is too large to resize"}
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return proxiedImage{}, unsupported
+	}
+
+	var resized image.Image = src
+	bounds := src.Bounds()
+	switch variant.fit {
+	case fitFill:
+		resized = resizeImage(src, variant.width, variant.height)
+	case fitCover:
+		crop := cropToAspect(bounds, variant.width, variant.height)
+		if sub, ok := src.(interface {
+			SubImage(image.Rectangle) image.Image
+		}); ok {
+			src = sub.SubImage(crop)
+		}
+		width, height := fitWithin(crop.Dx(), crop.Dy(), variant.width, variant.height)
+		resized = resizeImage(src, width, height)
+	default:
+		maxWidth, maxHeight := variant.width, variant.height
+		if maxWidth == 0 {
+			maxWidth = bounds.Dx()
+		}
+		if maxHeight == 0 {
+			maxHeight = bounds.Dy()
+		}
+		if width, height := fitWithin(bounds.Dx(), bounds.Dy(), maxWidth, maxHeight); width != bounds.Dx() || height != bounds.Dy() {
+			resized = resizeImage(src, width, height)
+		}
+	}
+
+	format := variant.format
+	if format == "" {
+		format = variantFormat(original)
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: variant.quality})
+	} else {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, resized)
+	}
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	return proxiedImage{data: buf.Bytes(), contentType: "image/" + format, lastModified: original.LastModified}, nil
+}
+
+// serveImage answers with the image cached under key, or with the
+// requested variant of it, which is cached separately.
+func serveImage(c echo.Context, key, cacheControl string, fetch func(context.Context) (proxiedImage, error)) error {
+	variant, resize, errs := parseImageVariant(c.QueryParams())
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid image size", errs...))
+	}
+	ctx := c.Request().Context()
+	if !resize {
+		entry, data, err := imageCache.get(ctx, key, fetch)
+		if err != nil {
+			return writeImageError(c, err)
+		}
+		return serveCachedImage(c, entry, data, cacheControl)
+	}
+
+	if variant.format == "" && variant.quality != defaultVariantQuality {
+		// Settle the format so a quality that cannot apply to a PNG
+		// original does not cache another copy of it
+		original, _, err := imageCache.get(ctx, key, fetch)
+		if err != nil {
+			return writeImageError(c, err)
+		}
+		variant.format = variantFormat(original)
+	}
+	entry, data, err := imageCache.get(ctx, key+"#"+variant.key(), func(ctx context.Context) (proxiedImage, error) {
+		original, data, err := imageCache.get(ctx, key, fetch)
+		if err != nil {
+			return proxiedImage{}, err
+		}
+		select {
+		case variantRenders <- struct{}{}:
+			defer func() { <-variantRenders }()
+		case <-ctx.Done():
+			return proxiedImage{}, ctx.Err()
+		}
+		return renderVariant(original, data, variant)
+	})
+	if err != nil {
+		return writeImageError(c, err)
+	}
+	return serveCachedImage(c, entry, data, cacheControl)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSnapVariantSize(t *testing.T) {
+	tests := []struct {
+		size, want int
+	}{
+		{1, 16},
+		{16, 16},
+		{17, 32},
+		{500, 640},
+		{1601, maxVariantDimension},
+		{maxVariantDimension, maxVariantDimension},
+	}
+	for _, test := range tests {
+		if got := snapVariantSize(test.size); got != test.want {
+			t.Errorf("snapVariantSize(%d) = %d, want %d", test.size, got, test.want)
+		}
+	}
+}
+
+func TestParseImageVariant(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    imageVariant
+		resize  bool
+		wantErr string
+	}{
+		{"", imageVariant{fit: fitContain, quality: defaultVariantQuality}, false, ""},
+		{"width=100", imageVariant{width: 128, fit: fitContain, quality: defaultVariantQuality}, true, ""},
+		{"width=100&height=50&fit=COVER", imageVariant{width: 128, height: 64, fit: fitCover, quality: defaultVariantQuality}, true, ""},
+		{"format=jpg&quality=60", imageVariant{fit: fitContain, quality: 60, format: "jpeg"}, true, ""},
+		{"width=0", imageVariant{}, true, "width must be between 1 and 2048"},
+		{"height=4096", imageVariant{}, true, "height must be between 1 and 2048"},
+		{"width=100&fit=cover", imageVariant{}, true, "fit=cover needs both width and height"},
+		{"fit=stretch", imageVariant{}, true, "fit must be contain, cover or fill"},
+		{"quality=101", imageVariant{}, true, "quality must be between 1 and 100"},
+		{"format=webp", imageVariant{}, true, "format must be jpeg or png"},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		variant, resize, errs := parseImageVariant(query)
+		if resize != test.resize {
+			t.Errorf("%q: resize = %v, want %v", test.query, resize, test.resize)
+		}
+		if test.wantErr != "" {
+			if !strings.Contains(strings.Join(errs, "; "), test.wantErr) {
+				t.Errorf("%q: errors = %v, want %q", test.query, errs, test.wantErr)
+			}
+			continue
+		}
+		if len(errs) > 0 || variant != test.want {
+			t.Errorf("%q: variant = %+v, %v; want %+v", test.query, variant, errs, test.want)
+		}
+	}
+}
+
+func TestCropToAspect(t *testing.T) {
+	tests := []struct {
+		bounds        image.Rectangle
+		width, height int
+		want          image.Rectangle
+	}{
+		{image.Rect(0, 0, 400, 200), 100, 100, image.Rect(100, 0, 300, 200)},
+		{image.Rect(0, 0, 200, 400), 100, 100, image.Rect(0, 100, 200, 300)},
+		{image.Rect(10, 10, 110, 60), 2, 1, image.Rect(10, 10, 110, 60)},
+	}
+	for _, test := range tests {
+		if got := cropToAspect(test.bounds, test.width, test.height); got != test.want {
+			t.Errorf("cropToAspect(%v, %d, %d) = %v, want %v", test.bounds, test.width, test.height, got, test.want)
+		}
+	}
+}
+
+func TestRenderVariant(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	original := cachedImage{ContentType: "image/png", LastModified: "Mon, 11 Mar 2030 09:00:00 GMT"}
+
+	tests := []struct {
+		name        string
+		variant     imageVariant
+		contentType string
+		width       int
+		height      int
+	}{
+		{"contain by width", imageVariant{width: 128, fit: fitContain, quality: 80}, "image/png", 128, 64},
+		{"never enlarged", imageVariant{width: 640, height: 640, fit: fitContain, quality: 80}, "image/png", 400, 200},
+		{"cover", imageVariant{width: 64, height: 64, fit: fitCover, quality: 80}, "image/png", 64, 64},
+		{"fill", imageVariant{width: 32, height: 128, fit: fitFill, quality: 80}, "image/png", 32, 128},
+		{"jpeg", imageVariant{width: 64, fit: fitContain, quality: 80, format: "jpeg"}, "image/jpeg", 64, 32},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := renderVariant(original, buf.Bytes(), test.variant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered.contentType != test.contentType || rendered.lastModified != original.LastModified {
+				t.Errorf("rendered %s, last modified %q", rendered.contentType, rendered.lastModified)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(rendered.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != test.width || config.Height != test.height {
+				t.Errorf("size = %dx%d, want %dx%d", config.Width, config.Height, test.width, test.height)
+			}
+		})
+	}
+
+	if _, err := renderVariant(original, []byte("not an image"), imageVariant{width: 16, fit: fitContain}); err == nil {
+		t.Error("rendered data that is not an image")
+	}
+}
+
+func TestImageVariantKeyIgnoresPNGQuality(t *testing.T) {
+	png60 := imageVariant{width: 128, fit: fitContain, quality: 60, format: "png"}
+	png90 := png60
+	png90.quality = 90
+	if png60.key() != png90.key() {
+		t.Errorf("PNG keys differ by quality: %s, %s", png60.key(), png90.key())
+	}
+	jpeg60, jpeg90 := png60, png90
+	jpeg60.format, jpeg90.format = "jpeg", "jpeg"
+	if jpeg60.key() == jpeg90.key() {
+		t.Errorf("JPEG keys ignore quality: %s", jpeg60.key())
+	}
+}
+
+func TestServeImageSharesPNGVariants(t *testing.T) {
+	saved := imageCache
+	imageCache = newImageCacheStore(t.TempDir(), 1<<20)
+	defer func() { imageCache = saved }()
+
+	var original bytes.Buffer
+	if err := png.Encode(&original, image.NewNRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(context.Context) (proxiedImage, error) {
+		return proxiedImage{data: original.Bytes(), contentType: "image/png"}, nil
+	}
+	for _, query := range []string{"width=128", "width=128&quality=30", "width=128&quality=95", "width=128&format=png&quality=10"} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/image?"+query, nil), rec)
+		if err := serveImage(c, "test:logo.png", publicImageCacheControl, fetch); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, %v", query, rec.Code, err)
+		}
+	}
+	// The original, the default variant and one PNG variant for any quality
+	if entries := imageCache.snapshot().Entries; entries != 3 {
+		t.Errorf("cache entries = %d, want 3", entries)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// resizeImage scales src to exactly width x height. Each destination pixel
+// averages the source pixels it covers, which keeps downscaled logos and
+// photos free of aliasing.
+func resizeImage(src image.Image, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := bounds.Min.Y + (y+1)*srcH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := bounds.Min.X + (x+1)*srcW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			// Average premultiplied values, then un-premultiply for NRGBA
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * 0xff / a),
+				G: uint8(g * 0xff / a),
+				B: uint8(b * 0xff / a),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// fitWithin returns the largest size with the source aspect ratio that fits
+// inside maxWidth x maxHeight without upscaling.
+func fitWithin(srcW, srcH, maxWidth, maxHeight int) (int, int) {
+	if srcW <= maxWidth && srcH <= maxHeight {
+		return srcW, srcH
+	}
+	width, height := maxWidth, srcH*maxWidth/srcW
+	if height > maxHeight {
+		width, height = srcW*maxHeight/srcH, maxHeight
+	}
+	return max(width, 1), max(height, 1)
+}
@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
-	"io"
-
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -21,12 +20,14 @@ type Credentials struct {
 }
 
 type OnBoardingRequestDto struct {
-	StudentCount  string `json:"studentsCount"`
-	StaffCount    string `json:"staffCount"`
-	SchoolAddress string `json:"schoolAddress"`
-	SchoolType    string `json:"schoolType"`
-	SchoolBoard   string `json:"schoolBoard"`
-	SchoolLogo    []byte `json:"schoolLogo"`
+	SchoolName    string              `json:"schoolName"`
+	StudentCount  string              `json:"studentsCount"`
+	StaffCount    string              `json:"staffCount"`
+	SchoolAddress string              `json:"schoolAddress"`
+	Address       *SchoolAddressModel `json:"address"`
+	SchoolType    string              `json:"schoolType"`
+	SchoolBoard   string              `json:"schoolBoard"`
+	SchoolLogo    []byte              `json:"schoolLogo"`
 }
 
 type OnBoardingSubjectDataRequestDto struct {
@@ -43,9 +44,21 @@ type Claims struct {
 	Id         string `json:"id"`
 	ChatAccess bool   `json:"chat_access"`
 	UserRole   string `json:"user_role"`
+	SchoolId   string `json:"school_id"`
+	// StudentId is the active student of a student or parent login.
+	StudentId string `json:"student_id,omitempty"`
 	jwt.StandardClaims
 }
 
+const (
+	roleStudent = "STUDENT"
+	roleTeacher = "TEACHER"
+	roleAdmin   = "ADMIN"
+
+	// defaultSchoolID is issued to every login until users belong to schools
+	defaultSchoolID = "SCH-0001"
+)
+
 var (
 	jwtKey        = []byte("your_secret_key")
 	maxWorkers    = 26000 // Number of worker goroutines
@@ -62,6 +75,8 @@ type BaseResponse struct {
 
 // Define the structs
 type CoreHomePageModel struct {
+	// StudentId is the student the page shows, when one is selected.
+	StudentId                       string                  `json:"studentId,omitempty"`
 	HomepageModel                   []GenericHomePageModel  `json:"homepageModel"`
 	LatestUpdateData                []CoreLatestUpdatedData `json:"latestUpdateData"`
 	PositionLatestUpdate            int                     `json:"positionLatestUpdate"`
@@ -101,6 +116,8 @@ var imageConstant = ImageConstant{
 }
 
 type AssignmentModel struct {
+	// StudentId is the student the assignments belong to.
+	StudentId      string                           `json:"studentId,omitempty"`
 	Filters        []string                         `json:"filters"`
 	AssignmentData map[string][]AssignmentModelList `json:"assignmentData"`
 }
@@ -154,6 +171,8 @@ type AcademicStatsButton struct {
 }
 
 type AcademicStatsModel struct {
+	// StudentId is the student the stats belong to.
+	StudentId                       string                `json:"studentId,omitempty"`
 	Test                            string                `json:"test"`
 	AttendanceData                  AttendanceData        `json:"attendanceData"`
 	MarksModel                      MarksModel            `json:"marksModel"`
@@ -195,8 +214,14 @@ type GenericBasicDetailsPageModel struct {
 	Accounts        []SwitchAccountModel `json:"accounts"`
 }
 
+// SwitchAccountModel is a student the login can switch to.
 type SwitchAccountModel struct {
-	// Define fields for SwitchAccountModel
+	StudentId       string `json:"studentId"`
+	Name            string `json:"name"`
+	ClassName       string `json:"className"`
+	AdmissionNumber string `json:"admissionNumber"`
+	Relation        string `json:"relation"`
+	Active          bool   `json:"active"`
 }
 
 type AdmissionDetailsModel struct {
@@ -227,6 +252,9 @@ type InformationDetailsModel struct {
 	MotherNameValue string `json:"motherNameValue"`
 	AddressText     string `json:"addressText"`
 	AddressValue    string `json:"addressValue"`
+	// Guardians are the student's linked guardians, when a student is
+	// selected.
+	Guardians []StudentGuardianModel `json:"guardians,omitempty"`
 }
 
 type EventModel struct {
@@ -296,6 +324,8 @@ type GenericStudentHomeworkViewModel struct {
 }
 
 type CoreHomeworkPageModel struct {
+	// StudentId is the student the homework is set for.
+	StudentId                       string                            `json:"studentId,omitempty"`
 	HomeWorkModel                   []GenericStudentHomeworkViewModel `json:"homeWorkModel"`
 	ExpiryCacheInAllowedTime        string                            `json:"expiryCacheInAllowedTime"`
 	ExpiryCacheInAllowedTimeUnit    string                            `json:"expiryCacheInAllowedTimeUnit"`
@@ -400,8 +430,9 @@ func fillLeaveRequestStudentData() []map[string]interface{} {
 
 }
 
-func fillCoreHomeWorkPageModel() CoreHomeworkPageModel {
+func fillCoreHomeWorkPageModel(studentId string) CoreHomeworkPageModel {
 	return CoreHomeworkPageModel{
+		StudentId:                       studentId,
 		HomeWorkModel:                   fillGenericStudentHomeworkViewModel(),
 		ExpiryCacheInAllowedTime:        "10",
 		ExpiryCacheInAllowedTimeUnit:    "minutes",
@@ -410,47 +441,6 @@ func fillCoreHomeWorkPageModel() CoreHomeworkPageModel {
 	}
 }
 
-func fillOnBoardModel() CoreOnBoarding {
-	return CoreOnBoarding{
-		Data: map[string]interface{}{
-			"comments": []map[string]interface{}{
-				{
-					"title":       "Step-1 : Basic Information",
-					"description": "Provide Some Basic Details About School",
-					"imageSrc":    "assets/icons/check_circled_filled.svg",
-					"routes":      "/onboard-user-basic-info",
-					"isClickable": true,
-				},
-				{
-					"title":       "Step-2 : Subjects Information",
-					"description": "Provide Some Basic Details About Subjects in School",
-					"imageSrc":    "assets/icons/close_circled_filled.svg",
-					"routes":      "/onboard-user-setup-subjects",
-					"isClickable": true,
-				},
-				{
-					"title":       "Step-3 : Bus Routes Information",
-					"description": "Provide Some Basic Details About Subjects in School",
-					"imageSrc":    "assets/icons/close_circled_filled.svg",
-					"routes":      "/onboard-user-bus-route",
-					"isClickable": true,
-				},
-				{
-					"title":       "Step-4 : Student Information",
-					"description": "Provide Some Basic Details About Students Data",
-					"imageSrc":    "assets/icons/close_circled_filled.svg",
-					"routes":      "/onboard-user-student-onboarding",
-					"isClickable": true,
-				},
-			},
-		},
-		ExpiryCacheInAllowedTime:        "1",
-		ExpiryCacheInAllowedTimeUnit:    "minutes",
-		ExpiryCacheInNotAllowedTime:     "1",
-		ExpiryCacheInNotAllowedTimeUnit: "minutes",
-	}
-}
-
 func fillGenericStudentHomeworkViewModel() []GenericStudentHomeworkViewModel {
 	return []GenericStudentHomeworkViewModel{
 		{
@@ -591,6 +581,22 @@ func fillCalendar() DateModel {
 	}
 }
 
+// buildCalendar returns the calendar as seen by one user, with recurring
+// events expanded within [from, to). CalendarHandler and the ICS export both
+// render from it so they never disagree.
+func buildCalendar(userId, role string, from, to time.Time) DateModel {
+	calendar := fillCalendar()
+	recurringEvents.expand(&calendar, from, to)
+
+	// Teachers also see the periods they substitute for colleagues on leave
+	if role == roleTeacher {
+		for date, periods := range teacherLeaves.substitutionsFor(userId) {
+			calendar.TimeTable[date] = append(calendar.TimeTable[date], periods...)
+		}
+	}
+	return calendar
+}
+
 func fillProfileModel() CoreProfilePageModel {
 	return CoreProfilePageModel{
 		GenericBasicDetailsPageModel: &GenericBasicDetailsPageModel{
@@ -677,9 +683,10 @@ func fillProfileModel() CoreProfilePageModel {
 
 }
 
-func fillGenericAcademicStatsModel() AcademicStatsModel {
+func fillGenericAcademicStatsModel(studentId string) AcademicStatsModel {
 	return AcademicStatsModel{
-		Test: "",
+		StudentId: studentId,
+		Test:      "",
 		AttendanceData: AttendanceData{
 			Filter: "Weekly",
 			FilterData: map[string]map[string][]AttendanceStats{
@@ -952,13 +959,14 @@ func fillGenericAcademicStatsModel() AcademicStatsModel {
 	}
 }
 
-// fillAssignmentModel creates and returns an AssignmentModel.
-func fillAssignmentModel() AssignmentModel {
+// fillAssignmentModel creates and returns the AssignmentModel of a student.
+func fillAssignmentModel(studentId string) AssignmentModel {
 	layout := "2006-01-02"
 	dueDate, _ := time.Parse(layout, "2024-08-20")
 
 	return AssignmentModel{
-		Filters: []string{"QUARTER1", "QUARTER2"},
+		StudentId: studentId,
+		Filters:   []string{"QUARTER1", "QUARTER2"},
 		AssignmentData: map[string][]AssignmentModelList{
 			"QUARTER1": {
 				{
@@ -1190,6 +1198,7 @@ func main() {
 
 	// Echo instance
 	e := echo.New()
+	e.IPExtractor = clientIPExtractor()
 
 	// Middleware
 	e.Use(middleware.Logger())
@@ -1241,6 +1250,37 @@ func main() {
 	e.GET("/homework", HomeworkHandler)
 
 	e.GET("/image", handleImageProxy)
+	e.GET("/image/stats", ImageCacheStatsHandler)
+	e.GET("/image/source", ImageSourceHandler)
+	e.POST("/image/source", SaveImageSourceHandler)
+
+	e.POST("/media", RegisterMediaHandler)
+	e.GET("/media/:id/url", MediaURLHandler)
+	e.GET("/media-files/:schoolId/:id", MediaFileHandler)
+
+	e.POST("/student-accounts", StudentAccountLinkHandler)
+	e.GET("/student-accounts", StudentAccountsHandler)
+	e.GET("/accounts", AccountsHandler)
+	e.POST("/accounts/switch", SwitchAccountHandler)
+
+	e.GET("/profile/guardians", GuardiansHandler)
+	e.POST("/profile/guardians", GuardianChangeHandler)
+	e.GET("/profile/guardians/requests", GuardianChangeRequestsHandler)
+	e.POST("/profile/guardians/requests/decision", GuardianChangeDecisionHandler)
+
+	e.POST("/profile/edit-requests", ProfileEditRequestHandler)
+	e.GET("/profile/edit-requests", ProfileEditRequestsHandler)
+	e.POST("/profile/edit-requests/decision", ProfileEditDecisionHandler)
+	e.GET("/profile/history", ProfileHistoryHandler)
+
+	e.GET("/documents", DocumentsHandler)
+	e.POST("/documents", UploadDocumentHandler)
+	e.POST("/documents/verify", VerifyDocumentHandler)
+	e.GET("/documents/reminders", DocumentRemindersHandler)
+	e.GET("/documents/types", DocumentTypesHandler)
+	e.POST("/documents/types", SaveDocumentTypeHandler)
+
+	e.GET("/blob/*", BlobHandler)
 
 	e.POST("/leaveRequest", LeaveHandler)
 
@@ -1248,19 +1288,61 @@ func main() {
 
 	e.GET("/onboard", OnBoardHandler)
 
-	e.GET("/country", getCountries)
-	e.GET("/country/:country/state", getStates)
-	e.GET("/country/:country/:state/cities", getCities)
+	e.GET("/country", CountriesHandler)
+	e.GET("/country/:country/state", StatesHandler)
+	e.GET("/country/:country/:state/cities", CitiesHandler)
+	e.GET("/postal-code/:code", PostalCodeHandler)
+	e.GET("/cities/search", CitySearchHandler)
 
 	e.POST("/onboard-step-1", OnBoardHandlerStep1)
 
 	e.POST("/extract-dropdown", DropDownHandler)
+	e.GET("/dropdown-master", DropDownMasterHandler)
+	e.POST("/dropdown-master/value", SaveDropDownValueHandler)
+	e.POST("/dropdown-master/value/delete", DeleteDropDownValueHandler)
+	e.POST("/dropdown-master/reorder", ReorderDropDownHandler)
 
 	e.POST("/onBoard-subject-admin", OnBoardHandlerSubjectData)
 
-	e.POST("/onBoard-bus-routes-admin", OnBoardHandlerSubjectData)
-
-	e.POST("/submit-enquiry-event", PostTestAPIMockResponse)
+	e.POST("/onboard-student", StudentOnboardHandler)
+	e.POST("/onboard-student-import", StudentImportHandler)
+	e.GET("/onboard-student-import/template", StudentImportTemplateHandler)
+	e.GET("/students", StudentListHandler)
+
+	e.POST("/onBoard-bus-routes-admin", BusRoutesOnboardHandler)
+	e.GET("/transport", TransportHandler)
+	e.POST("/transport/vehicle", SaveVehicleHandler)
+	e.POST("/transport/route", SaveRouteHandler)
+	e.POST("/transport/assign", AssignTransportHandler)
+	e.POST("/transport/unassign", UnassignTransportHandler)
+	e.GET("/transport/assignments", TransportAssignmentsHandler)
+	e.POST("/transport/device-token", IssueDeviceTokenHandler)
+	e.POST("/transport/gps", GPSPingHandler)
+	e.GET("/transport/live", LiveLocationHandler)
+	e.GET("/transport/live/stream", LiveLocationStreamHandler)
+	e.GET("/transport/trip-history", TripHistoryHandler)
+
+	e.POST("/submit-enquiry-event", EnquiryEventHandler)
+	e.GET("/enquiries", EnquiryListHandler)
+	e.GET("/enquiries/reminders", EnquiryRemindersHandler)
+	e.GET("/enquiries/report", EnquiryReportHandler)
+	e.GET("/enquiries/:id", EnquiryHandler)
+	e.GET("/public/:schoolId/enquiry/challenge", PublicEnquiryChallengeHandler)
+	e.POST("/public/:schoolId/enquiry", PublicEnquiryHandler, publicEnquiryRateLimiter)
+
+	e.POST("/calendar/recurring-event", RecurringEventHandler)
+	e.GET("/calendar/recurring-event", RecurringEventListHandler)
+	e.POST("/calendar/recurring-event/edit", EditRecurringEventHandler)
+
+	e.GET("/calendar/feed-url", CalendarFeedURLHandler)
+	e.POST("/calendar/feed-url/reset", ResetCalendarFeedURLHandler)
+	e.GET("/calendar/feed/:token", CalendarFeedHandler)
+	e.GET("/calendar/export", CalendarExportHandler)
+
+	e.POST("/teacher-leave", TeacherLeaveHandler)
+	e.GET("/teacher-leave", TeacherLeaveListHandler)
+	e.POST("/teacher-leave/decision", TeacherLeaveDecisionHandler)
+	e.POST("/teacher-leave/substitute", ConfirmSubstituteHandler)
 
 	// Start worker pool
 	var wg sync.WaitGroup
@@ -1297,6 +1379,140 @@ func processRequest(r *http.Request) {
 	fmt.Println("Processed request:", r.URL.Path)
 }
 
+// bearerClaims verifies the Bearer token in the Authorization header and
+// returns its claims. On failure the claims are nil and the status code and
+// BaseResponse describe the error the handler should reply with.
+func bearerClaims(c echo.Context) (jwt.MapClaims, int, BaseResponse) {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, http.StatusBadRequest, BaseResponse{
+			Status:  "FAILED",
+			Message: "Authorization header missing",
+			Errors:  []string{"Authorization header missing"},
+		}
+	}
+
+	// Split the "Bearer" text from the token
+	if len(authHeader) <= 7 || authHeader[:7] != "Bearer " {
+		return nil, http.StatusBadRequest, BaseResponse{
+			Status:  "FAILED",
+			Message: "Invalid Authorization header format",
+			Errors:  []string{"Invalid Authorization header format"},
+		}
+	}
+
+	// Verify the token
+	token, err := jwt.Parse(authHeader[7:], func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return jwtKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, http.StatusUnauthorized, BaseResponse{
+			Status:  "UNAUTHORIZED",
+			Message: "Invalid token",
+			Errors:  []string{"Invalid token"},
+		}
+	}
+
+	// Extract claims from the token
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, http.StatusBadRequest, BaseResponse{
+			Status:  "FAILED",
+			Message: "Invalid token claims",
+			Errors:  []string{"Invalid token claims"},
+		}
+	}
+
+	// Check if the token is expired
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().Unix() > int64(exp) {
+		return nil, http.StatusUnauthorized, BaseResponse{
+			Status:  "UNAUTHORIZED",
+			Message: "Token expired",
+			Errors:  []string{"Token expired"},
+		}
+	}
+
+	return claims, http.StatusOK, BaseResponse{}
+}
+
+// claimString returns a string claim, or "" when it is absent.
+func claimString(claims jwt.MapClaims, key string) string {
+	value, _ := claims[key].(string)
+	return value
+}
+
+// schoolID returns the school the token belongs to. Tokens issued before
+// schools were tracked fall back to the default school.
+func schoolID(claims jwt.MapClaims) string {
+	if id := claimString(claims, "school_id"); id != "" {
+		return id
+	}
+	return defaultSchoolID
+}
+
+// failed builds the FAILED response used for validation errors.
+func failed(message string, errs ...string) BaseResponse {
+	if len(errs) == 0 {
+		errs = []string{message}
+	}
+	return BaseResponse{
+		Status:  "FAILED",
+		Message: message,
+		Errors:  errs,
+	}
+}
+
+// forbidden builds the response for an authenticated user lacking a role.
+func forbidden() BaseResponse {
+	return BaseResponse{
+		Status:  "FORBIDDEN",
+		Message: "Not allowed for this user",
+		Errors:  []string{"Not allowed for this user"},
+	}
+}
+
+// success wraps data in the standard SUCCESS response.
+func success(data interface{}) BaseResponse {
+	return BaseResponse{
+		Status:  "SUCCESS",
+		Message: "Success",
+		Data:    data,
+	}
+}
+
+var idCounter struct {
+	sync.Mutex
+	next map[string]int
+}
+
+// newID returns sequential, human readable ids such as "TL-0001".
+func newID(prefix string) string {
+	idCounter.Lock()
+	defer idCounter.Unlock()
+	if idCounter.next == nil {
+		idCounter.next = map[string]int{}
+	}
+	idCounter.next[prefix]++
+	return fmt.Sprintf("%s-%04d", prefix, idCounter.next[prefix])
+}
+
+// roleForUsername derives the role issued at login from the username until
+// users are backed by a real directory.
+func roleForUsername(username string) string {
+	switch {
+	case strings.HasPrefix(username, "admin"):
+		return roleAdmin
+	case strings.HasPrefix(username, "teacher"):
+		return roleTeacher
+	default:
+		return roleStudent
+	}
+}
+
 func OnBoardHandlerStep1(c echo.Context) error {
 	var creds OnBoardingRequestDto
 	if err := c.Bind(&creds); err != nil {
@@ -1358,7 +1574,17 @@ func OnBoardHandlerStep1(c echo.Context) error {
 		})
 	}
 
-	data := fillOnBoardModel()
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	profile, errs := saveSchoolProfile(schoolID(claims), creds)
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid school details", errs...))
+	}
+	onboardingProgress.complete(schoolID(claims), onboardingStepBasicInfo)
+
+	data := fillOnBoardModel(schoolID(claims))
+	data.Data["schoolProfile"] = profile
 	// Create the response
 	response := BaseResponse{
 		Status:  "SUCCESS",
@@ -1369,8 +1595,75 @@ func OnBoardHandlerStep1(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// fillDropDownData returns the school's dropdown master data served by
+// /extract-dropdown and used to validate submitted records, together with
+// the master data version.
+func fillDropDownData(schoolId string) (map[string]interface{}, int) {
+	dropDowns, version := dropDownMaster.render(schoolId)
+	defaults := defaultDropDownData()
+	for key := range managedDropDownKeys {
+		if value, ok := defaults[key]; ok {
+			dropDowns[key] = value
+		}
+	}
+	applyAcademicStructure(schoolId, dropDowns)
+	applyTransportDropDowns(schoolId, dropDowns)
+	return dropDowns, version
+}
+
+// defaultDropDownData is the master data every school starts with.
+func defaultDropDownData() map[string]interface{} {
+	return map[string]interface{}{
+		"sessionDropDown":                  []string{"2023-24", "2024-25"},
+		"termDropDown":                     []string{"Term-1", "Term-2"},
+		"examDropDown":                     []string{"UT-1", "UT-2", "Half Yearly", "UT-3", "UT-4", "Final Exam"},
+		"formatGenerateReportCardDropDown": []string{"GradeSheet", "ReportCard"},
+		"test-type-schedule-test":          []string{"Unit Test", "Class Test", "Surprise Test", "Half Yearly", "Final Exam"},
+		"classes":                          []string{"1", "2", "12"},
+		"boards":                           []string{"CBSE", "HSE", "TSE", "USE"},
+		"gender":                           []string{"MALE", "FEMALE"},
+		"teachers-data-admin":              []string{"ABC", "ACD"},
+		"yes-no-dropdown":                  []string{"Yes", "No"},
+		"vehicleName":                      []string{"Bus 1", "Bus 2"},
+		"routeName":                        []string{"Bus 1 - Round 1", "Bus 2 - Round 2"},
+		"admissionType":                    []string{"OLD", "NEW"},
+		"bankAccountsDropDownFees":         []string{"Test Bank", "Test Welfare Society"},
+		"banksForFeeDepositDropDown":       []string{"Axis Bank", "Hdfc Bank"},
+		"allTeachersDropDown":              []string{"Teacher 1", "Teacher 2"},
+		"allFieldsDailyFeesCollectionPage": []string{"Name", "DOA", "DOB", "Father Name", "Student Type", "Total Fees", "Previous Fees", "Last Amount Paid", "Last Paid Date", "Remarks", "Payment Mode"},
+		"defaultActiveFieldsDailyFeesCollectionPage": []string{"Name", "DOA", "DOB", "Father Name", "Student Type", "Total Fees", "Previous Fees"},
+		"religion":                            []string{"HINDU", "MUSLIM"},
+		"caste":                               []string{"GENERAL", "SC", "ST", "OBC"},
+		"enquiry-source":                      []string{"Source1", "Source2", "Source3", "Source4"},
+		"enquiry-preferred-communication":     []string{"Call", "Message", "Email"},
+		"enquiry-status":                      []string{"Not Contacted", "Attempted to Contact", "Not Interested", "Contacted", "Junk Lead", "LOST", "Contact in Future", "Missed"},
+		"status":                              []string{"Active", "InActive"},
+		"leave-request-status":                []string{"Accepted", "Rejected", "Pending"},
+		"school_type":                         []string{"Higher Secondary Education", "Secondary School Certificate"},
+		"importStudentAttendanceTypeDropDown": []string{"Attendance", "PTM"},
+
+		"exam-term-wise-drop-down": map[string][]string{
+			"Term-1": {"Unit Test 1", "Unit Test 2", "Half Yearly"},
+			"Term-2": {"Unit Test 3", "Unit Test 4", "Final Exam"},
+		},
+		"subjects": map[string][]string{
+			"1":  {"Hindi", "English"},
+			"2":  {"Math", "Science"},
+			"12": {"Physics", "Chemistry", "Biology", "Mathematics"},
+		},
+		"sections": map[string][]string{
+			"1":  {"A", "B"},
+			"2":  {"A", "B"},
+			"12": {"A", "B", "C", "D"},
+		},
+		"enquiry_status": []string{"PENDING", "DONE", "LEFT", "IN-LOOP/CALL"},
+	}
+}
+
+// DropDownHandler returns the dropdowns selected by the request body, or
+// all of them for an empty body.
 func DropDownHandler(c echo.Context) error {
-	var creds map[string]interface{}
+	var creds DropDownRequestDto
 	if err := c.Bind(&creds); err != nil {
 		return c.String(http.StatusBadRequest, "Invalid request")
 	}
@@ -1430,56 +1723,20 @@ func DropDownHandler(c echo.Context) error {
 		})
 	}
 
+	allDropDowns, version := fillDropDownData(schoolID(claims))
+	dropDowns, errs := selectDropDowns(allDropDowns, creds)
+	etag := dropDownETag(dropDowns)
+	c.Response().Header().Set("ETag", etag)
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
 	data := map[string]interface{}{
-		"data": map[string]interface{}{
-
-			"sessionDropDown":                  []string{"2023-24", "2024-25"},
-			"termDropDown":                     []string{"Term-1", "Term-2"},
-			"examDropDown":                     []string{"UT-1", "UT-2", "Half Yearly", "UT-3", "UT-4", "Final Exam"},
-			"formatGenerateReportCardDropDown": []string{"GradeSheet", "ReportCard"},
-			"test-type-schedule-test":          []string{"Unit Test", "Class Test", "Surprise Test", "Half Yearly", "Final Exam"},
-			"classes":                          []string{"1", "2", "12"},
-			"boards":                           []string{"CBSE", "HSE", "TSE", "USE"},
-			"gender":                           []string{"MALE", "FEMALE"},
-			"teachers-data-admin":              []string{"ABC", "ACD"},
-			"yes-no-dropdown":                  []string{"Yes", "No"},
-			"vehicleName":                      []string{"Bus 1", "Bus 2"},
-			"routeName":                        []string{"Bus 1 - Round 1", "Bus 2 - Round 2"},
-			"admissionType":                    []string{"OLD", "NEW"},
-			"bankAccountsDropDownFees":         []string{"Test Bank", "Test Welfare Society"},
-			"banksForFeeDepositDropDown":       []string{"Axis Bank", "Hdfc Bank"},
-			"allTeachersDropDown":              []string{"Teacher 1", "Teacher 2"},
-			"allFieldsDailyFeesCollectionPage": []string{"Name", "DOA", "DOB", "Father Name", "Student Type", "Total Fees", "Previous Fees", "Last Amount Paid", "Last Paid Date", "Remarks", "Payment Mode"},
-			"defaultActiveFieldsDailyFeesCollectionPage": []string{"Name", "DOA", "DOB", "Father Name", "Student Type", "Total Fees", "Previous Fees"},
-			"religion":                            []string{"HINDU", "MUSLIM"},
-			"caste":                               []string{"GENERAL", "SC", "ST", "OBC"},
-			"enquiry-source":                      []string{"Source1", "Source2", "Source3", "Source4"},
-			"enquiry-preferred-communication":     []string{"Call", "Message", "Email"},
-			"enquiry-status":                      []string{"Not Contacted", "Attempted to Contact", "Not Interested", "Contacted", "Junk Lead", "LOST", "Contact in Future", "Missed"},
-			"status":                              []string{"Active", "InActive"},
-			"leave-request-status":                []string{"Accepted", "Rejected", "Pending"},
-			"school_type":                         []string{"Higher Secondary Education", "Secondary School Certificate"},
-			"importStudentAttendanceTypeDropDown": []string{"Attendance", "PTM"},
-
-			"exam-term-wise-drop-down": map[string][]string{
-				"Term-1": {"Unit Test 1", "Unit Test 2", "Half Yearly"},
-				"Term-2": {"Unit Test 3", "Unit Test 4", "Final Exam"},
-			},
-			"subjects": map[string][]string{
-				"1":  {"Hindi", "English"},
-				"2":  {"Math", "Science"},
-				"12": {"Physics", "Chemistry", "Biology", "Mathematics"},
-			},
-			"sections": map[string][]string{
-				"1":  {"A", "B"},
-				"2":  {"A", "B"},
-				"12": {"A", "B", "C", "D"},
-			},
-			"enquiry_status": []string{"PENDING", "DONE", "LEFT", "IN-LOOP/CALL"},
-		},
-		"expiryCacheInAllowedTime_dropDown":        "1",
-		"expiryCacheInAllowedTimeUnit_dropDown":    "minutes",
-		"expiryCacheInNotAllowedTime_dropDown":     "1",
+		"data":                                  dropDowns,
+		"version":                               version,
+		"etag":                                  etag,
+		"expiryCacheInAllowedTime_dropDown":     "1",
+		"expiryCacheInAllowedTimeUnit_dropDown": "minutes",
+		"expiryCacheInNotAllowedTime_dropDown":  "1",
 		"expiryCacheInNotAllowedTimeUnit_dropDown": "minutes",
 	}
 
@@ -1488,6 +1745,7 @@ func DropDownHandler(c echo.Context) error {
 		Status:  "SUCCESS",
 		Message: "Success",
 		Data:    data,
+		Errors:  errs,
 	}
 	// Return the JSON response
 	return c.JSON(http.StatusOK, response)
@@ -1554,7 +1812,33 @@ func OnBoardHandlerSubjectData(c echo.Context) error {
 		})
 	}
 
-	data := fillOnBoardModel()
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	if blocked := onboardingProgress.blockedBy(schoolID(claims), onboardingStepSubjects); len(blocked) > 0 {
+		return c.JSON(http.StatusConflict, failed("Complete the earlier onboarding steps first", blocked...))
+	}
+
+	classes, rowErrors := parseClassStructure(schoolID(claims), creds.Data)
+	if len(rowErrors) > 0 {
+		var errs []string
+		for _, rowError := range rowErrors {
+			for _, err := range rowError.Errors {
+				errs = append(errs, fmt.Sprintf("row %d: %s", rowError.Row, err))
+			}
+		}
+		response := failed("Invalid subject data", errs...)
+		response.Data = rowErrors
+		return c.JSON(http.StatusBadRequest, response)
+	}
+	if stranded := strandedStudents(schoolID(claims), classes); len(stranded) > 0 {
+		return c.JSON(http.StatusConflict, failed("Move students out of the removed classes and sections first", stranded...))
+	}
+	academicStructures.set(schoolID(claims), classes)
+	onboardingProgress.complete(schoolID(claims), onboardingStepSubjects)
+
+	data := fillOnBoardModel(schoolID(claims))
+	data.Data["classStructure"] = classes
 	// Create the response
 	response := BaseResponse{
 		Status:  "SUCCESS",
@@ -1622,7 +1906,7 @@ func OnBoardHandler(c echo.Context) error {
 		})
 	}
 
-	data := fillOnBoardModel()
+	data := fillOnBoardModel(schoolID(claims))
 	// Create the response
 	response := BaseResponse{
 		Status:  "SUCCESS",
@@ -1633,54 +1917,6 @@ func OnBoardHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
-func handleImageProxy(c echo.Context) error {
-	// Extract the file ID from the query parameters or path
-	fileID := c.QueryParam("id")
-	if fileID == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "File ID is required")
-	}
-
-	// Construct the Google Drive image URL
-	imageURL := fmt.Sprintf("https://drive.google.com/uc?id=%s", fileID)
-
-	// Create a HTTP client with connection reuse
-	client := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        10,
-			MaxIdleConnsPerHost: 10,
-		},
-	}
-
-	// Create a GET request to fetch the image from Google Drive
-	req, err := http.NewRequest("GET", imageURL, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to create request: %v", err))
-	}
-
-	// Fetch the image using the client
-	resp, err := client.Do(req)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to fetch image: %v", err))
-	}
-	defer resp.Body.Close()
-
-	// Set headers from Google Drive's response to the proxy response
-	c.Response().Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	c.Response().Header().Set("Content-Length", resp.Header.Get("Content-Length"))
-	c.Response().Header().Set("Cache-Control", "public, max-age=604800") // Example caching header, adjust as needed
-
-	// Set additional CORS headers to allow any origin
-	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Stream the image data directly from Google Drive's response to the proxy response
-	c.Response().WriteHeader(resp.StatusCode)
-	if _, err := io.Copy(c.Response(), resp.Body); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to stream image data: %v", err))
-	}
-
-	return nil
-}
-
 // LoginHandler handles user login and issues JWT tokens
 func LoginHandler(c echo.Context) error {
 	var creds Credentials
@@ -1704,73 +1940,19 @@ func LoginHandler(c echo.Context) error {
 
 	result := string(ran)
 
-	// Generate JWT
-	expirationTime := time.Now().Add(50000 * time.Second)
-	claims := &Claims{
-		Email:      creds.Username,
+	username := creds.Username
+	response, err := issueTokens(Claims{
+		Email:      username,
 		Name:       "test " + result,
-		UserRole:   "STUDENT",
+		UserRole:   roleForUsername(username),
 		ChatAccess: false,
-		Id:         creds.Username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+		Id:         username,
+		SchoolId:   defaultSchoolID,
+		StudentId:  defaultActiveStudent(defaultSchoolID, username),
+	})
 	if err != nil {
 		return c.String(http.StatusInternalServerError, "Failed to generate token")
 	}
-
-	// Generate refresh token
-	refreshExpirationTime := time.Now().Add(24 * time.Hour) // Example: Refresh token lasts 24 hours
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
-	refreshClaims["email"] = creds.Username
-	refreshClaims["name"] = "test " + result
-	refreshClaims["chat_access"] = false
-	refreshClaims["user_role"] = "STUDENT"
-	refreshClaims["id"] = creds.Username
-	refreshClaims["exp"] = refreshExpirationTime.Unix()
-
-	refreshTokenString, err := refreshToken.SignedString(jwtKey)
-	if err != nil {
-		return c.String(http.StatusInternalServerError, "Failed to generate refresh token")
-	}
-
-	// Store refresh token (in a real application, you would store this securely)
-	refreshTokens[refreshTokenString] = creds.Username
-
-	// Return tokens to the client
-	response := map[string]interface{}{
-		"access_token":    tokenString,
-		"refresh_token":   refreshTokenString,
-		"expires_at":      expirationTime.Format(time.RFC3339),
-		"refresh_expires": refreshExpirationTime.Format(time.RFC3339),
-		"user_type":       "new_user",
-		"access-list": map[string]interface{}{
-			"overview": map[string]string{
-				"class": "1",
-			},
-			"comments": map[string]string{
-				"class": "1",
-			},
-			"students": map[string]string{
-				"class": "1",
-			},
-			"student_birthdays": map[string]string{
-				"class": "1",
-			},
-			"teacher_leaves": map[string]string{
-				"class": "1",
-			},
-			"service_requests": map[string]string{
-				"class": "1",
-			},
-		},
-	}
-
 	return c.JSON(http.StatusOK, response)
 }
 
@@ -1804,48 +1986,68 @@ func RefreshTokenHandler(c echo.Context) error {
 		return c.String(http.StatusUnauthorized, "Refresh token expired")
 	}
 
-	// Generate a new access token
-	expirationTime := time.Now().Add(50000 * time.Second)
-	newClaims := &Claims{
+	// Keep the active student while the login is still linked to it
+	studentId := claimString(claims, "student_id")
+	if !studentAccounts.canActFor(schoolID(claims), claims["id"].(string), studentId) {
+		studentId = defaultActiveStudent(schoolID(claims), claims["id"].(string))
+	}
+
+	// Generate new access and refresh tokens
+	response, err := issueTokens(Claims{
 		Email:      claims["email"].(string),
 		Name:       claims["name"].(string),
 		UserRole:   claims["user_role"].(string),
 		ChatAccess: claims["chat_access"].(bool),
 		Id:         claims["id"].(string),
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+		SchoolId:   schoolID(claims),
+		StudentId:  studentId,
+	})
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to generate token")
 	}
 
-	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims)
-	newTokenString, err := newToken.SignedString(jwtKey)
+	return c.JSON(http.StatusOK, response)
+}
+
+// issueTokens signs an access token and a refresh token for the claims and
+// builds the login response.
+func issueTokens(claims Claims) (map[string]interface{}, error) {
+	// Generate JWT
+	expirationTime := time.Now().Add(50000 * time.Second)
+	claims.StandardClaims = jwt.StandardClaims{
+		ExpiresAt: expirationTime.Unix(),
+	}
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString(jwtKey)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, "Failed to generate token")
+		return nil, err
 	}
 
-	// Generate a new refresh token (optional: refresh the refresh token)
+	// Generate refresh token
 	refreshExpirationTime := time.Now().Add(24 * time.Hour) // Example: Refresh token lasts 24 hours
-	newRefreshToken := jwt.New(jwt.SigningMethodHS256)
-	newRefreshClaims := newRefreshToken.Claims.(jwt.MapClaims)
-	newRefreshClaims["email"] = claims["email"]
-	newRefreshClaims["name"] = claims["name"]
-	newRefreshClaims["chat_access"] = claims["chat_access"]
-	newRefreshClaims["user_role"] = claims["user_role"]
-	newRefreshClaims["id"] = claims["id"]
-	newRefreshClaims["exp"] = refreshExpirationTime.Unix()
-
-	newRefreshTokenString, err := newRefreshToken.SignedString(jwtKey)
+	refreshToken := jwt.New(jwt.SigningMethodHS256)
+	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
+	refreshClaims["email"] = claims.Email
+	refreshClaims["name"] = claims.Name
+	refreshClaims["chat_access"] = claims.ChatAccess
+	refreshClaims["user_role"] = claims.UserRole
+	refreshClaims["id"] = claims.Id
+	refreshClaims["school_id"] = claims.SchoolId
+	if claims.StudentId != "" {
+		refreshClaims["student_id"] = claims.StudentId
+	}
+	refreshClaims["exp"] = refreshExpirationTime.Unix()
+
+	refreshTokenString, err := refreshToken.SignedString(jwtKey)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, "Failed to generate refresh token")
+		return nil, err
 	}
 
-	// Update refresh token in the map (optional: if refreshing the refresh token)
-	refreshTokens[newRefreshTokenString] = claims["email"].(string)
+	// Store refresh token (in a real application, you would store this securely)
+	refreshTokens[refreshTokenString] = claims.Email
 
-	// Return the new tokens to the client
-	response := map[string]interface{}{
-		"access_token":    newTokenString,
-		"refresh_token":   newRefreshTokenString,
+	return map[string]interface{}{
+		"access_token":    tokenString,
+		"refresh_token":   refreshTokenString,
 		"expires_at":      expirationTime.Format(time.RFC3339),
 		"refresh_expires": refreshExpirationTime.Format(time.RFC3339),
 		"user_type":       "new_user",
@@ -1869,9 +2071,7 @@ func RefreshTokenHandler(c echo.Context) error {
 				"class": "1",
 			},
 		},
-	}
-
-	return c.JSON(http.StatusOK, response)
+	}, nil
 }
 
 // HomePageHandler handles requests to the home page and checks the token in the Authorization header
@@ -1931,12 +2131,20 @@ func HomePageHandler(c echo.Context) error {
 			Errors:  []string{"Token expired"},
 		})
 	}
+	// Student and parent logins only see their active student
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+
 	var homePageModel CoreHomePageModel
 	if claims["email"] == "test@mail.com" {
 		homePageModel = fillGenericHomePageModelUser2()
 	} else {
 		homePageModel = fillGenericHomePageModelUser1()
 	}
+	homePageModel.StudentId = studentId
+	applySchoolBranding(&homePageModel.AppBarData, schoolID(claims))
 	// Create the response
 	response := BaseResponse{
 		Status:  "SUCCESS",
@@ -2005,8 +2213,14 @@ func AcademicStatsHandler(c echo.Context) error {
 		})
 	}
 
+	// Student and parent logins only see their active student
+	studentId, status, failure := pageStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+
 	// Fill the CoreHomePageModel
-	homePageModel := fillGenericAcademicStatsModel()
+	homePageModel := fillGenericAcademicStatsModel(studentId)
 
 	// Create the response
 	response := BaseResponse{
@@ -2075,8 +2289,14 @@ func AssignmentStatsHandler(c echo.Context) error {
 		})
 	}
 
+	// Student and parent logins only see their active student
+	studentId, status, failure := pageStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+
 	// Fill the CoreHomePageModel
-	homePageModel := fillAssignmentModel()
+	homePageModel := fillAssignmentModel(studentId)
 
 	// Create the response
 	response := BaseResponse{
@@ -2147,6 +2367,16 @@ func ProfileStatsHandler(c echo.Context) error {
 
 	// Fill the CoreHomePageModel
 	homePageModel := fillProfileModel()
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	applyActiveStudent(&homePageModel, claims, studentId)
+	if student, ok := students.get(schoolID(claims), studentId); ok {
+		applyStudentInformation(&homePageModel, schoolID(claims), student)
+		applyStudentAddress(&homePageModel, student)
+		applyStudentDocuments(&homePageModel, schoolID(claims), studentId, time.Now())
+	}
 
 	// Create the response
 	response := BaseResponse{
@@ -2220,8 +2450,16 @@ func CalendarHandler(c echo.Context) error {
 		})
 	}
 
+	// Build the month containing the selected date, defaulting to the
+	// month the calendar data was prepared for
+	month, _ := time.Parse("2006-01", fillCalendar().Month)
+	if selected, err := time.Parse(dateLayout, creds.SelectedDate[:min(len(creds.SelectedDate), 10)]); err == nil {
+		month = time.Date(selected.Year(), selected.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
 	// Fill the CoreHomePageModel
-	homePageModel := fillCalendar()
+	homePageModel := buildCalendar(claimString(claims, "id"), claimString(claims, "user_role"), month, month.AddDate(0, 1, 0))
+	homePageModel.Month = month.Format("2006-01")
 
 	// Create the response
 	response := BaseResponse{
@@ -2292,6 +2530,13 @@ func FeeHandler(c echo.Context) error {
 
 	// Fill the CoreHomePageModel
 	homePageModel := fillGenericFeePageModel()
+	studentId, status, failure := pageStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if studentId != "" {
+		applyFeeCharges(&homePageModel, studentId, time.Now())
+	}
 
 	// Create the response
 	response := BaseResponse{
@@ -2360,8 +2605,14 @@ func HomeworkHandler(c echo.Context) error {
 		})
 	}
 
+	// Student and parent logins only see their active student
+	studentId, status, failure := pageStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+
 	// Fill the CoreHomePageModel
-	homePageModel := fillCoreHomeWorkPageModel()
+	homePageModel := fillCoreHomeWorkPageModel(studentId)
 
 	// Create the response
 	response := BaseResponse{
@@ -2718,227 +2969,3 @@ func LeaveHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 	// Fill the LeaveRequestPageModel
 }
-
-var indiaStates = map[string]map[string][]string{
-	"India": {
-		"Andhra Pradesh": {
-			"Adoni", "Amalapuram", "Anantapur", "Bapatla", "Chilakaluripet", "Chirala", "Chittoor",
-			"Dharmavaram", "Eluru", "Gudivada", "Gudur", "Guntakal", "Guntur", "Hindupur", "Jaggaiahpet",
-			"Jammalamadugu", "Kadapa", "Kadiri", "Kakinada", "Kandukur", "Kavali", "Kurnool", "Macherla",
-			"Machilipatnam", "Madanapalle", "Mandapeta", "Markapur", "Narasaraopet", "Narsipatnam", "Nellore",
-			"Nidadavole", "Nuzvid", "Ongole", "Palacole", "Palasa Kasibugga", "Parvathipuram", "Pedana",
-			"Peddapuram", "Pithapuram", "Ponnur", "Proddatur", "Punganur", "Puttur", "Rajahmundry", "Rajam",
-			"Ramachandrapuram", "Rayachoti", "Rayadurg", "Renigunta", "Repalle", "Salur", "Samalkot", "Sattenapalle",
-			"Srikakulam", "Srikalahasti", "Srisailam Project (Right Flank Colony) Township", "Sullurpeta", "Tadepalligudem",
-			"Tadpatri", "Tanuku", "Tenali", "Tirupati", "Tiruvuru", "Tuni", "Uravakonda", "Venkatagiri", "Vijayawada",
-			"Vinukonda", "Visakhapatnam", "Vizianagaram", "Yemmiganur", "Yerraguntla",
-		},
-		"Arunachal Pradesh": {
-			"Naharlagun", "Pasighat",
-		},
-		"Assam": {
-			"Barpeta", "Bongaigaon City", "Dhubri", "Dibrugarh", "Diphu", "Goalpara", "Guwahati", "Jorhat", "Karimganj",
-			"Lanka", "Lumding", "Mangaldoi", "Mankachar", "Margherita", "Mariani", "Marigaon", "Nagaon", "Nalbari",
-			"North Lakhimpur", "Rangia", "Sibsagar", "Silapathar", "Silchar", "Tezpur", "Tinsukia",
-		},
-		"Bihar": {
-			"Araria", "Arrah", "Arwal", "Asarganj", "Aurangabad", "Bagaha", "Barh", "Begusarai", "Bettiah", "Bhabua",
-			"Bhagalpur", "Buxar", "Chhapra", "Darbhanga", "Dehri-on-Sone", "Dumraon", "Forbesganj", "Gaya", "Gopalganj",
-			"Hajipur", "Jamalpur", "Jamui", "Jehanabad", "Katihar", "Kishanganj", "Lakhisarai", "Lalganj", "Madhepura",
-			"Madhubani", "Maharajganj", "Mahnar Bazar", "Makhdumpur", "Maner", "Manihari", "Marhaura", "Masaurhi", "Mirganj",
-			"Mokameh", "Motihari", "Motipur", "Munger", "Murliganj", "Muzaffarpur", "Narkatiaganj", "Naugachhia", "Nawada",
-			"Nokha", "Patna", "Piro", "Purnia", "Rafiganj", "Rajgir", "Ramnagar", "Raxaul Bazar", "Revelganj", "Rosera",
-			"Saharsa", "Samastipur", "Sasaram", "Sheikhpura", "Sheohar", "Sherghati", "Silao", "Sitamarhi", "Siwan",
-			"Sonepur", "Sugauli", "Sultanganj", "Supaul", "Warisaliganj",
-		},
-		"Chhattisgarh": {
-			"Ambikapur", "Bhatapara", "Bhilai Nagar", "Bilaspur", "Chirmiri", "Dalli-Rajhara", "Dhamtari", "Durg", "Jagdalpur",
-			"Korba", "Mahasamund", "Manendragarh", "Mungeli", "Naila Janjgir", "Raigarh", "Raipur", "Rajnandgaon", "Sakti", "Tilda Newra",
-		},
-		"Goa": {
-			"Mapusa", "Margao", "Marmagao", "Panaji",
-		},
-		"Gujarat": {
-			"Adalaj", "Ahmedabad", "Amreli", "Anand", "Anjar", "Ankleshwar", "Bharuch", "Bhavnagar", "Bhuj", "Chhapra", "Deesa",
-			"Dhoraji", "Godhra", "Jamnagar", "Kadi", "Kapadvanj", "Keshod", "Khambhat", "Lathi", "Limbdi", "Lunawada", "Mahesana",
-			"Mahuva", "Manavadar", "Mandvi", "Mangrol", "Mansa", "Mahudha", "Modasa", "Morvi", "Nadiad", "Navsari", "Padra", "Palanpur",
-			"Palitana", "Pardi", "Patan", "Petlad", "Porbandar", "Radhanpur", "Rajkot", "Rajpipla", "Rajula", "Ranavav", "Rapar", "Salaya",
-			"Sanand", "Savarkundla", "Sidhpur", "Sihor", "Songadh", "Surat", "Talaja", "Thangadh", "Tharad", "Umbergaon", "Umreth", "Una",
-			"Unjha", "Upleta", "Vadnagar", "Vadodara", "Valsad", "Vapi", "Vapi", "Veraval", "Vijapur", "Viramgam", "Visnagar", "Vyara",
-			"Wadhwan", "Wankaner",
-		},
-		"Haryana": {
-			"Bahadurgarh", "Bhiwani", "Charkhi Dadri", "Faridabad", "Fatehabad", "Gohana", "Gurgaon", "Hansi", "Hisar", "Jind", "Kaithal",
-			"Karnal", "Ladwa", "Mahendragarh", "Mandi Dabwali", "Narnaul", "Narwana", "Palwal", "Panchkula", "Panipat", "Pehowa", "Pinjore",
-			"Rania", "Ratia", "Rewari", "Rohtak", "Safidon", "Samalkha", "Sarsod", "Shahbad", "Sirsa", "Sohna", "Sonipat", "Taraori", "Thanesar",
-			"Tohana", "Yamunanagar",
-		},
-		"Himachal Pradesh": {
-			"Mandi", "Nahan", "Palampur", "Shimla", "Solan", "Sundarnagar",
-		},
-		"Jharkhand": {
-			"Adityapur", "Bokaro Steel City", "Chaibasa", "Chatra", "Chirkunda", "Medininagar (Daltonganj)", "Deoghar", "Dhanbad", "Dumka",
-			"Giridih", "Gumia", "Hazaribag", "Jamshedpur", "Jhumri Tilaiya", "Lohardaga", "Madhupur", "Mihijam", "Musabani", "Pakaur", "Patratu",
-			"Phusro", "Ramgarh", "Ranchi", "Sahibganj", "Saunda", "Simdega", "Tenu dam-cum-Kathhara",
-		},
-		"Karnataka": {
-			"Adyar", "Afzalpur", "Arsikere", "Athni", "Bengaluru", "Belagavi", "Ballari", "Chikkamagaluru", "Davanagere", "Gokak", "Hubli-Dharwad",
-			"Karwar", "Kolar", "Lakshmeshwar", "Lingsugur", "Maddur", "Madhugiri", "Madikeri", "Magadi", "Mahalingapura", "Malavalli", "Malur",
-			"Mandya", "Mangaluru", "Mudalagi", "Mudabidri", "Muddebihal", "Mudhol", "Mulbagal", "Mundargi", "Nanjangud", "Nargund", "Navalgund",
-			"Nelamangala", "Pavagada", "Piriyapatna", "Puttur", "Raayachuru", "Rabkavi Banhatti", "Ramanagaram", "Ramdurg", "Ranibennur", "Robertson Pet",
-			"Ron", "Sadalagi", "Sagara", "Sakaleshapura", "Sindagi", "Sanduru", "Sankeshwara", "Saundatti-Yellamma", "Savanur", "Sedam", "Shahabad",
-			"Shahpur", "Shiggaon", "Shikaripur", "Shivamogga", "Surapura", "Shrirangapattana", "Sidlaghatta", "Sindhagi", "Sindhnur", "Sira", "Sirsi",
-			"Siruguppa", "Srinivaspur", "Tarikere", "Tekkalakote", "Terdal", "Talikota", "Tiptur", "Tumkur", "Udupi", "Vijayapura", "Wadi", "Yadgir",
-			"Mysuru",
-		},
-		"Kerala": {
-			"Adoor", "Alappuzha", "Attingal", "Chalakudy", "Changanassery", "Cherthala", "Chittur-Thathamangalam", "Guruvayoor", "Kanhangad",
-			"Kannur", "Kasaragod", "Kayamkulam", "Kochi", "Kollam", "Kottayam", "Kozhikode", "Kunnamkulam", "Malappuram", "Mattannur", "Mavelikkara",
-			"Mavoor", "Muvattupuzha", "Nedumangad", "Neyyattinkara", "Nilambur", "Ottappalam", "Palai", "Palakkad", "Panamattom", "Panniyannur",
-			"Pappinisseri", "Paravoor", "Pathanamthitta", "Peringathur", "Perinthalmanna", "Perumbavoor", "Ponnani", "Punalur", "Puthuppally", "Shoranur",
-			"Taliparamba", "Thiruvalla", "Thiruvananthapuram", "Thodupuzha", "Thrissur", "Tirur", "Vaikom", "Varkala",
-		},
-		"Madhya Pradesh": {
-			"Alirajpur", "Ashok Nagar", "Balaghat", "Betul", "Bhopal", "Burhanpur", "Chhatarpur", "Chhindwara", "Damoh", "Datia", "Dewas",
-			"Dhar", "Fatehabad", "Guna", "Gwalior", "Harda", "Hoshangabad", "Indore", "Itarsi", "Jabalpur", "Jhabua", "Khandwa", "Khargone",
-			"Maharajpur", "Maihar", "Malaj Khand", "Manasa", "Manawar", "Mandideep", "Mandla", "Mandsaur", "Mauganj", "Mhow Cantonment",
-			"Mhowgaon", "Morena", "Multai", "Murwara (Katni)", "Nagda", "Nainpur", "Narsinghgarh", "Narsinghgarh", "Neemuch", "Nepanagar",
-			"Niwari", "Nowgong", "Nowrozabad (Khodargama)", "Pachore", "Pali", "Panagar", "Pandhurna", "Panna", "Pasan", "Pipariya", "Pithampur",
-			"Porsa", "Prithvipur", "Raghogarh-Vijaypur", "Rahatgarh", "Raisen", "Rajgarh", "Ratlam", "Rau", "Rehli", "Rewa", "Sabalgarh", "Sagar",
-			"Sanawad", "Sarangpur", "Sarni", "Satna", "Sausar", "Sehore", "Sendhwa", "Seoni", "Seoni-Malwa", "Shahdol", "Shajapur", "Shamgarh",
-			"Sheopur", "Shivpuri", "Shujalpur", "Sidhi", "Sihora", "Singrauli", "Sironj", "Sohagpur", "Tarana", "Tikamgarh", "Ujjain", "Umaria",
-			"Vidisha", "Vijaypur", "Wara Seoni",
-		},
-		"Maharashtra": {
-			"Ahmednagar", "Akola", "Amravati", "Aurangabad", "Baramati", "Chalisgaon", "Chinchani", "Devgarh", "Dhule", "Dombivli", "Durgapur", "Ichalkaranji", "Jalna",
-			"Kalyan", "Latur", "Lonar", "Mahad", "Malegaon", "Malkapur", "Manchar", "Mangalvedhe", "Mangrulpir", "Manjlegaon", "Manmad", "Manwath", "Mehkar", "Mhaswad",
-			"Mira-Bhayandar", "Morshi", "Mukhed", "Mul", "Mumbai", "Murtijapur", "Nagpur", "Nalasopara", "Nanded", "Nandgaon", "Nandura", "Nandurbar", "Narkhed", "Nashik",
-			"Navi Mumbai", "Nawapur", "Nilanga", "Osmanabad", "Ozar", "Pachora", "Paithan", "Palghar", "Pandharkaoda", "Pandharpur", "Panvel", "Parbhani", "Parli", "Parola",
-			"Partur", "Pathardi", "Pathri", "Patur", "Pauni", "Pen", "Phaltan", "Pulgaon", "Pune", "Purna", "Pusad", "Rahuri", "Raigad", "Ratnagiri", "Raver", "Risod", "Sailu",
-			"Sangamner", "Sangli", "Sangole", "Sasvad", "Satana", "Satara", "Savner", "Sawantwadi", "Shahade", "Shegaon", "Shendurjana", "Shirdi", "Shirpur-Warwade", "Shirur",
-			"Shrigonda", "Shrirampur", "Sillod", "Sinnar", "Solapur", "Soyagaon", "Talegaon Dabhade", "Talode", "Tasgaon", "Thane", "Tirora", "Tuljapur", "Tumsar", "Uchgaon",
-			"Udgir", "Umarga", "Umarkhed", "Umred", "Uran", "Uran Islampur", "Vadgaon Kasba", "Vaijapur", "Vasai-Virar", "Vita", "Wadgaon Road", "Wai", "Wani", "Wardha", "Warora",
-			"Warud", "Washim", "Yavatmal", "Yawal", "Yevla",
-		},
-		"Manipur": {
-			"Imphal",
-		},
-		"Meghalaya": {
-			"Shillong",
-		},
-		"Mizoram": {
-			"Aizawl",
-		},
-		"Nagaland": {
-			"Dimapur", "Kohima",
-		},
-		"Odisha": {
-			"Balangir", "Baleshwar", "Barbil", "Bargarh", "Baripada Town", "Bhadrak", "Bhawanipatna", "Bhubaneswar", "Brahmapur", "Byasanagar",
-			"Cuttack", "Debagarh", "Dhenkanal", "Jatani", "Jharsuguda", "Kendrapara", "Kendujhar", "Malkangiri", "Nabarangapur", "Paradip", "Parlakhemundi",
-			"Pattamundai", "Phulabani", "Puri", "Rairangpur", "Rajagangapur", "Raurkela", "Rayagada", "Sambalpur", "Soro", "Sunabeda", "Sundargarh",
-			"Talcher", "Titlagarh", "Umarkote",
-		},
-		"Punjab": {
-			"Amritsar", "Barnala", "Batala", "Bathinda", "Dhuri", "Faridkot", "Fazilka", "Firozpur", "Firozpur Cantt.", "Gobindgarh", "Gurdaspur",
-			"Hoshiarpur", "Jagraon", "Jalandhar", "Kapurthala", "Khanna", "Kharar", "Kot Kapura", "Longowal", "Ludhiana", "Malerkotla", "Malout",
-			"Mansa", "Moga", "Mohali", "Morinda, India", "Muktsar", "Nabha", "Nakodar", "Nangal", "Nawanshahr", "Pathankot", "Patiala", "Pattran",
-			"Patti", "Phagwara", "Phillaur", "Qadian", "Raikot", "Rajpura", "Rampura Phul", "Rupnagar", "Samana", "Sangrur", "Sirhind Fatehgarh Sahib",
-			"Sujanpur", "Sunam", "Talwara", "Tarn Taran", "Urmar Tanda", "Zira", "Zirakpur",
-		},
-		"Rajasthan": {
-			"Ajmer", "Alwar", "Bikaner", "Bharatpur", "Bhilwara", "Bhiwadi", "Bhilwara", "Bikaner", "Chittorgarh", "Churu", "Daosa", "Firozpur Jhirka", "Gangapur",
-			"Hanumangarh", "Hindaun", "Jaipur", "Jaisalmer", "Jalore", "Jhalawar", "Jhunjhunu", "Kankroli", "Kishangarh", "Kota", "Kuchaman City", "Ladnu", "Lakheri",
-			"Lalsot", "Losal", "Makrana", "Malpura", "Mandalgarh", "Mandawa", "Mangrol", "Merta City", "Mount Abu", "Nadbai", "Nagar", "Nagaur", "Nargund", "Nasirabad",
-			"Nathdwara", "Neem-Ka-Thana", "Nimbahera", "Nohar", "Nokha", "Pali", "Phalodi", "Phulera", "Pilani", "Pilibanga", "Pindwara", "Pipar City", "Prantij",
-			"Pratapgarh", "Raisinghnagar", "Rajakhera", "Rajaldesar", "Rajgarh Alwar", "Rajgarh Churu", "Rajsamand", "Ramganj Mandi", "Ramngarh", "Ratangarh", "Rawatbhata",
-			"Rawatsar", "Reengus", "Sadri", "Sadulshahar", "Sadulpur", "Sagwara", "Sambhar", "Sanchore", "Sangaria", "Sardarshahar", "Sawai Madhopur", "Shahpura", "Shahpura",
-			"Sheoganj", "Sikar", "Sirohi", "Sojat", "Sri Madhopur", "Sujangarh", "Sumerpur", "Suratgarh", "Taranagar", "Todabhim", "Todaraisingh", "Tonk", "Udaipur", "Udaipurwati",
-			"Vijainagar, Ajmer", "Gangapur City",
-		},
-		"Sikkim": {
-			"Gangtok",
-		},
-		"Tamil Nadu": {
-			"Arcot", "Aruppukkottai", "Chennai", "Coimbatore", "Erode", "Kanchipuram", "Karur", "Lalgudi", "Madurai", "Manasa", "Masaurhi", "Nagercoil", "Namagiripettai", "Namakkal",
-			"Nandivaram-Guduvancheri", "Nanjikottai", "Natham", "Nellikuppam", "Neyveli", "O' Valley", "Oddanchatram", "P.N.Patti", "Pacode", "Padmanabhapuram", "Palani", "Palladam",
-			"Pallapatti", "Pallikonda", "Panagudi", "Panruti", "Paramakudi", "Parangipettai", "Pattukkottai", "Perambalur", "Peravurani", "Periyakulam", "Periyasemur", "Pernampattu",
-			"Pollachi", "Polur", "Ponneri", "Pudukkottai", "Pudupattinam", "Puliyankudi", "Punjaipugalur", "Ranipet", "Rajapalayam", "Ramanathapuram", "Rameshwaram", "Rasipuram",
-			"Salem", "Sankarankoil", "Sankari", "Sathyamangalam", "Sattur", "Shenkottai", "Sholavandan", "Sholingur", "Sirkali", "Sivaganga", "Sivagiri", "Sivakasi", "Srivilliputhur",
-			"Surandai", "Suriyampalayam", "Tenkasi", "Thammampatti", "Thanjavur", "Tharamangalam", "Tharangambadi", "Theni Allinagaram", "Thirumangalam", "Thirupuvanam", "Thiruthuraipoondi",
-			"Thiruvallur", "Thiruvarur", "Thuraiyur", "Tindivanam", "Tiruchendur", "Tiruchengode", "Tiruchirappalli", "Tirukalukundram", "Tirukkoyilur", "Tirunelveli", "Tirupathur", "Tirupathur",
-			"Tiruppur", "Tiruttani", "Tiruvannamalai", "Tiruvethipuram", "Tittakudi", "Udhagamandalam", "Udumalaipettai", "Unnamalaikadai", "Usilampatti", "Uthamapalayam", "Uthiramerur",
-			"Vadakkuvalliyur", "Vadalur", "Vadipatti", "Valparai", "Vandavasi", "Vaniyambadi", "Vedaranyam", "Vellakoil", "Vellore", "Vikramasingapuram", "Viluppuram", "Virudhachalam",
-			"Virudhunagar", "Viswanatham",
-		},
-		"Telangana": {
-			"Adilabad", "Bellampalle", "Bhadrachalam", "Bhainsa", "Bhongir", "Bodhan", "Farooqnagar", "Gadwal", "Hyderabad", "Jagtial", "Jangaon", "Kagaznagar", "Kamareddy",
-			"Karimnagar", "Khammam", "Koratla", "Kothagudem", "Kyathampalle", "Mahbubnagar", "Mancherial", "Mandamarri", "Manuguru", "Medak", "Miryalaguda", "Nagarkurnool",
-			"Narayanpet", "Nirmal", "Nizamabad", "Palwancha", "Ramagundam", "Sadasivpet", "Sangareddy", "Siddipet", "Sircilla", "Suryapet", "Tandur", "Vikarabad", "Wanaparthy",
-			"Warangal", "Yellandu",
-		},
-		"Tripura": {
-			"Agartala",
-		},
-		"Uttar Pradesh": {
-			"Achhnera", "Agra", "Aligarh", "Allahabad", "Amroha", "Azamgarh", "Bahraich", "Ballia", "Balrampur", "Banda", "Barabanki", "Bareilly", "Basti", "Bijnor", "Bithur",
-			"Budaun", "Bulandshahr", "Chandausi", "Chitrakoot Dham (Karwi)", "Deoria", "Etawah", "Faizabad", "Farrukhabad", "Fatehpur", "Fatehpur Sikri", "Ghaziabad", "Ghazipur",
-			"Gonda", "Gorakhpur", "Hamirpur", "Hardoi", "Hasanpur", "Hathras", "Jalaun", "Jaunpur", "Jhansi", "Kannauj", "Kanpur", "Lakhimpur", "Lalitpur", "Loni", "Lucknow",
-			"Mathura", "Meerut", "Modinagar", "Moradabad", "Noida", "Orai", "Pilibhit", "Raebareli", "Rampur", "Rae Bareli", "Rampur", "Saharanpur", "Sambhal", "Shahjahanpur",
-			"Shamli", "Shikohabad", "Sitapur", "Sultanpur", "Tanda", "Ujhani", "Unnao", "Varanasi",
-		},
-		"Uttarakhand": {
-			"Bageshwar", "Dehradun", "Haldwani-cum-Kathgodam", "Hardwar", "Kashipur", "Manglaur", "Mussoorie", "Nagla", "Nainital", "Pauri", "Pithoragarh", "Ramnagar", "Rishikesh", "Roorkee", "Rudrapur", "Sitarganj", "Srinagar",
-		},
-		"West Bengal": {
-			"Adra", "Alipurduar", "Arambagh", "Asansol", "Baharampur", "Bally", "Balurghat", "Bankura", "Barakar", "Barasat", "Bardhaman", "Bidhan Nagar", "Chakdaha", "Chandannagar", "Contai", "Cooch Behar", "Darjiling", "Durgapur", "Haldia", "Howrah", "Islampur", "Jalpaiguri", "Jhargram", "Kalimpong", "Kalyani", "Kamarhati", "Kanchrapara", "Kharagpur", "Kolkata", "Krishnanagar", "Mainaguri", "Malda", "Medinipur", "Memari", "Monoharpur", "Murshidabad", "Nabadwip", "Naihati", "Panihati", "Purulia", "Raiganj", "Rampurhat", "Ranaghat", "Sainthia", "Santipur", "Siliguri", "Sonamukhi", "Srirampore", "Titagarh",
-		},
-		"Andaman and Nicobar Islands": {
-			"Port Blair",
-		},
-		"Chandigarh": {
-			"Chandigarh",
-		},
-		"Dadra and Nagar Haveli": {
-			"Silvassa",
-		},
-		"Daman and Diu": {
-			"Daman", "Diu",
-		},
-		"Lakshadweep": {
-			"Kavaratti",
-		},
-		"Delhi": {
-			"Delhi",
-		},
-		"Puducherry": {
-			"Karaikal", "Mahe", "Pondicherry", "Yanam",
-		},
-	},
-}
-
-func getCountries(c echo.Context) error {
-	states := make([]string, 0, len(indiaStates))
-	for state := range indiaStates {
-		states = append(states, state)
-	}
-	return c.JSON(http.StatusOK, states)
-}
-
-func getStates(c echo.Context) error {
-	country := c.Param("country")
-	var states []string
-	for state := range indiaStates[country] {
-		states = append(states, state)
-	}
-	return c.JSON(http.StatusOK, states)
-}
-
-func getCities(c echo.Context) error {
-	country := c.Param("country")
-	state := c.Param("state")
-	cities, ok := indiaStates[country][state]
-	if !ok {
-		return c.JSON(http.StatusNotFound, echo.Map{"error": "State not found"})
-	}
-	return c.JSON(http.StatusOK, cities)
-}
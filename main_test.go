@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// testToken signs an access token for a login of the default school.
+func testToken(t *testing.T, id, role, studentId string) string {
+	t.Helper()
+	response, err := issueTokens(Claims{Email: id, Name: id, Id: id, UserRole: role, SchoolId: defaultSchoolID, StudentId: studentId})
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+	return response["access_token"].(string)
+}
+
+// callHandler runs handler on a request carrying body as JSON (unless it is
+// nil) and token as the bearer token.
+func callHandler(t *testing.T, handler echo.HandlerFunc, method, target, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	return rec
+}
+
+// decodeData checks the status code and decodes the data of the response
+// into data, unless it is nil.
+func decodeData(t *testing.T, rec *httptest.ResponseRecorder, status int, data interface{}) BaseResponse {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	var response BaseResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v: %s", err, rec.Body.String())
+	}
+	if data != nil {
+		raw, _ := json.Marshal(response.Data)
+		if err := json.Unmarshal(raw, data); err != nil {
+			t.Fatalf("decode data: %v: %s", err, raw)
+		}
+	}
+	return response
+}
+
+func TestBearerClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"missing", "", http.StatusBadRequest},
+		{"not bearer", "Basic abc", http.StatusBadRequest},
+		{"garbage", "Bearer abc.def.ghi", http.StatusUnauthorized},
+		{"valid", "Bearer " + testToken(t, "admin", roleAdmin, ""), http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, test.header)
+			}
+			claims, status, _ := bearerClaims(echo.New().NewContext(req, httptest.NewRecorder()))
+			if status != test.status || (claims != nil) != (test.status == http.StatusOK) {
+				t.Errorf("status = %d, claims = %v, want %d", status, claims, test.status)
+			}
+		})
+	}
+}
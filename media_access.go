@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	mediaURLTTL = 15 * time.Minute
+
+	// mediaSourceDrive items are Google Drive file ids; mediaSourceSchool
+	// items are keys in the school's configured image source;
+	// mediaSourceBlob items are private files in the blob store, such as
+	// student documents, and are downloaded as they are.
+	mediaSourceDrive  = "drive"
+	mediaSourceSchool = "school"
+	mediaSourceBlob   = "blob"
+
+	mediaVisibilityPublic = "PUBLIC"
+	mediaVisibilityStaff  = "STAFF"
+	// mediaVisibilityFamily limits an item to staff, the item's viewers and
+	// the logins linked to the owner student.
+	mediaVisibilityFamily = "FAMILY"
+)
+
+// MediaItemModel is a file served through signed media URLs together with
+// who may see it.
+type MediaItemModel struct {
+	Id             string `json:"id"`
+	Title          string `json:"title"`
+	Source         string `json:"source"`
+	Key            string `json:"key"`
+	OwnerStudentId string `json:"ownerStudentId,omitempty"`
+	Visibility     string `json:"visibility"`
+	// Viewers are the logins besides staff that may see a FAMILY item.
+	Viewers   []string `json:"viewers,omitempty"`
+	CreatedBy string   `json:"createdBy"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+type MediaURLModel struct {
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+type mediaStore struct {
+	sync.RWMutex
+	items map[string]map[string]*MediaItemModel
+	// restricted holds the image cache keys of the files of non-public
+	// items, which the open /image endpoint refuses to serve. Keys come
+	// from the backend each item resolves to, so one file reached through
+	// different sources is restricted once.
+	restricted map[string]bool
+	// public holds the cache keys of the files of PUBLIC items, the only
+	// Drive files /image serves without a signature.
+	public map[string]bool
+}
+
+var mediaItems = &mediaStore{items: map[string]map[string]*MediaItemModel{}, restricted: map[string]bool{}, public: map[string]bool{}}
+
+// school returns the items of a school by id. Callers must hold the lock.
+func (s *mediaStore) school(schoolId string) map[string]*MediaItemModel {
+	items, ok := s.items[schoolId]
+	if !ok {
+		items = map[string]*MediaItemModel{}
+		s.items[schoolId] = items
+	}
+	return items
+}
+
+// mediaFileKey identifies the file behind an item by its image cache key,
+// or returns "" for files /image never serves.
+func mediaFileKey(schoolId string, item MediaItemModel) string {
+	if item.Source == mediaSourceBlob {
+		return ""
+	}
+	return mediaImageSource(schoolId, item).CacheKey(item.Key)
+}
+
+func (s *mediaStore) add(schoolId string, item MediaItemModel) MediaItemModel {
+	s.Lock()
+	defer s.Unlock()
+	item.Id = newID("MED")
+	s.school(schoolId)[item.Id] = &item
+	s.index(schoolId, item)
+	return item
+}
+
+// index records the item's file as public or restricted. Callers must hold
+// the lock.
+func (s *mediaStore) index(schoolId string, item MediaItemModel) {
+	key := mediaFileKey(schoolId, item)
+	switch {
+	case key == "":
+	case item.Visibility == mediaVisibilityPublic:
+		s.public[key] = true
+	default:
+		s.restricted[key] = true
+	}
+}
+
+// reindex recomputes the public and restricted files after a school changes its image
+// source, which moves its items to another backend.
+func (s *mediaStore) reindex() {
+	s.Lock()
+	defer s.Unlock()
+	s.restricted, s.public = map[string]bool{}, map[string]bool{}
+	for schoolId, items := range s.items {
+		for _, item := range items {
+			s.index(schoolId, *item)
+		}
+	}
+}
+
+func (s *mediaStore) get(schoolId, id string) (MediaItemModel, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	item, ok := s.items[schoolId][id]
+	if !ok {
+		return MediaItemModel{}, false
+	}
+	return *item, true
+}
+
+// isRestricted reports whether the file with the image cache key belongs
+// to a non-public item.
+func (s *mediaStore) isRestricted(cacheKey string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.restricted[cacheKey]
+}
+
+// isPublic reports whether the file with the image cache key belongs to a
+// PUBLIC item and to no restricted one.
+func (s *mediaStore) isPublic(cacheKey string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.public[cacheKey] && !s.restricted[cacheKey]
+}
+
+// canAccessMedia applies the item's ACL to the caller.
+func canAccessMedia(claims jwt.MapClaims, item MediaItemModel) bool {
+	role := claimString(claims, "user_role")
+	switch {
+	case item.Visibility == mediaVisibilityPublic || role == roleAdmin || role == roleTeacher:
+		return true
+	case item.Visibility == mediaVisibilityFamily:
+		return containsValue(item.Viewers, claimString(claims, "id")) || canViewStudent(claims, item.OwnerStudentId)
+	default:
+		return false
+	}
+}
+
+func mediaSignature(schoolId, id string, expires int64) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(fmt.Sprintf("media:%s|%s|%d", schoolId, id, expires)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedMediaURL returns a URL that serves the item without a token until
+// it expires.
+func signedMediaURL(schoolId, id string, now time.Time) MediaURLModel {
+	expires := now.Add(mediaURLTTL).Unix()
+	query := url.Values{"expires": {strconv.FormatInt(expires, 10)}, "signature": {mediaSignature(schoolId, id, expires)}}
+	return MediaURLModel{
+		URL:       "/media-files/" + url.PathEscape(schoolId) + "/" + url.PathEscape(id) + "?" + query.Encode(),
+		ExpiresAt: time.Unix(expires, 0).Format(time.RFC3339),
+	}
+}
+
+// verifyMediaSignature returns the expiry of a valid signature.
+func verifyMediaSignature(schoolId, id, expiresParam, signature string, now time.Time) (time.Time, bool) {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || now.Unix() > expires || expires > now.Add(mediaURLTTL).Unix() {
+		return time.Time{}, false
+	}
+	return time.Unix(expires, 0), hmac.Equal([]byte(signature), []byte(mediaSignature(schoolId, id, expires)))
+}
+
+// mediaImageSource resolves where an item's file is served from.
+func mediaImageSource(schoolId string, item MediaItemModel) ImageSource {
+	if item.Source == mediaSourceDrive {
+		return driveImageSource{}
+	}
+	return imageSources.forSchool(schoolId)
+}
+
+// RegisterMediaHandler registers a file for signed access. Staff only.
+func RegisterMediaHandler(c echo.Context) error {
+	var req MediaItemModel
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	schoolId := schoolID(claims)
+
+	item := MediaItemModel{
+		Title:          strings.TrimSpace(req.Title),
+		Source:         strings.ToLower(strings.TrimSpace(req.Source)),
+		Key:            strings.TrimSpace(req.Key),
+		OwnerStudentId: strings.TrimSpace(req.OwnerStudentId),
+		Visibility:     strings.ToUpper(strings.TrimSpace(req.Visibility)),
+		CreatedBy:      claimString(claims, "id"),
+		CreatedAt:      time.Now().Format(time.RFC3339),
+	}
+	if item.Visibility == "" {
+		item.Visibility = mediaVisibilityFamily
+	}
+	var errs []string
+	if item.Source != mediaSourceDrive && item.Source != mediaSourceSchool {
+		errs = append(errs, "source must be drive or school")
+	} else if problem := mediaImageSource(schoolId, item).ValidateKey(schoolId, item.Key); problem != "" {
+		errs = append(errs, problem)
+	}
+	switch item.Visibility {
+	case mediaVisibilityFamily:
+		if _, ok := students.get(schoolId, item.OwnerStudentId); !ok {
+			errs = append(errs, "ownerStudentId "+item.OwnerStudentId+" not found")
+		}
+	case mediaVisibilityPublic, mediaVisibilityStaff:
+	default:
+		errs = append(errs, "visibility must be PUBLIC, STAFF or FAMILY")
+	}
+	for _, viewer := range req.Viewers {
+		viewer = strings.TrimSpace(viewer)
+		switch {
+		case viewer == "" || containsValue(item.Viewers, viewer):
+		case roleForUsername(viewer) != roleStudent:
+			errs = append(errs, "viewer "+viewer+" is a staff login")
+		default:
+			item.Viewers = append(item.Viewers, viewer)
+		}
+	}
+	if item.Visibility != mediaVisibilityFamily && len(item.Viewers) > 0 {
+		errs = append(errs, "viewers apply to FAMILY items only")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid media item", errs...))
+	}
+
+	created := mediaItems.add(schoolId, item)
+	return c.JSON(http.StatusOK, success(map[string]interface{}{"item": created, "access": signedMediaURL(schoolId, created.Id, time.Now())}))
+}
+
+// MediaURLHandler issues a signed URL for an item the caller may see.
+func MediaURLHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	schoolId := schoolID(claims)
+	item, ok := mediaItems.get(schoolId, c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Media not found"))
+	}
+	if !canAccessMedia(claims, item) {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	return c.JSON(http.StatusOK, success(signedMediaURL(schoolId, item.Id, time.Now())))
+}
+
+// serveMediaBlob downloads a private blob store file as an attachment.
+func serveMediaBlob(c echo.Context, item MediaItemModel, cacheControl string) error {
+	data, object, err := blobs.Get(item.Key)
+	if errors.Is(err, errBlobNotFound) || errors.Is(err, errInvalidBlobKey) {
+		return c.JSON(http.StatusNotFound, failed("Media not found"))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to read file"))
+	}
+	etag := `"` + object.SHA256 + `"`
+	header := c.Response().Header()
+	header.Set("Cache-Control", cacheControl)
+	header.Set("ETag", etag)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": item.Title + path.Ext(item.Key)}))
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, object.ContentType, data)
+}
+
+// MediaFileHandler serves an item after checking the URL signature. Images
+// go through the image cache and accept the resize parameters of /image.
+func MediaFileHandler(c echo.Context) error {
+	schoolId, id := c.Param("schoolId"), c.Param("id")
+	now := time.Now()
+	expires, ok := verifyMediaSignature(schoolId, id, c.QueryParam("expires"), c.QueryParam("signature"), now)
+	if !ok {
+		return c.JSON(http.StatusForbidden, failed("Media link is invalid or has expired"))
+	}
+	item, ok := mediaItems.get(schoolId, id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Media not found"))
+	}
+	cacheControl := fmt.Sprintf("private, max-age=%d", int(expires.Sub(now).Seconds()))
+	if item.Source == mediaSourceBlob {
+		return serveMediaBlob(c, item, cacheControl)
+	}
+	source := mediaImageSource(schoolId, item)
+	return serveImage(c, source.CacheKey(item.Key), cacheControl, func(ctx context.Context) (proxiedImage, error) {
+		return source.Fetch(ctx, schoolId, item.Key)
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+func TestVerifyMediaSignature(t *testing.T) {
+	now := time.Date(2030, 3, 1, 9, 0, 0, 0, time.UTC)
+	expires := now.Add(mediaURLTTL).Unix()
+	param := strconv.FormatInt(expires, 10)
+	signature := mediaSignature("SCH-A", "MED-1", expires)
+	tests := []struct {
+		name      string
+		school    string
+		id        string
+		expires   string
+		signature string
+		at        time.Time
+		want      bool
+	}{
+		{"valid", "SCH-A", "MED-1", param, signature, now, true},
+		{"valid until expiry", "SCH-A", "MED-1", param, signature, now.Add(mediaURLTTL), true},
+		{"expired", "SCH-A", "MED-1", param, signature, now.Add(mediaURLTTL + time.Second), false},
+		{"other item", "SCH-A", "MED-2", param, signature, now, false},
+		{"other school", "SCH-B", "MED-1", param, signature, now, false},
+		{"extended expiry", "SCH-A", "MED-1", strconv.FormatInt(expires+60, 10), signature, now, false},
+		{"expiry beyond the TTL", "SCH-A", "MED-1", strconv.FormatInt(expires+3600, 10),
+			mediaSignature("SCH-A", "MED-1", expires+3600), now, false},
+		{"bad expiry", "SCH-A", "MED-1", "soon", signature, now, false},
+		{"missing signature", "SCH-A", "MED-1", param, "", now, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, ok := verifyMediaSignature(test.school, test.id, test.expires, test.signature, test.at); ok != test.want {
+				t.Errorf("verifyMediaSignature = %v, want %v", ok, test.want)
+			}
+		})
+	}
+
+	access := signedMediaURL("SCH A", "MED-1", now)
+	if access.URL != "/media-files/SCH%20A/MED-1?expires="+param+"&signature="+mediaSignature("SCH A", "MED-1", expires) {
+		t.Errorf("URL = %s", access.URL)
+	}
+	if access.ExpiresAt != time.Unix(expires, 0).Format(time.RFC3339) {
+		t.Errorf("ExpiresAt = %s", access.ExpiresAt)
+	}
+}
+
+func TestMediaFileHandlerChecksSignature(t *testing.T) {
+	mediaItems = &mediaStore{items: map[string]map[string]*MediaItemModel{}, restricted: map[string]bool{}, public: map[string]bool{}}
+	item := mediaItems.add(defaultSchoolID, MediaItemModel{Title: "Report", Source: mediaSourceSchool, Key: "schools/" + defaultSchoolID + "/report.png", Visibility: mediaVisibilityStaff})
+	access := signedMediaURL(defaultSchoolID, item.Id, time.Now())
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"unsigned", "/media-files/" + defaultSchoolID + "/" + item.Id, http.StatusForbidden},
+		{"tampered", access.URL + "x", http.StatusForbidden},
+		{"unknown item", signedMediaURL(defaultSchoolID, "MED-NONE", time.Now()).URL, http.StatusNotFound},
+	}
+	e := echo.New()
+	e.GET("/media-files/:schoolId/:id", MediaFileHandler)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d", rec.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestCanAccessMedia(t *testing.T) {
+	studentAccounts = &studentAccountStore{links: map[string]map[string][]StudentAccountLinkModel{}}
+	studentAccounts.Lock()
+	studentAccounts.school(defaultSchoolID)["parent1"] = []StudentAccountLinkModel{{Username: "parent1", StudentId: "STU-1", Relation: accountRelationParent}}
+	studentAccounts.Unlock()
+
+	claims := func(id, role string) jwt.MapClaims {
+		return jwt.MapClaims{"id": id, "user_role": role, "school_id": defaultSchoolID}
+	}
+	family := MediaItemModel{Visibility: mediaVisibilityFamily, OwnerStudentId: "STU-1", Viewers: []string{"grandma"}}
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		item   MediaItemModel
+		want   bool
+	}{
+		{"public", claims("stranger", roleStudent), MediaItemModel{Visibility: mediaVisibilityPublic}, true},
+		{"staff item to a teacher", claims("teacher1", roleTeacher), MediaItemModel{Visibility: mediaVisibilityStaff}, true},
+		{"staff item to a student", claims("student1", roleStudent), MediaItemModel{Visibility: mediaVisibilityStaff}, false},
+		{"family item to a linked parent", claims("parent1", roleStudent), family, true},
+		{"family item to a viewer", claims("grandma", roleStudent), family, true},
+		{"family item to another family", claims("parent2", roleStudent), family, false},
+		{"family item to an admin", claims("admin", roleAdmin), family, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := canAccessMedia(test.claims, test.item); got != test.want {
+				t.Errorf("canAccessMedia = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMediaStoreIndexesFiles(t *testing.T) {
+	mediaItems = &mediaStore{items: map[string]map[string]*MediaItemModel{}, restricted: map[string]bool{}, public: map[string]bool{}}
+	imageSources = &imageSourceStore{configs: map[string]ImageSourceConfigModel{"SCH-M": {Type: imageSourceDrive}}}
+	defer func() { imageSources = &imageSourceStore{configs: map[string]ImageSourceConfigModel{}} }()
+
+	const shared, open = "sharedfile0001", "openfile000001"
+	mediaItems.add("SCH-M", MediaItemModel{Source: mediaSourceDrive, Key: shared, Visibility: mediaVisibilityPublic})
+	mediaItems.add("SCH-M", MediaItemModel{Source: mediaSourceSchool, Key: shared, Visibility: mediaVisibilityStaff})
+	mediaItems.add("SCH-M", MediaItemModel{Source: mediaSourceDrive, Key: open, Visibility: mediaVisibilityPublic})
+	mediaItems.add("SCH-M", MediaItemModel{Source: mediaSourceBlob, Key: "schools/SCH-M/report.pdf", Visibility: mediaVisibilityStaff})
+
+	check := func(stage, key string, restricted, public bool) {
+		t.Helper()
+		if got := mediaItems.isRestricted(key); got != restricted {
+			t.Errorf("%s: isRestricted(%s) = %v, want %v", stage, key, got, restricted)
+		}
+		if got := mediaItems.isPublic(key); got != public {
+			t.Errorf("%s: isPublic(%s) = %v, want %v", stage, key, got, public)
+		}
+	}
+	// The school's source is Drive, so both items of the shared file reach
+	// the same Drive file and the restricted one wins
+	check("drive source", "drive:"+shared, true, false)
+	check("drive source", "drive:"+open, false, true)
+	check("drive source", "local:schools/SCH-M/report.pdf", false, false)
+
+	imageSources.Lock()
+	delete(imageSources.configs, "SCH-M")
+	imageSources.Unlock()
+	mediaItems.reindex()
+	check("local source", "drive:"+shared, false, true)
+	check("local source", "local:"+shared, true, false)
+}
+
+func TestImageProxyServesRegisteredFiles(t *testing.T) {
+	mediaItems = &mediaStore{items: map[string]map[string]*MediaItemModel{}, restricted: map[string]bool{}, public: map[string]bool{}}
+	savedBlobs, savedCache := blobs, imageCache
+	blobs, imageCache = newFileBlobStore(t.TempDir()), newImageCacheStore(t.TempDir(), 1<<20)
+	defer func() { blobs, imageCache = savedBlobs, savedCache }()
+
+	var logo bytes.Buffer
+	png.Encode(&logo, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(logo.Bytes())
+	}))
+	defer bucket.Close()
+	imageSources = &imageSourceStore{configs: map[string]ImageSourceConfigModel{
+		"SCH-S3": {Type: imageSourceS3, S3: &S3SourceConfigModel{Endpoint: bucket.URL, Region: "us-east-1", Bucket: "media", PathStyle: true, AccessKeyId: "key", SecretAccessKey: "secret"}},
+	}}
+	defer func() { imageSources = &imageSourceStore{configs: map[string]ImageSourceConfigModel{}} }()
+
+	for _, key := range []string{"schools/SCH-L/logo.png", "schools/SCH-L/report.png"} {
+		if _, err := blobs.Put(key, "image/png", logo.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mediaItems.add("SCH-L", MediaItemModel{Source: mediaSourceSchool, Key: "schools/SCH-L/report.png", Visibility: mediaVisibilityStaff})
+	mediaItems.add("SCH-S3", MediaItemModel{Source: mediaSourceSchool, Key: "gallery/sports.png", Visibility: mediaVisibilityPublic})
+	mediaItems.add("SCH-S3", MediaItemModel{Source: mediaSourceSchool, Key: "reports/term1.png", Visibility: mediaVisibilityFamily})
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"own local file", "?school=SCH-L&key=schools/SCH-L/logo.png", http.StatusOK},
+		{"restricted local file", "?school=SCH-L&key=schools/SCH-L/report.png", http.StatusForbidden},
+		{"public bucket object", "?school=SCH-S3&key=gallery/sports.png", http.StatusOK},
+		{"restricted bucket object", "?school=SCH-S3&key=reports/term1.png", http.StatusForbidden},
+		{"unregistered bucket object", "?school=SCH-S3&key=private/salaries.png", http.StatusForbidden},
+		{"unregistered Drive file", "?id=unregistered00001", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if err := handleImageProxy(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/image"+test.query, nil), rec)); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, test.status, rec.Body.String())
+			}
+		})
+	}
+}
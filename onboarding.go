@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	onboardingStepBasicInfo = "basic-info"
+	onboardingStepSubjects  = "subjects"
+	onboardingStepBusRoutes = "bus-routes"
+	onboardingStepStudents  = "students"
+
+	onboardingIconComplete   = "assets/icons/check_circled_filled.svg"
+	onboardingIconIncomplete = "assets/icons/close_circled_filled.svg"
+)
+
+type onboardingStep struct {
+	Key         string
+	Title       string
+	Description string
+	Route       string
+	Requires    []string
+}
+
+// onboardingSteps is the wizard in display order. A step can only be opened
+// once every step it requires is complete.
+var onboardingSteps = []onboardingStep{
+	{
+		Key:         onboardingStepBasicInfo,
+		Title:       "Step-1 : Basic Information",
+		Description: "Provide Some Basic Details About School",
+		Route:       "/onboard-user-basic-info",
+	},
+	{
+		Key:         onboardingStepSubjects,
+		Title:       "Step-2 : Subjects Information",
+		Description: "Provide Some Basic Details About Subjects in School",
+		Route:       "/onboard-user-setup-subjects",
+		Requires:    []string{onboardingStepBasicInfo},
+	},
+	{
+		Key:         onboardingStepBusRoutes,
+		Title:       "Step-3 : Bus Routes Information",
+		Description: "Provide Some Basic Details About Bus Routes in School",
+		Route:       "/onboard-user-bus-route",
+		Requires:    []string{onboardingStepBasicInfo},
+	},
+	{
+		Key:         onboardingStepStudents,
+		Title:       "Step-4 : Student Information",
+		Description: "Provide Some Basic Details About Students Data",
+		Route:       "/onboard-user-student-onboarding",
+		Requires:    []string{onboardingStepSubjects},
+	},
+}
+
+type onboardingProgressStore struct {
+	sync.RWMutex
+	completed map[string]map[string]time.Time
+}
+
+var onboardingProgress = &onboardingProgressStore{completed: map[string]map[string]time.Time{}}
+
+// complete records that a school finished a step. Re-submitting a step keeps
+// the original completion time.
+func (s *onboardingProgressStore) complete(schoolId, step string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.completed[schoolId] == nil {
+		s.completed[schoolId] = map[string]time.Time{}
+	}
+	if _, ok := s.completed[schoolId][step]; !ok {
+		s.completed[schoolId][step] = time.Now()
+	}
+}
+
+// blockedBy returns the titles of unfinished steps that the step requires.
+func (s *onboardingProgressStore) blockedBy(schoolId, step string) []string {
+	s.RLock()
+	defer s.RUnlock()
+	var blocked []string
+	for _, candidate := range onboardingSteps {
+		if candidate.Key != step {
+			continue
+		}
+		for _, required := range candidate.Requires {
+			if _, ok := s.completed[schoolId][required]; !ok {
+				blocked = append(blocked, onboardingStepTitle(required)+" is not complete")
+			}
+		}
+	}
+	return blocked
+}
+
+func onboardingStepTitle(key string) string {
+	for _, step := range onboardingSteps {
+		if step.Key == key {
+			return step.Title
+		}
+	}
+	return key
+}
+
+// fillOnBoardModel builds the wizard for a school from its saved progress.
+func fillOnBoardModel(schoolId string) CoreOnBoarding {
+	onboardingProgress.RLock()
+	completed := onboardingProgress.completed[schoolId]
+	steps := make([]map[string]interface{}, 0, len(onboardingSteps))
+	done := 0
+	nextStep := ""
+	for _, step := range onboardingSteps {
+		completedAt, isCompleted := completed[step.Key]
+		clickable := true
+		for _, required := range step.Requires {
+			if _, ok := completed[required]; !ok {
+				clickable = false
+			}
+		}
+		imageSrc := onboardingIconIncomplete
+		if isCompleted {
+			imageSrc = onboardingIconComplete
+			done++
+		} else if clickable && nextStep == "" {
+			nextStep = step.Route
+		}
+		entry := map[string]interface{}{
+			"key":         step.Key,
+			"title":       step.Title,
+			"description": step.Description,
+			"imageSrc":    imageSrc,
+			"routes":      step.Route,
+			"isClickable": clickable,
+			"isCompleted": isCompleted,
+		}
+		if isCompleted {
+			entry["completedAt"] = completedAt.Format(time.RFC3339)
+		}
+		steps = append(steps, entry)
+	}
+	onboardingProgress.RUnlock()
+
+	return CoreOnBoarding{
+		Data: map[string]interface{}{
+			"comments":             steps,
+			"completionPercentage": done * 100 / len(onboardingSteps),
+			"isComplete":           done == len(onboardingSteps),
+			"nextStep":             nextStep,
+		},
+		ExpiryCacheInAllowedTime:        "1",
+		ExpiryCacheInAllowedTimeUnit:    "minutes",
+		ExpiryCacheInNotAllowedTime:     "1",
+		ExpiryCacheInNotAllowedTimeUnit: "minutes",
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// onboardedSchool returns a fresh school that finished the given steps.
+func onboardedSchool(name string, steps ...string) string {
+	schoolId := "SCH-OB-" + name
+	onboardingProgress.Lock()
+	delete(onboardingProgress.completed, schoolId)
+	onboardingProgress.Unlock()
+	for _, step := range steps {
+		onboardingProgress.complete(schoolId, step)
+	}
+	return schoolId
+}
+
+func TestOnboardingBlockedBy(t *testing.T) {
+	tests := []struct {
+		name      string
+		completed []string
+		step      string
+		want      []string
+	}{
+		{"first step", nil, onboardingStepBasicInfo, nil},
+		{"subjects first", nil, onboardingStepSubjects, []string{"Step-1 : Basic Information is not complete"}},
+		{"bus routes after basic info", []string{onboardingStepBasicInfo}, onboardingStepBusRoutes, nil},
+		{"students before subjects", []string{onboardingStepBasicInfo, onboardingStepBusRoutes}, onboardingStepStudents, []string{"Step-2 : Subjects Information is not complete"}},
+		{"students after subjects", []string{onboardingStepBasicInfo, onboardingStepSubjects}, onboardingStepStudents, nil},
+		{"unknown step", nil, "fees", nil},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schoolId := onboardedSchool(fmt.Sprint(i), test.completed...)
+			if got := onboardingProgress.blockedBy(schoolId, test.step); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("blockedBy(%s) = %q, want %q", test.step, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFillOnBoardModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		completed  []string
+		percentage int
+		clickable  []bool
+		nextStep   string
+	}{
+		{"new school", nil, 0, []bool{true, false, false, false}, "/onboard-user-basic-info"},
+		{"basic info", []string{onboardingStepBasicInfo}, 25, []bool{true, true, true, false}, "/onboard-user-setup-subjects"},
+		{"bus routes before subjects", []string{onboardingStepBasicInfo, onboardingStepBusRoutes}, 50, []bool{true, true, true, false}, "/onboard-user-setup-subjects"},
+		{"subjects", []string{onboardingStepBasicInfo, onboardingStepSubjects}, 50, []bool{true, true, true, true}, "/onboard-user-bus-route"},
+		{"everything", []string{onboardingStepBasicInfo, onboardingStepSubjects, onboardingStepBusRoutes, onboardingStepStudents}, 100, []bool{true, true, true, true}, ""},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := fillOnBoardModel(onboardedSchool(fmt.Sprint(i), test.completed...)).Data
+			if data["completionPercentage"] != test.percentage || data["nextStep"] != test.nextStep || data["isComplete"] != (test.percentage == 100) {
+				t.Errorf("data = %v, want %d%% and next step %q", data, test.percentage, test.nextStep)
+			}
+			steps := data["comments"].([]map[string]interface{})
+			for j, step := range steps {
+				if completed := containsValue(test.completed, step["key"].(string)); step["isCompleted"] != completed {
+					t.Errorf("%s completed = %v, want %v", step["key"], step["isCompleted"], completed)
+				}
+				if step["isClickable"] != test.clickable[j] {
+					t.Errorf("%s clickable = %v, want %v", step["key"], step["isClickable"], test.clickable[j])
+				}
+				if _, ok := step["completedAt"]; ok != (step["isCompleted"] == true) {
+					t.Errorf("%s completedAt = %v with isCompleted %v", step["key"], step["completedAt"], step["isCompleted"])
+				}
+			}
+		})
+	}
+}
+
+func TestOnboardingCompleteKeepsFirstTime(t *testing.T) {
+	schoolId := onboardedSchool("repeat", onboardingStepBasicInfo)
+	onboardingProgress.RLock()
+	first := onboardingProgress.completed[schoolId][onboardingStepBasicInfo]
+	onboardingProgress.RUnlock()
+	time.Sleep(time.Millisecond)
+	onboardingProgress.complete(schoolId, onboardingStepBasicInfo)
+	onboardingProgress.RLock()
+	defer onboardingProgress.RUnlock()
+	if got := onboardingProgress.completed[schoolId][onboardingStepBasicInfo]; !got.Equal(first) {
+		t.Errorf("completed at %v after resubmitting, want %v", got, first)
+	}
+}
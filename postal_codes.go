@@ -0,0 +1,200 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// postalCodeData maps the first three digits of Indian PIN codes, the
+// sorting district, to the states they are delivered in and, for the larger
+// districts, the city of the head office.
+//
+//go:embed geodata/pincodes.json
+var postalCodeData []byte
+
+const (
+	postalPrefixLength   = 3
+	minCitySearchLength  = 2
+	defaultCitySearchMax = 10
+	maxCitySearchMax     = 50
+)
+
+type PostalStateModel struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// PostalCodeModel is what a postal code resolves to. City is only known for
+// the larger districts; States lists more than one state when the district
+// crosses a border.
+type PostalCodeModel struct {
+	PostalCode  string             `json:"postalCode"`
+	CountryCode string             `json:"countryCode"`
+	Country     string             `json:"country"`
+	City        string             `json:"city,omitempty"`
+	States      []PostalStateModel `json:"states"`
+}
+
+type CityMatchModel struct {
+	City        string `json:"city"`
+	StateCode   string `json:"stateCode"`
+	State       string `json:"state"`
+	CountryCode string `json:"countryCode"`
+	Country     string `json:"country"`
+}
+
+type postalDistrict struct {
+	Prefix       string   `json:"prefix"`
+	Subdivisions []string `json:"subdivisions"`
+	City         string   `json:"city"`
+}
+
+type cityEntry struct {
+	key   string
+	match CityMatchModel
+}
+
+// postalIndex holds the PIN districts and a city index sorted by lower case
+// name for prefix search.
+type postalIndex struct {
+	country   string
+	districts map[string]postalDistrict
+	cities    []cityEntry
+}
+
+var postalCodes = loadPostalCodes()
+
+func loadPostalCodes() *postalIndex {
+	var data struct {
+		Country   string           `json:"country"`
+		Districts []postalDistrict `json:"districts"`
+	}
+	if err := json.Unmarshal(postalCodeData, &data); err != nil {
+		panic("geodata/pincodes.json: " + err.Error())
+	}
+	index := &postalIndex{country: data.Country, districts: map[string]postalDistrict{}}
+	for _, district := range data.Districts {
+		index.districts[district.Prefix] = district
+	}
+	for _, country := range geography.countries {
+		for _, subdivision := range country.Subdivisions {
+			for _, city := range subdivision.Cities {
+				index.cities = append(index.cities, cityEntry{
+					key: strings.ToLower(city),
+					match: CityMatchModel{
+						City:        city,
+						StateCode:   subdivision.Code,
+						State:       subdivision.Name,
+						CountryCode: country.Code,
+						Country:     country.Name,
+					},
+				})
+			}
+		}
+	}
+	sort.SliceStable(index.cities, func(i, j int) bool { return index.cities[i].key < index.cities[j].key })
+	return index
+}
+
+// resolve looks up a postal code of the country. ok is false when the code
+// is well formed but not in the dataset.
+func (p *postalIndex) resolve(country *CountryModel, code string) (PostalCodeModel, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	result := PostalCodeModel{PostalCode: code, CountryCode: country.Code, Country: country.Name, States: []PostalStateModel{}}
+	if country.Code != p.country || len(code) < postalPrefixLength {
+		return result, false
+	}
+	district, ok := p.districts[code[:postalPrefixLength]]
+	if !ok {
+		return result, false
+	}
+	result.City = district.City
+	for _, subdivisionCode := range district.Subdivisions {
+		if subdivision, ok := country.subdivision(subdivisionCode); ok {
+			result.States = append(result.States, PostalStateModel{Code: subdivision.Code, Name: subdivision.Name})
+		}
+	}
+	return result, true
+}
+
+// searchCities returns up to limit cities whose name starts with prefix,
+// optionally within one country or subdivision.
+func (p *postalIndex) searchCities(prefix, countryCode, stateCode string, limit int) []CityMatchModel {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	matches := []CityMatchModel{}
+	start := sort.Search(len(p.cities), func(i int) bool { return p.cities[i].key >= prefix })
+	for _, entry := range p.cities[start:] {
+		if !strings.HasPrefix(entry.key, prefix) || len(matches) == limit {
+			break
+		}
+		if (countryCode == "" || entry.match.CountryCode == countryCode) && (stateCode == "" || entry.match.StateCode == stateCode) {
+			matches = append(matches, entry.match)
+		}
+	}
+	return matches
+}
+
+// PostalCodeHandler resolves a postal code to its city and state. The
+// country defaults to India.
+func PostalCodeHandler(c echo.Context) error {
+	countryKey := c.QueryParam("country")
+	if countryKey == "" {
+		countryKey = postalCodes.country
+	}
+	country, ok := geography.country(countryKey)
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Country "+countryKey+" not found"))
+	}
+	code := c.Param("code")
+	if !country.validPostalCode(code) {
+		return c.JSON(http.StatusBadRequest, failed(code+" is not a valid postal code for "+country.Name))
+	}
+	result, ok := postalCodes.resolve(country, code)
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Postal code "+result.PostalCode+" not found"))
+	}
+	return c.JSON(http.StatusOK, success(result))
+}
+
+// CitySearchHandler autocompletes city names by prefix. country and state
+// narrow the search; state codes and names are only unique within a
+// country, so state needs country. limit defaults to 10.
+func CitySearchHandler(c echo.Context) error {
+	query := strings.TrimSpace(c.QueryParam("q"))
+	if len(query) < minCitySearchLength {
+		return c.JSON(http.StatusBadRequest, failed("q must be at least "+strconv.Itoa(minCitySearchLength)+" characters"))
+	}
+	limit := defaultCitySearchMax
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxCitySearchMax {
+			return c.JSON(http.StatusBadRequest, failed("limit must be between 1 and "+strconv.Itoa(maxCitySearchMax)))
+		}
+		limit = parsed
+	}
+	if c.QueryParam("state") != "" && c.QueryParam("country") == "" {
+		return c.JSON(http.StatusBadRequest, failed("country is required with state"))
+	}
+	var countryCode, stateCode string
+	if value := c.QueryParam("country"); value != "" {
+		country, ok := geography.country(value)
+		if !ok {
+			return c.JSON(http.StatusNotFound, failed("Country "+value+" not found"))
+		}
+		countryCode = country.Code
+		if value := c.QueryParam("state"); value != "" {
+			subdivision, ok := country.subdivision(value)
+			if !ok {
+				return c.JSON(http.StatusNotFound, failed("State "+value+" not found in "+country.Name))
+			}
+			stateCode = subdivision.Code
+		}
+	}
+	return c.JSON(http.StatusOK, success(postalCodes.searchCities(query, countryCode, stateCode, limit)))
+}
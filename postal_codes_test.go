@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestResolvePostalCode(t *testing.T) {
+	india, _ := geography.country("IN")
+	usa, _ := geography.country("US")
+	tests := []struct {
+		name    string
+		country *CountryModel
+		code    string
+		wantOK  bool
+		city    string
+		states  []PostalStateModel
+	}{
+		{"city district", india, " 560001 ", true, "Bengaluru", []PostalStateModel{{"IN-KA", "Karnataka"}}},
+		{"rural district", india, "120001", true, "", []PostalStateModel{{"IN-HR", "Haryana"}}},
+		{"crosses a border", india, "160017", true, "Chandigarh", []PostalStateModel{{"IN-CH", "Chandigarh"}, {"IN-PB", "Punjab"}}},
+		{"unknown district", india, "990001", false, "", []PostalStateModel{}},
+		{"too short", india, "56", false, "", []PostalStateModel{}},
+		{"other country", usa, "56000", false, "", []PostalStateModel{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, ok := postalCodes.resolve(test.country, test.code)
+			if ok != test.wantOK || result.City != test.city || !reflect.DeepEqual(result.States, test.states) || result.CountryCode != test.country.Code {
+				t.Errorf("resolve(%q) = %+v, %v", test.code, result, ok)
+			}
+		})
+	}
+}
+
+func TestSearchCities(t *testing.T) {
+	names := func(matches []CityMatchModel) []string {
+		list := []string{}
+		for _, match := range matches {
+			list = append(list, match.City+" "+match.StateCode)
+		}
+		return list
+	}
+	tests := []struct {
+		name                   string
+		prefix, country, state string
+		limit                  int
+		want                   []string
+	}{
+		{"case and spaces", " BEL", "", "", 10, []string{"Belagavi IN-KA", "Bellampalle IN-TG"}},
+		{"same name in two states", "aurangabad", "", "", 10, []string{"Aurangabad IN-BR", "Aurangabad IN-MH"}},
+		{"within a state", "aurangabad", "IN", "IN-MH", 10, []string{"Aurangabad IN-MH"}},
+		{"within another country", "aurangabad", "US", "", 10, []string{}},
+		{"limited", "aurangabad", "", "", 1, []string{"Aurangabad IN-BR"}},
+		{"no match", "zzz", "", "", 10, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := names(postalCodes.searchCities(test.prefix, test.country, test.state, test.limit)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("searchCities(%q) = %q, want %q", test.prefix, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCitySearchHandler(t *testing.T) {
+	e := echo.New()
+	e.GET("/cities/search", CitySearchHandler)
+	tests := []struct {
+		query  string
+		status int
+		want   int
+	}{
+		{"?q=aurangabad", http.StatusOK, 2},
+		{"?q=aurangabad&country=india&state=Maharashtra", http.StatusOK, 1},
+		{"?q=a&limit=3", http.StatusBadRequest, 0},
+		{"?q=au&limit=51", http.StatusBadRequest, 0},
+		{"?q=ba&limit=3", http.StatusOK, 3},
+		{"?q=aurangabad&state=MH", http.StatusBadRequest, 0},
+		{"?q=aurangabad&country=XX", http.StatusNotFound, 0},
+		{"?q=aurangabad&country=IN&state=Atlantis", http.StatusNotFound, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cities/search"+test.query, nil))
+			var matches []CityMatchModel
+			if test.status != http.StatusOK {
+				decodeData(t, rec, test.status, nil)
+				return
+			}
+			decodeData(t, rec, test.status, &matches)
+			if len(matches) != test.want {
+				t.Errorf("matches = %+v, want %d", matches, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	profileSectionBasicDetails = "basicDetails"
+	profileSectionInformation  = "information"
+)
+
+// profileField is a profile field families may ask to change, with the
+// section of the profile page it is shown in and the student record field
+// behind it.
+type profileField struct {
+	section  string
+	guardian string
+	current  func(StudentModel) string
+}
+
+var profileFields = map[string]profileField{
+	"name":       {section: profileSectionBasicDetails, current: func(s StudentModel) string { return s.Name }},
+	"fatherName": {section: profileSectionInformation, guardian: "FATHER", current: func(s StudentModel) string { return s.FatherName }},
+	"motherName": {section: profileSectionInformation, guardian: "MOTHER", current: func(s StudentModel) string { return s.MotherName }},
+	"address":    {section: profileSectionInformation, current: func(s StudentModel) string { return s.Address }},
+}
+
+var profileFieldNames = []string{"name", "fatherName", "motherName", "address"}
+
+// ProfileFieldChangeDto is the new value of one field. The address is given
+// as addressDetails.
+type ProfileFieldChangeDto struct {
+	Field          string              `json:"field"`
+	Value          string              `json:"value"`
+	AddressDetails *SchoolAddressModel `json:"addressDetails,omitempty"`
+}
+
+type ProfileEditRequestDto struct {
+	StudentId string                  `json:"studentId"`
+	Changes   []ProfileFieldChangeDto `json:"changes"`
+	Note      string                  `json:"note"`
+}
+
+// ProfileEditRequestModel is a requested change to one profile field.
+// OldValue is the value when the request was made; approval fails if the
+// field has changed since.
+type ProfileEditRequestModel struct {
+	Id             string              `json:"id"`
+	StudentId      string              `json:"studentId"`
+	Section        string              `json:"section"`
+	Field          string              `json:"field"`
+	OldValue       string              `json:"oldValue"`
+	NewValue       string              `json:"newValue"`
+	AddressDetails *SchoolAddressModel `json:"addressDetails,omitempty"`
+	Note           string              `json:"note,omitempty"`
+	Status         string              `json:"status"`
+	Reason         string              `json:"reason,omitempty"`
+	RequestedBy    string              `json:"requestedBy"`
+	RequestedAt    string              `json:"requestedAt"`
+	ReviewedBy     string              `json:"reviewedBy,omitempty"`
+	ReviewedAt     string              `json:"reviewedAt,omitempty"`
+}
+
+// ProfileChangeHistoryModel is an applied change. History entries are never
+// modified or removed.
+type ProfileChangeHistoryModel struct {
+	RequestId   string `json:"requestId"`
+	StudentId   string `json:"studentId"`
+	Field       string `json:"field"`
+	OldValue    string `json:"oldValue"`
+	NewValue    string `json:"newValue"`
+	RequestedBy string `json:"requestedBy"`
+	RequestedAt string `json:"requestedAt"`
+	ApprovedBy  string `json:"approvedBy"`
+	AppliedAt   string `json:"appliedAt"`
+}
+
+type profileEditStore struct {
+	sync.RWMutex
+	requests map[string]map[string]*ProfileEditRequestModel
+	history  map[string]map[string][]ProfileChangeHistoryModel
+}
+
+var profileEdits = &profileEditStore{requests: map[string]map[string]*ProfileEditRequestModel{}, history: map[string]map[string][]ProfileChangeHistoryModel{}}
+
+// school returns the edit requests of a school by id. Callers must hold the
+// lock.
+func (s *profileEditStore) school(schoolId string) map[string]*ProfileEditRequestModel {
+	requests, ok := s.requests[schoolId]
+	if !ok {
+		requests = map[string]*ProfileEditRequestModel{}
+		s.requests[schoolId] = requests
+	}
+	return requests
+}
+
+// apply writes approved changes to one student's record and appends them
+// to the student's history. Every change is checked against the current
+// record first, so either all of them apply or none does. Callers must hold
+// the lock.
+func (s *profileEditStore) apply(schoolId, studentId string, requests []*ProfileEditRequestModel, approvedBy string, now time.Time) string {
+	// Guardians are read before the students lock is taken
+	for _, request := range requests {
+		if problem := guardianManagedField(schoolId, studentId, request.Field); problem != "" {
+			return problem
+		}
+	}
+
+	students.Lock()
+	defer students.Unlock()
+	student, ok := students.school(schoolId).students[studentId]
+	if !ok {
+		return "Student " + studentId + " not found"
+	}
+	updated := *student
+	for _, request := range requests {
+		if profileFields[request.Field].current(*student) != request.OldValue {
+			return request.Field + " has changed since the request was made"
+		}
+		switch request.Field {
+		case "name":
+			updated.Name = request.NewValue
+		case "fatherName":
+			updated.FatherName = request.NewValue
+		case "motherName":
+			updated.MotherName = request.NewValue
+		case "address":
+			updated.Address = request.NewValue
+			details := *request.AddressDetails
+			updated.AddressDetails = &details
+		}
+	}
+	if updated.FatherName == "" && updated.MotherName == "" {
+		return "Father Name or Mother Name is required"
+	}
+	*student = updated
+
+	history, ok := s.history[schoolId]
+	if !ok {
+		history = map[string][]ProfileChangeHistoryModel{}
+		s.history[schoolId] = history
+	}
+	for _, request := range requests {
+		history[studentId] = append(history[studentId], ProfileChangeHistoryModel{
+			RequestId:   request.Id,
+			StudentId:   studentId,
+			Field:       request.Field,
+			OldValue:    request.OldValue,
+			NewValue:    request.NewValue,
+			RequestedBy: request.RequestedBy,
+			RequestedAt: request.RequestedAt,
+			ApprovedBy:  approvedBy,
+			AppliedAt:   now.Format(time.RFC3339),
+		})
+	}
+	return ""
+}
+
+// guardianManagedField explains why a field cannot be edited when it comes
+// from a guardian linked to the student, or returns "".
+func guardianManagedField(schoolId, studentId, fieldName string) string {
+	field := profileFields[fieldName]
+	if field.guardian == "" {
+		return ""
+	}
+	guardians.RLock()
+	defer guardians.RUnlock()
+	school, ok := guardians.schools[schoolId]
+	if !ok {
+		return ""
+	}
+	for _, guardian := range school.forStudent(studentId) {
+		if guardian.Relationship == field.guardian {
+			return fieldName + " comes from guardian " + guardian.GuardianId + "; change it through the student's guardians"
+		}
+	}
+	return ""
+}
+
+// normalizeProfileChange validates the new value of a field against the
+// student and builds the request for it.
+func normalizeProfileChange(schoolId string, student StudentModel, change ProfileFieldChangeDto) (ProfileEditRequestModel, []string) {
+	field, ok := profileFields[change.Field]
+	if !ok {
+		return ProfileEditRequestModel{}, []string{change.Field + " cannot be changed; editable fields are " + strings.Join(profileFieldNames, ", ")}
+	}
+	request := ProfileEditRequestModel{
+		StudentId: student.Id,
+		Section:   field.section,
+		Field:     change.Field,
+		OldValue:  field.current(student),
+	}
+	if problem := guardianManagedField(schoolId, student.Id, change.Field); problem != "" {
+		return request, []string{problem}
+	}
+
+	var errs []string
+	switch change.Field {
+	case "address":
+		if change.AddressDetails == nil {
+			return request, []string{"addressDetails is required to change the address"}
+		}
+		details := *change.AddressDetails
+		if errs = validateAddress("addressDetails", &details); len(errs) > 0 {
+			return request, errs
+		}
+		request.AddressDetails = &details
+		request.NewValue = formatAddress(details)
+	default:
+		request.NewValue = strings.Join(strings.Fields(change.Value), " ")
+		if len(request.NewValue) > 100 {
+			errs = append(errs, change.Field+" must be at most 100 characters")
+		}
+	}
+	switch {
+	case change.Field == "name" && request.NewValue == "":
+		errs = append(errs, "name is required")
+	case change.Field == "fatherName" && request.NewValue == "" && student.MotherName == "",
+		change.Field == "motherName" && request.NewValue == "" && student.FatherName == "":
+		errs = append(errs, "Father Name or Mother Name is required")
+	case request.NewValue == request.OldValue:
+		errs = append(errs, change.Field+" is unchanged")
+	}
+	return request, errs
+}
+
+// applyStudentAddress shows the student's address on the Information tab.
+func applyStudentAddress(page *CoreProfilePageModel, student StudentModel) {
+	if student.Address == "" {
+		return
+	}
+	for i, item := range page.OptionMenuModel.MenuItems {
+		if information, ok := item.DTO.(InformationDetailsModel); ok {
+			information.AddressText = "ADDRESS"
+			information.AddressValue = student.Address
+			page.OptionMenuModel.MenuItems[i].DTO = information
+		}
+	}
+}
+
+// ProfileEditRequestHandler records changes to a student's profile, one
+// request per field. Changes by the student or a parent wait for an admin;
+// an admin's apply at once.
+func ProfileEditRequestHandler(c echo.Context) error {
+	var req ProfileEditRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role == roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	studentId, status, failure := scopedStudent(claims, req.StudentId)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if studentId == "" {
+		return c.JSON(http.StatusBadRequest, failed("studentId is required"))
+	}
+	student, ok := students.get(schoolID(claims), studentId)
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Student "+studentId+" not found"))
+	}
+	if role != roleAdmin && !studentAccounts.canActFor(schoolID(claims), claimString(claims, "id"), studentId) {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	if len(req.Changes) == 0 {
+		return c.JSON(http.StatusBadRequest, failed("changes are required"))
+	}
+
+	schoolId := schoolID(claims)
+	var errs []string
+	seen := map[string]bool{}
+	requests := make([]ProfileEditRequestModel, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		if seen[change.Field] {
+			errs = append(errs, change.Field+" is changed more than once")
+			continue
+		}
+		seen[change.Field] = true
+		request, changeErrs := normalizeProfileChange(schoolId, student, change)
+		errs = append(errs, changeErrs...)
+		requests = append(requests, request)
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid profile change", errs...))
+	}
+
+	now := time.Now()
+	profileEdits.Lock()
+	defer profileEdits.Unlock()
+	school := profileEdits.school(schoolId)
+	for _, existing := range school {
+		if existing.Status == changePending && existing.StudentId == studentId && seen[existing.Field] {
+			return c.JSON(http.StatusConflict, failed("A change to "+existing.Field+" is already waiting for approval ("+existing.Id+")"))
+		}
+	}
+	pending := make([]*ProfileEditRequestModel, len(requests))
+	for i := range requests {
+		request := &requests[i]
+		request.Id = newID("PER")
+		request.Note = strings.TrimSpace(req.Note)
+		request.Status = changePending
+		request.RequestedBy = claimString(claims, "id")
+		request.RequestedAt = now.Format(time.RFC3339)
+		pending[i] = request
+	}
+	if role == roleAdmin {
+		if problem := profileEdits.apply(schoolId, studentId, pending, claimString(claims, "id"), now); problem != "" {
+			return c.JSON(http.StatusConflict, failed(problem))
+		}
+		for _, request := range pending {
+			request.Status = changeApproved
+			request.ReviewedBy = request.RequestedBy
+			request.ReviewedAt = request.RequestedAt
+		}
+	}
+	for _, request := range pending {
+		stored := *request
+		school[request.Id] = &stored
+	}
+	return c.JSON(http.StatusOK, success(requests))
+}
+
+// ProfileEditRequestsHandler lists profile edit requests, newest first.
+// Admins see the whole school, filtered by studentId and status; families
+// see their active student's.
+func ProfileEditRequestsHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") == roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin && studentId == "" {
+		return c.JSON(http.StatusOK, success([]ProfileEditRequestModel{}))
+	}
+	statusFilter := strings.ToUpper(c.QueryParam("status"))
+
+	profileEdits.RLock()
+	defer profileEdits.RUnlock()
+	result := []ProfileEditRequestModel{}
+	for _, request := range profileEdits.requests[schoolID(claims)] {
+		if (studentId == "" || request.StudentId == studentId) && (statusFilter == "" || request.Status == statusFilter) {
+			result = append(result, *request)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id > result[j].Id })
+	return c.JSON(http.StatusOK, success(result))
+}
+
+// ProfileEditDecisionHandler approves or rejects a pending profile edit.
+// Approval re-checks the guardian and parent name rules and applies it;
+// rejection needs a reason.
+func ProfileEditDecisionHandler(c echo.Context) error {
+	var req ChangeDecisionDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !req.Approve && req.Reason == "" {
+		return c.JSON(http.StatusBadRequest, failed("reason is required to reject a change"))
+	}
+
+	now := time.Now()
+	schoolId := schoolID(claims)
+	profileEdits.Lock()
+	defer profileEdits.Unlock()
+	request, ok := profileEdits.school(schoolId)[req.RequestId]
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Change request not found"))
+	}
+	if request.Status != changePending {
+		return c.JSON(http.StatusConflict, failed("Change request already "+request.Status))
+	}
+	if req.Approve {
+		if problem := profileEdits.apply(schoolId, request.StudentId, []*ProfileEditRequestModel{request}, claimString(claims, "id"), now); problem != "" {
+			return c.JSON(http.StatusConflict, failed(problem))
+		}
+		request.Status = changeApproved
+	} else {
+		request.Status = changeRejected
+	}
+	request.Reason = req.Reason
+	request.ReviewedBy = claimString(claims, "id")
+	request.ReviewedAt = now.Format(time.RFC3339)
+	return c.JSON(http.StatusOK, success(*request))
+}
+
+// ProfileHistoryHandler lists the changes applied to a student's profile,
+// oldest first.
+func ProfileHistoryHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	studentId, status, failure := requestStudent(c, claims)
+	if status != http.StatusOK {
+		return c.JSON(status, failure)
+	}
+	if studentId == "" {
+		return c.JSON(http.StatusBadRequest, failed("studentId is required"))
+	}
+	profileEdits.RLock()
+	defer profileEdits.RUnlock()
+	history := append([]ProfileChangeHistoryModel{}, profileEdits.history[schoolID(claims)][studentId]...)
+	return c.JSON(http.StatusOK, success(history))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeProfileChange(t *testing.T) {
+	guardians = &guardianStore{schools: map[string]*schoolGuardians{}}
+	student := StudentModel{Id: "STU-1", Name: "Ravi Kumar", FatherName: "Suresh Kumar"}
+	tests := []struct {
+		name    string
+		change  ProfileFieldChangeDto
+		want    string
+		wantErr string
+	}{
+		{"tidied", ProfileFieldChangeDto{Field: "name", Value: " Ravi   K "}, "Ravi K", ""},
+		{"mother added", ProfileFieldChangeDto{Field: "motherName", Value: "Lakshmi"}, "Lakshmi", ""},
+		{"unknown field", ProfileFieldChangeDto{Field: "dateOfBirth", Value: "2010-01-01"}, "", "dateOfBirth cannot be changed"},
+		{"no name", ProfileFieldChangeDto{Field: "name", Value: "  "}, "", "name is required"},
+		{"unchanged", ProfileFieldChangeDto{Field: "name", Value: "Ravi  Kumar"}, "", "name is unchanged"},
+		{"only parent removed", ProfileFieldChangeDto{Field: "fatherName"}, "", "Father Name or Mother Name is required"},
+		{"too long", ProfileFieldChangeDto{Field: "name", Value: strings.Repeat("a", 101)}, "", "at most 100 characters"},
+		{"address without details", ProfileFieldChangeDto{Field: "address", Value: "Bangalore"}, "", "addressDetails is required"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, errs := normalizeProfileChange(defaultSchoolID, student, test.change)
+			if test.wantErr != "" {
+				if !strings.Contains(strings.Join(errs, "; "), test.wantErr) {
+					t.Errorf("errors = %v, want %q", errs, test.wantErr)
+				}
+				return
+			}
+			if len(errs) > 0 || request.NewValue != test.want || request.OldValue != profileFields[test.change.Field].current(student) {
+				t.Errorf("request = %+v, %v; want new value %q", request, errs, test.want)
+			}
+		})
+	}
+}
+
+func TestProfileEditApproval(t *testing.T) {
+	ravi := guardianFamily(t)[0]
+	profileEdits = &profileEditStore{requests: map[string]map[string]*ProfileEditRequestModel{}, history: map[string]map[string][]ProfileChangeHistoryModel{}}
+	students.Lock()
+	student := students.school(defaultSchoolID).students[ravi]
+	student.FatherName, student.MotherName = "Suresh Kumar", "Lakshmi Kumar"
+	students.Unlock()
+
+	admin := testToken(t, "admin", roleAdmin, "")
+	parent := testToken(t, "parent1", roleStudent, ravi)
+	request := func(token string, status int, changes ...ProfileFieldChangeDto) []ProfileEditRequestModel {
+		t.Helper()
+		var requests []ProfileEditRequestModel
+		decodeData(t, callHandler(t, ProfileEditRequestHandler, http.MethodPost, "/profile/edit-requests", token, ProfileEditRequestDto{StudentId: ravi, Changes: changes}), status, &requests)
+		return requests
+	}
+	decide := func(req ChangeDecisionDto, status int) ProfileEditRequestModel {
+		t.Helper()
+		var request ProfileEditRequestModel
+		decodeData(t, callHandler(t, ProfileEditDecisionHandler, http.MethodPost, "/profile/edit-requests/decision", admin, req), status, &request)
+		return request
+	}
+	current := func() StudentModel {
+		student, _ := students.get(defaultSchoolID, ravi)
+		return student
+	}
+
+	// A family's changes wait, one pending change per field
+	renamed := request(parent, http.StatusOK, ProfileFieldChangeDto{Field: "name", Value: "Ravi K"})[0]
+	if renamed.Status != changePending || current().Name != "Ravi Kumar" {
+		t.Fatalf("family change = %+v, name %q", renamed, current().Name)
+	}
+	request(parent, http.StatusConflict, ProfileFieldChangeDto{Field: "name", Value: "R Kumar"})
+	request(parent, http.StatusBadRequest, ProfileFieldChangeDto{Field: "address", Value: "x"}, ProfileFieldChangeDto{Field: "address", Value: "y"})
+	request(testToken(t, "teacher1", roleTeacher, ""), http.StatusForbidden, ProfileFieldChangeDto{Field: "name", Value: "Ravi"})
+
+	decide(ChangeDecisionDto{RequestId: renamed.Id}, http.StatusBadRequest)
+	if approved := decide(ChangeDecisionDto{RequestId: renamed.Id, Approve: true}, http.StatusOK); approved.Status != changeApproved || current().Name != "Ravi K" {
+		t.Errorf("approved = %+v, name %q", approved, current().Name)
+	}
+	decide(ChangeDecisionDto{RequestId: renamed.Id, Reason: "Too late"}, http.StatusConflict)
+
+	// An admin's changes apply together or not at all, so each parent name
+	// may go alone but not both
+	before := current()
+	request(admin, http.StatusConflict, ProfileFieldChangeDto{Field: "name", Value: "Ravi Kumar"},
+		ProfileFieldChangeDto{Field: "fatherName"}, ProfileFieldChangeDto{Field: "motherName"})
+	if after := current(); after != before {
+		t.Errorf("a failed batch changed the student from %+v to %+v", before, after)
+	}
+
+	// Each parent name may go while the other stays, but approval re-checks
+	// that one is left
+	noFather := request(parent, http.StatusOK, ProfileFieldChangeDto{Field: "fatherName"})[0]
+	noMother := request(parent, http.StatusOK, ProfileFieldChangeDto{Field: "motherName"})[0]
+	decide(ChangeDecisionDto{RequestId: noFather.Id, Approve: true}, http.StatusOK)
+	decide(ChangeDecisionDto{RequestId: noMother.Id, Approve: true}, http.StatusConflict)
+	if rejected := decide(ChangeDecisionDto{RequestId: noMother.Id, Reason: "A parent name is required"}, http.StatusOK); rejected.Status != changeRejected {
+		t.Errorf("rejected = %+v", rejected)
+	}
+
+	// A father linked as a guardian after the request takes over the field
+	father := request(parent, http.StatusOK, ProfileFieldChangeDto{Field: "fatherName", Value: "Suresh K"})[0]
+	var link GuardianChangeRequestModel
+	decodeData(t, callHandler(t, GuardianChangeHandler, http.MethodPost, "/profile/guardians", admin, GuardianChangeRequestDto{
+		StudentId: ravi, Action: guardianChangeAdd, Guardian: StudentGuardianModel{Name: "Suresh Kumar", Relationship: "FATHER", Phone: "9845012345"},
+	}), http.StatusOK, &link)
+	decide(ChangeDecisionDto{RequestId: father.Id, Approve: true}, http.StatusConflict)
+	request(admin, http.StatusBadRequest, ProfileFieldChangeDto{Field: "fatherName", Value: "Suresh K"})
+
+	// An admin's changes apply at once
+	applied := request(admin, http.StatusOK, ProfileFieldChangeDto{Field: "name", Value: "Ravi Kumar"}, ProfileFieldChangeDto{Field: "motherName", Value: "Lakshmi K"})
+	if applied[0].Status != changeApproved || current().MotherName != "Lakshmi K" {
+		t.Errorf("admin changes = %+v, student %+v", applied, current())
+	}
+
+	var history []ProfileChangeHistoryModel
+	decodeData(t, callHandler(t, ProfileHistoryHandler, http.MethodGet, "/profile/history?studentId="+ravi, admin, nil), http.StatusOK, &history)
+	var fields []string
+	for _, entry := range history {
+		fields = append(fields, entry.Field+"="+entry.NewValue)
+	}
+	if got := strings.Join(fields, ", "); got != "name=Ravi K, fatherName=, name=Ravi Kumar, motherName=Lakshmi K" {
+		t.Errorf("history = %s", got)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"math/bits"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	// powDifficulty is the number of leading zero bits the solution hash
+	// needs; 16 bits takes a browser well under a second.
+	powDifficulty     = 16
+	powChallengeTTL   = 10 * time.Minute
+	minFormFillTime   = 3 * time.Second
+	publicEnquiryUser = "public-form"
+	enquiryEventMerge = "MERGE"
+)
+
+type PublicEnquiryChallengeModel struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+	ExpiresAt  string `json:"expiresAt"`
+}
+
+// PublicEnquiryDto is submitted by the school website. Website is a
+// honeypot field hidden from people; bots that fill it are discarded.
+type PublicEnquiryDto struct {
+	StudentName            string `json:"studentName"`
+	ParentName             string `json:"parentName"`
+	Phone                  string `json:"phone"`
+	Email                  string `json:"email"`
+	ClassName              string `json:"className"`
+	Source                 string `json:"source"`
+	PreferredCommunication string `json:"preferredCommunication"`
+	Message                string `json:"message"`
+	Website                string `json:"website"`
+	Challenge              string `json:"challenge"`
+	Solution               string `json:"solution"`
+}
+
+// clientIPExtractor decides where c.RealIP comes from. By default it is the
+// connecting address, so clients cannot pick their own rate limit key with
+// X-Forwarded-For. TRUSTED_PROXIES lists the CIDR ranges of reverse proxies
+// whose X-Forwarded-For is believed.
+func clientIPExtractor() echo.IPExtractor {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range strings.Split(value, ",") {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			log.Printf("ignoring invalid TRUSTED_PROXIES range %q", cidr)
+			continue
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// publicEnquiryRateLimiter allows each IP five submissions a minute.
+var publicEnquiryRateLimiter = middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+	Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+		Rate:      5.0 / 60,
+		Burst:     5,
+		ExpiresIn: 10 * time.Minute,
+	}),
+	IdentifierExtractor: func(c echo.Context) (string, error) {
+		return c.RealIP(), nil
+	},
+	DenyHandler: func(c echo.Context, identifier string, err error) error {
+		return c.JSON(http.StatusTooManyRequests, failed("Too many enquiries, please try again in a minute"))
+	},
+	ErrorHandler: func(c echo.Context, err error) error {
+		return c.JSON(http.StatusForbidden, failed("Could not identify the client"))
+	},
+})
+
+// usedChallenges remembers solved challenges until they expire so each one
+// is accepted once.
+var usedChallenges = struct {
+	sync.Mutex
+	expiry map[string]time.Time
+}{expiry: map[string]time.Time{}}
+
+func signChallenge(payload string) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte("enquiry-challenge:" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEnquiryChallenge issues a signed proof-of-work challenge for a school.
+func newEnquiryChallenge(schoolId string, now time.Time) (PublicEnquiryChallengeModel, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return PublicEnquiryChallengeModel{}, err
+	}
+	payload := strings.Join([]string{schoolId, hex.EncodeToString(nonce), strconv.FormatInt(now.Unix(), 10), strconv.Itoa(powDifficulty)}, "|")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return PublicEnquiryChallengeModel{
+		Challenge:  encoded + "." + signChallenge(payload),
+		Difficulty: powDifficulty,
+		ExpiresAt:  now.Add(powChallengeTTL).Format(time.RFC3339),
+	}, nil
+}
+
+// verifyEnquiryChallenge checks the signature, age and proof of work of a
+// solved challenge and marks it used.
+func verifyEnquiryChallenge(schoolId, challenge, solution string, now time.Time) string {
+	encoded, signature, ok := strings.Cut(challenge, ".")
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil || !hmac.Equal([]byte(signature), []byte(signChallenge(string(raw)))) {
+		return "challenge is invalid"
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 4 || parts[0] != schoolId {
+		return "challenge is invalid"
+	}
+	issuedUnix, _ := strconv.ParseInt(parts[2], 10, 64)
+	difficulty, _ := strconv.Atoi(parts[3])
+	issuedAt := time.Unix(issuedUnix, 0)
+	if now.Sub(issuedAt) > powChallengeTTL {
+		return "challenge has expired, reload the form"
+	}
+	if now.Sub(issuedAt) < minFormFillTime {
+		return "form was submitted too quickly"
+	}
+
+	if solution == "" || leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) < difficulty {
+		return "proof of work is not solved"
+	}
+
+	usedChallenges.Lock()
+	defer usedChallenges.Unlock()
+	for key, expiry := range usedChallenges.expiry {
+		if now.After(expiry) {
+			delete(usedChallenges.expiry, key)
+		}
+	}
+	if _, used := usedChallenges.expiry[parts[1]]; used {
+		return "challenge was already used"
+	}
+	usedChallenges.expiry[parts[1]] = issuedAt.Add(powChallengeTTL)
+	return ""
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// findDuplicateEnquiry returns an open enquiry for the same child from the
+// same phone or email. Callers must hold the lock.
+func findDuplicateEnquiry(schoolId string, enquiry EnquiryModel) *EnquiryModel {
+	for _, existing := range enquiries.school(schoolId) {
+		if existing.Status == enquiryStatusConverted || existing.Status == "Junk Lead" {
+			continue
+		}
+		sameContact := (enquiry.Phone != "" && existing.Phone == enquiry.Phone) || (enquiry.Email != "" && existing.Email == enquiry.Email)
+		if sameContact && strings.EqualFold(existing.StudentName, enquiry.StudentName) {
+			return existing
+		}
+	}
+	return nil
+}
+
+// mergeEnquiry records a repeated enquiry as a note on the existing one.
+// Details that differ from the lead are listed for staff to confirm rather
+// than copied in, since anyone can submit the form with a known phone number.
+func mergeEnquiry(existing *EnquiryModel, enquiry EnquiryModel, message string, now time.Time) {
+	note := "Repeated enquiry from the public form"
+	var details []string
+	differs := func(label, current, value string) {
+		if value != "" && !strings.EqualFold(current, value) {
+			details = append(details, label+" "+value)
+		}
+	}
+	differs("parent", existing.ParentName, enquiry.ParentName)
+	differs("phone", existing.Phone, enquiry.Phone)
+	differs("email", existing.Email, enquiry.Email)
+	differs("class", existing.ClassName, enquiry.ClassName)
+	differs("prefers", existing.PreferredCommunication, enquiry.PreferredCommunication)
+	if len(details) > 0 {
+		note += " with " + strings.Join(details, ", ")
+	}
+	if message != "" {
+		note += ": " + message
+	}
+	existing.Notes = append(existing.Notes, EnquiryNoteModel{Text: note, CreatedBy: publicEnquiryUser, CreatedAt: now.Format(time.RFC3339)})
+	existing.record(enquiryEventMerge, "", enquiry.Source, publicEnquiryUser, now)
+}
+
+func publicSchoolExists(schoolId string) bool {
+	_, ok := schoolProfiles.get(schoolId)
+	return ok
+}
+
+// PublicEnquiryChallengeHandler issues the proof-of-work challenge the
+// public enquiry form solves before submitting.
+func PublicEnquiryChallengeHandler(c echo.Context) error {
+	schoolId := c.Param("schoolId")
+	if !publicSchoolExists(schoolId) {
+		return c.JSON(http.StatusNotFound, failed("School not found"))
+	}
+	challenge, err := newEnquiryChallenge(schoolId, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, failed("Failed to issue a challenge"))
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, success(challenge))
+}
+
+// PublicEnquiryHandler accepts enquiries from prospective parents without a
+// login. Repeated enquiries for the same child are merged into the open
+// lead instead of creating another. Every accepted submission gets the same
+// reply, so the form does not tell strangers whether a child is already
+// enquiring or which lead they would reach.
+func PublicEnquiryHandler(c echo.Context) error {
+	schoolId := c.Param("schoolId")
+	if !publicSchoolExists(schoolId) {
+		return c.JSON(http.StatusNotFound, failed("School not found"))
+	}
+	var req PublicEnquiryDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	if strings.TrimSpace(req.Website) != "" {
+		// Look successful so bots do not learn about the honeypot
+		return c.JSON(http.StatusOK, success(nil))
+	}
+	now := time.Now()
+	if problem := verifyEnquiryChallenge(schoolId, req.Challenge, req.Solution, now); problem != "" {
+		return c.JSON(http.StatusBadRequest, failed("Could not verify the form", problem))
+	}
+
+	enquiry, errs := normalizeEnquiry(schoolId, EnquiryModel{
+		StudentName:            req.StudentName,
+		ParentName:             req.ParentName,
+		Phone:                  req.Phone,
+		Email:                  req.Email,
+		ClassName:              req.ClassName,
+		Source:                 req.Source,
+		PreferredCommunication: req.PreferredCommunication,
+	})
+	message := strings.TrimSpace(req.Message)
+	if len(message) > 1000 {
+		errs = append(errs, "message must be at most 1000 characters")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid enquiry", errs...))
+	}
+
+	enquiries.Lock()
+	defer enquiries.Unlock()
+	if existing := findDuplicateEnquiry(schoolId, enquiry); existing != nil {
+		mergeEnquiry(existing, enquiry, message, now)
+		return c.JSON(http.StatusOK, success(nil))
+	}
+	created := enquiries.create(schoolId, enquiry, publicEnquiryUser, now)
+	if message != "" {
+		created.Notes = append(created.Notes, EnquiryNoteModel{Text: message, CreatedBy: publicEnquiryUser, CreatedAt: now.Format(time.RFC3339)})
+	}
+	return c.JSON(http.StatusOK, success(nil))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// solveChallenge finds a solution the way the enquiry form does.
+func solveChallenge(t *testing.T, challenge string, difficulty int) string {
+	t.Helper()
+	for i := 0; i < 1<<24; i++ {
+		solution := strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) >= difficulty {
+			return solution
+		}
+	}
+	t.Fatal("no solution found")
+	return ""
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0xff}, 8},
+		{[]byte{0x00, 0x00, 0x10}, 19},
+	}
+	for _, test := range tests {
+		var sum [sha256.Size]byte
+		copy(sum[:], test.prefix)
+		sum[sha256.Size-1] |= 1
+		if got := leadingZeroBits(sum); got != test.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestVerifyEnquiryChallenge(t *testing.T) {
+	issued := time.Date(2030, 3, 1, 9, 0, 0, 0, time.UTC)
+	submitted := issued.Add(time.Minute)
+	issue := func() (string, string) {
+		challenge, err := newEnquiryChallenge(defaultSchoolID, issued)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return challenge.Challenge, solveChallenge(t, challenge.Challenge, challenge.Difficulty)
+	}
+
+	challenge, solution := issue()
+	encoded, signature, _ := strings.Cut(challenge, ".")
+	forged := encoded + "." + strings.Repeat("0", len(signature))
+	unsolved := "x"
+	for leadingZeroBits(sha256.Sum256([]byte(challenge+":"+unsolved))) >= powDifficulty {
+		unsolved += "x"
+	}
+	tests := []struct {
+		name      string
+		school    string
+		challenge string
+		solution  string
+		now       time.Time
+		want      string
+	}{
+		{"other school", "SCH-OTHER", challenge, solution, submitted, "challenge is invalid"},
+		{"forged signature", defaultSchoolID, forged, solution, submitted, "challenge is invalid"},
+		{"no signature", defaultSchoolID, encoded, solution, submitted, "challenge is invalid"},
+		{"not base64", defaultSchoolID, "!!." + signature, solution, submitted, "challenge is invalid"},
+		{"too quick", defaultSchoolID, challenge, solution, issued.Add(time.Second), "form was submitted too quickly"},
+		{"expired", defaultSchoolID, challenge, solution, issued.Add(powChallengeTTL + time.Second), "challenge has expired, reload the form"},
+		{"missing solution", defaultSchoolID, challenge, "", submitted, "proof of work is not solved"},
+		{"wrong solution", defaultSchoolID, challenge, unsolved, submitted, "proof of work is not solved"},
+		{"solved", defaultSchoolID, challenge, solution, submitted, ""},
+		{"replayed", defaultSchoolID, challenge, solution, submitted.Add(time.Second), "challenge was already used"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := verifyEnquiryChallenge(test.school, test.challenge, test.solution, test.now); got != test.want {
+				t.Errorf("verifyEnquiryChallenge = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClientIPExtractor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{"direct", "", "203.0.113.7:4000", "", "203.0.113.7"},
+		{"spoofed header without proxies", "", "203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"private peer is not trusted by default", "", "10.0.0.5:4000", "198.51.100.1", "10.0.0.5"},
+		{"trusted proxy", "10.0.0.0/8", "10.0.0.5:4000", "198.51.100.1", "198.51.100.1"},
+		{"client spoofs before the proxy", "10.0.0.0/8", "10.0.0.5:4000", "192.0.2.9, 198.51.100.1", "198.51.100.1"},
+		{"untrusted peer", "10.0.0.0/8", "203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"invalid range is ignored", "bogus, 10.0.0.0/8", "10.0.0.5:4000", "198.51.100.1", "198.51.100.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", test.trustedProxies)
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				req.Header.Set(echo.HeaderXForwardedFor, test.forwardedFor)
+			}
+			if got := clientIPExtractor()(req); got != test.want {
+				t.Errorf("client IP = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPublicEnquiryRateLimitIgnoresForwardedFor(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	e := echo.New()
+	e.IPExtractor = clientIPExtractor()
+	e.POST("/public/enquiry", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }, publicEnquiryRateLimiter)
+
+	var codes []int
+	for i := 0; i < 6; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/public/enquiry", nil)
+		req.RemoteAddr = "203.0.113.50:4000"
+		req.Header.Set(echo.HeaderXForwardedFor, fmt.Sprintf("198.51.100.%d", i))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[4] != http.StatusNoContent || codes[5] != http.StatusTooManyRequests {
+		t.Errorf("status codes = %v, want the sixth request limited", codes)
+	}
+}
+
+func TestPublicEnquiryMergesQuietly(t *testing.T) {
+	resetEnquiries()
+	saved := schoolProfiles
+	schoolProfiles = &schoolProfileStore{profiles: map[string]*SchoolProfileModel{enquirySchool: {}}}
+	defer func() { schoolProfiles = saved }()
+	e := echo.New()
+	e.POST("/public/:schoolId/enquiry", PublicEnquiryHandler)
+
+	submit := func(req PublicEnquiryDto) string {
+		t.Helper()
+		challenge, err := newEnquiryChallenge(enquirySchool, time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Challenge, req.Solution = challenge.Challenge, solveChallenge(t, challenge.Challenge, challenge.Difficulty)
+		body, _ := json.Marshal(req)
+		httpReq := httptest.NewRequest(http.MethodPost, "/public/"+enquirySchool+"/enquiry", bytes.NewReader(body))
+		httpReq.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httpReq)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	first := PublicEnquiryDto{StudentName: "Ravi Kumar", ParentName: "Suresh Kumar", Phone: "9845012345", ClassName: "12", Source: "Source1"}
+	repeat := first
+	repeat.StudentName, repeat.ParentName, repeat.Email, repeat.ClassName, repeat.Message = "ravi kumar", "Someone Else", "other@mail.com", "2", "Please call back"
+
+	created := submit(first)
+	merged := submit(repeat)
+	if created != merged || strings.Contains(merged, "ENQ") {
+		t.Errorf("reply to a repeat = %s, to a new lead = %s", merged, created)
+	}
+
+	leads := enquiries.school(enquirySchool)
+	if len(leads) != 1 {
+		t.Fatalf("leads = %d, want the repeat merged", len(leads))
+	}
+	for _, lead := range leads {
+		if lead.ParentName != "Suresh Kumar" || lead.Email != "" || lead.ClassName != "12" {
+			t.Errorf("lead changed by the repeat: %+v", lead)
+		}
+		want := "Repeated enquiry from the public form with parent Someone Else, email other@mail.com, class 2: Please call back"
+		if len(lead.Notes) != 1 || lead.Notes[0].Text != want {
+			t.Errorf("notes = %+v, want %q", lead.Notes, want)
+		}
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RecurringEventModel is a calendar event series. RRule follows the RFC 5545
+// subset supported by parseRRule; ExDates and Overrides hold per-occurrence
+// cancellations and title edits keyed by YYYY-MM-DD.
+type RecurringEventModel struct {
+	Id        string            `json:"id"`
+	Title     string            `json:"title"`
+	StartDate string            `json:"startDate"`
+	RRule     string            `json:"rrule"`
+	ExDates   []string          `json:"exDates"`
+	Overrides map[string]string `json:"overrides"`
+}
+
+type RecurringEventRequestDto struct {
+	Title     string   `json:"title"`
+	StartDate string   `json:"startDate"`
+	RRule     string   `json:"rrule"`
+	ExDates   []string `json:"exDates"`
+}
+
+// RecurringEventEditDto edits one occurrence ("THIS"), the occurrence and all
+// later ones ("FUTURE") or the whole series ("ALL").
+type RecurringEventEditDto struct {
+	EventId        string `json:"eventId"`
+	OccurrenceDate string `json:"occurrenceDate"`
+	Scope          string `json:"scope"`
+	Title          string `json:"title"`
+	RRule          string `json:"rrule"`
+	Cancel         bool   `json:"cancel"`
+}
+
+type byDayRule struct {
+	Ordinal int // 0 means every such weekday, 1 the first, -1 the last
+	Weekday time.Weekday
+}
+
+type rrule struct {
+	Freq       string
+	Interval   int
+	ByDay      []byDayRule
+	ByMonthDay []int
+	Until      time.Time
+	Count      int
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRRule parses FREQ=DAILY|WEEKLY|MONTHLY with INTERVAL, BYDAY (e.g.
+// MO,WE or 1MO/-1FR for monthly), BYMONTHDAY, UNTIL=YYYYMMDD and COUNT.
+func parseRRule(value string) (rrule, error) {
+	rule := rrule{Interval: 1}
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(value), "RRULE:"), ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return rule, fmt.Errorf("invalid rule part %q", part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+			if rule.Freq != "DAILY" && rule.Freq != "WEEKLY" && rule.Freq != "MONTHLY" {
+				return rule, fmt.Errorf("unsupported FREQ %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			rule.Interval = n
+		case "BYDAY":
+			for _, day := range strings.Split(strings.ToUpper(val), ",") {
+				if len(day) < 2 {
+					return rule, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				weekday, ok := rruleWeekdays[day[len(day)-2:]]
+				if !ok {
+					return rule, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				ordinal := 0
+				if prefix := day[:len(day)-2]; prefix != "" {
+					n, err := strconv.Atoi(prefix)
+					if err != nil || n == 0 || n < -5 || n > 5 {
+						return rule, fmt.Errorf("invalid BYDAY %q", day)
+					}
+					ordinal = n
+				}
+				rule.ByDay = append(rule.ByDay, byDayRule{Ordinal: ordinal, Weekday: weekday})
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return rule, fmt.Errorf("invalid BYMONTHDAY %q", day)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "UNTIL":
+			until, err := time.Parse("20060102", val[:min(len(val), 8)])
+			if err != nil {
+				return rule, fmt.Errorf("invalid UNTIL %q", val)
+			}
+			rule.Until = until
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid COUNT %q", val)
+			}
+			rule.Count = n
+		default:
+			return rule, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+	if rule.Freq == "" {
+		return rule, fmt.Errorf("FREQ is required")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return rule, fmt.Errorf("UNTIL and COUNT cannot be combined")
+	}
+	return rule, nil
+}
+
+func (r rrule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, day := range r.ByDay {
+			code := strings.ToUpper(day.Weekday.String()[:2])
+			if day.Ordinal != 0 {
+				code = strconv.Itoa(day.Ordinal) + code
+			}
+			days = append(days, code)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		var days []string
+		for _, day := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(day))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	return strings.Join(parts, ";")
+}
+
+// matches reports whether date is an occurrence of a rule starting at start.
+func (r rrule) matches(start, date time.Time) bool {
+	switch r.Freq {
+	case "DAILY":
+		return int(date.Sub(start).Hours()/24)%r.Interval == 0
+	case "WEEKLY":
+		// Weeks start on Monday, the RFC 5545 default WKST
+		startWeek := start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		weeks := int(date.Sub(startWeek).Hours()/24) / 7
+		if weeks%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 {
+			return date.Weekday() == start.Weekday()
+		}
+		for _, day := range r.ByDay {
+			if day.Weekday == date.Weekday() {
+				return true
+			}
+		}
+		return false
+	case "MONTHLY":
+		months := (date.Year()-start.Year())*12 + int(date.Month()-start.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+			return date.Day() == start.Day()
+		}
+		lastDay := time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		for _, day := range r.ByMonthDay {
+			if day == date.Day() || lastDay+day+1 == date.Day() {
+				return true
+			}
+		}
+		for _, day := range r.ByDay {
+			if day.Weekday != date.Weekday() {
+				continue
+			}
+			switch {
+			case day.Ordinal == 0:
+				return true
+			case day.Ordinal > 0 && (date.Day()-1)/7+1 == day.Ordinal:
+				return true
+			case day.Ordinal < 0 && (lastDay-date.Day())/7+1 == -day.Ordinal:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// periodStart returns the first day of the rule's period (day, week or
+// month) holding date. Weeks start on Monday, the RFC 5545 default WKST.
+func (r rrule) periodStart(date time.Time) time.Time {
+	switch r.Freq {
+	case "WEEKLY":
+		return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+	case "MONTHLY":
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	}
+	return date
+}
+
+// addPeriods moves a period start n periods on.
+func (r rrule) addPeriods(period time.Time, n int) time.Time {
+	switch r.Freq {
+	case "WEEKLY":
+		return period.AddDate(0, 0, 7*n)
+	case "MONTHLY":
+		return period.AddDate(0, n, 0)
+	}
+	return period.AddDate(0, 0, n)
+}
+
+// periodsBetween counts the periods from the one holding start to the one
+// holding date.
+func (r rrule) periodsBetween(start, date time.Time) int {
+	switch r.Freq {
+	case "WEEKLY":
+		return int(math.Round(r.periodStart(date).Sub(r.periodStart(start)).Hours()/24)) / 7
+	case "MONTHLY":
+		return (date.Year()-start.Year())*12 + int(date.Month()-start.Month())
+	}
+	return int(math.Round(date.Sub(start).Hours() / 24))
+}
+
+// occurrences expands the rule into dates within [from, to). COUNT is applied
+// before exclusions, as in RFC 5545, so counted rules are walked from start;
+// others jump straight to the last period on the rule's interval before from.
+// Only periods on the interval are scanned.
+func (r rrule) occurrences(start, from, to time.Time, exDates []string) []time.Time {
+	excluded := map[string]bool{}
+	for _, date := range exDates {
+		excluded[date] = true
+	}
+	var dates []time.Time
+	count := 0
+	period := r.periodStart(start)
+	if r.Count == 0 {
+		fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, start.Location())
+		if skip := r.periodsBetween(start, fromDay); skip > 0 {
+			period = r.addPeriods(period, skip-skip%r.Interval)
+		}
+	}
+	for ; period.Before(to); period = r.addPeriods(period, r.Interval) {
+		next := r.addPeriods(period, 1)
+		for date := period; date.Before(next); date = date.AddDate(0, 0, 1) {
+			if date.Before(start) {
+				continue
+			}
+			if !date.Before(to) || (!r.Until.IsZero() && date.After(r.Until)) {
+				return dates
+			}
+			if !r.matches(start, date) {
+				continue
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return dates
+			}
+			if !date.Before(from) && !excluded[date.Format(dateLayout)] {
+				dates = append(dates, date)
+			}
+		}
+	}
+	return dates
+}
+
+type recurringEventStore struct {
+	sync.RWMutex
+	events map[string]*RecurringEventModel
+	order  []string
+}
+
+var recurringEvents = &recurringEventStore{events: map[string]*RecurringEventModel{}}
+
+// expand adds every occurrence within [from, to) to the calendar's Events.
+func (s *recurringEventStore) expand(calendar *DateModel, from, to time.Time) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, id := range s.order {
+		event := s.events[id]
+		rule, err := parseRRule(event.RRule)
+		if err != nil {
+			continue
+		}
+		start, err := time.Parse(dateLayout, event.StartDate)
+		if err != nil {
+			continue
+		}
+		for _, date := range rule.occurrences(start, from, to, event.ExDates) {
+			title := event.Title
+			if override, ok := event.Overrides[date.Format(dateLayout)]; ok {
+				title = override
+			}
+			key := date.Format(time.RFC3339)
+			day := calendar.Events[key]
+			day.Events = append(append([]string{}, day.Events...), title)
+			calendar.Events[key] = day
+		}
+	}
+}
+
+func (s *recurringEventStore) add(event *RecurringEventModel) {
+	s.events[event.Id] = event
+	s.order = append(s.order, event.Id)
+}
+
+func (s *recurringEventStore) remove(id string) {
+	delete(s.events, id)
+	for i, other := range s.order {
+		if other == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// normalizeExDates checks that every excluded date is an occurrence of the
+// rule and returns them sorted without duplicates.
+func normalizeExDates(rule rrule, start time.Time, values []string) ([]string, []string) {
+	exDates := []string{}
+	var errs []string
+	for _, value := range values {
+		date, err := time.Parse(dateLayout, strings.TrimSpace(value))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("exDate %q must use the YYYY-MM-DD format", value))
+			continue
+		}
+		if len(rule.occurrences(start, date, date.AddDate(0, 0, 1), nil)) == 0 {
+			errs = append(errs, fmt.Sprintf("exDate %s is not an occurrence of this event", date.Format(dateLayout)))
+			continue
+		}
+		if !containsValue(exDates, date.Format(dateLayout)) {
+			exDates = append(exDates, date.Format(dateLayout))
+		}
+	}
+	sort.Strings(exDates)
+	return exDates, errs
+}
+
+// RecurringEventHandler creates an event series.
+func RecurringEventHandler(c echo.Context) error {
+	var req RecurringEventRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	if strings.TrimSpace(req.Title) == "" {
+		return c.JSON(http.StatusBadRequest, failed("title is required"))
+	}
+	start, err := time.Parse(dateLayout, req.StartDate)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("startDate must use the YYYY-MM-DD format"))
+	}
+	rule, err := parseRRule(req.RRule)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("Invalid rrule", err.Error()))
+	}
+	exDates, errs := normalizeExDates(rule, start, req.ExDates)
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid exDates", errs...))
+	}
+
+	event := &RecurringEventModel{
+		Id:        newID("EVT"),
+		Title:     req.Title,
+		StartDate: req.StartDate,
+		RRule:     rule.String(),
+		ExDates:   exDates,
+		Overrides: map[string]string{},
+	}
+	recurringEvents.Lock()
+	recurringEvents.add(event)
+	recurringEvents.Unlock()
+	return c.JSON(http.StatusOK, success(event))
+}
+
+// RecurringEventListHandler lists all event series.
+func RecurringEventListHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	recurringEvents.RLock()
+	defer recurringEvents.RUnlock()
+	events := []RecurringEventModel{}
+	for _, id := range recurringEvents.order {
+		events = append(events, *recurringEvents.events[id])
+	}
+	return c.JSON(http.StatusOK, success(events))
+}
+
+// EditRecurringEventHandler applies an edit or cancellation to one
+// occurrence, to all future occurrences (splitting the series) or to all.
+func EditRecurringEventHandler(c echo.Context) error {
+	var req RecurringEventEditDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	recurringEvents.Lock()
+	defer recurringEvents.Unlock()
+	event, ok := recurringEvents.events[req.EventId]
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Event not found"))
+	}
+	rule, _ := parseRRule(event.RRule)
+	start, _ := time.Parse(dateLayout, event.StartDate)
+
+	var newRule rrule
+	if req.RRule != "" {
+		parsed, err := parseRRule(req.RRule)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, failed("Invalid rrule", err.Error()))
+		}
+		newRule = parsed
+	}
+
+	switch strings.ToUpper(req.Scope) {
+	case "ALL":
+		if req.Cancel {
+			recurringEvents.remove(event.Id)
+			return c.JSON(http.StatusOK, success(nil))
+		}
+		if req.Title != "" {
+			event.Title = req.Title
+		}
+		if req.RRule != "" {
+			event.RRule = newRule.String()
+		}
+		return c.JSON(http.StatusOK, success(*event))
+
+	case "THIS", "FUTURE":
+		date, err := time.Parse(dateLayout, req.OccurrenceDate)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, failed("occurrenceDate must use the YYYY-MM-DD format"))
+		}
+		if len(rule.occurrences(start, date, date.AddDate(0, 0, 1), nil)) == 0 {
+			return c.JSON(http.StatusBadRequest, failed("occurrenceDate is not an occurrence of this event"))
+		}
+
+		if strings.ToUpper(req.Scope) == "THIS" {
+			if req.Cancel {
+				event.ExDates = append(event.ExDates, req.OccurrenceDate)
+			} else if req.Title != "" {
+				event.Overrides[req.OccurrenceDate] = req.Title
+			}
+			return c.JSON(http.StatusOK, success(*event))
+		}
+
+		// Split the series: the original ends the day before and, unless the
+		// future is cancelled, a new series continues from the occurrence. At
+		// the first occurrence nothing is left of the original, so it goes.
+		earlier := len(rule.occurrences(start, start, date, nil))
+		remaining := 0
+		if rule.Count > 0 {
+			remaining = rule.Count - earlier
+		}
+		truncated := rule
+		truncated.Count = 0
+		truncated.Until = date.AddDate(0, 0, -1)
+		event.RRule = truncated.String()
+		if earlier == 0 {
+			recurringEvents.remove(event.Id)
+		}
+
+		if req.Cancel {
+			if earlier == 0 {
+				return c.JSON(http.StatusOK, success(nil))
+			}
+			return c.JSON(http.StatusOK, success(*event))
+		}
+		next := rule
+		if req.RRule != "" {
+			next = newRule
+		} else if remaining > 0 {
+			next.Count = remaining
+		}
+		title := event.Title
+		if req.Title != "" {
+			title = req.Title
+		}
+		future := &RecurringEventModel{
+			Id:        newID("EVT"),
+			Title:     title,
+			StartDate: req.OccurrenceDate,
+			RRule:     next.String(),
+			ExDates:   []string{},
+			Overrides: map[string]string{},
+		}
+		for _, exDate := range event.ExDates {
+			if exDate >= req.OccurrenceDate {
+				future.ExDates = append(future.ExDates, exDate)
+			}
+		}
+		for date, override := range event.Overrides {
+			if date >= req.OccurrenceDate && req.Title == "" {
+				future.Overrides[date] = override
+			}
+		}
+		recurringEvents.add(future)
+		sort.Strings(future.ExDates)
+		if earlier == 0 {
+			return c.JSON(http.StatusOK, success([]RecurringEventModel{*future}))
+		}
+		return c.JSON(http.StatusOK, success([]RecurringEventModel{*event, *future}))
+	}
+	return c.JSON(http.StatusBadRequest, failed("scope must be THIS, FUTURE or ALL"))
+}
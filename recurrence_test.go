@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustDate(t *testing.T, value string) time.Time {
+	t.Helper()
+	date, err := time.Parse(dateLayout, value)
+	if err != nil {
+		t.Fatalf("parse %q: %v", value, err)
+	}
+	return date
+}
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // canonical form
+		wantErr bool
+	}{
+		{in: "FREQ=DAILY", want: "FREQ=DAILY"},
+		{in: "RRULE:freq=weekly;byday=mo,we;interval=2", want: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE"},
+		{in: "FREQ=MONTHLY;BYDAY=1MO,-1FR", want: "FREQ=MONTHLY;BYDAY=1MO,-1FR"},
+		{in: "FREQ=MONTHLY;BYMONTHDAY=1,-1;COUNT=6", want: "FREQ=MONTHLY;BYMONTHDAY=1,-1;COUNT=6"},
+		{in: "FREQ=WEEKLY;UNTIL=20300630T000000Z", want: "FREQ=WEEKLY;UNTIL=20300630"},
+		{in: "", wantErr: true},
+		{in: "INTERVAL=2", wantErr: true},
+		{in: "FREQ=YEARLY", wantErr: true},
+		{in: "FREQ=DAILY;INTERVAL=0", wantErr: true},
+		{in: "FREQ=WEEKLY;BYDAY=XX", wantErr: true},
+		{in: "FREQ=MONTHLY;BYDAY=6MO", wantErr: true},
+		{in: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{in: "FREQ=DAILY;COUNT=3;UNTIL=20300101", wantErr: true},
+		{in: "FREQ=DAILY;BYHOUR=9", wantErr: true},
+		{in: "FREQ", wantErr: true},
+	}
+	for _, test := range tests {
+		rule, err := parseRRule(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseRRule(%q) error = %v, wantErr %v", test.in, err, test.wantErr)
+			continue
+		}
+		if err == nil && rule.String() != test.want {
+			t.Errorf("parseRRule(%q) = %q, want %q", test.in, rule.String(), test.want)
+		}
+	}
+}
+
+func TestRRuleOccurrences(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		start    string
+		from, to string
+		exDates  []string
+		want     []string
+	}{
+		{
+			name: "every third day", rule: "FREQ=DAILY;INTERVAL=3", start: "2030-03-01",
+			from: "2030-03-01", to: "2030-03-11",
+			want: []string{"2030-03-01", "2030-03-04", "2030-03-07", "2030-03-10"},
+		},
+		{
+			name: "fortnightly on Monday and Friday", rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", start: "2030-03-06",
+			from: "2030-03-01", to: "2030-04-01",
+			want: []string{"2030-03-08", "2030-03-18", "2030-03-22"},
+		},
+		{
+			name: "last Friday of the month", rule: "FREQ=MONTHLY;BYDAY=-1FR", start: "2030-01-01",
+			from: "2030-01-01", to: "2030-04-01",
+			want: []string{"2030-01-25", "2030-02-22", "2030-03-29"},
+		},
+		{
+			name: "first and last day of the month", rule: "FREQ=MONTHLY;BYMONTHDAY=1,-1", start: "2030-02-01",
+			from: "2030-02-01", to: "2030-04-01",
+			want: []string{"2030-02-01", "2030-02-28", "2030-03-01", "2030-03-31"},
+		},
+		{
+			name: "count applies before exclusions", rule: "FREQ=WEEKLY;COUNT=3", start: "2030-03-04",
+			from: "2030-03-01", to: "2030-06-01", exDates: []string{"2030-03-11"},
+			want: []string{"2030-03-04", "2030-03-18"},
+		},
+		{
+			name: "count over a later window", rule: "FREQ=DAILY;COUNT=5", start: "2030-03-01",
+			from: "2030-03-04", to: "2030-04-01",
+			want: []string{"2030-03-04", "2030-03-05"},
+		},
+		{
+			name: "until is inclusive", rule: "FREQ=DAILY;UNTIL=20300303", start: "2030-03-01",
+			from: "2030-03-01", to: "2030-04-01",
+			want: []string{"2030-03-01", "2030-03-02", "2030-03-03"},
+		},
+		{
+			name: "window before the start", rule: "FREQ=DAILY", start: "2030-03-01",
+			from: "2030-02-01", to: "2030-03-01",
+			want: nil,
+		},
+		{
+			name: "daily decades after the start", rule: "FREQ=DAILY;INTERVAL=3", start: "2000-01-01",
+			from: "2030-01-01", to: "2030-01-10",
+			want: []string{"2030-01-02", "2030-01-05", "2030-01-08"},
+		},
+		{
+			name: "weekly decades after the start", rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", start: "2000-01-03",
+			from: "2030-01-01", to: "2030-01-31",
+			want: []string{"2030-01-07", "2030-01-21"},
+		},
+		{
+			name: "monthly decades after the start", rule: "FREQ=MONTHLY;INTERVAL=5;BYDAY=-1FR", start: "2000-01-01",
+			from: "2030-01-01", to: "2031-01-01",
+			want: []string{"2030-01-25", "2030-06-28", "2030-11-29"},
+		},
+		{
+			name: "until before a late window", rule: "FREQ=DAILY;UNTIL=20200101", start: "2000-01-01",
+			from: "2030-01-01", to: "2030-02-01",
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := parseRRule(test.rule)
+			if err != nil {
+				t.Fatalf("parseRRule: %v", err)
+			}
+			var got []string
+			for _, date := range rule.occurrences(mustDate(t, test.start), mustDate(t, test.from), mustDate(t, test.to), test.exDates) {
+				got = append(got, date.Format(dateLayout))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("occurrences = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecurringEventExDates(t *testing.T) {
+	recurringEvents = &recurringEventStore{events: map[string]*RecurringEventModel{}}
+	admin := testToken(t, "admin", roleAdmin, "")
+	tests := []struct {
+		name    string
+		exDates []string
+		status  int
+		want    []string
+	}{
+		{"sorted and deduplicated", []string{"2030-03-18", "2030-03-11", "2030-03-18"}, http.StatusOK, []string{"2030-03-11", "2030-03-18"}},
+		{"not a date", []string{"18/03/2030"}, http.StatusBadRequest, nil},
+		{"not an occurrence", []string{"2030-03-12"}, http.StatusBadRequest, nil},
+		{"before the start", []string{"2030-03-04"}, http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var event RecurringEventModel
+			rec := callHandler(t, RecurringEventHandler, http.MethodPost, "/calendar/recurring", admin, RecurringEventRequestDto{
+				Title: "Assembly", StartDate: "2030-03-11", RRule: "FREQ=WEEKLY;BYDAY=MO", ExDates: test.exDates,
+			})
+			decodeData(t, rec, test.status, &event)
+			if test.status == http.StatusOK && !reflect.DeepEqual(event.ExDates, test.want) {
+				t.Errorf("exDates = %v, want %v", event.ExDates, test.want)
+			}
+		})
+	}
+}
+
+func TestEditRecurringEventFutureSplit(t *testing.T) {
+	admin := testToken(t, "admin", roleAdmin, "")
+	create := func() RecurringEventModel {
+		t.Helper()
+		var event RecurringEventModel
+		rec := callHandler(t, RecurringEventHandler, http.MethodPost, "/calendar/recurring", admin, RecurringEventRequestDto{
+			Title: "Assembly", StartDate: "2030-03-04", RRule: "FREQ=WEEKLY;COUNT=6",
+		})
+		decodeData(t, rec, http.StatusOK, &event)
+		return event
+	}
+
+	tests := []struct {
+		name       string
+		date       string
+		cancel     bool
+		wantEvents int
+		wantRules  []string
+	}{
+		{"mid series", "2030-03-18", false, 2, []string{"FREQ=WEEKLY;UNTIL=20300317", "FREQ=WEEKLY;COUNT=4"}},
+		{"mid series cancelled", "2030-03-18", true, 1, []string{"FREQ=WEEKLY;UNTIL=20300317"}},
+		{"first occurrence", "2030-03-04", false, 1, []string{"FREQ=WEEKLY;COUNT=6"}},
+		{"first occurrence cancelled", "2030-03-04", true, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recurringEvents = &recurringEventStore{events: map[string]*RecurringEventModel{}}
+			event := create()
+			rec := callHandler(t, EditRecurringEventHandler, http.MethodPost, "/calendar/recurring/edit", admin, RecurringEventEditDto{
+				EventId: event.Id, OccurrenceDate: test.date, Scope: "FUTURE", Title: "Morning Assembly", Cancel: test.cancel,
+			})
+			decodeData(t, rec, http.StatusOK, nil)
+
+			if len(recurringEvents.order) != test.wantEvents {
+				t.Fatalf("got %d series, want %d", len(recurringEvents.order), test.wantEvents)
+			}
+			var rules []string
+			for _, id := range recurringEvents.order {
+				rules = append(rules, recurringEvents.events[id].RRule)
+			}
+			if !reflect.DeepEqual(rules, test.wantRules) {
+				t.Errorf("rules = %v, want %v", rules, test.wantRules)
+			}
+			if _, ok := recurringEvents.events[event.Id]; ok != (test.date != event.StartDate) {
+				t.Errorf("original series kept = %v", ok)
+			}
+		})
+	}
+}
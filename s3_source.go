@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body, signed for GETs.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var (
+	s3BucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	s3RegionPattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+)
+
+// S3SourceConfigModel points at a bucket of an S3-compatible store. Prefix
+// is prepended to every key; PathStyle puts the bucket in the path rather
+// than the host name, as MinIO and most non-AWS stores expect.
+type S3SourceConfigModel struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix"`
+	PathStyle       bool   `json:"pathStyle"`
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+}
+
+func normalizeS3Config(config *S3SourceConfigModel) []string {
+	var errs []string
+	config.Endpoint = strings.TrimRight(strings.TrimSpace(config.Endpoint), "/")
+	config.Region = strings.TrimSpace(config.Region)
+	config.Bucket = strings.TrimSpace(config.Bucket)
+	config.Prefix = strings.TrimLeft(strings.TrimSpace(config.Prefix), "/")
+	config.AccessKeyId = strings.TrimSpace(config.AccessKeyId)
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" || endpoint.Path != "" || endpoint.RawQuery != "" || endpoint.User != nil {
+		errs = append(errs, "s3.endpoint must be an https URL without a path, e.g. https://s3.ap-south-1.amazonaws.com")
+	}
+	if !s3RegionPattern.MatchString(config.Region) {
+		errs = append(errs, "s3.region is invalid")
+	}
+	if !s3BucketPattern.MatchString(config.Bucket) {
+		errs = append(errs, "s3.bucket is not a valid bucket name")
+	}
+	if strings.Contains(config.Prefix, "..") {
+		errs = append(errs, "s3.prefix must not contain ..")
+	}
+	if config.AccessKeyId == "" || config.SecretAccessKey == "" {
+		errs = append(errs, "s3.accessKeyId and s3.secretAccessKey are required")
+	}
+	return errs
+}
+
+// s3ImageSource fetches objects from an S3-compatible bucket, signing each
+// request with AWS Signature Version 4.
+type s3ImageSource struct {
+	config S3SourceConfigModel
+}
+
+func (s s3ImageSource) ValidateKey(schoolId, key string) string {
+	if len(key) > 1024 || strings.HasPrefix(key, "/") || strings.Contains(key, "..") {
+		return "key is not a valid object key"
+	}
+	return ""
+}
+
+func (s s3ImageSource) CacheKey(key string) string {
+	return "s3:" + s.config.Endpoint + "/" + s.config.Bucket + "/" + s.config.Prefix + key
+}
+
+func (s s3ImageSource) Fetch(ctx context.Context, schoolId, key string) (proxiedImage, error) {
+	endpoint, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	path := "/" + s.config.Prefix + key
+	if s.config.PathStyle {
+		path = "/" + s.config.Bucket + path
+	} else {
+		endpoint.Host = s.config.Bucket + "." + endpoint.Host
+	}
+	endpoint.Path = path
+	endpoint.RawPath = s3EscapePath(path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return proxiedImage{}, err
+	}
+	signS3Request(req, s.config, time.Now())
+	return fetchImage(req)
+}
+
+// s3EscapePath percent-encodes every byte of the path except the unreserved
+// characters and '/', as SigV4 requires.
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || strings.IndexByte("-_.~/", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{ch})))
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// signS3Request adds the SigV4 Authorization header to a body-less request,
+// signing the host and every header already set on it.
+func signS3Request(req *http.Request, config S3SourceConfigModel, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretAccessKey), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+config.AccessKeyId+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
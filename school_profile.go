@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SchoolAddressModel struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	Country    string `json:"country"`
+	PostalCode string `json:"postalCode"`
+}
+
+type SchoolProfileModel struct {
+	SchoolId          string             `json:"schoolId"`
+	SchoolName        string             `json:"schoolName"`
+	StudentCount      int                `json:"studentsCount"`
+	StaffCount        int                `json:"staffCount"`
+	SchoolType        string             `json:"schoolType"`
+	SchoolBoard       string             `json:"schoolBoard"`
+	Address           SchoolAddressModel `json:"address"`
+	LogoPath          string             `json:"logoPath"`
+	LogoThumbnailPath string             `json:"logoThumbnailPath"`
+	UpdatedAt         string             `json:"updatedAt"`
+}
+
+const (
+	maxLogoSize      = 2 << 20
+	maxLogoDimension = 4096
+	logoThumbnailMax = 128
+)
+
+type schoolProfileStore struct {
+	sync.RWMutex
+	profiles map[string]*SchoolProfileModel
+}
+
+var schoolProfiles = &schoolProfileStore{profiles: map[string]*SchoolProfileModel{}}
+
+func (s *schoolProfileStore) get(schoolId string) (SchoolProfileModel, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	profile, ok := s.profiles[schoolId]
+	if !ok {
+		return SchoolProfileModel{}, false
+	}
+	return *profile, true
+}
+
+// validateAddress checks a structured address against the geography served
+// by the /country endpoints and rewrites country, state and city to their
+// canonical names, so codes and former names are accepted. field prefixes
+// the error messages.
+func validateAddress(field string, address *SchoolAddressModel) []string {
+	var errs []string
+	if strings.TrimSpace(address.Line1) == "" {
+		errs = append(errs, field+".line1 is required")
+	}
+	country, ok := geography.country(address.Country)
+	if !ok {
+		return append(errs, fmt.Sprintf("%s.country %q is not a known country", field, address.Country))
+	}
+	address.Country = country.Name
+	state, ok := country.subdivision(address.State)
+	if !ok {
+		return append(errs, fmt.Sprintf("%s.state %q is not a state of %s", field, address.State, country.Name))
+	}
+	address.State = state.Name
+	if len(state.Cities) == 0 {
+		// Cities are only listed for some countries
+		if address.City = strings.TrimSpace(address.City); address.City == "" {
+			errs = append(errs, field+".city is required")
+		}
+	} else if city, ok := state.city(address.City); ok {
+		address.City = city
+	} else {
+		errs = append(errs, fmt.Sprintf("%s.city %q is not a city of %s", field, address.City, state.Name))
+	}
+	address.PostalCode = strings.ToUpper(strings.TrimSpace(address.PostalCode))
+	if !country.validPostalCode(address.PostalCode) {
+		return append(errs, fmt.Sprintf("%s.postalCode %q is not a valid postal code for %s", field, address.PostalCode, country.Name))
+	}
+	if resolved, ok := postalCodes.resolve(country, address.PostalCode); ok {
+		var names []string
+		for _, candidate := range resolved.States {
+			if candidate.Code == state.Code {
+				return errs
+			}
+			names = append(names, candidate.Name)
+		}
+		errs = append(errs, fmt.Sprintf("%s.postalCode %s belongs to %s, not %s", field, address.PostalCode, strings.Join(names, " or "), state.Name))
+	}
+	return errs
+}
+
+// formatAddress renders an address on one line, e.g. for the student
+// information page.
+func formatAddress(address SchoolAddressModel) string {
+	var parts []string
+	for _, part := range []string{address.Line1, address.Line2, address.City, address.State + " " + address.PostalCode, address.Country} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func parseCount(field, value string) (int, []string) {
+	count, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || count < 1 {
+		return 0, []string{field + " must be a positive whole number"}
+	}
+	return count, nil
+}
+
+// storeSchoolLogo checks the uploaded logo and stores it together with a
+// thumbnail, returning the public paths of both.
+func storeSchoolLogo(schoolId string, logo []byte) (string, string, []string) {
+	if len(logo) > maxLogoSize {
+		return "", "", []string{"schoolLogo must be at most 2 MB"}
+	}
+	contentType := http.DetectContentType(logo)
+	if contentType != "image/png" && contentType != "image/jpeg" {
+		return "", "", []string{"schoolLogo must be a PNG or JPEG image"}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(logo))
+	if err != nil {
+		return "", "", []string{"schoolLogo could not be read as an image"}
+	}
+	if config.Width > maxLogoDimension || config.Height > maxLogoDimension {
+		return "", "", []string{fmt.Sprintf("schoolLogo must be at most %dx%d pixels", maxLogoDimension, maxLogoDimension)}
+	}
+	img, _, err := image.Decode(bytes.NewReader(logo))
+	if err != nil {
+		return "", "", []string{"schoolLogo could not be read as an image"}
+	}
+
+	width, height := fitWithin(config.Width, config.Height, logoThumbnailMax, logoThumbnailMax)
+	var thumbnail bytes.Buffer
+	if err := png.Encode(&thumbnail, resizeImage(img, width, height)); err != nil {
+		return "", "", []string{"Failed to create the logo thumbnail"}
+	}
+
+	version := time.Now().UnixNano()
+	ext := map[string]string{"image/png": "png", "image/jpeg": "jpg"}[contentType]
+	logoKey := fmt.Sprintf("schools/%s/logo-%d.%s", schoolId, version, ext)
+	thumbKey := fmt.Sprintf("schools/%s/logo-%d-thumb.png", schoolId, version)
+	if _, err := blobs.Put(logoKey, contentType, logo); err != nil {
+		return "", "", []string{"Failed to store schoolLogo"}
+	}
+	if _, err := blobs.Put(thumbKey, "image/png", thumbnail.Bytes()); err != nil {
+		return "", "", []string{"Failed to store schoolLogo"}
+	}
+	return "/blob/" + logoKey, "/blob/" + thumbKey, nil
+}
+
+// saveSchoolProfile validates the basic information step and stores it.
+func saveSchoolProfile(schoolId string, req OnBoardingRequestDto) (SchoolProfileModel, []string) {
+	var errs []string
+	profile := SchoolProfileModel{
+		SchoolId:    schoolId,
+		SchoolName:  strings.Join(strings.Fields(req.SchoolName), " "),
+		SchoolType:  strings.TrimSpace(req.SchoolType),
+		SchoolBoard: strings.ToUpper(strings.TrimSpace(req.SchoolBoard)),
+	}
+	if profile.SchoolName == "" {
+		errs = append(errs, "schoolName is required")
+	}
+	var countErrs []string
+	profile.StudentCount, countErrs = parseCount("studentsCount", req.StudentCount)
+	errs = append(errs, countErrs...)
+	profile.StaffCount, countErrs = parseCount("staffCount", req.StaffCount)
+	errs = append(errs, countErrs...)
+	if boards := dropDownList(schoolId, "boards"); !containsValue(boards, profile.SchoolBoard) {
+		errs = append(errs, "schoolBoard must be one of "+strings.Join(boards, ", "))
+	}
+	if types := dropDownList(schoolId, "school_type"); !containsValue(types, profile.SchoolType) {
+		errs = append(errs, "schoolType must be one of "+strings.Join(types, ", "))
+	}
+	if req.Address == nil {
+		errs = append(errs, "address is required")
+	} else {
+		profile.Address = *req.Address
+		errs = append(errs, validateAddress("address", &profile.Address)...)
+	}
+	if len(errs) > 0 {
+		return SchoolProfileModel{}, errs
+	}
+
+	existing, hasExisting := schoolProfiles.get(schoolId)
+	if len(req.SchoolLogo) > 0 {
+		logoPath, thumbPath, logoErrs := storeSchoolLogo(schoolId, req.SchoolLogo)
+		if len(logoErrs) > 0 {
+			return SchoolProfileModel{}, logoErrs
+		}
+		profile.LogoPath, profile.LogoThumbnailPath = logoPath, thumbPath
+	} else if hasExisting {
+		profile.LogoPath, profile.LogoThumbnailPath = existing.LogoPath, existing.LogoThumbnailPath
+	}
+	profile.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	schoolProfiles.Lock()
+	previous := schoolProfiles.profiles[schoolId]
+	schoolProfiles.profiles[schoolId] = &profile
+	schoolProfiles.Unlock()
+
+	// A new logo supersedes the previous one, whose blobs nothing refers to
+	// any more
+	if previous != nil {
+		for _, path := range []string{previous.LogoPath, previous.LogoThumbnailPath} {
+			if path != "" && path != profile.LogoPath && path != profile.LogoThumbnailPath {
+				if err := blobs.Delete(strings.TrimPrefix(path, "/blob/")); err != nil {
+					log.Printf("school %s: deleting superseded logo %s: %v", schoolId, path, err)
+				}
+			}
+		}
+	}
+	return profile, nil
+}
+
+// applySchoolBranding replaces the app bar defaults with the school's saved
+// name and logo.
+func applySchoolBranding(appBar *AppBarData, schoolId string) {
+	profile, ok := schoolProfiles.get(schoolId)
+	if !ok {
+		return
+	}
+	appBar.SchoolName = profile.SchoolName
+	if profile.LogoThumbnailPath != "" {
+		appBar.ImagePath = profile.LogoThumbnailPath
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address SchoolAddressModel
+		want    SchoolAddressModel
+		wantErr string
+	}{
+		{
+			name:    "codes become names",
+			address: SchoolAddressModel{Line1: "1 MG Road", City: "bengaluru", State: "KA", Country: "IN", PostalCode: " 560001 "},
+			want:    SchoolAddressModel{Line1: "1 MG Road", City: "Bengaluru", State: "Karnataka", Country: "India", PostalCode: "560001"},
+		},
+		{
+			name:    "country without listed cities",
+			address: SchoolAddressModel{Line1: "1 Main St", City: "Springfield", State: "IL", Country: "US", PostalCode: "62701"},
+			want:    SchoolAddressModel{Line1: "1 Main St", City: "Springfield", State: "Illinois", Country: "United States", PostalCode: "62701"},
+		},
+		{name: "no line1", address: SchoolAddressModel{City: "Bengaluru", State: "KA", Country: "IN", PostalCode: "560001"}, wantErr: "address.line1 is required"},
+		{name: "unknown country", address: SchoolAddressModel{Line1: "1", Country: "Atlantis"}, wantErr: `address.country "Atlantis" is not a known country`},
+		{name: "state of another country", address: SchoolAddressModel{Line1: "1", State: "IL", Country: "IN"}, wantErr: `address.state "IL" is not a state of India`},
+		{name: "unknown city", address: SchoolAddressModel{Line1: "1", City: "Atlantis", State: "KA", Country: "IN", PostalCode: "560001"}, wantErr: `address.city "Atlantis" is not a city of Karnataka`},
+		{name: "malformed postal code", address: SchoolAddressModel{Line1: "1", City: "Bengaluru", State: "KA", Country: "IN", PostalCode: "5600"}, wantErr: "is not a valid postal code for India"},
+		{name: "postal code of another state", address: SchoolAddressModel{Line1: "1", City: "Bengaluru", State: "KA", Country: "IN", PostalCode: "110001"}, wantErr: "address.postalCode 110001 belongs to Delhi, not Karnataka"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := test.address
+			errs := validateAddress("address", &address)
+			if test.wantErr != "" {
+				if !strings.Contains(strings.Join(errs, "; "), test.wantErr) {
+					t.Errorf("errors = %v, want %q", errs, test.wantErr)
+				}
+				return
+			}
+			if len(errs) > 0 || address != test.want {
+				t.Errorf("address = %+v, %v; want %+v", address, errs, test.want)
+			}
+		})
+	}
+}
+
+func TestSaveSchoolProfileReplacesLogo(t *testing.T) {
+	resetDropDownMaster()
+	schoolProfiles = &schoolProfileStore{profiles: map[string]*SchoolProfileModel{}}
+	saved := blobs
+	blobs = newFileBlobStore(t.TempDir())
+	defer func() { blobs = saved }()
+
+	var logo bytes.Buffer
+	if err := png.Encode(&logo, image.NewNRGBA(image.Rect(0, 0, 512, 256))); err != nil {
+		t.Fatal(err)
+	}
+	req := OnBoardingRequestDto{
+		SchoolName: " Green   Valley School ", StudentCount: "450", StaffCount: "30", SchoolType: "Higher Secondary Education", SchoolBoard: "cbse",
+		Address:    &SchoolAddressModel{Line1: "1 MG Road", City: "Bengaluru", State: "KA", Country: "IN", PostalCode: "560001"},
+		SchoolLogo: logo.Bytes(),
+	}
+
+	first, errs := saveSchoolProfile("SCH-P", req)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if first.SchoolName != "Green Valley School" || first.SchoolBoard != "CBSE" || first.StudentCount != 450 || first.LogoPath == "" {
+		t.Errorf("profile = %+v", first)
+	}
+	thumbnail, _, err := blobs.Get(strings.TrimPrefix(first.LogoThumbnailPath, "/blob/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config, err := png.DecodeConfig(bytes.NewReader(thumbnail)); err != nil || config.Width != logoThumbnailMax || config.Height != logoThumbnailMax/2 {
+		t.Errorf("thumbnail = %+v, %v", config, err)
+	}
+
+	// Saving without a logo keeps it, a new one replaces its blobs
+	req.SchoolLogo = nil
+	if kept, _ := saveSchoolProfile("SCH-P", req); kept.LogoPath != first.LogoPath {
+		t.Errorf("logo = %s, want %s kept", kept.LogoPath, first.LogoPath)
+	}
+	req.SchoolLogo = logo.Bytes()
+	second, _ := saveSchoolProfile("SCH-P", req)
+	for _, path := range []string{first.LogoPath, first.LogoThumbnailPath} {
+		if _, _, err := blobs.Get(strings.TrimPrefix(path, "/blob/")); err == nil {
+			t.Errorf("superseded %s is still stored", path)
+		}
+	}
+	if _, _, err := blobs.Get(strings.TrimPrefix(second.LogoPath, "/blob/")); err != nil {
+		t.Errorf("new logo: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(*OnBoardingRequestDto)
+		wantErr string
+	}{
+		{"no name", func(r *OnBoardingRequestDto) { r.SchoolName = " " }, "schoolName is required"},
+		{"bad count", func(r *OnBoardingRequestDto) { r.StaffCount = "0" }, "staffCount must be a positive whole number"},
+		{"unknown board", func(r *OnBoardingRequestDto) { r.SchoolBoard = "IB" }, "schoolBoard must be one of"},
+		{"no address", func(r *OnBoardingRequestDto) { r.Address = nil }, "address is required"},
+		{"not an image", func(r *OnBoardingRequestDto) { r.SchoolLogo = []byte("GIF89a") }, "schoolLogo must be a PNG or JPEG image"},
+		{"logo too large", func(r *OnBoardingRequestDto) { r.SchoolLogo = make([]byte, maxLogoSize+1) }, "schoolLogo must be at most 2 MB"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invalid := req
+			address := *req.Address
+			invalid.Address = &address
+			test.change(&invalid)
+			if _, errs := saveSchoolProfile("SCH-P", invalid); !strings.Contains(strings.Join(errs, "; "), test.wantErr) {
+				t.Errorf("errors = %v, want %q", errs, test.wantErr)
+			}
+		})
+	}
+	if profile, _ := schoolProfiles.get("SCH-P"); profile.LogoPath != second.LogoPath {
+		t.Errorf("a rejected save replaced the profile: %+v", profile)
+	}
+}
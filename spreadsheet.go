@@ -0,0 +1,219 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSpreadsheetRows bounds the rows read from an upload, header
+	// included.
+	maxSpreadsheetRows = 10000
+	// maxSpreadsheetColumns is the last column of a worksheet, XFD.
+	maxSpreadsheetColumns = 16384
+)
+
+// readSpreadsheet returns the rows of a CSV file or of the first worksheet of
+// an XLSX workbook, chosen by the file extension.
+func readSpreadsheet(filename string, data []byte) ([][]string, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		var rows [][]string
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return rows, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(rows) == maxSpreadsheetRows {
+				return nil, fmt.Errorf("file has more than %d rows", maxSpreadsheetRows)
+			}
+			if len(row) > maxSpreadsheetColumns {
+				return nil, fmt.Errorf("row %d has more than %d columns", len(rows)+1, maxSpreadsheetColumns)
+			}
+			rows = append(rows, row)
+		}
+	case ".xlsx":
+		return readXLSX(data)
+	default:
+		return nil, fmt.Errorf("unsupported file type %q, upload a .csv or .xlsx file", path.Ext(filename))
+	}
+}
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelId string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Items []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				Text string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX reads cell values from the first worksheet. Only the parts of the
+// format produced by spreadsheet exports of tabular data are supported.
+func readXLSX(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %v", err)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	decode := func(name string, into interface{}) error {
+		file, ok := files[name]
+		if !ok {
+			return io.ErrUnexpectedEOF
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return xml.NewDecoder(io.LimitReader(rc, 32<<20)).Decode(into)
+	}
+
+	var shared xlsxSharedStrings
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, fmt.Errorf("invalid xlsx shared strings: %v", err)
+		}
+	}
+	// The first worksheet is the first sheet listed in the workbook, which
+	// need not be sheet1.xml once sheets have been reordered or deleted
+	var workbook xlsxWorkbook
+	if err := decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, fmt.Errorf("invalid xlsx workbook: %v", err)
+	}
+	var rels xlsxRelationships
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, fmt.Errorf("invalid xlsx workbook relationships: %v", err)
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("xlsx workbook has no sheets")
+	}
+	sheetPart := ""
+	for _, rel := range rels.Items {
+		if rel.Id == workbook.Sheets[0].RelId {
+			// Targets are relative to xl/ unless they start at the package root
+			if strings.HasPrefix(rel.Target, "/") {
+				sheetPart = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				sheetPart = path.Join("xl", rel.Target)
+			}
+		}
+	}
+	if sheetPart == "" {
+		return nil, fmt.Errorf("xlsx workbook has no part for its first sheet")
+	}
+	var sheet xlsxWorksheet
+	if err := decode(sheetPart, &sheet); err != nil {
+		return nil, fmt.Errorf("invalid xlsx worksheet: %v", err)
+	}
+
+	if len(sheet.Rows) > maxSpreadsheetRows {
+		return nil, fmt.Errorf("file has more than %d rows", maxSpreadsheetRows)
+	}
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var values []string
+		for _, cell := range row.Cells {
+			column := xlsxColumn(cell.Ref)
+			if column >= maxSpreadsheetColumns || len(values) >= maxSpreadsheetColumns {
+				return nil, fmt.Errorf("cell %s is beyond column XFD", cell.Ref)
+			}
+			for len(values) < column {
+				values = append(values, "")
+			}
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index >= len(shared.Items) {
+					return nil, fmt.Errorf("invalid shared string reference in %s", cell.Ref)
+				}
+				item := shared.Items[index]
+				value = item.Text
+				for _, run := range item.Runs {
+					value += run.Text
+				}
+			case "inlineStr":
+				value = cell.Inline.Text
+			}
+			values = append(values, strings.TrimSpace(value))
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
+
+// xlsxColumn converts the letters of a cell reference such as "C7" into a
+// zero based column index. Columns past XFD return maxSpreadsheetColumns.
+func xlsxColumn(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+		if column > maxSpreadsheetColumns {
+			return maxSpreadsheetColumns
+		}
+	}
+	return column - 1
+}
+
+// parseSpreadsheetDate accepts ISO, day-first and Excel serial dates.
+func parseSpreadsheetDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{dateLayout, "02/01/2006", "02-01-2006", "2/1/2006"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 && serial < 100000 {
+		// Excel counts days from 1899-12-30 once its leap year bug is accounted for
+		return time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(serial)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
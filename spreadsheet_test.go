@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildXLSX zips the given worksheet and shared strings XML into a minimal
+// workbook. An empty sharedStrings leaves the part out.
+func buildXLSX(t *testing.T, sheet, sharedStrings string) []byte {
+	t.Helper()
+	parts := map[string]string{
+		"xl/workbook.xml":            workbook("rId1"),
+		"xl/_rels/workbook.xml.rels": workbookRels(`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>`),
+		"xl/worksheets/sheet1.xml":   sheet,
+	}
+	if sharedStrings != "" {
+		parts["xl/sharedStrings.xml"] = sharedStrings
+	}
+	return zipParts(t, parts)
+}
+
+func zipParts(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("close workbook: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// workbook lists sheets by relationship id, in tab order.
+func workbook(relIds ...string) string {
+	var sheets string
+	for i, id := range relIds {
+		sheets += fmt.Sprintf(`<sheet name="Sheet%d" sheetId="%d" r:id="%s"/>`, i+1, i+1, id)
+	}
+	return `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + sheets + `</sheets></workbook>`
+}
+
+func workbookRels(relationships ...string) string {
+	return `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + strings.Join(relationships, "") + `</Relationships>`
+}
+
+func worksheet(rows ...string) string {
+	return `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + strings.Join(rows, "") + `</sheetData></worksheet>`
+}
+
+func TestReadSpreadsheetCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name: "ragged rows and quoting",
+			data: "Name,DOB\n\"Kumar, Ravi\", 2010-05-01\nAnu\n",
+			want: [][]string{{"Name", "DOB"}, {"Kumar, Ravi", "2010-05-01"}, {"Anu"}},
+		},
+		{name: "empty", data: "", want: nil},
+		{name: "bad quoting", data: "Name\n\"Ravi\n", wantErr: "extraneous or missing"},
+		{name: "too many rows", data: strings.Repeat("a\n", maxSpreadsheetRows+1), wantErr: "more than 10000 rows"},
+		{name: "row limit", data: strings.Repeat("a\n", maxSpreadsheetRows), want: nil},
+		{name: "too many columns", data: strings.Repeat(",", maxSpreadsheetColumns) + "\n", wantErr: "more than 16384 columns"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := readSpreadsheet("students.CSV", []byte(test.data))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readSpreadsheet: %v", err)
+			}
+			if test.name == "row limit" {
+				if len(rows) != maxSpreadsheetRows {
+					t.Errorf("got %d rows, want %d", len(rows), maxSpreadsheetRows)
+				}
+				return
+			}
+			if !reflect.DeepEqual(rows, test.want) {
+				t.Errorf("rows = %q, want %q", rows, test.want)
+			}
+		})
+	}
+}
+
+func TestReadSpreadsheetXLSX(t *testing.T) {
+	shared := `<sst><si><t>Name</t></si><si><t>DOB</t></si><si><r><t>Ravi </t></r><r><t>Kumar</t></r></si></sst>`
+	tests := []struct {
+		name    string
+		sheet   string
+		shared  string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name: "shared, inline and number cells with gaps",
+			sheet: worksheet(
+				`<row><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>`,
+				`<row><c r="A2" t="s"><v>2</v></c><c r="B2" t="inlineStr"><is><t> 12 </t></is></c><c r="C2"><v>40299</v></c></row>`,
+			),
+			shared: shared,
+			want:   [][]string{{"Name", "", "DOB"}, {"Ravi Kumar", "12", "40299"}},
+		},
+		{
+			name:   "last column XFD",
+			sheet:  worksheet(`<row><c r="XFD1" t="inlineStr"><is><t>x</t></is></c></row>`),
+			want:   [][]string{append(make([]string, maxSpreadsheetColumns-1), "x")},
+			shared: "",
+		},
+		{
+			name:    "column past XFD",
+			sheet:   worksheet(`<row><c r="XFE1"><v>1</v></c></row>`),
+			wantErr: "beyond column XFD",
+		},
+		{
+			name:    "huge column reference",
+			sheet:   worksheet(`<row><c r="ZZZZZZZZZZZZ1"><v>1</v></c></row>`),
+			wantErr: "beyond column XFD",
+		},
+		{
+			name:    "shared string out of range",
+			sheet:   worksheet(`<row><c r="A1" t="s"><v>7</v></c></row>`),
+			shared:  shared,
+			wantErr: "invalid shared string reference in A1",
+		},
+		{
+			name:    "too many rows",
+			sheet:   worksheet(strings.Repeat(`<row><c r="A1"><v>1</v></c></row>`, maxSpreadsheetRows+1)),
+			wantErr: "more than 10000 rows",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := readSpreadsheet("students.xlsx", buildXLSX(t, test.sheet, test.shared))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readSpreadsheet: %v", err)
+			}
+			if !reflect.DeepEqual(rows, test.want) {
+				t.Errorf("rows = %q, want %q", rows, test.want)
+			}
+		})
+	}
+}
+
+func TestReadSpreadsheetRejects(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     []byte
+		wantErr  string
+	}{
+		{"students.xls", []byte("x"), "unsupported file type"},
+		{"students", []byte("x"), "unsupported file type"},
+		{"students.xlsx", []byte("not a zip"), "invalid xlsx file"},
+		{"students.xlsx", buildXLSX(t, "", `<sst>`), "invalid xlsx shared strings"},
+		{"students.xlsx", zipParts(t, map[string]string{"xl/worksheets/sheet1.xml": worksheet()}), "invalid xlsx workbook"},
+		{"students.xlsx", zipParts(t, map[string]string{"xl/workbook.xml": workbook(), "xl/_rels/workbook.xml.rels": workbookRels()}), "no sheets"},
+		{"students.xlsx", zipParts(t, map[string]string{"xl/workbook.xml": workbook("rId9"), "xl/_rels/workbook.xml.rels": workbookRels()}), "no part for its first sheet"},
+	}
+	for _, test := range tests {
+		if _, err := readSpreadsheet(test.filename, test.data); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("readSpreadsheet(%s) error = %v, want %q", test.filename, err, test.wantErr)
+		}
+	}
+}
+
+func TestReadSpreadsheetFirstSheet(t *testing.T) {
+	// Sheet1 was deleted and the remaining sheets reordered, as Excel leaves
+	// them: the first tab is sheet3.xml
+	cell := func(text string) string {
+		return worksheet(`<row><c r="A1" t="inlineStr"><is><t>` + text + `</t></is></c></row>`)
+	}
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"relative target", "worksheets/sheet3.xml"},
+		{"absolute target", "/xl/worksheets/sheet3.xml"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := zipParts(t, map[string]string{
+				"xl/workbook.xml": workbook("rId3", "rId2"),
+				"xl/_rels/workbook.xml.rels": workbookRels(
+					`<Relationship Id="rId2" Target="worksheets/sheet2.xml"/>`,
+					`<Relationship Id="rId3" Target="`+test.target+`"/>`,
+				),
+				"xl/worksheets/sheet2.xml": cell("second"),
+				"xl/worksheets/sheet3.xml": cell("first"),
+			})
+			rows, err := readSpreadsheet("students.xlsx", data)
+			if err != nil || !reflect.DeepEqual(rows, [][]string{{"first"}}) {
+				t.Errorf("rows = %q, %v; want the first tab", rows, err)
+			}
+		})
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"A1", 0},
+		{"Z9", 25},
+		{"AA10", 26},
+		{"XFD1", maxSpreadsheetColumns - 1},
+		{"XFE1", maxSpreadsheetColumns},
+		{strings.Repeat("Z", 40) + "1", maxSpreadsheetColumns},
+		{"", -1},
+	}
+	for _, test := range tests {
+		if got := xlsxColumn(test.ref); got != test.want {
+			t.Errorf("xlsxColumn(%q) = %d, want %d", test.ref, got, test.want)
+		}
+	}
+}
+
+func TestParseSpreadsheetDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "2010-05-01", want: "2010-05-01"},
+		{in: " 01/05/2010 ", want: "2010-05-01"},
+		{in: "01-05-2010", want: "2010-05-01"},
+		{in: "1/5/2010", want: "2010-05-01"},
+		{in: "40299", want: "2010-05-01"},
+		{in: "40299.5", want: "2010-05-01"},
+		{in: "05/13/2010", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "100000", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, test := range tests {
+		date, err := parseSpreadsheetDate(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseSpreadsheetDate(%q) error = %v, wantErr %v", test.in, err, test.wantErr)
+			continue
+		}
+		if err == nil && date.Format(dateLayout) != test.want {
+			t.Errorf("parseSpreadsheetDate(%q) = %s, want %s", test.in, date.Format(dateLayout), test.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	accountRelationSelf   = "SELF"
+	accountRelationParent = "PARENT"
+)
+
+// StudentAccountLinkModel connects a login to a student it may act for,
+// either the student's own account or a parent's.
+type StudentAccountLinkModel struct {
+	Username  string `json:"username"`
+	StudentId string `json:"studentId"`
+	Relation  string `json:"relation"`
+	LinkedBy  string `json:"linkedBy"`
+	LinkedAt  string `json:"linkedAt"`
+}
+
+type studentAccountStore struct {
+	sync.RWMutex
+	links map[string]map[string][]StudentAccountLinkModel
+}
+
+var studentAccounts = &studentAccountStore{links: map[string]map[string][]StudentAccountLinkModel{}}
+
+// school returns the links of a school by username. Callers must hold the
+// lock.
+func (s *studentAccountStore) school(schoolId string) map[string][]StudentAccountLinkModel {
+	links, ok := s.links[schoolId]
+	if !ok {
+		links = map[string][]StudentAccountLinkModel{}
+		s.links[schoolId] = links
+	}
+	return links
+}
+
+// linked returns the students a login may act for.
+func (s *studentAccountStore) linked(schoolId, username string) []StudentAccountLinkModel {
+	s.RLock()
+	defer s.RUnlock()
+	return append([]StudentAccountLinkModel{}, s.links[schoolId][username]...)
+}
+
+// canActFor reports whether the login is the student or one of its parents.
+func (s *studentAccountStore) canActFor(schoolId, username, studentId string) bool {
+	for _, link := range s.linked(schoolId, username) {
+		if link.StudentId == studentId {
+			return true
+		}
+	}
+	return false
+}
+
+// canViewStudent reports whether the caller is staff or may act for the
+// student.
+func canViewStudent(claims jwt.MapClaims, studentId string) bool {
+	role := claimString(claims, "user_role")
+	return role == roleAdmin || role == roleTeacher || studentAccounts.canActFor(schoolID(claims), claimString(claims, "id"), studentId)
+}
+
+// StudentAccountLinkHandler links a login to a student. Relation is SELF for
+// the student's own login or PARENT.
+func StudentAccountLinkHandler(c echo.Context) error {
+	var req StudentAccountLinkModel
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.Relation = strings.ToUpper(strings.TrimSpace(req.Relation))
+	var errs []string
+	if req.Username == "" {
+		errs = append(errs, "username is required")
+	} else if roleForUsername(req.Username) != roleStudent {
+		errs = append(errs, "username "+req.Username+" is a staff login")
+	}
+	if _, ok := students.get(schoolID(claims), req.StudentId); !ok {
+		errs = append(errs, "studentId "+req.StudentId+" not found")
+	}
+	if req.Relation != accountRelationSelf && req.Relation != accountRelationParent {
+		errs = append(errs, "relation must be SELF or PARENT")
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid account link", errs...))
+	}
+
+	schoolId := schoolID(claims)
+	studentAccounts.Lock()
+	defer studentAccounts.Unlock()
+	links := studentAccounts.school(schoolId)
+	for _, userLinks := range links {
+		for _, link := range userLinks {
+			if req.Relation == accountRelationSelf && link.StudentId == req.StudentId && link.Relation == accountRelationSelf && link.Username != req.Username {
+				return c.JSON(http.StatusConflict, failed("Student "+req.StudentId+" already has its own login "+link.Username))
+			}
+		}
+	}
+	for _, link := range links[req.Username] {
+		if link.StudentId == req.StudentId {
+			return c.JSON(http.StatusConflict, failed(req.Username+" is already linked to "+req.StudentId))
+		}
+		if req.Relation == accountRelationSelf || link.Relation == accountRelationSelf {
+			return c.JSON(http.StatusConflict, failed("A student's own login cannot be linked to other students"))
+		}
+	}
+	req.LinkedBy = claimString(claims, "id")
+	req.LinkedAt = time.Now().Format(time.RFC3339)
+	links[req.Username] = append(links[req.Username], req)
+	return c.JSON(http.StatusOK, success(req))
+}
+
+// StudentAccountsHandler lists the logins linked to a student.
+func StudentAccountsHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	studentId := c.QueryParam("studentId")
+	studentAccounts.RLock()
+	defer studentAccounts.RUnlock()
+	result := []StudentAccountLinkModel{}
+	for _, links := range studentAccounts.links[schoolID(claims)] {
+		for _, link := range links {
+			if studentId == "" || link.StudentId == studentId {
+				result = append(result, link)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].StudentId != result[j].StudentId {
+			return result[i].StudentId < result[j].StudentId
+		}
+		return result[i].Username < result[j].Username
+	})
+	return c.JSON(http.StatusOK, success(result))
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type StudentModel struct {
+	Id              string `json:"id"`
+	AdmissionNumber string `json:"admissionNumber"`
+	Name            string `json:"name"`
+	DateOfBirth     string `json:"dateOfBirth"`
+	DateOfAdmission string `json:"dateOfAdmission"`
+	Gender          string `json:"gender"`
+	Religion        string `json:"religion"`
+	Caste           string `json:"caste"`
+	AdmissionType   string `json:"admissionType"`
+	FatherName      string `json:"fatherName"`
+	MotherName      string `json:"motherName"`
+	Address         string `json:"address"`
+	// AddressDetails is the structured address; when given it is validated
+	// and Address is rendered from it.
+	AddressDetails *SchoolAddressModel `json:"addressDetails,omitempty"`
+	ClassName      string              `json:"className"`
+	Section        string              `json:"section"`
+}
+
+type StudentImportRowResult struct {
+	Row             int      `json:"row"`
+	Name            string   `json:"name"`
+	Status          string   `json:"status"`
+	AdmissionNumber string   `json:"admissionNumber,omitempty"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+type StudentImportResult struct {
+	DryRun    bool                     `json:"dryRun"`
+	Total     int                      `json:"total"`
+	Created   int                      `json:"created"`
+	Duplicate int                      `json:"duplicate"`
+	Invalid   int                      `json:"invalid"`
+	Rows      []StudentImportRowResult `json:"rows"`
+}
+
+const (
+	importCreated   = "CREATED"
+	importValid     = "VALID"
+	importDuplicate = "DUPLICATE"
+	importInvalid   = "INVALID"
+)
+
+// maxImportFileSize bounds uploaded spreadsheets.
+const maxImportFileSize = 5 << 20
+
+// studentImportColumns lists the template columns in order. Headers are
+// matched case-insensitively ignoring spaces and underscores.
+var studentImportColumns = []string{
+	"Name", "DOB", "DOA", "Gender", "Religion", "Caste", "Admission Type",
+	"Father Name", "Mother Name", "Address", "Class", "Section",
+}
+
+var studentImportAliases = map[string]string{
+	"name": "Name", "studentname": "Name",
+	"dob": "DOB", "dateofbirth": "DOB",
+	"doa": "DOA", "dateofadmission": "DOA",
+	"gender": "Gender", "religion": "Religion", "caste": "Caste",
+	"admissiontype": "Admission Type", "studenttype": "Admission Type",
+	"fathername": "Father Name", "mothername": "Mother Name",
+	"address": "Address", "class": "Class", "classname": "Class", "section": "Section",
+}
+
+type schoolStudents struct {
+	students      map[string]*StudentModel
+	order         []string
+	lastAdmission int
+}
+
+type studentStore struct {
+	sync.RWMutex
+	schools map[string]*schoolStudents
+}
+
+var students = &studentStore{schools: map[string]*schoolStudents{}}
+
+// school returns the school's students. Admission numbers continue the
+// school's existing sequence (000248 is the last number issued before
+// onboarding). Callers must hold the lock.
+func (s *studentStore) school(schoolId string) *schoolStudents {
+	if school, ok := s.schools[schoolId]; ok {
+		return school
+	}
+	school := &schoolStudents{students: map[string]*StudentModel{}, lastAdmission: 248}
+	s.schools[schoolId] = school
+	return school
+}
+
+func studentDuplicateKey(student StudentModel) string {
+	return strings.ToLower(strings.Join(strings.Fields(student.Name), " ")) + "|" +
+		student.DateOfBirth + "|" +
+		strings.ToLower(strings.Join(strings.Fields(student.FatherName), " "))
+}
+
+// findDuplicate returns the stored student matching name, date of birth and
+// father's name. Callers must hold the lock.
+func (s *schoolStudents) findDuplicate(student StudentModel) (*StudentModel, bool) {
+	key := studentDuplicateKey(student)
+	for _, existing := range s.students {
+		if studentDuplicateKey(*existing) == key {
+			return existing, true
+		}
+	}
+	return nil, false
+}
+
+// insert assigns the id and admission number. Callers must hold the lock.
+func (s *schoolStudents) insert(student StudentModel) StudentModel {
+	s.lastAdmission++
+	student.Id = newID("STU")
+	student.AdmissionNumber = fmt.Sprintf("%06d", s.lastAdmission)
+	s.students[student.Id] = &student
+	s.order = append(s.order, student.Id)
+	return student
+}
+
+// get returns a student of the school.
+func (s *studentStore) get(schoolId, id string) (StudentModel, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	school, ok := s.schools[schoolId]
+	if !ok {
+		return StudentModel{}, false
+	}
+	student, ok := school.students[id]
+	if !ok {
+		return StudentModel{}, false
+	}
+	return *student, true
+}
+
+// dropDownList returns a flat dropdown such as "gender" or "religion".
+func dropDownList(schoolId, key string) []string {
+	dropDowns, _ := fillDropDownData(schoolId)
+	return dropDownValues(dropDowns, key)
+}
+
+// dropDownValues returns a flat dropdown from already filled dropdown data.
+func dropDownValues(dropDowns map[string]interface{}, key string) []string {
+	values, _ := dropDowns[key].([]string)
+	return values
+}
+
+// dropDownDependent returns a dropdown keyed by a parent value, such as the
+// "sections" of a class.
+func dropDownDependent(dropDowns map[string]interface{}, key, parent string) []string {
+	values, _ := dropDowns[key].(map[string][]string)
+	return values[parent]
+}
+
+func containsValue(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeStudent trims and upper-cases coded fields, converts dates to
+// YYYY-MM-DD and validates everything against the school's dropdown data,
+// which callers fill once per request so imports do not rebuild it per row.
+func normalizeStudent(dropDowns map[string]interface{}, student StudentModel) (StudentModel, []string) {
+	var errs []string
+	student.Name = strings.Join(strings.Fields(student.Name), " ")
+	student.FatherName = strings.Join(strings.Fields(student.FatherName), " ")
+	student.MotherName = strings.Join(strings.Fields(student.MotherName), " ")
+	student.Address = strings.TrimSpace(student.Address)
+	if student.AddressDetails != nil {
+		details := *student.AddressDetails
+		if addressErrs := validateAddress("addressDetails", &details); len(addressErrs) > 0 {
+			errs = append(errs, addressErrs...)
+		} else {
+			student.Address = formatAddress(details)
+		}
+		student.AddressDetails = &details
+	}
+	student.Gender = strings.ToUpper(strings.TrimSpace(student.Gender))
+	student.Religion = strings.ToUpper(strings.TrimSpace(student.Religion))
+	student.Caste = strings.ToUpper(strings.TrimSpace(student.Caste))
+	student.AdmissionType = strings.ToUpper(strings.TrimSpace(student.AdmissionType))
+	student.ClassName = strings.TrimSpace(student.ClassName)
+	student.Section = strings.ToUpper(strings.TrimSpace(student.Section))
+
+	if student.Name == "" {
+		errs = append(errs, "Name is required")
+	}
+	if student.FatherName == "" && student.MotherName == "" {
+		errs = append(errs, "Father Name or Mother Name is required")
+	}
+
+	dob, err := parseSpreadsheetDate(student.DateOfBirth)
+	if err != nil {
+		errs = append(errs, "DOB: "+err.Error())
+	} else {
+		student.DateOfBirth = dob.Format(dateLayout)
+	}
+	if student.DateOfAdmission == "" {
+		student.DateOfAdmission = time.Now().Format(dateLayout)
+	}
+	doa, err := parseSpreadsheetDate(student.DateOfAdmission)
+	if err != nil {
+		errs = append(errs, "DOA: "+err.Error())
+	} else {
+		student.DateOfAdmission = doa.Format(dateLayout)
+	}
+	if !dob.IsZero() && !doa.IsZero() && !dob.Before(doa) {
+		errs = append(errs, "DOB must be before DOA")
+	}
+
+	checks := []struct{ field, key, value string }{
+		{"Gender", "gender", student.Gender},
+		{"Religion", "religion", student.Religion},
+		{"Caste", "caste", student.Caste},
+		{"Admission Type", "admissionType", student.AdmissionType},
+		{"Class", "classes", student.ClassName},
+	}
+	for _, check := range checks {
+		if allowed := dropDownValues(dropDowns, check.key); !containsValue(allowed, check.value) {
+			errs = append(errs, fmt.Sprintf("%s %q must be one of %s", check.field, check.value, strings.Join(allowed, ", ")))
+		}
+	}
+	if sections := dropDownDependent(dropDowns, "sections", student.ClassName); len(sections) > 0 && !containsValue(sections, student.Section) {
+		errs = append(errs, fmt.Sprintf("Section %q must be one of %s for class %s", student.Section, strings.Join(sections, ", "), student.ClassName))
+	}
+	return student, errs
+}
+
+// studentFromRow maps a spreadsheet row onto a student using the header.
+func studentFromRow(header map[string]int, row []string) StudentModel {
+	cell := func(column string) string {
+		index, ok := header[column]
+		if !ok || index >= len(row) {
+			return ""
+		}
+		return row[index]
+	}
+	return StudentModel{
+		Name:            cell("Name"),
+		DateOfBirth:     cell("DOB"),
+		DateOfAdmission: cell("DOA"),
+		Gender:          cell("Gender"),
+		Religion:        cell("Religion"),
+		Caste:           cell("Caste"),
+		AdmissionType:   cell("Admission Type"),
+		FatherName:      cell("Father Name"),
+		MotherName:      cell("Mother Name"),
+		Address:         cell("Address"),
+		ClassName:       cell("Class"),
+		Section:         cell("Section"),
+	}
+}
+
+// StudentOnboardHandler adds a single student.
+func StudentOnboardHandler(c echo.Context) error {
+	var req StudentModel
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	if blocked := onboardingProgress.blockedBy(schoolID(claims), onboardingStepStudents); len(blocked) > 0 {
+		return c.JSON(http.StatusConflict, failed("Complete the earlier onboarding steps first", blocked...))
+	}
+
+	dropDowns, _ := fillDropDownData(schoolID(claims))
+	student, errs := normalizeStudent(dropDowns, req)
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid student data", errs...))
+	}
+	students.Lock()
+	defer students.Unlock()
+	school := students.school(schoolID(claims))
+	if existing, ok := school.findDuplicate(student); ok {
+		return c.JSON(http.StatusConflict, failed("Student already exists with admission number "+existing.AdmissionNumber))
+	}
+	created := school.insert(student)
+	onboardingProgress.complete(schoolID(claims), onboardingStepStudents)
+	return c.JSON(http.StatusOK, success(created))
+}
+
+// StudentImportHandler bulk imports students from an uploaded CSV or XLSX
+// file. With dryRun=true rows are only validated. Invalid and duplicate rows
+// are reported and skipped; the remaining rows are imported.
+func StudentImportHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	if blocked := onboardingProgress.blockedBy(schoolID(claims), onboardingStepStudents); len(blocked) > 0 {
+		return c.JSON(http.StatusConflict, failed("Complete the earlier onboarding steps first", blocked...))
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("file is required"))
+	}
+	if fileHeader.Size > maxImportFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, failed("file must be at most 5 MB"))
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("Unable to read file"))
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxImportFileSize))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("Unable to read file"))
+	}
+	rows, err := readSpreadsheet(fileHeader.Filename, data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, failed("Unable to parse file", err.Error()))
+	}
+	if len(rows) < 2 {
+		return c.JSON(http.StatusBadRequest, failed("file must contain a header row and at least one student"))
+	}
+
+	header := map[string]int{}
+	for i, title := range rows[0] {
+		normalized := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(title)))
+		if column, ok := studentImportAliases[normalized]; ok {
+			header[column] = i
+		}
+	}
+	var missing []string
+	for _, column := range studentImportColumns {
+		if _, ok := header[column]; !ok && column != "DOA" && column != "Mother Name" && column != "Address" {
+			missing = append(missing, "Missing column "+column)
+		}
+	}
+	if len(missing) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid header row", missing...))
+	}
+
+	result := StudentImportResult{DryRun: c.QueryParam("dryRun") == "true" || c.FormValue("dryRun") == "true"}
+
+	// Normalize outside the store lock: it reads the dropdown master and the
+	// transport routes, which take their own locks.
+	type importRow struct {
+		number  int
+		student StudentModel
+		errs    []string
+	}
+	var parsed []importRow
+	dropDowns, _ := fillDropDownData(schoolID(claims))
+	for i, row := range rows[1:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		student, errs := normalizeStudent(dropDowns, studentFromRow(header, row))
+		parsed = append(parsed, importRow{number: i + 2, student: student, errs: errs})
+	}
+
+	seen := map[string]int{}
+	students.Lock()
+	defer students.Unlock()
+	school := students.school(schoolID(claims))
+	for _, row := range parsed {
+		result.Total++
+		student := row.student
+		rowResult := StudentImportRowResult{Row: row.number, Name: student.Name}
+		key := studentDuplicateKey(student)
+
+		switch {
+		case len(row.errs) > 0:
+			rowResult.Status = importInvalid
+			rowResult.Errors = row.errs
+			result.Invalid++
+		case seen[key] != 0:
+			rowResult.Status = importDuplicate
+			rowResult.Errors = []string{fmt.Sprintf("Same student as row %d", seen[key])}
+			result.Duplicate++
+		default:
+			if existing, ok := school.findDuplicate(student); ok {
+				rowResult.Status = importDuplicate
+				rowResult.AdmissionNumber = existing.AdmissionNumber
+				rowResult.Errors = []string{"Already exists with admission number " + existing.AdmissionNumber}
+				result.Duplicate++
+				break
+			}
+			seen[key] = row.number
+			if result.DryRun {
+				rowResult.Status = importValid
+			} else {
+				rowResult.Status = importCreated
+				rowResult.AdmissionNumber = school.insert(student).AdmissionNumber
+				result.Created++
+			}
+		}
+		result.Rows = append(result.Rows, rowResult)
+	}
+	if result.Created > 0 {
+		onboardingProgress.complete(schoolID(claims), onboardingStepStudents)
+	}
+	return c.JSON(http.StatusOK, success(result))
+}
+
+// StudentImportTemplateHandler downloads an empty CSV with the expected
+// header row.
+func StudentImportTemplateHandler(c echo.Context) error {
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="student-import-template.csv"`)
+	return c.Blob(http.StatusOK, "text/csv", []byte(strings.Join(studentImportColumns, ",")+"\n"))
+}
+
+// StudentListHandler lists students, optionally filtered by class and
+// section.
+func StudentListHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	className, section := c.QueryParam("class"), strings.ToUpper(c.QueryParam("section"))
+	students.RLock()
+	defer students.RUnlock()
+	list := []StudentModel{}
+	school := students.schools[schoolID(claims)]
+	if school == nil {
+		return c.JSON(http.StatusOK, success(list))
+	}
+	for _, id := range school.order {
+		student := school.students[id]
+		if (className == "" || student.ClassName == className) && (section == "" || student.Section == section) {
+			list = append(list, *student)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].AdmissionNumber < list[j].AdmissionNumber })
+	return c.JSON(http.StatusOK, success(list))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func resetStudents() {
+	students = &studentStore{schools: map[string]*schoolStudents{}}
+}
+
+// completeOnboardingBefore marks the steps a step requires as done.
+func completeOnboardingBefore(schoolId, step string) {
+	for _, candidate := range onboardingSteps {
+		if candidate.Key == step {
+			for _, required := range candidate.Requires {
+				onboardingProgress.complete(schoolId, required)
+			}
+		}
+	}
+}
+
+func importStudents(t *testing.T, token, filename string, data []byte, dryRun bool) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	if dryRun {
+		form.WriteField("dryRun", "true")
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/onboard-student-import", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	if err := StudentImportHandler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestStudentImport(t *testing.T) {
+	resetDropDownMaster()
+	resetStudents()
+	completeOnboardingBefore(defaultSchoolID, onboardingStepStudents)
+	admin := testToken(t, "admin", roleAdmin, "")
+
+	csv := []byte("Name,DOB,Gender,Religion,Caste,Admission Type,Father Name,Class,Section\n" +
+		"Ravi Kumar,01/05/2010,male,hindu,general,new,Suresh Kumar,12,a\n" +
+		"ravi  kumar,2010-05-01,MALE,HINDU,GENERAL,NEW,suresh kumar,12,A\n" +
+		",2010-05-01,MALE,HINDU,GENERAL,NEW,Suresh Kumar,12,A\n" +
+		"Anu Das,2011-02-03,FEMALE,HINDU,SC,OLD,Mohan Das,12,Z\n" +
+		",,,,,,,,\n" +
+		"Anu Das,2011-02-03,FEMALE,HINDU,SC,OLD,Mohan Das,12,B\n")
+
+	tests := []struct {
+		name   string
+		dryRun bool
+		want   StudentImportResult
+		status []string
+	}{
+		{"dry run", true, StudentImportResult{DryRun: true, Total: 5, Created: 0, Duplicate: 1, Invalid: 2},
+			[]string{importValid, importDuplicate, importInvalid, importInvalid, importValid}},
+		{"import", false, StudentImportResult{Total: 5, Created: 2, Duplicate: 1, Invalid: 2},
+			[]string{importCreated, importDuplicate, importInvalid, importInvalid, importCreated}},
+		{"re-import", false, StudentImportResult{Total: 5, Created: 0, Duplicate: 3, Invalid: 2},
+			[]string{importDuplicate, importDuplicate, importInvalid, importInvalid, importDuplicate}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var result StudentImportResult
+			decodeData(t, importStudents(t, admin, "students.csv", csv, test.dryRun), http.StatusOK, &result)
+			if result.Total != test.want.Total || result.Created != test.want.Created || result.Duplicate != test.want.Duplicate ||
+				result.Invalid != test.want.Invalid || result.DryRun != test.want.DryRun {
+				t.Errorf("result = %+v, want %+v", result, test.want)
+			}
+			var status []string
+			for _, row := range result.Rows {
+				status = append(status, row.Status)
+			}
+			if len(status) != len(test.status) {
+				t.Fatalf("row statuses = %v, want %v", status, test.status)
+			}
+			for i := range status {
+				if status[i] != test.status[i] {
+					t.Errorf("row %d status = %s, want %s", result.Rows[i].Row, status[i], test.status[i])
+				}
+			}
+		})
+	}
+
+	school := students.schools[defaultSchoolID]
+	if len(school.order) != 2 || school.students[school.order[0]].AdmissionNumber != "000249" {
+		t.Errorf("stored %d students starting at %+v", len(school.order), school.students[school.order[0]])
+	}
+}
+
+func TestStudentImportRejectsFiles(t *testing.T) {
+	resetDropDownMaster()
+	resetStudents()
+	completeOnboardingBefore(defaultSchoolID, onboardingStepStudents)
+	admin := testToken(t, "admin", roleAdmin, "")
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+		status   int
+	}{
+		{"unsupported type", "students.txt", "Name\n", http.StatusBadRequest},
+		{"header only", "students.csv", "Name,DOB\n", http.StatusBadRequest},
+		{"missing columns", "students.csv", "Name,DOB\nRavi,2010-05-01\n", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decodeData(t, importStudents(t, admin, test.filename, []byte(test.data), false), test.status, nil)
+		})
+	}
+	if rec := importStudents(t, testToken(t, "teacher1@mail.com", roleTeacher, ""), "students.csv", []byte("Name\n"), false); rec.Code != http.StatusForbidden {
+		t.Errorf("teacher import status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestStudentStoreKeyedBySchool(t *testing.T) {
+	resetStudents()
+	student := StudentModel{Name: "Ravi Kumar", DateOfBirth: "2010-05-01", FatherName: "Suresh Kumar"}
+
+	students.Lock()
+	first := students.school("SCH-A").insert(student)
+	_, duplicateElsewhere := students.school("SCH-B").findDuplicate(student)
+	second := students.school("SCH-B").insert(student)
+	students.Unlock()
+
+	if duplicateElsewhere {
+		t.Error("a student of another school counts as a duplicate")
+	}
+	if first.AdmissionNumber != "000249" || second.AdmissionNumber != "000249" {
+		t.Errorf("admission numbers = %s, %s, want each school's sequence", first.AdmissionNumber, second.AdmissionNumber)
+	}
+	if _, ok := students.get("SCH-A", second.Id); ok {
+		t.Error("a student is visible to another school")
+	}
+	if got, ok := students.get("SCH-B", second.Id); !ok || got.Name != student.Name {
+		t.Errorf("get = %+v, %v", got, ok)
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const dateLayout = "2006-01-02"
+
+// maxLeaveDays bounds a single leave request; longer absences are filed as
+// several requests.
+const maxLeaveDays = 90
+
+type TeacherModel struct {
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Subjects []string `json:"subjects"`
+}
+
+// WeeklyPeriodModel is one slot of the recurring weekly timetable.
+type WeeklyPeriodModel struct {
+	ClassSection string `json:"classSection"`
+	Weekday      string `json:"weekday"`
+	Period       string `json:"period"`
+	Subject      string `json:"subject"`
+	TeacherId    string `json:"teacherId"`
+	StartTime    string `json:"startTime"`
+	EndTime      string `json:"endTime"`
+}
+
+type TeacherLeaveRequestDto struct {
+	TeacherId string `json:"teacherId"`
+	FromDate  string `json:"fromDate"`
+	ToDate    string `json:"toDate"`
+	Reason    string `json:"reason"`
+}
+
+type TeacherLeaveDecisionDto struct {
+	LeaveId string `json:"leaveId"`
+	Approve bool   `json:"approve"`
+	Remarks string `json:"remarks"`
+}
+
+type SubstituteConfirmDto struct {
+	LeaveId        string `json:"leaveId"`
+	SubstitutionId string `json:"substitutionId"`
+	TeacherId      string `json:"teacherId"`
+}
+
+type SubstituteCandidate struct {
+	TeacherId      string `json:"teacherId"`
+	Name           string `json:"name"`
+	SubjectMatch   bool   `json:"subjectMatch"`
+	PeriodsThatDay int    `json:"periodsThatDay"`
+}
+
+type SubstitutionModel struct {
+	Id                    string                `json:"id"`
+	Date                  string                `json:"date"`
+	Period                string                `json:"period"`
+	ClassSection          string                `json:"classSection"`
+	Subject               string                `json:"subject"`
+	StartTime             string                `json:"startTime"`
+	EndTime               string                `json:"endTime"`
+	Suggestions           []SubstituteCandidate `json:"suggestions"`
+	SubstituteTeacherId   string                `json:"substituteTeacherId,omitempty"`
+	SubstituteTeacherName string                `json:"substituteTeacherName,omitempty"`
+	Status                string                `json:"status"`
+}
+
+type TeacherLeaveModel struct {
+	Id            string              `json:"id"`
+	TeacherId     string              `json:"teacherId"`
+	TeacherName   string              `json:"teacherName"`
+	RequestDate   string              `json:"requestDate"`
+	FromDate      string              `json:"fromDate"`
+	ToDate        string              `json:"toDate"`
+	Reason        string              `json:"reason"`
+	Status        string              `json:"status"`
+	Remarks       string              `json:"remarks"`
+	Substitutions []SubstitutionModel `json:"substitutions"`
+}
+
+const (
+	substitutionSuggested  = "SUGGESTED"
+	substitutionConfirmed  = "CONFIRMED"
+	substitutionUnassigned = "UNASSIGNED"
+)
+
+var teachers = []TeacherModel{
+	{Id: "teacher1@mail.com", Name: "Teacher 1", Subjects: []string{"Math"}},
+	{Id: "teacher2@mail.com", Name: "Teacher 2", Subjects: []string{"Science", "Math"}},
+	{Id: "teacher3@mail.com", Name: "Teacher 3", Subjects: []string{"English"}},
+	{Id: "teacher4@mail.com", Name: "Teacher 4", Subjects: []string{"Hindi", "English"}},
+	{Id: "teacher5@mail.com", Name: "Teacher 5", Subjects: []string{"Science"}},
+}
+
+var weeklyTimetable = fillWeeklyTimetable()
+
+func fillWeeklyTimetable() []WeeklyPeriodModel {
+	slots := []struct{ period, start, end string }{
+		{"Period 1", "09:00 AM", "10:00 AM"},
+		{"Period 2", "10:00 AM", "11:00 AM"},
+		{"Period 3", "11:30 AM", "12:30 PM"},
+		{"Period 4", "12:30 PM", "01:30 PM"},
+	}
+	rotation := map[string][]struct{ subject, teacher string }{
+		"10th A": {
+			{"Math", "teacher1@mail.com"},
+			{"English", "teacher3@mail.com"},
+			{"Science", "teacher5@mail.com"},
+			{"Hindi", "teacher4@mail.com"},
+		},
+		"10th B": {
+			{"Science", "teacher2@mail.com"},
+			{"Math", "teacher1@mail.com"},
+			{"English", "teacher4@mail.com"},
+			{"Science", "teacher5@mail.com"},
+		},
+	}
+	var timetable []WeeklyPeriodModel
+	for day := time.Monday; day <= time.Saturday; day++ {
+		for _, classSection := range []string{"10th A", "10th B"} {
+			for i, slot := range slots {
+				// Shift the rotation each day so every teacher has free periods.
+				entry := rotation[classSection][(i+int(day))%len(slots)]
+				timetable = append(timetable, WeeklyPeriodModel{
+					ClassSection: classSection,
+					Weekday:      day.String(),
+					Period:       slot.period,
+					Subject:      entry.subject,
+					TeacherId:    entry.teacher,
+					StartTime:    slot.start,
+					EndTime:      slot.end,
+				})
+			}
+		}
+	}
+	return timetable
+}
+
+func findTeacher(id string) (TeacherModel, bool) {
+	for _, teacher := range teachers {
+		if teacher.Id == id {
+			return teacher, true
+		}
+	}
+	return TeacherModel{}, false
+}
+
+type teacherLeaveStore struct {
+	sync.RWMutex
+	leaves map[string]*TeacherLeaveModel
+	order  []string
+}
+
+var teacherLeaves = &teacherLeaveStore{leaves: map[string]*TeacherLeaveModel{}}
+
+// onLeave reports whether the teacher has an approved leave covering date.
+// Callers must hold the lock.
+func (s *teacherLeaveStore) onLeave(teacherId string, date time.Time) bool {
+	day := date.Format(dateLayout)
+	for _, leave := range s.leaves {
+		if leave.TeacherId == teacherId && leave.Status == "Approved" && leave.FromDate <= day && day <= leave.ToDate {
+			return true
+		}
+	}
+	return false
+}
+
+// busy reports whether the teacher already teaches or substitutes in the
+// given period on date. Callers must hold the lock.
+func (s *teacherLeaveStore) busy(teacherId string, date time.Time, period string) bool {
+	for _, slot := range weeklyTimetable {
+		if slot.TeacherId == teacherId && slot.Weekday == date.Weekday().String() && slot.Period == period {
+			return true
+		}
+	}
+	day := date.Format(dateLayout)
+	for _, leave := range s.leaves {
+		for _, sub := range leave.Substitutions {
+			if sub.Status == substitutionConfirmed && sub.SubstituteTeacherId == teacherId && sub.Date == day && sub.Period == period {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// suggestSubstitutes ranks the teachers free for a period: teachers of the
+// same subject first, then those with the lightest load that day.
+// Callers must hold the lock.
+func (s *teacherLeaveStore) suggestSubstitutes(absentId string, date time.Time, slot WeeklyPeriodModel) []SubstituteCandidate {
+	candidates := []SubstituteCandidate{}
+	for _, teacher := range teachers {
+		if teacher.Id == absentId || s.onLeave(teacher.Id, date) || s.busy(teacher.Id, date, slot.Period) {
+			continue
+		}
+		load := 0
+		for _, other := range weeklyTimetable {
+			if other.TeacherId == teacher.Id && other.Weekday == slot.Weekday {
+				load++
+			}
+		}
+		match := false
+		for _, subject := range teacher.Subjects {
+			if subject == slot.Subject {
+				match = true
+			}
+		}
+		candidates = append(candidates, SubstituteCandidate{
+			TeacherId:      teacher.Id,
+			Name:           teacher.Name,
+			SubjectMatch:   match,
+			PeriodsThatDay: load,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].SubjectMatch != candidates[j].SubjectMatch {
+			return candidates[i].SubjectMatch
+		}
+		if candidates[i].PeriodsThatDay != candidates[j].PeriodsThatDay {
+			return candidates[i].PeriodsThatDay < candidates[j].PeriodsThatDay
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates
+}
+
+// planSubstitutions lists every timetable period the teacher misses during
+// the leave together with suggested substitutes. Callers must hold the lock.
+func (s *teacherLeaveStore) planSubstitutions(leave *TeacherLeaveModel) []SubstitutionModel {
+	from, _ := time.Parse(dateLayout, leave.FromDate)
+	to, _ := time.Parse(dateLayout, leave.ToDate)
+	substitutions := []SubstitutionModel{}
+	for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+		for _, slot := range weeklyTimetable {
+			if slot.TeacherId != leave.TeacherId || slot.Weekday != date.Weekday().String() {
+				continue
+			}
+			status := substitutionSuggested
+			suggestions := s.suggestSubstitutes(leave.TeacherId, date, slot)
+			if len(suggestions) == 0 {
+				status = substitutionUnassigned
+			}
+			substitutions = append(substitutions, SubstitutionModel{
+				Id:           newID("SUB"),
+				Date:         date.Format(dateLayout),
+				Period:       slot.Period,
+				ClassSection: slot.ClassSection,
+				Subject:      slot.Subject,
+				StartTime:    slot.StartTime,
+				EndTime:      slot.EndTime,
+				Suggestions:  suggestions,
+				Status:       status,
+			})
+		}
+	}
+	return substitutions
+}
+
+// overlapping returns a pending or approved leave of the teacher sharing a
+// day with from to to. Callers must hold the lock.
+func (s *teacherLeaveStore) overlapping(teacherId, from, to string) (*TeacherLeaveModel, bool) {
+	for _, leave := range s.leaves {
+		if leave.TeacherId == teacherId && (leave.Status == "Pending" || leave.Status == "Approved") && leave.FromDate <= to && from <= leave.ToDate {
+			return leave, true
+		}
+	}
+	return nil, false
+}
+
+// replanSubstitutes re-plans the periods of other approved leaves that fall
+// during leave and name its teacher as the substitute or a suggestion.
+// Callers must hold the lock.
+func (s *teacherLeaveStore) replanSubstitutes(leave *TeacherLeaveModel) {
+	for _, other := range s.leaves {
+		if other.Id == leave.Id || other.Status != "Approved" {
+			continue
+		}
+		for i := range other.Substitutions {
+			sub := &other.Substitutions[i]
+			if sub.Date < leave.FromDate || leave.ToDate < sub.Date {
+				continue
+			}
+			named := sub.SubstituteTeacherId == leave.TeacherId
+			for _, suggestion := range sub.Suggestions {
+				if suggestion.TeacherId == leave.TeacherId {
+					named = true
+				}
+			}
+			if !named {
+				continue
+			}
+			if sub.SubstituteTeacherId == leave.TeacherId {
+				sub.SubstituteTeacherId, sub.SubstituteTeacherName = "", ""
+			}
+			date, _ := time.Parse(dateLayout, sub.Date)
+			sub.Suggestions = s.suggestSubstitutes(other.TeacherId, date, WeeklyPeriodModel{
+				ClassSection: sub.ClassSection,
+				Weekday:      date.Weekday().String(),
+				Period:       sub.Period,
+				Subject:      sub.Subject,
+			})
+			switch {
+			case sub.SubstituteTeacherId != "":
+			case len(sub.Suggestions) == 0:
+				sub.Status = substitutionUnassigned
+			default:
+				sub.Status = substitutionSuggested
+			}
+		}
+	}
+}
+
+// substitutionsFor returns the confirmed substitute periods assigned to a
+// teacher keyed the same way as DateModel.TimeTable.
+func (s *teacherLeaveStore) substitutionsFor(teacherId string) map[string][]TimetableModel {
+	s.RLock()
+	defer s.RUnlock()
+	periods := map[string][]TimetableModel{}
+	for _, leave := range s.leaves {
+		for _, sub := range leave.Substitutions {
+			if sub.Status != substitutionConfirmed || sub.SubstituteTeacherId != teacherId {
+				continue
+			}
+			date, err := time.Parse(dateLayout, sub.Date)
+			if err != nil {
+				continue
+			}
+			key := date.Format(time.RFC3339)
+			periods[key] = append(periods[key], TimetableModel{
+				Period:         sub.Period + " (Substitution " + sub.ClassSection + ")",
+				Subject:        sub.Subject,
+				SubjectTeacher: sub.SubstituteTeacherName,
+				StartTime:      sub.StartTime,
+				EndTime:        sub.EndTime,
+			})
+		}
+	}
+	return periods
+}
+
+// TeacherLeaveHandler files a leave request for the calling teacher, or for
+// any teacher when called by an admin.
+func TeacherLeaveHandler(c echo.Context) error {
+	var req TeacherLeaveRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+
+	role := claimString(claims, "user_role")
+	switch role {
+	case roleTeacher:
+		req.TeacherId = claimString(claims, "id")
+	case roleAdmin:
+	default:
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	teacher, ok := findTeacher(req.TeacherId)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, failed("Unknown teacher"))
+	}
+	from, errFrom := time.Parse(dateLayout, req.FromDate)
+	to, errTo := time.Parse(dateLayout, req.ToDate)
+	if errFrom != nil || errTo != nil {
+		return c.JSON(http.StatusBadRequest, failed("Dates must use the YYYY-MM-DD format"))
+	}
+	if to.Before(from) {
+		return c.JSON(http.StatusBadRequest, failed("toDate must not be before fromDate"))
+	}
+	if to.Sub(from) >= maxLeaveDays*24*time.Hour {
+		return c.JSON(http.StatusBadRequest, failed("A leave can span at most 90 days"))
+	}
+
+	leave := &TeacherLeaveModel{
+		Id:            newID("TL"),
+		TeacherId:     teacher.Id,
+		TeacherName:   teacher.Name,
+		RequestDate:   time.Now().Format(dateLayout),
+		FromDate:      req.FromDate,
+		ToDate:        req.ToDate,
+		Reason:        req.Reason,
+		Status:        "Pending",
+		Substitutions: []SubstitutionModel{},
+	}
+	teacherLeaves.Lock()
+	defer teacherLeaves.Unlock()
+	if existing, ok := teacherLeaves.overlapping(teacher.Id, leave.FromDate, leave.ToDate); ok {
+		return c.JSON(http.StatusConflict, failed("Overlaps leave "+existing.Id+" from "+existing.FromDate+" to "+existing.ToDate))
+	}
+	teacherLeaves.leaves[leave.Id] = leave
+	teacherLeaves.order = append(teacherLeaves.order, leave.Id)
+
+	return c.JSON(http.StatusOK, success(*leave))
+}
+
+// TeacherLeaveListHandler lists every leave for admins and the caller's own
+// leaves for teachers.
+func TeacherLeaveListHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	teacherLeaves.RLock()
+	defer teacherLeaves.RUnlock()
+	leaves := []TeacherLeaveModel{}
+	for _, id := range teacherLeaves.order {
+		leave := teacherLeaves.leaves[id]
+		if role == roleTeacher && leave.TeacherId != claimString(claims, "id") {
+			continue
+		}
+		leaves = append(leaves, *leave)
+	}
+	return c.JSON(http.StatusOK, success(leaves))
+}
+
+// TeacherLeaveDecisionHandler approves or rejects a pending leave. Approval
+// plans the substitutions for every period the teacher misses and re-plans
+// the periods the teacher was to cover for others meanwhile.
+func TeacherLeaveDecisionHandler(c echo.Context) error {
+	var req TeacherLeaveDecisionDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	teacherLeaves.Lock()
+	defer teacherLeaves.Unlock()
+	leave, ok := teacherLeaves.leaves[req.LeaveId]
+	if !ok {
+		return c.JSON(http.StatusNotFound, failed("Leave request not found"))
+	}
+	if leave.Status != "Pending" {
+		return c.JSON(http.StatusConflict, failed("Leave request already "+leave.Status))
+	}
+
+	leave.Remarks = req.Remarks
+	if !req.Approve {
+		leave.Status = "Rejected"
+		return c.JSON(http.StatusOK, success(*leave))
+	}
+	leave.Status = "Approved"
+	leave.Substitutions = teacherLeaves.planSubstitutions(leave)
+	teacherLeaves.replanSubstitutes(leave)
+	return c.JSON(http.StatusOK, success(*leave))
+}
+
+// ConfirmSubstituteHandler lets an admin assign a substitute to one of the
+// periods of an approved leave.
+func ConfirmSubstituteHandler(c echo.Context) error {
+	var req SubstituteConfirmDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	teacherLeaves.Lock()
+	defer teacherLeaves.Unlock()
+	leave, ok := teacherLeaves.leaves[req.LeaveId]
+	if !ok || leave.Status != "Approved" {
+		return c.JSON(http.StatusNotFound, failed("Approved leave request not found"))
+	}
+	for i := range leave.Substitutions {
+		sub := &leave.Substitutions[i]
+		if sub.Id != req.SubstitutionId {
+			continue
+		}
+		teacher, ok := findTeacher(req.TeacherId)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, failed("Unknown teacher"))
+		}
+		date, _ := time.Parse(dateLayout, sub.Date)
+		if sub.SubstituteTeacherId != teacher.Id &&
+			(teacher.Id == leave.TeacherId || teacherLeaves.onLeave(teacher.Id, date) || teacherLeaves.busy(teacher.Id, date, sub.Period)) {
+			return c.JSON(http.StatusConflict, failed("Teacher is not free for this period"))
+		}
+		sub.SubstituteTeacherId = teacher.Id
+		sub.SubstituteTeacherName = teacher.Name
+		sub.Status = substitutionConfirmed
+		return c.JSON(http.StatusOK, success(*sub))
+	}
+	return c.JSON(http.StatusNotFound, failed("Substitution not found"))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func resetTeacherLeaves() {
+	teacherLeaves = &teacherLeaveStore{leaves: map[string]*TeacherLeaveModel{}}
+}
+
+func TestTeacherLeaveOverlapping(t *testing.T) {
+	resetTeacherLeaves()
+	teacherLeaves.leaves["TL-A"] = &TeacherLeaveModel{Id: "TL-A", TeacherId: "teacher1@mail.com", FromDate: "2030-03-10", ToDate: "2030-03-12", Status: "Pending"}
+	teacherLeaves.leaves["TL-B"] = &TeacherLeaveModel{Id: "TL-B", TeacherId: "teacher1@mail.com", FromDate: "2030-04-01", ToDate: "2030-04-05", Status: "Rejected"}
+
+	tests := []struct {
+		name     string
+		teacher  string
+		from, to string
+		want     bool
+	}{
+		{"before", "teacher1@mail.com", "2030-03-01", "2030-03-09", false},
+		{"touches start", "teacher1@mail.com", "2030-03-05", "2030-03-10", true},
+		{"inside", "teacher1@mail.com", "2030-03-11", "2030-03-11", true},
+		{"encloses", "teacher1@mail.com", "2030-03-01", "2030-03-31", true},
+		{"touches end", "teacher1@mail.com", "2030-03-12", "2030-03-20", true},
+		{"after", "teacher1@mail.com", "2030-03-13", "2030-03-20", false},
+		{"rejected leave", "teacher1@mail.com", "2030-04-02", "2030-04-03", false},
+		{"other teacher", "teacher2@mail.com", "2030-03-11", "2030-03-11", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, got := teacherLeaves.overlapping(test.teacher, test.from, test.to); got != test.want {
+				t.Errorf("overlapping(%s, %s) = %v, want %v", test.from, test.to, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTeacherLeaveHandler(t *testing.T) {
+	resetTeacherLeaves()
+	token := testToken(t, "teacher1@mail.com", roleTeacher, "")
+	tests := []struct {
+		name     string
+		from, to string
+		status   int
+	}{
+		{"bad date", "2030-1-1", "2030-01-02", http.StatusBadRequest},
+		{"reversed", "2030-01-05", "2030-01-01", http.StatusBadRequest},
+		{"longer than 90 days", "2030-01-01", "2030-04-01", http.StatusBadRequest},
+		{"90 days", "2030-01-01", "2030-03-31", http.StatusOK},
+		{"overlaps the last day", "2030-03-31", "2030-04-02", http.StatusConflict},
+		{"next day", "2030-04-01", "2030-04-02", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := callHandler(t, TeacherLeaveHandler, http.MethodPost, "/teacher-leave", token, TeacherLeaveRequestDto{FromDate: test.from, ToDate: test.to})
+			decodeData(t, rec, test.status, nil)
+		})
+	}
+}
+
+func TestTeacherLeaveApprovalReplansSubstitutes(t *testing.T) {
+	resetTeacherLeaves()
+	admin := testToken(t, "admin", roleAdmin, "")
+	fileAndApprove := func(teacherId, date string) TeacherLeaveModel {
+		t.Helper()
+		var leave TeacherLeaveModel
+		rec := callHandler(t, TeacherLeaveHandler, http.MethodPost, "/teacher-leave", admin, TeacherLeaveRequestDto{TeacherId: teacherId, FromDate: date, ToDate: date})
+		decodeData(t, rec, http.StatusOK, &leave)
+		rec = callHandler(t, TeacherLeaveDecisionHandler, http.MethodPost, "/teacher-leave/decision", admin, TeacherLeaveDecisionDto{LeaveId: leave.Id, Approve: true})
+		decodeData(t, rec, http.StatusOK, &leave)
+		return leave
+	}
+
+	// 2030-03-11 is a Monday, when Teacher 1 teaches two periods
+	first := fileAndApprove("teacher1@mail.com", "2030-03-11")
+	if len(first.Substitutions) != 2 {
+		t.Fatalf("got %d substitutions, want 2", len(first.Substitutions))
+	}
+	sub := first.Substitutions[0]
+	if len(sub.Suggestions) == 0 {
+		t.Fatalf("no substitutes suggested for %+v", sub)
+	}
+	substitute := sub.Suggestions[0].TeacherId
+	rec := callHandler(t, ConfirmSubstituteHandler, http.MethodPost, "/teacher-leave/substitute", admin, SubstituteConfirmDto{LeaveId: first.Id, SubstitutionId: sub.Id, TeacherId: substitute})
+	decodeData(t, rec, http.StatusOK, nil)
+
+	// The substitute goes on leave the same day
+	fileAndApprove(substitute, "2030-03-11")
+	replanned := teacherLeaves.leaves[first.Id].Substitutions[0]
+	if replanned.SubstituteTeacherId != "" || replanned.Status == substitutionConfirmed {
+		t.Errorf("substitution still confirmed for %s: %+v", replanned.SubstituteTeacherId, replanned)
+	}
+	for _, suggestion := range replanned.Suggestions {
+		if suggestion.TeacherId == substitute {
+			t.Errorf("%s is still suggested while on leave", substitute)
+		}
+	}
+}
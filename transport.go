@@ -0,0 +1,608 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const transportFeeSource = "transport"
+
+type VehicleModel struct {
+	Id                 string `json:"id"`
+	Name               string `json:"name"`
+	RegistrationNumber string `json:"registrationNumber"`
+	Capacity           int    `json:"capacity"`
+	DriverName         string `json:"driverName"`
+	DriverPhone        string `json:"driverPhone"`
+}
+
+// RouteStopModel is a pickup point. Stops are kept in the order the bus
+// reaches them in the morning.
+type RouteStopModel struct {
+	Id         string  `json:"id"`
+	Name       string  `json:"name"`
+	PickupTime string  `json:"pickupTime"`
+	DropTime   string  `json:"dropTime"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+}
+
+// RouteModel is one trip of a vehicle. A vehicle doing two rounds has two
+// routes, and each round is limited to the vehicle capacity.
+type RouteModel struct {
+	Id         string           `json:"id"`
+	Name       string           `json:"name"`
+	VehicleId  string           `json:"vehicleId"`
+	MonthlyFee int              `json:"monthlyFee"`
+	Stops      []RouteStopModel `json:"stops"`
+	Occupancy  int              `json:"occupancy"`
+	Capacity   int              `json:"capacity"`
+}
+
+type TransportAssignmentModel struct {
+	StudentId  string `json:"studentId"`
+	RouteId    string `json:"routeId"`
+	StopId     string `json:"stopId"`
+	MonthlyFee int    `json:"monthlyFee"`
+	AssignedAt string `json:"assignedAt"`
+}
+
+// TransportSetupDto is the bus routes onboarding step. Routes reference
+// their vehicle by name or registration number because ids are not known
+// yet.
+type TransportSetupDto struct {
+	Vehicles []VehicleModel `json:"vehicles"`
+	Routes   []struct {
+		RouteModel
+		Vehicle string `json:"vehicle"`
+	} `json:"routes"`
+}
+
+type TransportAssignRequestDto struct {
+	StudentId string `json:"studentId"`
+	RouteId   string `json:"routeId"`
+	StopId    string `json:"stopId"`
+}
+
+var (
+	clockTimePattern    = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+	registrationPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{1,2}[A-Z]{0,3}[0-9]{4}$`)
+	mobileNumberPattern = regexp.MustCompile(`^[6-9][0-9]{9}$`)
+)
+
+type schoolTransport struct {
+	vehicles    map[string]*VehicleModel
+	routes      map[string]*RouteModel
+	assignments map[string]*TransportAssignmentModel
+}
+
+type transportStore struct {
+	sync.RWMutex
+	schools map[string]*schoolTransport
+}
+
+var transport = &transportStore{schools: map[string]*schoolTransport{}}
+
+// school returns the school's transport data. Callers must hold the lock.
+func (s *transportStore) school(schoolId string) *schoolTransport {
+	school, ok := s.schools[schoolId]
+	if !ok {
+		school = &schoolTransport{
+			vehicles:    map[string]*VehicleModel{},
+			routes:      map[string]*RouteModel{},
+			assignments: map[string]*TransportAssignmentModel{},
+		}
+		s.schools[schoolId] = school
+	}
+	return school
+}
+
+func (t *schoolTransport) occupancy(routeId string) int {
+	count := 0
+	for _, assignment := range t.assignments {
+		if assignment.RouteId == routeId {
+			count++
+		}
+	}
+	return count
+}
+
+// routeView fills the derived occupancy and capacity of a route.
+func (t *schoolTransport) routeView(route RouteModel) RouteModel {
+	route.Stops = append([]RouteStopModel{}, route.Stops...)
+	route.Occupancy = t.occupancy(route.Id)
+	if vehicle, ok := t.vehicles[route.VehicleId]; ok {
+		route.Capacity = vehicle.Capacity
+	}
+	return route
+}
+
+func (t *schoolTransport) sortedRoutes() []RouteModel {
+	routes := make([]RouteModel, 0, len(t.routes))
+	for _, route := range t.routes {
+		routes = append(routes, t.routeView(*route))
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+	return routes
+}
+
+func (t *schoolTransport) sortedVehicles() []VehicleModel {
+	vehicles := make([]VehicleModel, 0, len(t.vehicles))
+	for _, vehicle := range t.vehicles {
+		vehicles = append(vehicles, *vehicle)
+	}
+	sort.Slice(vehicles, func(i, j int) bool { return vehicles[i].Name < vehicles[j].Name })
+	return vehicles
+}
+
+// normalizeVehicle validates a vehicle against the others of the school.
+// Callers must hold the lock.
+func (t *schoolTransport) normalizeVehicle(vehicle VehicleModel) (VehicleModel, []string) {
+	var errs []string
+	vehicle.Name = strings.Join(strings.Fields(vehicle.Name), " ")
+	vehicle.RegistrationNumber = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(vehicle.RegistrationNumber))
+	vehicle.DriverName = strings.Join(strings.Fields(vehicle.DriverName), " ")
+	vehicle.DriverPhone = strings.TrimSpace(vehicle.DriverPhone)
+
+	if vehicle.Name == "" {
+		errs = append(errs, "name is required")
+	}
+	if !registrationPattern.MatchString(vehicle.RegistrationNumber) {
+		errs = append(errs, fmt.Sprintf("registrationNumber %q is not a valid registration such as KA01AB1234", vehicle.RegistrationNumber))
+	}
+	if vehicle.Capacity < 1 {
+		errs = append(errs, "capacity must be a positive whole number")
+	}
+	if vehicle.DriverName == "" {
+		errs = append(errs, "driverName is required")
+	}
+	if !mobileNumberPattern.MatchString(vehicle.DriverPhone) {
+		errs = append(errs, "driverPhone must be a 10 digit mobile number")
+	}
+	for _, other := range t.vehicles {
+		if other.Id == vehicle.Id {
+			continue
+		}
+		if strings.EqualFold(other.Name, vehicle.Name) {
+			errs = append(errs, fmt.Sprintf("a vehicle named %q already exists", vehicle.Name))
+		}
+		if other.RegistrationNumber == vehicle.RegistrationNumber {
+			errs = append(errs, fmt.Sprintf("registrationNumber %s is already used by %s", vehicle.RegistrationNumber, other.Name))
+		}
+	}
+	if vehicle.Id != "" {
+		if _, ok := t.vehicles[vehicle.Id]; !ok {
+			return vehicle, []string{"vehicle " + vehicle.Id + " does not exist"}
+		}
+		for _, route := range t.routes {
+			if route.VehicleId == vehicle.Id && t.occupancy(route.Id) > vehicle.Capacity {
+				errs = append(errs, fmt.Sprintf("capacity %d is below the %d students assigned to %s", vehicle.Capacity, t.occupancy(route.Id), route.Name))
+			}
+		}
+	}
+	return vehicle, errs
+}
+
+// normalizeRoute validates a route and its stops. Callers must hold the
+// lock.
+func (t *schoolTransport) normalizeRoute(route RouteModel) (RouteModel, []string) {
+	var errs []string
+	route.Name = strings.Join(strings.Fields(route.Name), " ")
+	if route.Name == "" {
+		errs = append(errs, "name is required")
+	}
+	vehicle, ok := t.vehicles[route.VehicleId]
+	if !ok {
+		errs = append(errs, fmt.Sprintf("vehicleId %q does not exist", route.VehicleId))
+	}
+	if route.MonthlyFee < 0 {
+		errs = append(errs, "monthlyFee cannot be negative")
+	}
+	for _, other := range t.routes {
+		if other.Id != route.Id && strings.EqualFold(other.Name, route.Name) {
+			errs = append(errs, fmt.Sprintf("a route named %q already exists", route.Name))
+		}
+	}
+
+	if len(route.Stops) == 0 {
+		errs = append(errs, "at least one stop is required")
+	}
+	route.Stops = append([]RouteStopModel{}, route.Stops...)
+	seenStops := map[string]bool{}
+	previousPickup := ""
+	for i := range route.Stops {
+		stop := &route.Stops[i]
+		stop.Name = strings.Join(strings.Fields(stop.Name), " ")
+		if stop.Name == "" {
+			errs = append(errs, fmt.Sprintf("stops[%d].name is required", i))
+		} else if seenStops[strings.ToLower(stop.Name)] {
+			errs = append(errs, fmt.Sprintf("stop %q is repeated", stop.Name))
+		}
+		seenStops[strings.ToLower(stop.Name)] = true
+		if !clockTimePattern.MatchString(stop.PickupTime) {
+			errs = append(errs, fmt.Sprintf("stops[%d].pickupTime must be HH:MM", i))
+		} else if stop.PickupTime < previousPickup {
+			errs = append(errs, fmt.Sprintf("stop %q is picked up before the previous stop", stop.Name))
+		} else {
+			previousPickup = stop.PickupTime
+		}
+		if !clockTimePattern.MatchString(stop.DropTime) {
+			errs = append(errs, fmt.Sprintf("stops[%d].dropTime must be HH:MM", i))
+		}
+		if stop.Latitude < -90 || stop.Latitude > 90 || stop.Longitude < -180 || stop.Longitude > 180 {
+			errs = append(errs, fmt.Sprintf("stop %q has invalid coordinates", stop.Name))
+		}
+	}
+	if !dropTimesInOrder(route.Stops) {
+		errs = append(errs, "stops must be dropped in stop order or in reverse stop order")
+	}
+
+	if route.Id != "" {
+		existing, ok := t.routes[route.Id]
+		if !ok {
+			return route, []string{"route " + route.Id + " does not exist"}
+		}
+		kept := map[string]bool{}
+		for _, stop := range route.Stops {
+			kept[stop.Id] = true
+		}
+		for _, stop := range existing.Stops {
+			if !kept[stop.Id] {
+				for _, assignment := range t.assignments {
+					if assignment.StopId == stop.Id {
+						errs = append(errs, fmt.Sprintf("stop %q still has students assigned", stop.Name))
+						break
+					}
+				}
+			}
+		}
+		if vehicle != nil && t.occupancy(route.Id) > vehicle.Capacity {
+			errs = append(errs, fmt.Sprintf("%s only seats %d but %d students are assigned", vehicle.Name, vehicle.Capacity, t.occupancy(route.Id)))
+		}
+	}
+	for i := range route.Stops {
+		if route.Stops[i].Id == "" {
+			route.Stops[i].Id = newID("STP")
+		}
+	}
+	route.Occupancy, route.Capacity = 0, 0
+	return route, errs
+}
+
+// dropTimesInOrder reports whether the drop times run with the stop order
+// or against it, so the afternoon run reaches the stops one way along the
+// route. Malformed times are reported on their own.
+func dropTimesInOrder(stops []RouteStopModel) bool {
+	ascending, descending := true, true
+	for i := 1; i < len(stops); i++ {
+		previous, drop := stops[i-1].DropTime, stops[i].DropTime
+		if !clockTimePattern.MatchString(previous) || !clockTimePattern.MatchString(drop) {
+			return true
+		}
+		ascending = ascending && drop >= previous
+		descending = descending && drop <= previous
+	}
+	return ascending || descending
+}
+
+// assign places an existing student on a route stop and raises the
+// transport fee from the current month. Callers must hold the lock.
+func (t *schoolTransport) assign(req TransportAssignRequestDto) (TransportAssignmentModel, int, []string) {
+	route, ok := t.routes[req.RouteId]
+	if !ok {
+		return TransportAssignmentModel{}, http.StatusNotFound, []string{"route " + req.RouteId + " does not exist"}
+	}
+	var stop *RouteStopModel
+	for i := range route.Stops {
+		if route.Stops[i].Id == req.StopId {
+			stop = &route.Stops[i]
+		}
+	}
+	if stop == nil {
+		return TransportAssignmentModel{}, http.StatusBadRequest, []string{"stop " + req.StopId + " is not on " + route.Name}
+	}
+
+	current, assigned := t.assignments[req.StudentId]
+	if !assigned || current.RouteId != route.Id {
+		vehicle := t.vehicles[route.VehicleId]
+		if t.occupancy(route.Id) >= vehicle.Capacity {
+			return TransportAssignmentModel{}, http.StatusConflict, []string{fmt.Sprintf("%s is full (%d seats)", route.Name, vehicle.Capacity)}
+		}
+	}
+
+	assignment := TransportAssignmentModel{
+		StudentId:  req.StudentId,
+		RouteId:    route.Id,
+		StopId:     stop.Id,
+		MonthlyFee: route.MonthlyFee,
+		AssignedAt: time.Now().Format(time.RFC3339),
+	}
+	t.assignments[req.StudentId] = &assignment
+	feeLedger.chargeMonthly(req.StudentId, transportFeeSource, "Transport Fee", route.Name+" - "+stop.Name, route.MonthlyFee, time.Now())
+	return assignment, http.StatusOK, nil
+}
+
+// applyTransportDropDowns overrides the "vehicleName" and "routeName"
+// dropdowns with the school's vehicles and routes, and adds the stops of
+// each route under "routeStops".
+func applyTransportDropDowns(schoolId string, dropDowns map[string]interface{}) {
+	transport.RLock()
+	defer transport.RUnlock()
+	school, ok := transport.schools[schoolId]
+	if !ok || len(school.vehicles) == 0 {
+		return
+	}
+	vehicles := []string{}
+	for _, vehicle := range school.sortedVehicles() {
+		vehicles = append(vehicles, vehicle.Name)
+	}
+	routes := []string{}
+	stops := map[string][]string{}
+	for _, route := range school.sortedRoutes() {
+		routes = append(routes, route.Name)
+		for _, stop := range route.Stops {
+			stops[route.Name] = append(stops[route.Name], stop.Name)
+		}
+	}
+	dropDowns["vehicleName"] = vehicles
+	dropDowns["routeName"] = routes
+	dropDowns["routeStops"] = stops
+}
+
+// BusRoutesOnboardHandler saves the vehicles and routes of the bus routes
+// onboarding step. Submitting again replaces them until students are
+// assigned; after that the /transport endpoints must be used.
+func BusRoutesOnboardHandler(c echo.Context) error {
+	var req TransportSetupDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	schoolId := schoolID(claims)
+	if blocked := onboardingProgress.blockedBy(schoolId, onboardingStepBusRoutes); len(blocked) > 0 {
+		return c.JSON(http.StatusConflict, failed("Complete the earlier onboarding steps first", blocked...))
+	}
+
+	transport.Lock()
+	defer transport.Unlock()
+	if len(transport.school(schoolId).assignments) > 0 {
+		return c.JSON(http.StatusConflict, failed("Students are already assigned to routes; edit routes individually"))
+	}
+
+	setup := &schoolTransport{
+		vehicles:    map[string]*VehicleModel{},
+		routes:      map[string]*RouteModel{},
+		assignments: map[string]*TransportAssignmentModel{},
+	}
+	var errs []string
+	if len(req.Vehicles) == 0 {
+		errs = append(errs, "at least one vehicle is required")
+	}
+	for i, vehicle := range req.Vehicles {
+		vehicle.Id = ""
+		vehicle, vehicleErrs := setup.normalizeVehicle(vehicle)
+		for _, e := range vehicleErrs {
+			errs = append(errs, fmt.Sprintf("vehicles[%d]: %s", i, e))
+		}
+		if len(vehicleErrs) == 0 {
+			vehicle.Id = newID("VEH")
+			setup.vehicles[vehicle.Id] = &vehicle
+		}
+	}
+	for i, row := range req.Routes {
+		route := row.RouteModel
+		route.Id = ""
+		for _, vehicle := range setup.vehicles {
+			if strings.EqualFold(vehicle.Name, strings.TrimSpace(row.Vehicle)) || vehicle.RegistrationNumber == strings.ToUpper(strings.ReplaceAll(row.Vehicle, " ", "")) {
+				route.VehicleId = vehicle.Id
+			}
+		}
+		if route.VehicleId == "" {
+			errs = append(errs, fmt.Sprintf("routes[%d]: vehicle %q is not one of the submitted vehicles", i, row.Vehicle))
+			continue
+		}
+		route, routeErrs := setup.normalizeRoute(route)
+		for _, e := range routeErrs {
+			errs = append(errs, fmt.Sprintf("routes[%d]: %s", i, e))
+		}
+		if len(routeErrs) == 0 {
+			route.Id = newID("RT")
+			setup.routes[route.Id] = &route
+		}
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid bus route data", errs...))
+	}
+
+	transport.schools[schoolId] = setup
+	onboardingProgress.complete(schoolId, onboardingStepBusRoutes)
+	data := fillOnBoardModel(schoolId)
+	data.Data["vehicles"] = setup.sortedVehicles()
+	data.Data["routes"] = setup.sortedRoutes()
+	return c.JSON(http.StatusOK, success(data))
+}
+
+// SaveVehicleHandler creates a vehicle, or updates it when an id is given.
+func SaveVehicleHandler(c echo.Context) error {
+	var req VehicleModel
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	schoolId := schoolID(claims)
+
+	transport.Lock()
+	defer transport.Unlock()
+	school := transport.school(schoolId)
+	vehicle, errs := school.normalizeVehicle(req)
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid vehicle", errs...))
+	}
+	if vehicle.Id == "" {
+		vehicle.Id = newID("VEH")
+	}
+	school.vehicles[vehicle.Id] = &vehicle
+	return c.JSON(http.StatusOK, success(vehicle))
+}
+
+// SaveRouteHandler creates a route, or updates it when an id is given.
+// Stops without an id are new; existing stops keep theirs.
+func SaveRouteHandler(c echo.Context) error {
+	var req RouteModel
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	schoolId := schoolID(claims)
+
+	transport.Lock()
+	defer transport.Unlock()
+	school := transport.school(schoolId)
+	route, errs := school.normalizeRoute(req)
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, failed("Invalid route", errs...))
+	}
+	if route.Id == "" {
+		route.Id = newID("RT")
+	} else if previous := school.routes[route.Id]; previous.MonthlyFee != route.MonthlyFee || previous.Name != route.Name {
+		// Revise the transport fee of everyone on the route from this month
+		for _, assignment := range school.assignments {
+			if assignment.RouteId != route.Id {
+				continue
+			}
+			assignment.MonthlyFee = route.MonthlyFee
+			for _, stop := range route.Stops {
+				if stop.Id == assignment.StopId {
+					feeLedger.chargeMonthly(assignment.StudentId, transportFeeSource, "Transport Fee", route.Name+" - "+stop.Name, route.MonthlyFee, time.Now())
+				}
+			}
+		}
+	}
+	school.routes[route.Id] = &route
+	return c.JSON(http.StatusOK, success(school.routeView(route)))
+}
+
+// TransportHandler lists the school's vehicles and routes with their
+// occupancy.
+func TransportHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	transport.RLock()
+	defer transport.RUnlock()
+	school, ok := transport.schools[schoolID(claims)]
+	if !ok {
+		return c.JSON(http.StatusOK, success(map[string]interface{}{"vehicles": []VehicleModel{}, "routes": []RouteModel{}}))
+	}
+	return c.JSON(http.StatusOK, success(map[string]interface{}{
+		"vehicles": school.sortedVehicles(),
+		"routes":   school.sortedRoutes(),
+	}))
+}
+
+// AssignTransportHandler assigns a student to a stop, moving them if they
+// are already on a route.
+func AssignTransportHandler(c echo.Context) error {
+	var req TransportAssignRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	schoolId := schoolID(claims)
+	if _, ok := students.get(schoolId, req.StudentId); !ok {
+		return c.JSON(http.StatusNotFound, failed("Could not assign transport", "student "+req.StudentId+" does not exist"))
+	}
+
+	transport.Lock()
+	defer transport.Unlock()
+	assignment, status, errs := transport.school(schoolId).assign(req)
+	if len(errs) > 0 {
+		return c.JSON(status, failed("Could not assign transport", errs...))
+	}
+	return c.JSON(http.StatusOK, success(assignment))
+}
+
+// UnassignTransportHandler takes a student off transport. The current
+// month stays billed; later transport charges are cancelled.
+func UnassignTransportHandler(c echo.Context) error {
+	var req TransportAssignRequestDto
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid request")
+	}
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	if claimString(claims, "user_role") != roleAdmin {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+	schoolId := schoolID(claims)
+
+	transport.Lock()
+	defer transport.Unlock()
+	school := transport.school(schoolId)
+	if _, ok := school.assignments[req.StudentId]; !ok {
+		return c.JSON(http.StatusNotFound, failed("Student "+req.StudentId+" is not assigned to transport"))
+	}
+	delete(school.assignments, req.StudentId)
+	feeLedger.cancelFrom(req.StudentId, transportFeeSource, nextMonth(time.Now()))
+	return c.JSON(http.StatusOK, success(nil))
+}
+
+// TransportAssignmentsHandler lists assignments, optionally for one route.
+func TransportAssignmentsHandler(c echo.Context) error {
+	claims, status, failure := bearerClaims(c)
+	if claims == nil {
+		return c.JSON(status, failure)
+	}
+	role := claimString(claims, "user_role")
+	if role != roleAdmin && role != roleTeacher {
+		return c.JSON(http.StatusForbidden, forbidden())
+	}
+
+	routeId := c.QueryParam("routeId")
+	transport.RLock()
+	defer transport.RUnlock()
+	list := []TransportAssignmentModel{}
+	if school, ok := transport.schools[schoolID(claims)]; ok {
+		for _, assignment := range school.assignments {
+			if routeId == "" || assignment.RouteId == routeId {
+				list = append(list, *assignment)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StudentId < list[j].StudentId })
+	return c.JSON(http.StatusOK, success(list))
+}